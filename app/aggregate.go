@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// aggregateFunctions are the functions computed from all the rows of a query, with their least and largest
+// number of arguments
+var aggregateFunctions = map[string]struct{ MinArgs, MaxArgs int }{
+	"COUNT":             {1, 1},
+	"MIN":               {1, 1},
+	"MAX":               {1, 1},
+	"SUM":               {1, 1},
+	"TOTAL":             {1, 1},
+	"AVG":               {1, 1},
+	"GROUP_CONCAT":      {1, 2},
+	"STRING_AGG":        {2, 2},
+	"JSON_GROUP_ARRAY":  {1, 1},
+	"JSON_GROUP_OBJECT": {2, 2},
+}
+
+// isAggregate tells if the expression calls an aggregate function, and not as a window function
+func isAggregate(expr *Expr) bool {
+	if expr.Op != "function" && expr.Op != "aggregate" || expr.Window != nil {
+		return false
+	}
+	function, found := aggregateFunctions[expr.Name]
+	return found && len(expr.Args) >= function.MinArgs && len(expr.Args) <= function.MaxArgs
+}
+
+// findAggregates adds the aggregate functions called by the expression to the list, once each
+func findAggregates(expr *Expr, aggregates []*Expr) []*Expr {
+	if expr == nil {
+		return aggregates
+	}
+	if isAggregate(expr) {
+		if slices.Contains(aggregates, expr) {
+			return aggregates
+		}
+		return append(aggregates, expr)
+	}
+	for _, arg := range operands(expr) {
+		aggregates = findAggregates(arg, aggregates)
+	}
+	return aggregates
+}
+
+// aggregateState has the result of an aggregate function for the rows seen so far. NULL values are not
+// counted, except by COUNT(*), and neither are the rows that don't pass the FILTER clause. The values of
+// an aggregate called with DISTINCT are kept on Seen to skip the ones equal to a value seen before,
+// compared with the collation of the argument. The JSON aggregates build an array or object on JSON, and
+// GROUP_CONCAT and STRING_AGG keep their texts on Texts, each with the separator written before it. SUM,
+// TOTAL and AVG add integers on Sum until they overflow or a value isn't an integer, and then add reals on
+// Real, keeping the error of the additions on Error like SQLite.
+type aggregateState struct {
+	Count    int64
+	Value    any
+	JSON     *jsonNode
+	Sum      int64
+	Real     float64
+	Error    float64
+	Approx   bool
+	Overflow bool
+	Seen     *rowSet
+
+	Texts, Separators []string
+}
+
+// step adds the row to the result of the aggregate, telling if it became the new result of MIN or MAX
+func (state *aggregateState) step(expr *Expr, row []any) (bool, error) {
+	if expr.Filter != nil {
+		if passed, err := allTrue([]*Expr{expr.Filter}, row); err != nil || !passed {
+			return false, err
+		}
+	}
+	if isStar(expr.Args[0]) {
+		state.Count++
+		return false, nil
+	}
+	if expr.Distinct {
+		value, err := evalExpr(expr.Args[0], row)
+		if err != nil {
+			return false, err
+		}
+		if state.Seen == nil {
+			state.Seen = &rowSet{collations: []string{orderCollation(expr.Args[0])}}
+		}
+		if !state.Seen.add([]any{value}) {
+			return false, nil
+		}
+	}
+	if strings.HasPrefix(expr.Name, "JSON_GROUP_") {
+		return false, state.stepJSON(expr, row)
+	}
+	value, err := evalExpr(expr.Args[0], row)
+	if err != nil || value == nil {
+		return false, err
+	}
+	state.Count++
+	switch expr.Name {
+	case "SUM", "TOTAL", "AVG":
+		state.add(numericAffinity(value))
+	case "GROUP_CONCAT", "STRING_AGG":
+		separator := ","
+		if len(expr.Args) > 1 {
+			value, err := evalExpr(expr.Args[1], row)
+			if err != nil {
+				return false, err
+			}
+			separator = textValue(value)
+		}
+		state.Texts, state.Separators = append(state.Texts, textValue(value)), append(state.Separators, separator)
+	case "MIN", "MAX":
+		comparison := compareCollated(value, state.Value, orderCollation(expr.Args[0]))
+		if state.Count == 1 || (expr.Name == "MIN" && comparison < 0) || (expr.Name == "MAX" && comparison > 0) {
+			state.Value = value
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// stepJSON adds the value of the row to the array of json_group_array, or the label and value to the
+// object of json_group_object, skipping the members with a NULL label
+func (state *aggregateState) stepJSON(expr *Expr, row []any) error {
+	if state.JSON == nil {
+		state.JSON = &jsonNode{Type: "array"}
+		if expr.Name == "JSON_GROUP_OBJECT" {
+			state.JSON.Type = "object"
+		}
+	}
+	values := make([]any, len(expr.Args))
+	for i, arg := range expr.Args {
+		var err error
+		if values[i], err = evalJSON(arg, row); err != nil {
+			return err
+		}
+	}
+	if expr.Name == "JSON_GROUP_OBJECT" {
+		if values[0] == nil {
+			return nil
+		}
+		state.JSON.Labels = append(state.JSON.Labels, escapeJSON(textValue(values[0])))
+	}
+	node, err := jsonFromValue(values[len(values)-1])
+	if err != nil {
+		return err
+	}
+	state.JSON.Children = append(state.JSON.Children, node)
+	return nil
+}
+
+// add adds a value to the sum. Integers are added as integers until the sum overflows, which is an error
+// for SUM unless a real is added after it.
+func (state *aggregateState) add(value any) {
+	integer, isInteger := value.(int64)
+	switch {
+	case !state.Approx && !isInteger:
+		state.approximate()
+		state.addReal(realValue(value))
+	case !state.Approx && (integer > 0 && state.Sum > math.MaxInt64-integer || integer < 0 && state.Sum < math.MinInt64-integer):
+		state.approximate()
+		state.Overflow = true
+		state.addInteger(integer)
+	case !state.Approx:
+		state.Sum += integer
+	case isInteger:
+		state.addInteger(integer)
+	default:
+		state.Overflow = false
+		state.addReal(realValue(value))
+	}
+}
+
+// remove takes a value away from the sum, for the rows leaving the frame of a window
+func (state *aggregateState) remove(value any) {
+	integer, isInteger := value.(int64)
+	switch {
+	case !state.Approx:
+		if integer > 0 && state.Sum < math.MinInt64+integer || integer < 0 && state.Sum > math.MaxInt64+integer {
+			state.Approx, state.Overflow = true, true
+		} else {
+			state.Sum -= integer
+		}
+	case isInteger && integer == math.MinInt64:
+		state.addInteger(math.MaxInt64)
+		state.addInteger(1)
+	case isInteger:
+		state.addInteger(-integer)
+	default:
+		state.addReal(-realValue(value))
+	}
+}
+
+// approximate moves the integer sum to the sum of reals, with the bits of a large one that don't fit in a
+// real on the error
+func (state *aggregateState) approximate() {
+	state.Approx = true
+	state.Real, state.Error = float64(state.Sum), 0
+	if state.Sum <= -1<<52 || state.Sum >= 1<<52 {
+		small := state.Sum % 16384
+		state.Real, state.Error = float64(state.Sum-small), float64(small)
+	}
+}
+
+// addInteger adds an integer to the sum of reals. The large ones are added in two parts, so that the error
+// keeps the bits that don't fit in a real.
+func (state *aggregateState) addInteger(value int64) {
+	if value <= -1<<52 || value >= 1<<52 {
+		small := value % 16384
+		state.addReal(float64(value - small))
+		state.addReal(float64(small))
+		return
+	}
+	state.addReal(float64(value))
+}
+
+// addReal adds a real to the sum with the Kahan-Babuska-Neumaier summation
+func (state *aggregateState) addReal(value float64) {
+	sum := state.Real + value
+	if math.Abs(state.Real) > math.Abs(value) {
+		state.Error += (state.Real - sum) + value
+	} else {
+		state.Error += (value - sum) + state.Real
+	}
+	state.Real = sum
+}
+
+// realSum is the sum of reals corrected by the error of the additions
+func (state *aggregateState) realSum() float64 {
+	if !state.Approx {
+		return float64(state.Sum)
+	}
+	if math.IsInf(state.Error, 0) || math.IsNaN(state.Error) {
+		return state.Real
+	}
+	return state.Real + state.Error
+}
+
+// inverse removes a row added before from the result, for the rows leaving the frame of a window. MIN
+// and MAX can't forget a row, so they are computed again for each frame.
+func (state *aggregateState) inverse(expr *Expr, row []any) error {
+	if expr.Filter != nil {
+		if passed, err := allTrue([]*Expr{expr.Filter}, row); err != nil || !passed {
+			return err
+		}
+	}
+	if isStar(expr.Args[0]) {
+		state.Count--
+		return nil
+	}
+	if strings.HasPrefix(expr.Name, "JSON_GROUP_") {
+		// the first row added is the first member
+		if expr.Name == "JSON_GROUP_OBJECT" {
+			label, err := evalExpr(expr.Args[0], row)
+			if err != nil || label == nil {
+				return err
+			}
+			state.JSON.Labels = state.JSON.Labels[1:]
+		}
+		state.JSON.Children = state.JSON.Children[1:]
+		return nil
+	}
+	value, err := evalExpr(expr.Args[0], row)
+	if err != nil || value == nil {
+		return err
+	}
+	state.Count--
+	switch expr.Name {
+	case "SUM", "TOTAL", "AVG":
+		state.remove(numericAffinity(value))
+	case "GROUP_CONCAT", "STRING_AGG":
+		// the first text added is the first one, and the separator after it is the one of the next text
+		state.Texts, state.Separators = state.Texts[1:], state.Separators[1:]
+	}
+	return nil
+}
+
+// hasInverse tells if the aggregate can remove the rows leaving the frame of a window
+func hasInverse(expr *Expr) bool {
+	return expr.Name != "MIN" && expr.Name != "MAX"
+}
+
+// result is the value of the aggregate after all the rows were added. SUM is NULL without values, and an
+// error when its integers overflow, while TOTAL is always a real.
+func (state *aggregateState) result(expr *Expr) (any, error) {
+	switch expr.Name {
+	case "COUNT":
+		return state.Count, nil
+	case "SUM":
+		switch {
+		case state.Count == 0:
+			return nil, nil
+		case state.Overflow:
+			return nil, fmt.Errorf("integer overflow")
+		case state.Approx:
+			return state.realSum(), nil
+		}
+		return state.Sum, nil
+	case "TOTAL":
+		return state.realSum(), nil
+	case "AVG":
+		if state.Count == 0 {
+			return nil, nil
+		}
+		return state.realSum() / float64(state.Count), nil
+	case "GROUP_CONCAT", "STRING_AGG":
+		if len(state.Texts) == 0 {
+			return nil, nil
+		}
+		var builder strings.Builder
+		for i, text := range state.Texts {
+			if i > 0 {
+				builder.WriteString(state.Separators[i])
+			}
+			builder.WriteString(text)
+		}
+		return builder.String(), nil
+	case "JSON_GROUP_ARRAY":
+		if state.JSON == nil {
+			return "[]", nil
+		}
+		return state.JSON.String(), nil
+	case "JSON_GROUP_OBJECT":
+		if state.JSON == nil {
+			return "{}", nil
+		}
+		return state.JSON.String(), nil
+	}
+	return state.Value, nil
+}
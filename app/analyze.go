@@ -0,0 +1,377 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// SpaceUsage has the space used by a single table or index b-tree. The fields are the same
+// ones from the space_used table generated by sqlite3_analyzer.
+type SpaceUsage struct {
+	Name            string
+	TableName       string
+	IsIndex         bool
+	IsWithoutRowid  bool
+	Entries         int // cells on all pages
+	LeafEntries     int // cells on leaf pages
+	Depth           int
+	Payload         int64
+	OverflowPayload int64 // part of the payload stored on overflow pages
+	OverflowEntries int   // cells that use overflow pages
+	MaxPayload      int64
+	InteriorPages   int
+	LeafPages       int
+	OverflowPages   int
+	InteriorUnused  int64
+	LeafUnused      int64
+	OverflowUnused  int64
+	GapCount        int     // pages not stored right after the previous page of the same b-tree
+	Errors          []error // pages that could not be read or are not b-tree pages
+	lastPage        int
+}
+
+func (usage *SpaceUsage) TotalPages() int {
+	return usage.InteriorPages + usage.LeafPages + usage.OverflowPages
+}
+
+func (usage *SpaceUsage) TotalUnused() int64 {
+	return usage.InteriorUnused + usage.LeafUnused + usage.OverflowUnused
+}
+
+// AnalyzeSpaceUsage walks the b-trees of every table and index in the database
+func (db *DbContext) AnalyzeSpaceUsage() []*SpaceUsage {
+	usages := []*SpaceUsage{}
+	schemaUsage := &SpaceUsage{Name: "sqlite_schema", TableName: "sqlite_schema"}
+	db.walkSpaceUsage(1, 1, schemaUsage)
+	usages = append(usages, schemaUsage)
+	for _, entry := range db.Schema {
+		if entry.RootPage <= 0 || (entry.Type != "table" && entry.Type != "index") {
+			continue
+		}
+		usage := &SpaceUsage{Name: entry.Name, TableName: entry.TableName, IsIndex: entry.Type == "index"}
+		if page, err := db.readPage(entry.RootPage); err == nil {
+			header, _ := db.parsePageHeader(entry.RootPage, page)
+			usage.IsWithoutRowid = !usage.IsIndex && (header.PageType == 0x02 || header.PageType == 0x0a)
+		}
+		db.walkSpaceUsage(entry.RootPage, 1, usage)
+		usages = append(usages, usage)
+	}
+	return usages
+}
+
+func (db *DbContext) walkSpaceUsage(pageNumber int, depth int, usage *SpaceUsage) {
+	page, err := db.readPage(pageNumber)
+	var header PageHeader
+	if err == nil {
+		header, err = db.parsePageHeader(pageNumber, page)
+	}
+	if err != nil {
+		usage.Errors = append(usage.Errors, err)
+		return
+	}
+	usage.countPage(pageNumber)
+	usage.Depth = max(usage.Depth, depth)
+
+	unused := int64(header.UnallocatedRegionSize) + int64(header.FragmentedFreeBytes) + int64(freeblocksSize(header, page))
+	interior := header.PageType == 0x02 || header.PageType == 0x05
+	if interior {
+		usage.InteriorPages++
+		usage.InteriorUnused += unused
+	} else {
+		usage.LeafPages++
+		usage.LeafUnused += unused
+		usage.LeafEntries += int(header.CellCount)
+	}
+	usage.Entries += int(header.CellCount)
+
+	for _, offset := range getCellOffsets(header, page) {
+		cell, err := db.parseCell(header, page, offset)
+		if err != nil {
+			continue
+		}
+		usage.Payload += cell.PayloadSize
+		usage.MaxPayload = max(usage.MaxPayload, cell.PayloadSize)
+		if cell.OverflowPage != 0 {
+			usage.OverflowEntries++
+			remaining := cell.PayloadSize - int64(len(cell.LocalPayload))
+			usage.OverflowPayload += remaining
+			for overflowPage := int(cell.OverflowPage); overflowPage != 0; {
+				next, data, err := db.getOverflowPage(overflowPage)
+				if err != nil {
+					break
+				}
+				usage.countPage(overflowPage)
+				usage.OverflowPages++
+				used := min(int64(len(data)), remaining)
+				usage.OverflowUnused += int64(len(data)) - used
+				remaining -= used
+				overflowPage = next
+			}
+		}
+		if cell.LeftChildPage != 0 {
+			db.walkSpaceUsage(int(cell.LeftChildPage), depth+1, usage)
+		}
+	}
+	if interior {
+		db.walkSpaceUsage(int(header.RightMostPointer), depth+1, usage)
+	}
+}
+
+func (usage *SpaceUsage) countPage(pageNumber int) {
+	if usage.lastPage != 0 && pageNumber != usage.lastPage+1 {
+		usage.GapCount++
+	}
+	usage.lastPage = pageNumber
+}
+
+// freeblocksSize adds up the size of all the freeblocks on the page
+func freeblocksSize(header PageHeader, page []byte) (size int) {
+	visited := map[int]bool{}
+	for freeblock := int(header.FirstFreeBlock); freeblock != 0 && freeblock+4 <= len(page) && !visited[freeblock]; {
+		visited[freeblock] = true
+		size += int(readBigEndianUint16(page[freeblock+2:]))
+		freeblock = int(readBigEndianUint16(page[freeblock:]))
+	}
+	return
+}
+
+// freelistPages follows the freelist trunk pages, returning the trunk and leaf page counts
+func (db *DbContext) freelistPages() (trunkPages, leafPages int) {
+	visited := map[int]bool{}
+	for trunk := int(db.Info.FirstFreeListPage); trunk != 0 && !visited[trunk]; {
+		visited[trunk] = true
+		page, err := db.readPage(trunk)
+		if err != nil {
+			break
+		}
+		trunkPages++
+		leafPages += int(readBigEndianUint32(page[4:8]))
+		trunk = int(readBigEndianUint32(page[0:4]))
+	}
+	return
+}
+
+// autovacuumPages counts the pointer map pages used by auto-vacuum databases
+func (db *DbContext) autovacuumPages(pageCount int) (count int) {
+	if db.Info.AutovacuumTopRoot == 0 {
+		return 0
+	}
+	// each pointer map page has 5 bytes entries for the pages that come after it
+	entriesPerPage := int(db.Info.UsablePageSize) / 5
+	for pageNumber := 2; pageNumber <= pageCount; pageNumber += entriesPerPage + 1 {
+		count++
+	}
+	return
+}
+
+// PrintSpaceUsage writes a disk space utilization report similar to the one from sqlite3_analyzer
+func (db *DbContext) PrintSpaceUsage(writer io.Writer) {
+	usages := db.AnalyzeSpaceUsage()
+	pageSize := db.Info.DatabasePageSize
+	pageCount := int(db.Info.DatabasePageCount)
+	if stat, err := db.File.Stat(); err == nil {
+		pageCount = int(stat.Size() / int64(pageSize))
+	}
+	trunkPages, leafPages := db.freelistPages()
+	freelistPages := trunkPages + leafPages
+	autovacuumPages := db.autovacuumPages(pageCount)
+
+	total := &SpaceUsage{}
+	tableCount, indexCount := 0, 0
+	for _, usage := range usages {
+		total.add(usage)
+		if usage.IsIndex {
+			indexCount++
+		} else {
+			tableCount++
+		}
+	}
+	percent := func(pages int) string {
+		if pageCount == 0 {
+			return ""
+		}
+		return fmt.Sprintf("%.1f%%", 100*float64(pages)/float64(pageCount))
+	}
+
+	fmt.Fprintf(writer, "/** Disk-Space Utilization Report For %s\n\n", db.File.Name())
+	printReportLine(writer, "Page size in bytes", pageSize, "")
+	printReportLine(writer, "Pages in the whole file (measured)", pageCount, "")
+	printReportLine(writer, "Pages in the whole file (calculated)", total.TotalPages()+freelistPages+autovacuumPages, "")
+	printReportLine(writer, "Pages that store data", total.TotalPages(), percent(total.TotalPages()))
+	printReportLine(writer, "Pages on the freelist (per header)", db.Info.FreelistPageCount, percent(int(db.Info.FreelistPageCount)))
+	printReportLine(writer, "Pages on the freelist (calculated)", freelistPages, percent(freelistPages))
+	printReportLine(writer, "Freelist trunk pages", trunkPages, "")
+	printReportLine(writer, "Pages of auto-vacuum overhead", autovacuumPages, percent(autovacuumPages))
+	printReportLine(writer, "Number of tables in the database", tableCount, "")
+	printReportLine(writer, "Number of indices", indexCount, "")
+	printReportLine(writer, "Size of the file in bytes", pageCount*pageSize, "")
+	printReportLine(writer, "Bytes of user payload stored", total.Payload, fmt.Sprintf("%.1f%%", 100*float64(total.Payload)/float64(max(1, pageCount*pageSize))))
+
+	// tables grouped with their indexes, largest first
+	groups := map[string]*SpaceUsage{}
+	groupNames := []string{}
+	for _, usage := range usages {
+		group, ok := groups[strings.ToLower(usage.TableName)]
+		if !ok {
+			group = &SpaceUsage{Name: usage.TableName}
+			groups[strings.ToLower(usage.TableName)] = group
+			groupNames = append(groupNames, strings.ToLower(usage.TableName))
+		}
+		group.add(usage)
+	}
+	slices.SortStableFunc(groupNames, func(a, b string) int {
+		return groups[b].TotalPages() - groups[a].TotalPages()
+	})
+	printReportTitle(writer, "Page counts for all tables with their indices")
+	for _, name := range groupNames {
+		printReportLine(writer, strings.ToUpper(groups[name].Name), groups[name].TotalPages(), percent(groups[name].TotalPages()))
+	}
+
+	sorted := slices.Clone(usages)
+	slices.SortStableFunc(sorted, func(a, b *SpaceUsage) int {
+		return b.TotalPages() - a.TotalPages()
+	})
+	printReportTitle(writer, "Page counts for all tables and indices separately")
+	for _, usage := range sorted {
+		printReportLine(writer, strings.ToUpper(usage.Name), usage.TotalPages(), percent(usage.TotalPages()))
+	}
+
+	printReportTitle(writer, "All tables and indices")
+	total.print(writer, pageSize, percent)
+	for _, usage := range usages {
+		kind := "Table"
+		if usage.IsIndex {
+			kind = "Index"
+		}
+		printReportTitle(writer, fmt.Sprintf("%s %s", kind, strings.ToUpper(usage.Name)))
+		usage.print(writer, pageSize, percent)
+	}
+	fmt.Fprintln(writer, "\n**********************************************************************************")
+	fmt.Fprintln(writer, "The entire text of this report can be sourced into any SQL database")
+	fmt.Fprintln(writer, "engine for further analysis.  All of the text above is an SQL comment.")
+	fmt.Fprintln(writer, "The data used to generate this report follows:")
+	fmt.Fprintln(writer, "*/")
+	db.printSpaceUsageSQL(writer, usages)
+}
+
+// PrintSpaceUsageSQL writes the space used by each table and index as SQL for a space_used table
+func (db *DbContext) PrintSpaceUsageSQL(writer io.Writer) {
+	db.printSpaceUsageSQL(writer, db.AnalyzeSpaceUsage())
+}
+
+func (db *DbContext) printSpaceUsageSQL(writer io.Writer, usages []*SpaceUsage) {
+	fmt.Fprintln(writer, "BEGIN;")
+	fmt.Fprintln(writer, `CREATE TABLE space_used(
+   name clob,        -- Name of a table or index in the database file
+   tblname clob,     -- Name of associated table
+   is_index boolean, -- TRUE if it is an index, false for a table
+   is_without_rowid boolean, -- TRUE if WITHOUT ROWID table
+   nentry int,       -- Number of entries in the BTree
+   leaf_entries int, -- Number of leaf entries
+   depth int,        -- Depth of the b-tree
+   payload int,      -- Total amount of data stored in this table or index
+   ovfl_payload int, -- Total amount of data stored on overflow pages
+   ovfl_cnt int,     -- Number of entries that use overflow
+   mx_payload int,   -- Maximum payload size
+   int_pages int,    -- Number of interior pages used
+   leaf_pages int,   -- Number of leaf pages used
+   ovfl_pages int,   -- Number of overflow pages used
+   int_unused int,   -- Number of unused bytes on interior pages
+   leaf_unused int,  -- Number of unused bytes on primary pages
+   ovfl_unused int,  -- Number of unused bytes on overflow pages
+   gap_cnt int,      -- Number of gaps in the page layout
+   compressed_size int  -- Total bytes stored on disk
+);`)
+	for _, usage := range usages {
+		values := []any{usage.Name, usage.TableName, boolToInt(usage.IsIndex), boolToInt(usage.IsWithoutRowid),
+			usage.Entries, usage.LeafEntries, usage.Depth, usage.Payload, usage.OverflowPayload, usage.OverflowEntries,
+			usage.MaxPayload, usage.InteriorPages, usage.LeafPages, usage.OverflowPages, usage.InteriorUnused,
+			usage.LeafUnused, usage.OverflowUnused, usage.GapCount, usage.TotalPages() * db.Info.DatabasePageSize}
+		literals := []string{}
+		for _, value := range values {
+			switch v := value.(type) {
+			case int:
+				value = int64(v)
+			}
+			literals = append(literals, quoteLiteral(value))
+		}
+		fmt.Fprintf(writer, "INSERT INTO space_used VALUES(%s);\n", strings.Join(literals, ","))
+	}
+	fmt.Fprintln(writer, "COMMIT;")
+}
+
+func (usage *SpaceUsage) add(other *SpaceUsage) {
+	usage.Entries += other.Entries
+	usage.LeafEntries += other.LeafEntries
+	usage.Depth = max(usage.Depth, other.Depth)
+	usage.Payload += other.Payload
+	usage.OverflowPayload += other.OverflowPayload
+	usage.OverflowEntries += other.OverflowEntries
+	usage.MaxPayload = max(usage.MaxPayload, other.MaxPayload)
+	usage.InteriorPages += other.InteriorPages
+	usage.LeafPages += other.LeafPages
+	usage.OverflowPages += other.OverflowPages
+	usage.InteriorUnused += other.InteriorUnused
+	usage.LeafUnused += other.LeafUnused
+	usage.OverflowUnused += other.OverflowUnused
+	usage.GapCount += other.GapCount
+	usage.Errors = append(usage.Errors, other.Errors...)
+}
+
+func (usage *SpaceUsage) print(writer io.Writer, pageSize int, percent func(int) string) {
+	entries := usage.LeafEntries
+	if usage.IsIndex {
+		entries = usage.Entries
+	}
+	storage := usage.TotalPages() * pageSize
+	printReportLine(writer, "Percentage of total database", percent(usage.TotalPages()), "")
+	printReportLine(writer, "Number of entries", entries, "")
+	printReportLine(writer, "Bytes of storage consumed", storage, "")
+	printReportLine(writer, "Bytes of payload", usage.Payload, fmt.Sprintf("%.1f%%", 100*float64(usage.Payload)/float64(max(1, storage))))
+	printReportLine(writer, "B-tree depth", usage.Depth, "")
+	if entries > 0 {
+		printReportLine(writer, "Average payload per entry", fmt.Sprintf("%.2f", float64(usage.Payload)/float64(entries)), "")
+		printReportLine(writer, "Average unused bytes per entry", fmt.Sprintf("%.2f", float64(usage.TotalUnused())/float64(entries)), "")
+	}
+	if usage.InteriorPages > 0 {
+		// every interior page has one child more than its number of cells
+		fanout := float64(usage.Entries-usage.LeafEntries+usage.InteriorPages) / float64(usage.InteriorPages)
+		printReportLine(writer, "Average fanout", fmt.Sprintf("%.2f", fanout), "")
+	}
+	printReportLine(writer, "Non-sequential pages", usage.GapCount, fmt.Sprintf("%.1f%%", 100*float64(usage.GapCount)/float64(max(1, usage.TotalPages()-1))))
+	printReportLine(writer, "Maximum payload per entry", usage.MaxPayload, "")
+	printReportLine(writer, "Entries that use overflow", usage.OverflowEntries, fmt.Sprintf("%.1f%%", 100*float64(usage.OverflowEntries)/float64(max(1, entries))))
+	if usage.InteriorPages > 0 {
+		printReportLine(writer, "Index pages used", usage.InteriorPages, "")
+	}
+	printReportLine(writer, "Primary pages used", usage.LeafPages, "")
+	printReportLine(writer, "Overflow pages used", usage.OverflowPages, "")
+	printReportLine(writer, "Total pages used", usage.TotalPages(), "")
+	if usage.InteriorPages > 0 {
+		printReportLine(writer, "Unused bytes on index pages", usage.InteriorUnused, fmt.Sprintf("%.1f%%", 100*float64(usage.InteriorUnused)/float64(usage.InteriorPages*pageSize)))
+	}
+	printReportLine(writer, "Unused bytes on primary pages", usage.LeafUnused, fmt.Sprintf("%.1f%%", 100*float64(usage.LeafUnused)/float64(max(1, usage.LeafPages*pageSize))))
+	printReportLine(writer, "Unused bytes on overflow pages", usage.OverflowUnused, fmt.Sprintf("%.1f%%", 100*float64(usage.OverflowUnused)/float64(max(1, usage.OverflowPages*pageSize))))
+	printReportLine(writer, "Unused bytes on all pages", usage.TotalUnused(), fmt.Sprintf("%.1f%%", 100*float64(usage.TotalUnused())/float64(max(1, storage))))
+	for _, err := range usage.Errors {
+		printReportLine(writer, "Damaged page", err, "")
+	}
+}
+
+func printReportTitle(writer io.Writer, title string) {
+	fmt.Fprintf(writer, "\n*** %s %s\n\n", title, strings.Repeat("*", max(3, 75-len(title))))
+}
+
+func printReportLine(writer io.Writer, label string, value any, extra string) {
+	line := fmt.Sprintf("%s%s %-10v %s", label, strings.Repeat(".", max(3, 50-len(label))), value, extra)
+	fmt.Fprintln(writer, strings.TrimRight(line, " "))
+}
+
+func boolToInt(value bool) int64 {
+	if value {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestAnalyzeSpaceUsage(t *testing.T) {
+	db := NewDbContext("../superheroes.db")
+	defer db.Close()
+
+	// expected values taken from the dbstat virtual table
+	var usage *SpaceUsage
+	for _, u := range db.AnalyzeSpaceUsage() {
+		if u.Name == "superheroes" {
+			usage = u
+		}
+	}
+	if usage == nil {
+		t.Fatalf("missing space usage for table: %q", "superheroes")
+	}
+	tests := []struct {
+		field            string
+		expected, result int64
+	}{
+		{"entries", 7003, int64(usage.Entries)},
+		{"leaf entries", 6895, int64(usage.LeafEntries)},
+		{"depth", 2, int64(usage.Depth)},
+		{"interior pages", 1, int64(usage.InteriorPages)},
+		{"leaf pages", 109, int64(usage.LeafPages)},
+		{"payload", 406794, usage.Payload},
+		{"max payload", 90, usage.MaxPayload},
+		{"unused", 7672, usage.TotalUnused()},
+	}
+	for _, test := range tests {
+		if test.result != test.expected {
+			t.Errorf("expected %s: %d - got: %d\n", test.field, test.expected, test.result)
+		}
+	}
+}
+
+func TestAnalyzeSpaceUsageWithDamagedPage(t *testing.T) {
+	db := NewDbContext("testdata/recover.db")
+	defer db.Close()
+
+	for _, usage := range db.AnalyzeSpaceUsage() {
+		if usage.Name != "items" {
+			continue
+		}
+		if len(usage.Errors) != 1 || usage.Errors[0].Error() != "page 9 has invalid type: 255" {
+			t.Errorf("expected the error of the damaged page - got: %v", usage.Errors)
+		}
+		if usage.LeafPages != 2 || usage.LeafEntries != 241 {
+			t.Errorf("expected the pages that can be read - got: %d leaf pages, %d entries", usage.LeafPages, usage.LeafEntries)
+		}
+	}
+}
+
+func TestPrintSpaceUsage(t *testing.T) {
+	db := NewDbContext("../superheroes.db")
+	defer db.Close()
+
+	result := new(bytes.Buffer)
+	db.PrintSpaceUsage(result)
+	expected := []string{
+		"Pages in the whole file (measured)................ 307",
+		"Pages on the freelist (calculated)................ 195        63.5%",
+		"SUPERHEROES....................................... 110        35.8%",
+		"INSERT INTO space_used VALUES('superheroes','superheroes',0,0,7003,6895,2,406794,",
+	}
+	for _, text := range expected {
+		if !strings.Contains(result.String(), text) {
+			t.Errorf("result does not contain text: %q", text)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import "strings"
+
+// collations compare texts by the upper case name of the collation. BINARY compares the bytes, NOCASE
+// ignores the case of ASCII letters and RTRIM ignores trailing spaces.
+var collations = map[string]func(a, b string) int{
+	"BINARY": strings.Compare,
+	"NOCASE": func(a, b string) int {
+		return strings.Compare(lowerASCII(a), lowerASCII(b))
+	},
+	"RTRIM": func(a, b string) int {
+		return strings.Compare(strings.TrimRight(a, " "), strings.TrimRight(b, " "))
+	},
+}
+
+// collationKeys convert texts to keys that are the same for the texts that a collation finds equal, for
+// the collations that have one
+var collationKeys = map[string]func(text string) string{
+	"BINARY": func(text string) string { return text },
+	"NOCASE": lowerASCII,
+	"RTRIM":  func(text string) string { return strings.TrimRight(text, " ") },
+}
+
+// RegisterCollation adds a collation that can be used by COLLATE and by the tables and indexes that name
+// it, or replaces the one with the same name. The name is not case sensitive. Collations must be registered
+// before running the queries that use them.
+func RegisterCollation(name string, compare func(a, b string) int) {
+	collations[strings.ToUpper(name)] = compare
+	delete(collationKeys, strings.ToUpper(name))
+}
+
+// findCollation finds the comparison function of a collation, or nil when there is no such collation
+func findCollation(name string) func(a, b string) int {
+	if compare, found := collations[name]; found {
+		return compare
+	}
+	return collations[strings.ToUpper(name)]
+}
+
+// findCollationKey finds the function giving the keys of the texts for a collation, where no collation
+// is BINARY, or nil when the collation has none
+func findCollationKey(name string) func(text string) string {
+	if name == "" {
+		name = "BINARY"
+	}
+	return collationKeys[strings.ToUpper(name)]
+}
+
+// exprCollation finds the collation of an expression, telling if it was given by the COLLATE operator.
+// Columns have the collation of their definition, and other expressions have none.
+func exprCollation(expr *Expr) (collation string, explicit bool) {
+	switch expr.Op {
+	case "COLLATE":
+		return expr.Name, true
+	case "column":
+		return expr.Collation, false
+	}
+	return "", false
+}
+
+// comparisonCollation finds the collation used to compare two expressions: the one given by the COLLATE
+// operator on the left or on the right operand, then the one of the left or of the right column, and
+// BINARY when none of them has one
+func comparisonCollation(left, right *Expr) string {
+	leftCollation, leftExplicit := exprCollation(left)
+	rightCollation, rightExplicit := exprCollation(right)
+	switch {
+	case leftExplicit:
+		return leftCollation
+	case rightExplicit:
+		return rightCollation
+	case leftCollation != "":
+		return leftCollation
+	case rightCollation != "":
+		return rightCollation
+	}
+	return "BINARY"
+}
+
+// orderCollation is the collation used to sort by an expression
+func orderCollation(expr *Expr) string {
+	if collation, _ := exprCollation(expr); collation != "" {
+		return collation
+	}
+	return "BINARY"
+}
+
+// skipCollate finds the expression under the COLLATE operators
+func skipCollate(expr *Expr) *Expr {
+	for expr.Op == "COLLATE" {
+		expr = expr.Args[0]
+	}
+	return expr
+}
@@ -69,179 +69,116 @@ func (db *DbContext) PrintSchema(writer io.Writer) {
 	}
 }
 
+// HandleSelect runs a query, with the values of its parameters taken from the ones set with .parameter
 func (db *DbContext) HandleSelect(query string, writer io.Writer) error {
-
-	queryTableName, queryColumnNames, filterColumnName, filterValue, err := parseSelectStatement(query)
+	statement, err := db.Prepare(query)
 	if err != nil {
 		return err
 	}
-
-	rootPage := 0
-	var tableColumns []ColumnDef
-
-	if strings.EqualFold(queryTableName, "sqlite_schema") || strings.EqualFold(queryTableName, "sqlite_master") {
-		rootPage = 1
-		// sqlite_schema has no table definition - this is the one from the docs: https://www.sqlite.org/fileformat.html#storage_of_the_sql_database_schema
-		_, tableColumns, _, _ = parseCreateTable("CREATE TABLE sqlite_schema(type text, name text, tbl_name text, rootpage integer, sql text);")
-	}
-
-	for _, entry := range db.Schema {
-		if entry.Type == "table" && strings.EqualFold(queryTableName, entry.Name) {
-			rootPage = entry.RootPage
-			tableColumns = entry.Columns
-			if debugMode {
-				fmt.Printf("select table entry: %#v\n", entry)
+	for number := 1; number <= statement.ParameterCount(); number++ {
+		name := statement.ParameterName(number)
+		if name == "" {
+			name = fmt.Sprintf("?%d", number)
+		}
+		if value, found := db.parameters[name]; found {
+			if err = statement.Bind(number, value); err != nil {
+				return err
 			}
-			break
 		}
 	}
+	return statement.Execute(writer)
+}
 
-	if rootPage == 0 {
-		return fmt.Errorf("no such table: %s", queryTableName)
+// SetParameter gives a value to the parameters with the name on the next queries. The value is an SQL
+// literal, and text that isn't one is used as a string.
+func (db *DbContext) SetParameter(name string, valueText string) error {
+	if name == "" || !strings.ContainsRune("?:@$", rune(name[0])) {
+		return fmt.Errorf("parameter should start with one of: $ : @ ?")
 	}
-
-	queryColumnNumbers := []int{}
-
-	countingOnly := strings.EqualFold(queryColumnNames[0], "COUNT(*)")
-
-	// use a fast count if no filter is used to avoid processing all data
-	if countingOnly {
-		if filterColumnName == "" {
-			rowCount := db.fastCountRows(rootPage)
-			fmt.Fprintln(writer, rowCount)
-			return nil
-		}
-	} else {
-		// replace "*" with the names for the table columns
-		if len(queryColumnNames) == 1 && queryColumnNames[0] == "*" {
-			for number := range tableColumns {
-				queryColumnNumbers = append(queryColumnNumbers, number)
-			}
-		} else {
-			// translate the column names from the query to the column numbers
-			for _, queryColumnName := range queryColumnNames {
-				found := false
-				for number, column := range tableColumns {
-					if strings.EqualFold(queryColumnName, column.Name) {
-						queryColumnNumbers = append(queryColumnNumbers, number)
-						found = true
-						break
-					}
-				}
-				if !found {
-					return fmt.Errorf("no such column: %s", queryColumnName)
-				}
-			}
+	var value any = valueText
+	if expr, err := parseExpr(NewTokenizer(valueText)); err == nil && isConstant(expr) {
+		if constant, err := evalExpr(expr, nil); err == nil {
+			value = constant
 		}
 	}
-
-	// integer primary keys are stored as null and aliased with the rowid
-	aliasedPKColumnNumber := -1
-outer:
-	for columnNumber, columnDef := range tableColumns {
-		if strings.EqualFold(columnDef.Type, "INTEGER") && len(columnDef.Constraints) > 0 {
-			for _, constraint := range columnDef.Constraints {
-				if strings.Contains(strings.ToUpper(constraint), "PRIMARY KEY") {
-					aliasedPKColumnNumber = columnNumber
-					break outer
-				}
-			}
-		}
+	if db.parameters == nil {
+		db.parameters = map[string]any{}
 	}
+	db.parameters[name] = value
+	return nil
+}
 
-	filterColumnNumber := -1
-	filterIndexPage := -1
-	indexSortOrder := 1
-	if filterColumnName == "rowid" {
-		filterColumnNumber = aliasedPKColumnNumber
-	} else if filterColumnName != "" {
-		found := false
-		for number, column := range tableColumns {
-			if strings.EqualFold(filterColumnName, column.Name) {
-				filterColumnNumber = number
-				found = true
-				break
-			}
-		}
-		if !found {
-			return fmt.Errorf("no such column: %s", filterColumnName)
-		}
-
-		for _, entry := range db.Schema {
-			if entry.Type == "index" && strings.EqualFold(queryTableName, entry.TableName) &&
-				len(entry.Columns) > 0 && strings.EqualFold(filterColumnName, entry.Columns[0].Name) {
-				filterIndexPage = entry.RootPage
-				if strings.EqualFold(entry.Columns[0].Type, "DESC") {
-					indexSortOrder = -1
-				}
-				break
-			}
-		}
+// UnsetParameter removes the value of a parameter, or of all of them when the name is empty
+func (db *DbContext) UnsetParameter(name string) {
+	if name == "" {
+		clear(db.parameters)
+	}
+	delete(db.parameters, name)
+}
 
-		// "without rowid" table?
-		if filterIndexPage == -1 {
-			for _, constraint := range tableColumns[filterColumnNumber].Constraints {
-				if strings.Contains(strings.ToUpper(constraint), "PRIMARY KEY") {
-					filterIndexPage = rootPage
-					break
-				}
-			}
-		}
+// PrintParameters lists the values of the parameters as SQL literals, sorted by name
+func (db *DbContext) PrintParameters(writer io.Writer) {
+	names := []string{}
+	width := 0
+	for name := range db.parameters {
+		names = append(names, name)
+		width = max(width, len(name))
 	}
+	slices.Sort(names)
+	for _, name := range names {
+		fmt.Fprintf(writer, "%-*s %s\n", width, name, quoteLiteral(db.parameters[name]))
+	}
+}
 
-	var tableData []TableRecord
-	if filterIndexPage == -1 {
-		tableData = db.fullTableScan(rootPage)
-	} else {
-		if filterColumnNumber == aliasedPKColumnNumber {
-			row := db.getRecordByRowid(rootPage, filterValue.(int64))
-			if row != nil {
-				tableData = append(tableData, *row)
-			}
-		} else if filterIndexPage == rootPage {
-			row := db.getRecordByPK(rootPage, filterColumnNumber, filterValue)
-			if row != nil {
-				tableData = append(tableData, *row)
-			}
-		} else {
-			tableData = db.indexedTableScan(rootPage, filterIndexPage, filterValue, indexSortOrder)
+// writeRow prints the values of a row separated by "|"
+func writeRow(writer io.Writer, values []any) {
+	for i, data := range values {
+		if i > 0 {
+			fmt.Fprint(writer, "|")
 		}
+		fmt.Fprint(writer, textValue(data))
 	}
+	fmt.Fprintln(writer)
+}
 
-	rowCount := 0
-	for _, tableRow := range tableData {
-		if aliasedPKColumnNumber >= 0 {
-			tableRow.Columns[aliasedPKColumnNumber] = tableRow.Rowid
-		}
-		if filterColumnNumber >= 0 && compareAny(tableRow.Columns[filterColumnNumber], filterValue) != 0 {
-			continue
-		}
-		if countingOnly {
-			rowCount++
+// expandColumns replaces "*" and "table.*" with the columns of the tables, leaving out the columns of
+// USING that were already shown for a table before, and binds the expressions of the result columns. The
+// tables of the queries around a subquery are not expanded.
+func expandColumns(columns []ResultColumn, sources []tableSource) ([]ResultColumn, error) {
+	resultColumns := []ResultColumn{}
+	for _, column := range columns {
+		if !isStar(column.Expr) {
+			if err := bindColumns(column.Expr, sources); err != nil {
+				return nil, err
+			}
+			resultColumns = append(resultColumns, column)
 			continue
 		}
-		for i, columnNumber := range queryColumnNumbers {
-			if i > 0 {
-				fmt.Fprint(writer, "|")
-			}
-			var data any
-			if columnNumber < len(tableRow.Columns) {
-				data = tableRow.Columns[columnNumber]
+		found := false
+		for _, source := range sources {
+			if source.Depth > 0 || column.Expr.Table != "" && !strings.EqualFold(column.Expr.Table, source.Name) {
+				continue
 			}
-			// TODO: Implement default value (https://www.sqlite.org/lang_createtable.html#dfltval)
-
-			// BLOB conversion for displaying
-			if bytes, ok := data.([]byte); ok {
-				data = string(bytes)
+			found = true
+			for number, tableColumn := range source.Table.Columns {
+				if strings.EqualFold(tableColumn.Type, "HIDDEN") {
+					// like the arguments of a table-valued function
+					continue
+				}
+				if column.Expr.Table == "" && slices.ContainsFunc(source.Using, func(name string) bool {
+					return strings.EqualFold(name, tableColumn.Name)
+				}) {
+					continue
+				}
+				resultColumns = append(resultColumns, ResultColumn{
+					Expr:  &Expr{Op: "column", Name: tableColumn.Name, Column: source.Offset + number},
+					Alias: tableColumn.Name,
+				})
 			}
-			fmt.Fprint(writer, data)
 		}
-		fmt.Fprintln(writer)
-	}
-
-	if countingOnly {
-		fmt.Fprintln(writer, rowCount)
+		if !found {
+			return nil, fmt.Errorf("no such table: %s", column.Expr.Table)
+		}
 	}
-
-	return nil
+	return resultColumns, nil
 }
@@ -170,3 +170,820 @@ func TestRetrieveDataUsingAnIndex(t *testing.T) {
 		}
 	}
 }
+
+func TestFilterDataWithRanges(t *testing.T) {
+	db := NewDbContext("testdata/indexes.db")
+	defer db.Close()
+
+	tests := []struct{ query, expected string }{
+		{"select id from events where id between 10 and 13 and id <> 11", "10\n12\n13\n"},
+		{"select id from events where id in (7, 3, 3, 99999, NULL)", "3\n7\n"},
+		{"select id from events where rowid >= 2999.5", "3000\n3001\n3002\n"},
+		{"select id from events where 3 > id", "1\n2\n"},
+		{"select id from events where kind like 'KIND-9%'", "3002\n"},
+		{"select id from events where kind like 'kind-1%' and id < 20", "1\n8\n15\n"},
+		{"select id from events where kind > 'kind-6' and id < 20", ""},
+		{"select id from events where score between 10 and 10 and id < 400", "10\n110\n210\n310\n"},
+		{"select count(*) from events where score < 2", "45\n"},
+		{"select id from events where score = 5.5", "3002\n"},
+		{"select count(*) from events where score > 'a'", "1\n"},
+		{"select count(*) from events where kind is null", "60\n"},
+		{"select count(*) from events where score = NULL", "0\n"},
+	}
+
+	for _, test := range tests {
+		result := new(bytes.Buffer)
+		err := db.HandleSelect(test.query, result)
+		if err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+	}
+}
+
+func TestChooseAccessPath(t *testing.T) {
+	db := NewDbContext("testdata/indexes.db")
+	defer db.Close()
+
+	tests := []struct{ where, kind string }{
+		{"id > 5", "rowid"},
+		{"rowid = 5 and kind = 'kind-1'", "rowid"},
+		{"kind = 'kind-1'", "index"},
+		{"kind like 'kind%'", "index"},
+		{"score in (1, 2) and kind > 'a'", "index"},
+		{"kind like '%1'", "scan"},
+		{"score <> 5", "scan"},
+		{"score = '5'", "index"},
+		{"id = 5 or score = 5", "scan"},
+	}
+
+	for _, test := range tests {
+		statement, err := parseSelectStatement("select * from events where " + test.where)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := db.planSelect(statement, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := plan.Steps[0].Path
+		if path.Kind != test.kind {
+			t.Errorf("where: %s - expected access: %s - got: %s", test.where, test.kind, path.Kind)
+		}
+	}
+}
+
+func TestFilterDataWithCompositeKeys(t *testing.T) {
+	db := NewDbContext("testdata/indexes.db")
+	defer db.Close()
+
+	tests := []struct{ query, expected string }{
+		{"select id, day from readings where sensor = 'sensor-3' and day > 497", "4993|499\n4983|498\n"},
+		{"select id from readings where sensor in ('sensor-2', 'sensor-1') and day = 7", "71\n72\n"},
+		{"select * from stock where warehouse = 'w3' and item >= 298", "w3|298|1198\nw3|299|1199\n"},
+		{"select * from stock where warehouse in ('w1','w0') and item in (5, 3)", "w0|3|3\nw0|5|5\nw1|3|303\nw1|5|305\n"},
+		{"select count(*) from stock where warehouse between 'w18' and 'w2'", "900\n"},
+	}
+
+	for _, test := range tests {
+		result := new(bytes.Buffer)
+		err := db.HandleSelect(test.query, result)
+		if err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+	}
+}
+
+func TestFilterDataWithoutRowid(t *testing.T) {
+	db := NewDbContext("testdata/indexes.db")
+	defer db.Close()
+
+	tests := []struct{ query, expected string }{
+		{"select note, currency from prices where sku = 10", "price note 0032|BRL\nprice note 0031|EUR\nprice note 0030|USD\n"},
+		{"select sku, currency from prices where amount = 9.0 and sku < 200", "2|USD\n34|EUR\n99|USD\n131|EUR\n163|BRL\n196|USD\n"},
+		{"select count(*) from prices where amount between 10 and 20 and currency = 'USD'", "47\n"},
+		{"select note from prices where sku > 665", "price note 1999\nprice note 1998\n"},
+		{"select count(*) from prices where amount is null", "80\n"},
+	}
+
+	for _, test := range tests {
+		result := new(bytes.Buffer)
+		err := db.HandleSelect(test.query, result)
+		if err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+	}
+
+	if err := db.HandleSelect("select rowid from prices where sku = 1", new(bytes.Buffer)); err == nil {
+		t.Errorf("expected error selecting rowid from a table without rowid")
+	}
+}
+
+func TestFilterDataWithAutoindexes(t *testing.T) {
+	db := NewDbContext("testdata/autoindex.db")
+	defer db.Close()
+
+	tests := []struct{ query, expected string }{
+		{"select v from k where name = 'key-5'", "10\n"},
+		{"select count(*) from k where name like 'key-1%'", "111\n"},
+		{"select id from u where g = 'g-3' and f = 4", "34\n"},
+		{"select e from u where e > 'e-98' order by e", "e-99\n"},
+		{"select a, b, c from w where b = 17", "a-2|17|51\n"},
+	}
+
+	for _, test := range tests {
+		result := new(bytes.Buffer)
+		err := db.HandleSelect(test.query, result)
+		if err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+	}
+}
+
+func TestFilterDataWithPartialIndexes(t *testing.T) {
+	db := NewDbContext("testdata/partial.db")
+	defer db.Close()
+
+	// the partial indexes only have the rows where c > 1, and where c is not NULL
+	tests := []struct{ query, expected string }{
+		{"select count(*) from t where c < 2", "175\n"},
+		{"select a from t where c < 0.5 order by a limit 3", "1\n41\n81\n"},
+		{"select count(*) from t where c > 1 and c < 3", "150\n"},
+		{"select count(*) from t where b = 'b-7'", "20\n"},
+		{"select count(*) from t where b = 'b-7' and c > 0", "20\n"},
+		{"select b from t where b = 'b-7' and c = 1.75", "b-7\nb-7\nb-7\nb-7\nb-7\n"},
+		{"select quote(c) from t order by c limit 3", "NULL\nNULL\nNULL\n"},
+		{"select c from t where c is not null order by c limit 2", "0.25\n0.25\n"},
+		{"select distinct c from t where c < 1.5 order by c desc", "1.25\n1.0\n0.75\n0.5\n0.25\n"},
+		{"select c from t where c > 1 order by c desc limit 2", "9.75\n9.75\n"},
+		{"select b from t where c is not null order by b limit 2", "b-1\nb-1\n"},
+		// MIN, MAX and COUNT only read the first or last key, or count the keys, of an index with all the rows
+		{"select min(c), max(c), count(c), count(*) from t", "0.25|9.75|900|1000\n"},
+		{"select min(c) from t", "0.25\n"},
+		{"select count(c) from t", "900\n"},
+		{"select count(*) from t", "1000\n"},
+		{"select min(c) from t where c > 1", "1.25\n"},
+		{"select min(b) from t where c is not null", "b-1\n"},
+	}
+
+	for _, test := range tests {
+		result := new(bytes.Buffer)
+		err := db.HandleSelect(test.query, result)
+		if err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+	}
+}
+
+func TestTypeAffinity(t *testing.T) {
+	db := NewDbContext("testdata/indexes.db")
+	defer db.Close()
+
+	tests := []struct{ query, expected string }{
+		{"select id, kind from events where id = '5'", "5|kind-5\n"},
+		{"select count(*) from events where id between '3' and '5'", "3\n"},
+		{"select id from events where score = '5' and id < 300", "5\n105\n205\n"},
+		{"select value from readings where value = 1 and day < 5", "1.0\n"},
+		{"select sku from prices where sku in ('3', 4.0) and currency = 'EUR'", "3\n4\n"},
+		{"select count(*) from events e join readings r on e.kind = r.day", "0\n"},
+		{"select a.id, b.id from events a join events b on a.label = b.label collate nocase where a.id < 4", "1|1\n2|2\n3|3\n"},
+	}
+
+	for _, test := range tests {
+		result := new(bytes.Buffer)
+		err := db.HandleSelect(test.query, result)
+		if err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+	}
+}
+
+func TestCollations(t *testing.T) {
+	db := NewDbContext("testdata/collation.db")
+	defer db.Close()
+	RegisterCollation("reverse", func(a, b string) int { return strings.Compare(b, a) })
+
+	tests := []struct{ query, expected string }{
+		{"select id from users where name = 'USER-5'", "5\n405\n805\n1205\n1605\n"},
+		{"select count(*) from users where name collate binary = 'USER-5'", "1\n"},
+		{"select id from users where email = 'MAIL0002@EXAMPLE.COM' collate nocase", "2\n"},
+		{"select count(*) from users where email = 'MAIL0002@EXAMPLE.COM'", "0\n"},
+		{"select id from users where code = 'c005  ' and id < 300", "5\n255\n"},
+		{"select count(*) from users where name in ('USER-7', 'user-8', 'User-7')", "10\n"},
+		{"select count(*) from users where email like 'mail000%'", "9\n"},
+		{"select id, name from users where id < 8 order by name collate binary", "1|USER-1\n4|USER-4\n7|USER-7\n2|User-2\n5|User-5\n3|user-3\n6|user-6\n"},
+		{"select id from users where id < 5 order by email collate reverse", "4\n2\n3\n1\n"},
+		{"select id from users where email < 'mail0003' collate binary and id < 6", "1\n2\n3\n5\n"},
+		{"select min(name), max(email collate nocase) from users", "USER-0|mail2000@example.com\n"},
+		{"select 'a' = 'A' collate nocase, 'a ' = 'a' collate rtrim, 'b' collate nocase in ('B')", "1|1|1\n"},
+	}
+
+	for _, test := range tests {
+		result := new(bytes.Buffer)
+		err := db.HandleSelect(test.query, result)
+		if err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+	}
+
+	if err := db.HandleSelect("select * from users where name = 'x' collate unknown", new(bytes.Buffer)); err == nil {
+		t.Errorf("expected error using an unknown collation")
+	}
+}
+
+func TestDefaultValues(t *testing.T) {
+	db := NewDbContext("testdata/defaults.db")
+	defer db.Close()
+
+	tests := []struct{ query, expected string }{
+		{"select * from items", "1|first|5|none|-1.0|AB|1|\n2|second|5|none|-1.0|AB|1|\n3|third|7|some|2.5||0|x\n4|fourth|5|none|-1.0|AB|1|\n"},
+		{"select id from items where note = 'NONE' and qty = 5", "1\n2\n4\n"},
+		{"select * from pairs where w = 'dflt'", "a|1|dflt\nb|2|dflt\n"},
+	}
+
+	for _, test := range tests {
+		result := new(bytes.Buffer)
+		err := db.HandleSelect(test.query, result)
+		if err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+	}
+}
+
+func TestGeneratedColumns(t *testing.T) {
+	db := NewDbContext("testdata/generated.db")
+	defer db.Close()
+
+	tests := []struct{ query, expected string }{
+		{"select * from orders where id in (1, 2)", "1|1|0.5|0.5|order-1|0.45|ORDER-1:1\n2|2|2.0|1.0|order-2|1.8|ORDER-2:2\n"},
+		{"select id, total from orders where total = '36.0' and id < 300", "90|36.0\n181|36.0\n272|36.0\n"},
+		{"select id from orders where total = '4.5' and id < 100", "3\n22\n94\n"},
+		{"select count(*) from orders where discounted > 30", "11\n"},
+		{"select max(total) from orders", "9.0\n"},
+		{"select * from shapes where area >= 4 order by area", "square|2|2|4\nwide|5|1|5\n"},
+	}
+
+	for _, test := range tests {
+		result := new(bytes.Buffer)
+		err := db.HandleSelect(test.query, result)
+		if err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+	}
+}
+
+func TestViews(t *testing.T) {
+	db := NewDbContext("testdata/views.db")
+	defer db.Close()
+
+	tests := []struct{ query, expected string }{
+		{"select * from senior_engineers", "1|Ada|120.0\n6|fay|101.0\n"},
+		{"select who, monthly from payroll limit 2", "Ada|10.0\nfay|8.41666666666667\n"},
+		{"select * from headcount", "6|120.0\n"},
+		{"select * from top_senior", "Ada!\n"},
+		{"select employee from managers where manager = 'ADA'", "bob\nCy\nEve\n"},
+		{"select e.name from employees e join engineers g on g.id = e.manager order by e.id", "bob\nCy\nEve\nfay\n"},
+		{"explain query plan select name, total from employees left join headcount on total > id", "QUERY PLAN\n|--MATERIALIZE headcount\n|  `--SCAN employees\n|--SCAN employees\n`--SCAN headcount LEFT-JOIN\n"},
+	}
+
+	for _, test := range tests {
+		result := new(bytes.Buffer)
+		err := db.HandleSelect(test.query, result)
+		if err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+	}
+
+	if err := db.CreateView("create view pairs(a, b) as select id, id * 2 from engineers where id > 1"); err != nil {
+		t.Fatal(err)
+	}
+	result := new(bytes.Buffer)
+	if err := db.HandleSelect("select b from pairs", result); err != nil || result.String() != "4\n12\n" {
+		t.Errorf("expected the rows of the new view - got: %q %v", result.String(), err)
+	}
+	for _, sql := range []string{"create view pairs as select 1", "create view bad(a, b) as select 1", "create view bad as select * from nope", "drop view employees", "drop view nope"} {
+		if err := db.CreateView(sql); strings.HasPrefix(sql, "create") && err == nil {
+			t.Errorf("sql: %s - expected error", sql)
+		}
+		if err := db.DropView(sql); strings.HasPrefix(sql, "drop") && err == nil {
+			t.Errorf("sql: %s - expected error", sql)
+		}
+	}
+	if err := db.DropView("drop view pairs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.HandleSelect("select * from pairs", result); err == nil || err.Error() != "no such table: pairs" {
+		t.Errorf("expected the view to be dropped - got: %v", err)
+	}
+}
+
+func TestSubqueries(t *testing.T) {
+	db := NewDbContext("testdata/views.db")
+	defer db.Close()
+
+	tests := []struct{ query, expected string }{
+		{"select name, (select count(*) from employees m where m.manager = e.id) from employees e where id < 4", "Ada|3\nbob|1\nCy|1\n"},
+		{"select name from employees where id in (select manager from employees)", "Ada\nbob\nCy\n"},
+		{"select name from employees e where not exists (select 1 from employees m where m.manager = e.id)", "dee\nEve\nfay\n"},
+		{"select name from employees where manager not in (select id from employees where dept = 'eng')", "dee\n"},
+		{"select null in (select 1 where 0), null in (select 1), 3 in (select manager from employees)", "0||1\n"},
+		{"select x from (select id * 10 x from employees where dept = 'ops') sub where x > 30", "40\n"},
+		{"select name from employees e where salary = (select max(salary) from employees where dept = e.dept)", "Ada\ndee\nEve\n"},
+		{"explain query plan select name, (select count(*) from employees m where m.manager = e.id) c from employees e order by c", "QUERY PLAN\n|--SCAN e\n|--CORRELATED SCALAR SUBQUERY 1\n|  `--SCAN m\n`--USE TEMP B-TREE FOR ORDER BY\n"},
+	}
+
+	for _, test := range tests {
+		result := new(bytes.Buffer)
+		err := db.HandleSelect(test.query, result)
+		if err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+	}
+	if err := db.HandleSelect("select name from employees where id in (select id, name from employees)", new(bytes.Buffer)); err == nil {
+		t.Errorf("expected error for a subquery with 2 columns")
+	}
+
+	// the result kept for a subquery is computed again with other values of its parameters
+	statement, err := db.Prepare("select count(*) from employees where id in (select manager from employees where salary > ?)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct{ salary, expected string }{{"90", "2\n"}, {"0", "3\n"}} {
+		result := new(bytes.Buffer)
+		if err = statement.Bind(1, test.salary); err != nil {
+			t.Fatal(err)
+		}
+		if err = statement.Execute(result); err != nil || result.String() != test.expected {
+			t.Errorf("salary: %s - expected: %q - got: %q %v", test.salary, test.expected, result.String(), err)
+		}
+	}
+}
+
+func TestCommonTables(t *testing.T) {
+	db := NewDbContext("testdata/views.db")
+	defer db.Close()
+
+	tests := []struct{ query, expected string }{
+		{"with eng as (select * from employees where dept = 'eng'), rich as (select name from eng where salary > 100) select * from rich", "Ada\nfay\n"},
+		{"with recursive chain(id, name, depth) as (select id, name, 0 from employees where manager is null union all select e.id, e.name, depth + 1 from employees e join chain on e.manager = chain.id) select name, depth from chain order by depth desc, name limit 3", "dee|2\nfay|2\nbob|1\n"},
+		{"with recursive tree(id, path) as (select id, name from employees where id = 1 union all select e.id, path || '/' || e.name from tree, employees e where e.manager = tree.id order by 2) select path from tree", "Ada\nAda/bob\nAda/bob/fay\nAda/Cy\nAda/Cy/dee\nAda/Eve\n"},
+		{"with recursive up(id) as (select 4 union select manager from employees, up where employees.id = up.id and manager is not null) select count(*) from up", "3\n"},
+		// a series without an end, read up to the LIMIT
+		{"with recursive c(x) as (select 1 union all select x+1 from c) select x from c limit 5", "1\n2\n3\n4\n5\n"},
+		{"with series(n) as (select 1 union all select n + 1 from series limit 2, 3) select max(n), count(*) from series", "5|3\n"},
+		{"with c(x) as (select 1 union all select x + 1 from c where x < 3) select (select count(*) from c), (select max(x) from c)", "3|3\n"},
+		{"select * from (with a as (select 1 z) select * from a)", "1\n"},
+		{"with c(x) as (select 1 union select 1.0) select * from c", "1.0\n"},
+		{"explain query plan with c(x) as (select 1 union all select x + 1 from c where x < 5) select * from c a, c b", "QUERY PLAN\n|--MATERIALIZE c\n|  |--SETUP\n|  |  `--SCAN CONSTANT ROW\n|  `--RECURSIVE STEP\n|     `--SCAN c\n|--SCAN a\n`--SCAN b\n"},
+	}
+
+	for _, test := range tests {
+		result := new(bytes.Buffer)
+		err := db.HandleSelect(test.query, result)
+		if err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+	}
+
+	for _, query := range []string{
+		"with c as (select * from c) select * from c",
+		"with c(x, y) as (select 1) select * from c",
+		"with c(x) as (select 1 union all select x + 1 from c, c d) select * from c",
+		"with c(x) as (select 1 union all select x + 1 from c where x in (select x from c)) select * from c",
+		"with c(x) as (select 1 union all select x + 1, 2 from c) select * from c",
+		"with c(x) as (select 1 union all select count(*) from c) select * from c",
+		"with c(x) as (select 1 union all select x + 1 from c order by nope) select * from c",
+	} {
+		if err := db.HandleSelect(query, new(bytes.Buffer)); err == nil {
+			t.Errorf("query: %s - expected error", query)
+		}
+	}
+
+	// the rows kept for a table are computed again with other values of the parameters
+	statement, err := db.Prepare("with recursive s(n) as (select 1 union all select n + 1 from s where n < ?) select count(*) from s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		last     int
+		expected string
+	}{{3, "3\n"}, {5, "5\n"}} {
+		result := new(bytes.Buffer)
+		if err = statement.Bind(1, test.last); err != nil {
+			t.Fatal(err)
+		}
+		if err = statement.Execute(result); err != nil || result.String() != test.expected {
+			t.Errorf("last: %d - expected: %q - got: %q %v", test.last, test.expected, result.String(), err)
+		}
+	}
+}
+
+func TestCompoundSelect(t *testing.T) {
+	db := NewDbContext("testdata/views.db")
+	defer db.Close()
+
+	tests := []struct{ query, expected string }{
+		{"select dept from employees union select 'hr' order by 1 desc", "sales\nops\nhr\neng\n"},
+		{"select name from employees where dept = 'eng' intersect select name from employees where salary > 100", "Ada\nfay\n"},
+		{"select manager from employees except select id from employees where dept = 'ops'", "\n1\n2\n"},
+		{"select dept from employees union all select dept from employees where id < 3 union select 'Eng' limit 3 offset 1", "eng\nops\nsales\n"},
+		{"select name from employees where id = 2 union select 'BOB'", "BOB\n"},
+		{"select 1 union select 1.0", "1.0\n"},
+		{"select id, name from employees where id < 3 union all select id * 10, dept from employees where id < 3 order by name desc", "10|eng\n20|eng\n2|bob\n1|Ada\n"},
+		{"select count(*) from (select dept from employees union select manager from employees)", "7\n"},
+		{"select 4 in (select 5 union select 4), (select 2 except select 2)", "1|\n"},
+		{"explain query plan select id from employees union select manager from employees", "QUERY PLAN\n`--COMPOUND QUERY\n   |--LEFT-MOST SUBQUERY\n   |  `--SCAN employees USING COVERING INDEX idx_employees_dept\n   `--UNION USING TEMP B-TREE\n      `--SCAN employees\n"},
+	}
+
+	for _, test := range tests {
+		result := new(bytes.Buffer)
+		err := db.HandleSelect(test.query, result)
+		if err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+	}
+
+	for _, query := range []string{"select 1, 2 union select 3", "select id from employees union select name from employees order by dept", "select 1 union select 2 order by 2"} {
+		if err := db.HandleSelect(query, new(bytes.Buffer)); err == nil {
+			t.Errorf("query: %s - expected error", query)
+		}
+	}
+}
+
+func TestSelectDistinct(t *testing.T) {
+	db := NewDbContext("testdata/views.db")
+	defer db.Close()
+
+	tests := []struct{ query, expected string }{
+		{"select distinct dept from employees", "eng\nops\nsales\n"},
+		{"select distinct name collate binary from employees where name like '%a%' union all select distinct 'ADA' collate nocase", "Ada\nfay\nADA\n"},
+		{"select distinct salary > 90, dept from employees", "1|eng\n0|ops\n0|sales\n"},
+		{"select distinct salary, name from employees order by 1 limit 2 offset 1", "70.0|Cy\n82.0|dee\n"},
+		{"select distinct manager from employees order by manager desc", "3\n2\n1\n\n"},
+		{"select all dept from employees where id < 3", "eng\neng\n"},
+		{"select count(*) from (select distinct dept, manager from employees)", "6\n"},
+		{"explain query plan select distinct dept from employees", "QUERY PLAN\n`--SCAN employees USING COVERING INDEX idx_employees_dept\n"},
+		{"explain query plan select distinct id, dept from employees", "QUERY PLAN\n`--SCAN employees USING COVERING INDEX idx_employees_dept\n"},
+		{"explain query plan select distinct name from employees", "QUERY PLAN\n|--SCAN employees\n`--USE TEMP B-TREE FOR DISTINCT\n"},
+		{"explain query plan select distinct name, salary from employees order by 1, 2", "QUERY PLAN\n|--SCAN employees\n`--USE TEMP B-TREE FOR DISTINCT\n"},
+		{"explain query plan select distinct name from employees order by salary", "QUERY PLAN\n|--SCAN employees\n|--USE TEMP B-TREE FOR DISTINCT\n`--USE TEMP B-TREE FOR ORDER BY\n"},
+	}
+
+	for _, test := range tests {
+		result := new(bytes.Buffer)
+		err := db.HandleSelect(test.query, result)
+		if err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	tests := []struct{ database, query, expected string }{
+		{"indexes.db", "select kind, count(*), max(score) from events where id < 40 group by kind order by kind desc", "kind-6|5|34\nkind-5|5|33\nkind-4|6|39\nkind-3|6|38\nkind-2|6|37\nkind-1|6|36\nkind-0|5|35\n"},
+		{"indexes.db", "select score % 3 s, count(*) from events where id < 20 group by 1 having s is not null", "0|6\n1|7\n2|6\n"},
+		{"indexes.db", "select kind k, count(*) c from events group by k having c > 420", ""},
+		{"indexes.db", "select count(*) from events where 0 group by kind", ""},
+		{"indexes.db", "select id, kind from events where id > 2990 group by kind collate nocase", "3000|\n2996|kind-0\n2997|kind-1\n2991|kind-2\n2992|kind-3\n2993|kind-4\n2994|kind-5\n2995|kind-6\n3002|KIND-9\n"},
+		{"indexes.db", "select sensor, day, count(*) from readings where day < 2 group by day, sensor limit 3", "sensor-0|1|1\nsensor-1|1|1\nsensor-1|0|1\n"},
+		{"indexes.db", "select count(distinct kind), count(distinct kind collate nocase) from events", "9|8\n"},
+		{"indexes.db", "select kind, count(distinct score % 5) from events where id < 30 group by kind limit 3", "kind-0|4\nkind-1|5\nkind-2|4\n"},
+		{"collation.db", "select name, count(*), min(id) from users where name = 'user-5' group by name", "User-5|5|5\n"},
+		{"collation.db", "select name collate binary n, count(*) from users where name = 'user-5' group by n", "USER-5|1\nUser-5|2\nuser-5|2\n"},
+		{"collation.db", "select count(*) from (select code from users group by code)", "250\n"},
+		{"collation.db", "select count(*) from (select code from users group by code collate binary)", "750\n"},
+		{"collation.db", "select count(distinct email), count(distinct email collate nocase), count(distinct code) from users", "2000|2000|250\n"},
+		{"indexes.db", "explain query plan select kind, count(*) from events group by kind order by kind desc", "QUERY PLAN\n`--SCAN events USING COVERING INDEX idx_events_kind\n"},
+		{"indexes.db", "explain query plan select label, count(distinct score) from events group by label", "QUERY PLAN\n|--SCAN events\n|--USE TEMP B-TREE FOR GROUP BY\n`--USE TEMP B-TREE FOR count(DISTINCT)\n"},
+		{"indexes.db", "explain query plan select count(distinct kind), count(distinct label) from events", "QUERY PLAN\n|--USE TEMP B-TREE FOR count(DISTINCT)\n|--USE TEMP B-TREE FOR count(DISTINCT)\n`--SCAN events\n"},
+		{"indexes.db", "explain query plan select sensor, day, count(*) from readings group by day, sensor order by sensor, day", "QUERY PLAN\n|--SCAN readings USING COVERING INDEX idx_readings_sensor_day\n`--USE TEMP B-TREE FOR ORDER BY\n"},
+		{"indexes.db", "explain query plan select kind from events group by kind having kind > 'kind-4'", "QUERY PLAN\n`--SEARCH events USING COVERING INDEX idx_events_kind (kind>?)\n"},
+		{"collation.db", "explain query plan select name, count(*) from users group by name collate binary", "QUERY PLAN\n|--SCAN users USING COVERING INDEX idx_users_name\n`--USE TEMP B-TREE FOR GROUP BY\n"},
+		{"collation.db", "explain query plan select count(distinct name) from users", "QUERY PLAN\n`--SCAN users USING COVERING INDEX idx_users_name\n"},
+	}
+
+	for _, test := range tests {
+		db := NewDbContext("testdata/" + test.database)
+		result := new(bytes.Buffer)
+		err := db.HandleSelect(test.query, result)
+		if err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+		db.Close()
+	}
+
+	db := NewDbContext("testdata/indexes.db")
+	defer db.Close()
+	for query, message := range map[string]string{
+		"select 1 from events order by count(*)":                     "misuse of aggregate: count()",
+		"select 1 from events where count(*) > 1":                    "misuse of aggregate function count()",
+		"select kind from events having count(*) > 1 and 1":          "HAVING clause on a non-aggregate query",
+		"select kind from events group by count(*)":                  "aggregate functions are not allowed in the GROUP BY clause",
+		"select kind from events group by 2":                         "1st GROUP BY term out of range - should be between 1 and 1",
+		"select json_group_object(distinct kind, score) from events": "DISTINCT aggregates must have exactly one argument",
+		"select count(distinct kind) over () from events":            "DISTINCT is not supported for window functions",
+	} {
+		if err := db.HandleSelect(query, new(bytes.Buffer)); err == nil || err.Error() != message {
+			t.Errorf("query: %s - expected error: %s - got: %v", query, message, err)
+		}
+	}
+}
+
+func TestSelectOperators(t *testing.T) {
+	db := NewDbContext("testdata/views.db")
+	defer db.Close()
+
+	tests := []struct{ query, expected string }{
+		{"select name, case when salary >= 100 then 'high' when salary >= 80 then 'mid' else 'low' end, case dept when 'eng' then 1 when 'ops' then 2 end from employees order by id", "Ada|high|1\nbob|mid|1\nCy|low|2\ndee|mid|2\nEve|low|\nfay|high|1\n"},
+		{"select cast(salary as integer), cast(id as text) || '-' || dept, cast('12abc' as integer), cast('1.0' as numeric), cast(' 2.5 ' as real), quote(cast(7 as blob)) from employees where id = 1", "120|1-eng|12|1|2.5|X'37'\n"},
+		{"select cast(3.0 as numeric), cast('1e3' as integer), cast(1e30 as integer), cast('9223372036854775808' as numeric), cast(4.0 as int) = '4', cast(1 as text) = 1", "3.0|1|9223372036854775807|9.22337203685478e+18|1|1\n"},
+		{"select id from employees where manager is null or manager is 2 order by id", "1\n6\n"},
+		{"select id from employees where manager is distinct from 1 and manager is not distinct from manager order by id", "1\n4\n6\n"},
+		{"select 1 is 1.0, null is null, 1 is not null, case 1 when 1.0 then 'x' else 'y' end, case when 0 then 1 end", "1|1|1|x|\n"},
+		{"select name from employees where name like 'A%' or name glob '[be]*' or name regexp '^f' order by name", "Ada\nbob\nfay\n"},
+		{"select name from employees where name not like '%e%' and name not glob '*y' order by id", "Ada\nbob\n"},
+		{"select '10%' like '10!%' escape '!', '10x' like '10!%' escape '!', 'abc' not regexp 'b', regexp('b', 'abc'), null regexp 'a'", "1|0|0|1|\n"},
+		{"select id from employees where dept glob 'e*' and id between 2 and 6 and dept in ('eng', 'hr')", "2\n6\n"},
+		{"select quote(null in ()), quote(null not in ()), quote(null in (1)), quote(1 in (null, 1))", "0|1|NULL|1\n"},
+	}
+
+	for _, test := range tests {
+		result := new(bytes.Buffer)
+		err := db.HandleSelect(test.query, result)
+		if err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+	}
+
+	for query, message := range map[string]string{
+		"select 'a' like 'a' escape 'ab'": "ESCAPE expression must be a single character",
+		"select 'abc' regexp '['":         "error parsing regexp: missing closing ]: `[`",
+	} {
+		if err := db.HandleSelect(query, new(bytes.Buffer)); err == nil || err.Error() != message {
+			t.Errorf("query: %s - expected error: %s - got: %v", query, message, err)
+		}
+	}
+}
+
+func TestWindowFunctions(t *testing.T) {
+	db := NewDbContext("testdata/views.db")
+	defer db.Close()
+
+	tests := []struct{ query, expected string }{
+		{"select name, dept, row_number() over (partition by dept order by salary desc), rank() over (order by dept), dense_rank() over (order by dept) from employees order by id", "Ada|eng|1|1|1\nbob|eng|3|1|1\nCy|ops|2|4|2\ndee|ops|1|4|2\nEve|sales|1|6|3\nfay|eng|2|1|1\n"},
+		{"select name, percent_rank() over (order by dept), cume_dist() over (order by dept), ntile(4) over (order by id) from employees order by id", "Ada|0.0|0.5|1\nbob|0.0|0.5|1\nCy|0.6|0.833333333333333|2\ndee|0.6|0.833333333333333|2\nEve|1.0|1.0|3\nfay|0.0|0.5|4\n"},
+		{"select name, lag(name) over (order by id), lead(name, 2, 'none') over (order by id), first_value(name) over w, last_value(name) over w, nth_value(name, 2) over w from employees window w as (partition by dept order by salary) order by id", "Ada||Cy|bob|Ada|fay\nbob|Ada|dee|bob|bob|\nCy|bob|Eve|Cy|Cy|\ndee|Cy|fay|Cy|dee|dee\nEve|dee|none|Eve|Eve|\nfay|Eve|none|bob|fay|fay\n"},
+		// running totals, and aggregates over the frames
+		{"select id, salary, sum(salary) over (order by id), count(manager) over (order by id), avg(salary) over (partition by dept), max(salary) over (order by id rows between 1 preceding and 1 following) from employees", "1|120.0|120.0|0|105.5|120.0\n2|95.5|215.5|1|105.5|120.0\n3|70.0|285.5|2|76.0|95.5\n4|82.0|367.5|3|76.0|82.0\n5|60.0|427.5|4|60.0|101.0\n6|101.0|528.5|5|105.5|101.0\n"},
+		{"select id, sum(id) over (order by id rows between 1 preceding and 1 following), sum(id) over (order by dept groups between 1 preceding and current row), sum(id) over (order by salary range between 20 preceding and 10 following) from employees order by id", "1|3|9|7\n2|6|9|12\n3|9|16|8\n4|12|16|7\n5|15|12|8\n6|11|9|12\n"},
+		{"select id, count(*) over (order by dept rows between unbounded preceding and unbounded following exclude group), count(*) over (order by dept groups between 1 preceding and 1 following exclude ties), json_group_array(id) over (order by id rows between 1 preceding and current row exclude current row) from employees order by id", "1|3|3|[]\n2|3|3|[1]\n3|4|5|[2]\n4|4|5|[3]\n5|5|3|[4]\n6|3|3|[5]\n"},
+		{"select name, row_number() over (win rows 1 preceding), count(*) over win from employees window base as (partition by dept), win as (base order by id) order by id", "Ada|1|1\nbob|2|2\nCy|1|1\ndee|2|2\nEve|1|1\nfay|3|3\n"},
+		// without ORDER BY, the rows are in the order of the first window
+		{"select name, row_number() over (order by salary) from employees", "Eve|1\nCy|2\ndee|3\nbob|4\nfay|5\nAda|6\n"},
+		{"select dept, salary, sum(salary) over (partition by dept order by salary desc) from employees where salary > 80 order by id", "eng|120.0|120.0\neng|95.5|316.5\nops|82.0|82.0\neng|101.0|221.0\n"},
+		{"select name from (select name, rank() over (partition by dept order by salary desc) r from employees) where r = 1 order by name", "Ada\ndee\nEve\n"},
+		{"select count(*), row_number() over () from employees", "6|1\n"},
+		{"select sum(id), total(manager), avg(salary), sum(salary) from employees", "21|8.0|88.0833333333333|528.5\n"},
+		{"select quote(sum(manager)), quote(total(manager)), quote(avg(manager)) from employees where manager > 5", "NULL|0.0|NULL\n"},
+		// the FILTER clause skips rows of aggregates, also over a window
+		{"select dept, group_concat(name), string_agg(name, ';') filter (where salary > 80), count(*) filter (where manager is null) from employees group by dept", "eng|Ada,bob,fay|Ada;bob;fay|1\nops|Cy,dee|dee|0\nsales|Eve||0\n"},
+		{"select id, group_concat(name, '+') over (order by id rows between 1 preceding and current row), sum(id) filter (where dept = 'eng') over (order by id), count(*) filter (where id > 2) over (order by id rows between 1 preceding and 1 following) from employees", "1|Ada|1|0\n2|Ada+bob|3|1\n3|bob+Cy|3|2\n4|Cy+dee|3|3\n5|dee+Eve|3|3\n6|Eve+fay|9|2\n"},
+		{"select group_concat(distinct dept), quote(group_concat(manager, null)), quote(string_agg(null, ',')) from employees", "eng,ops,sales|'11312'|NULL\n"},
+	}
+
+	for _, test := range tests {
+		result := new(bytes.Buffer)
+		err := db.HandleSelect(test.query, result)
+		if err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+	}
+
+	for query, message := range map[string]string{
+		"select row_number() from employees":                                              "misuse of window function row_number()",
+		"select id from employees where rank() over () > 1":                               "misuse of window function rank()",
+		"select abs(id) over () from employees":                                           "abs() may not be used as a window function",
+		"select count(*) over w from employees":                                           "no such window: w",
+		"select count(*) over (w order by id) from employees window w as (order by dept)": "cannot override ORDER BY clause of window: w",
+		"select count(*) over (range 1 preceding) from employees":                         "RANGE with offset PRECEDING/FOLLOWING requires one ORDER BY expression",
+		"select count(*) over (rows -1 preceding) from employees":                         "frame starting offset must be a non-negative integer",
+		"select ntile(0) over () from employees":                                          "argument of ntile must be a positive integer",
+		"select sum(9223372036854775807) from employees":                                  "integer overflow",
+		"select count(*) over (rows between 1 following and current row) from employees":  "unsupported frame specification",
+		"select string_agg(name) from employees":                                          "wrong number of arguments to function string_agg()",
+		"select group_concat(distinct name, ',') from employees":                          "DISTINCT aggregates must have exactly one argument",
+		"select count(*) filter (where count(*) > 1) from employees":                      "misuse of aggregate function count()",
+		"select abs(id) filter (where id > 1) from employees":                             "FILTER may not be used with non-aggregate abs()",
+		"select rank() filter (where id > 1) over () from employees":                      "FILTER clause may only be used with aggregate window functions",
+	} {
+		if err := db.HandleSelect(query, new(bytes.Buffer)); err == nil || err.Error() != message {
+			t.Errorf("query: %s - expected error: %s - got: %v", query, message, err)
+		}
+	}
+}
+
+func TestJoinTables(t *testing.T) {
+	db := NewDbContext("testdata/stats.db")
+	defer db.Close()
+
+	tests := []struct{ query, expected string }{
+		{"select count(*) from customers c, orders o where c.id = o.customer and o.status = 'void'", "50\n"},
+		{"select count(*) from customers c join orders o on o.customer = c.id where c.city = 'city-1' and o.status = 'open'", "50\n"},
+		{"select c.name, o.id from customers c left join orders o on o.customer = c.id and o.status = 'void' where c.id < 3", "customer 001|500\ncustomer 001|1000\ncustomer 001|1500\ncustomer 001|2000\ncustomer 001|2500\ncustomer 001|3000\ncustomer 001|3500\ncustomer 001|4000\ncustomer 001|4500\ncustomer 001|5000\ncustomer 002|\n"},
+		{"select c.name from customers c left join orders o on o.customer = c.id and o.status = 'void' where o.id is null and c.id < 4", "customer 002\ncustomer 003\n"},
+		{"select * from customers c join orders o using (id) where id < 3", "1|customer 001|city-1|done|2|1\n2|customer 002|city-2|done|3|2\n"},
+		{"select o.id, o.total from orders o cross join customers c where c.id = 3 and o.customer = c.id and o.total > 500", "502|502\n1502|502\n2502|502\n3502|502\n4502|502\n"},
+		{"select c.name, o.id from customers c left join orders o on o.total = c.id * 100 where c.id < 3", "customer 001|100\ncustomer 001|1100\ncustomer 001|2100\ncustomer 001|3100\ncustomer 001|4100\ncustomer 002|200\ncustomer 002|1200\ncustomer 002|2200\ncustomer 002|3200\ncustomer 002|4200\n"},
+	}
+
+	for _, test := range tests {
+		result := new(bytes.Buffer)
+		err := db.HandleSelect(test.query, result)
+		if err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+	}
+
+	for _, query := range []string{"select id from customers c, orders o", "select c.nope from customers c", "select * from nope"} {
+		if err := db.HandleSelect(query, new(bytes.Buffer)); err == nil {
+			t.Errorf("query: %s - expected error", query)
+		}
+	}
+}
+
+func TestOrderBy(t *testing.T) {
+	tests := []struct{ database, query, expected string }{
+		{"stats.db", "select id, total from orders where customer = 7 order by total desc, id", "506|506\n1506|506\n2506|506\n3506|506\n4506|506\n6|6\n1006|6\n2006|6\n3006|6\n4006|6\n"},
+		{"stats.db", "select name n from customers where id < 4 order by n desc", "customer 003\ncustomer 002\ncustomer 001\n"},
+		// read from the covering index, in the order of its keys
+		{"indexes.db", "select sensor, day from readings where sensor = 'sensor-1' and day < 4", "sensor-1|3\nsensor-1|2\nsensor-1|1\nsensor-1|0\n"},
+		{"indexes.db", "select id, kind from events where kind = 'kind-3' and id < 20 order by 1", "3|kind-3\n10|kind-3\n17|kind-3\n"},
+		{"indexes.db", "select count(*) from events where kind = 'kind-3'", "420\n"},
+	}
+
+	for _, test := range tests {
+		db := NewDbContext("testdata/" + test.database)
+		result := new(bytes.Buffer)
+		err := db.HandleSelect(test.query, result)
+		if err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+		db.Close()
+	}
+
+	db := NewDbContext("testdata/indexes.db")
+	defer db.Close()
+	if err := db.HandleSelect("select kind from events order by 2", new(bytes.Buffer)); err == nil {
+		t.Errorf("expected error for ORDER BY term out of range")
+	}
+}
+
+func TestMinMaxAndLimit(t *testing.T) {
+	tests := []struct{ database, query, expected string }{
+		{"indexes.db", "select max(id) from events", "3002\n"},
+		{"indexes.db", "select min(kind), max(kind) from events", "KIND-9|kind-6\n"},
+		{"indexes.db", "select max(score) from events", "high\n"},
+		{"indexes.db", "select max(kind), id from events where kind < 'kind-5'", "kind-4|2993\n"},
+		{"indexes.db", "select max(day) from readings where sensor = 'sensor-1'", "499\n"},
+		{"indexes.db", "select max(qty) from stock where warehouse = 'w1'", "599\n"},
+		{"indexes.db", "select min(id) from events where id > 10000", "\n"},
+		{"indexes.db", "select count(kind), count(*) from events", "2942|3002\n"},
+		{"indexes.db", "select id, kind from events order by id desc limit 2 offset 3", "2999|kind-3\n2998|kind-2\n"},
+		{"indexes.db", "select id, kind from events order by id limit 3, 2", "4|kind-4\n5|kind-5\n"},
+		{"indexes.db", "select id, kind from events order by id desc limit '2' offset 3.0", "2999|kind-3\n2998|kind-2\n"},
+		{"indexes.db", "select id, day from readings where sensor = 'sensor-2' order by day limit 3", "2|0\n12|1\n22|2\n"},
+		{"indexes.db", "select id from events where id in (5, 9, 300) order by id desc", "300\n9\n5\n"},
+		{"indexes.db", "select * from stock where warehouse = 'w3' order by item desc limit 2", "w3|299|1199\nw3|298|1198\n"},
+		{"stats.db", "select * from orders order by total desc, id limit 3", "999|done|500|999\n1999|done|500|999\n2999|done|500|999\n"},
+	}
+
+	for _, test := range tests {
+		db := NewDbContext("testdata/" + test.database)
+		result := new(bytes.Buffer)
+		err := db.HandleSelect(test.query, result)
+		if err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+		db.Close()
+	}
+
+	// only the pages on the edge of the b-tree are read
+	db := NewDbContext("testdata/stats.db")
+	defer db.Close()
+	db.statistics()
+	for _, query := range []string{"select max(id) from orders", "select min(customer) from orders", "select * from orders order by id desc limit 5"} {
+		before := db.pagesRead
+		if err := db.HandleSelect(query, new(bytes.Buffer)); err != nil {
+			t.Errorf("query: %s - error: %v", query, err)
+		} else if pages := db.pagesRead - before; pages > 5 {
+			t.Errorf("query: %s - expected to read only the edge of the b-tree - read %d pages", query, pages)
+		}
+	}
+}
+
+func TestExplainQueryPlan(t *testing.T) {
+	tests := []struct{ database, query, expected string }{
+		{"indexes.db", "select * from events where id > 5 and id < 10", "`--SEARCH events USING INTEGER PRIMARY KEY (rowid>? AND rowid<?)"},
+		{"indexes.db", "select * from readings where sensor = 'a' and day between 1 and 3", "`--SEARCH readings USING INDEX idx_readings_sensor_day (sensor=? AND day>? AND day<?)"},
+		{"indexes.db", "select * from stock where warehouse = 'w1'", "`--SEARCH stock USING PRIMARY KEY (warehouse=?)"},
+		{"indexes.db", "select * from events e, readings r where e.id = r.day", "|--SCAN r\n`--SEARCH e USING INTEGER PRIMARY KEY (rowid=?)"},
+		{"indexes.db", "select * from events e left join readings r on r.id = e.id", "|--SCAN e\n`--SEARCH r USING INTEGER PRIMARY KEY (rowid=?) LEFT-JOIN"},
+		// the columns compared with the tables before, with no index of their own, are searched on an automatic index
+		{"indexes.db", "select count(*) from events e join readings r on e.kind = r.day", "|--SCAN e USING COVERING INDEX idx_events_kind\n|--BLOOM FILTER ON r (day=?)\n`--SEARCH r USING AUTOMATIC COVERING INDEX (day=?)"},
+		{"indexes.db", "select a.id, b.id from events a join events b on a.label = b.label collate nocase where a.id < 4", "|--SEARCH a USING INTEGER PRIMARY KEY (rowid<?)\n`--SEARCH b USING AUTOMATIC COVERING INDEX (label=?)"},
+		{"indexes.db", "select count(*) from events a join events b on a.label = b.label and a.score = b.score", "|--SCAN a\n`--SEARCH b USING INDEX idx_events_score (score=?)"},
+		{"stats.db", "select c.name, o.id from customers c left join orders o on o.total = c.id * 100 where c.id < 3", "|--SEARCH c USING INTEGER PRIMARY KEY (rowid<?)\n|--BLOOM FILTER ON o (total=?)\n`--SEARCH o USING AUTOMATIC COVERING INDEX (total=?) LEFT-JOIN"},
+		// the indexes of PRIMARY KEY and UNIQUE constraints are numbered in the order of the constraints
+		{"autoindex.db", "select v from k where name = 'key-5'", "`--SEARCH k USING INDEX sqlite_autoindex_k_1 (name=?)"},
+		{"autoindex.db", "select id from u where g = 'g-3' and f = 4", "`--SEARCH u USING COVERING INDEX sqlite_autoindex_u_2 (g=? AND f=?)"},
+		{"autoindex.db", "select a, b, c from w where b = 17", "`--SEARCH w USING INDEX sqlite_autoindex_w_1 (b=?)"},
+		// a partial index is only searched when the WHERE clause implies its own
+		{"partial.db", "select count(*) from t where c > 1 and c < 3", "`--SEARCH t USING COVERING INDEX t_part (c>? AND c<?)"},
+		{"partial.db", "select count(*) from t where b = 'b-7' and c > 0", "`--SEARCH t USING INDEX t_b (b=?)"},
+		{"partial.db", "select count(*) from t where b = 'b-7'", "`--SCAN t"},
+		// with statistics, the rare values of an index are searched and the common ones scanned
+		{"stats.db", "select * from orders where status = 'void'", "`--SEARCH orders USING INDEX idx_orders_status (status=?)"},
+		{"stats.db", "select * from orders where status = 'done'", "`--SCAN orders"},
+		{"stats.db", "select * from orders where status < 'e'", "`--SCAN orders"},
+		{"stats.db", "select * from orders where status = 'open' and customer = 7", "`--SEARCH orders USING INDEX idx_orders_customer (customer=?)"},
+		{"stats.db", "select * from orders o join customers c on c.id = o.customer where c.city = 'city-7'", "|--SEARCH c USING INDEX idx_customers_city (city=?)\n`--SEARCH o USING INDEX idx_orders_customer (customer=?)"},
+		{"stats.db", "select * from customers c, orders o where c.id = o.customer and o.status = 'void'", "|--SEARCH o USING INDEX idx_orders_status (status=?)\n`--SEARCH c USING INTEGER PRIMARY KEY (rowid=?)"},
+		// indexes with all the columns needed are read without the table, and avoid sorting the rows
+		{"indexes.db", "select count(*) from events where kind = 'x'", "`--SEARCH events USING COVERING INDEX idx_events_kind (kind=?)"},
+		{"indexes.db", "select count(*) from events", "`--SCAN events USING COVERING INDEX idx_events_score"},
+		{"indexes.db", "select kind from events order by kind", "`--SCAN events USING COVERING INDEX idx_events_kind"},
+		{"indexes.db", "select * from events order by kind", "`--SCAN events USING INDEX idx_events_kind"},
+		{"indexes.db", "select * from events order by label", "|--SCAN events\n`--USE TEMP B-TREE FOR ORDER BY"},
+		{"indexes.db", "select id, kind from events where kind = 'x' order by id", "`--SEARCH events USING COVERING INDEX idx_events_kind (kind=?)"},
+		{"indexes.db", "select sensor, day from readings order by sensor, day desc", "`--SCAN readings USING COVERING INDEX idx_readings_sensor_day"},
+		{"indexes.db", "select amount, sku from prices where amount > 3", "`--SEARCH prices USING COVERING INDEX idx_prices_amount (amount>?)"},
+		// a partial index doesn't have all the rows, unless the WHERE clause implies its own
+		{"partial.db", "select c from t order by c limit 3", "|--SCAN t\n`--USE TEMP B-TREE FOR ORDER BY"},
+		{"partial.db", "select c from t where c > 1 order by c desc limit 2", "`--SEARCH t USING COVERING INDEX t_part (c>?)"},
+		{"partial.db", "select b from t where c is not null order by b limit 2", "`--SCAN t USING INDEX t_b"},
+		{"partial.db", "select min(c) from t", "`--SEARCH t"},
+		{"partial.db", "select min(c) from t where c > 1", "`--SEARCH t USING COVERING INDEX t_part (c>?)"},
+		// MIN, MAX and ORDER BY in either direction use the order of the keys
+		{"indexes.db", "select max(id) from events", "`--SEARCH events"},
+		{"indexes.db", "select max(score) from events", "`--SEARCH events USING COVERING INDEX idx_events_score"},
+		{"indexes.db", "select max(qty) from stock", "`--SEARCH stock USING PRIMARY KEY"},
+		{"indexes.db", "select min(kind), max(kind) from events", "`--SCAN events USING COVERING INDEX idx_events_kind"},
+		{"indexes.db", "select * from events order by id desc limit 3", "`--SCAN events"},
+		{"indexes.db", "select * from events order by kind desc limit 3", "`--SCAN events USING INDEX idx_events_kind"},
+		{"indexes.db", "select sensor, day from readings order by sensor desc, day", "`--SCAN readings USING COVERING INDEX idx_readings_sensor_day"},
+		// each window sorts the rows given by the co-routine of the next one, unless they are in its order
+		{"indexes.db", "select id, sum(score) over (partition by kind order by id) from events", "|--CO-ROUTINE (subquery-2)\n|  `--SCAN events USING INDEX idx_events_kind\n`--SCAN (subquery-2)"},
+		{"indexes.db", "select row_number() over (order by label), rank() over (order by score desc) from events order by id", "|--CO-ROUTINE (subquery-2)\n|  |--CO-ROUTINE (subquery-3)\n|  |  `--SCAN events USING INDEX idx_events_score\n|  |--SCAN (subquery-3)\n|  `--USE TEMP B-TREE FOR ORDER BY\n|--SCAN (subquery-2)\n`--USE TEMP B-TREE FOR ORDER BY"},
+	}
+
+	for _, test := range tests {
+		db := NewDbContext("testdata/" + test.database)
+		result := new(bytes.Buffer)
+		err := db.HandleSelect("explain query plan "+test.query, result)
+		if err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if expected := "QUERY PLAN\n" + test.expected + "\n"; result.String() != expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, expected, result.String())
+		}
+		db.Close()
+	}
+}
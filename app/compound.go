@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// compoundPart is a SELECT of a compound SELECT, with the operator joining it to the ones before it,
+// which is empty for the first one
+type compoundPart struct {
+	Op string
+	*PreparedStatement
+}
+
+// planCompound plans the SELECTs of a compound SELECT, which must all have the same number of result
+// columns. The rows of the compound SELECT are the values of its result columns, and the terms of ORDER BY
+// must be result columns, given by their number, their name or their expression in one of the SELECTs.
+func (db *DbContext) planCompound(statement *SelectStatement, outer []tableSource) (*queryPlan, error) {
+	first := *statement
+	first.With, first.Compound, first.OrderBy, first.Limit, first.Offset = nil, nil, nil, nil, nil
+	parts := []compoundPart{{PreparedStatement: &PreparedStatement{SelectStatement: &first}}}
+	for _, next := range statement.Compound {
+		parts = append(parts, compoundPart{Op: next.Op, PreparedStatement: &PreparedStatement{SelectStatement: next.Select}})
+	}
+	plan := &queryPlan{Compound: parts}
+	for i, part := range parts {
+		var err error
+		part.db = db
+		if part.plan, err = db.planSelect(part.SelectStatement, outer); err != nil {
+			return nil, err
+		}
+		if i > 0 && len(part.plan.Columns) != len(parts[0].plan.Columns) {
+			return nil, fmt.Errorf("SELECTs to the left and right of %s do not have the same number of result columns", part.Op)
+		}
+		plan.Cost += part.plan.Cost
+		plan.Rows += part.plan.Rows
+	}
+
+	plan.Columns = compoundColumns(parts)
+	var err error
+	if plan.OrderBy, err = compoundOrder(statement.OrderBy, parts, plan.Columns); err != nil {
+		return nil, err
+	}
+	if len(plan.OrderBy) > 0 {
+		plan.Cost += 2 * plan.Rows * (math.Log2(plan.Rows+1) + 1)
+	}
+	for _, expr := range []*Expr{statement.Limit, statement.Offset} {
+		if err = bindColumns(expr, nil); err != nil {
+			return nil, err
+		}
+	}
+	plan.Width = len(plan.Columns)
+	return plan, nil
+}
+
+// compoundColumns makes the result columns of a compound SELECT, which give the values of its rows. They
+// are named like the columns of the first SELECT and have their affinity, and each one has the collation
+// of the first SELECT whose expression has one.
+func compoundColumns(parts []compoundPart) []ResultColumn {
+	columns := []ResultColumn{}
+	for i, column := range queryColumns(parts[0].plan.Columns, nil) {
+		expr := &Expr{Op: "column", Name: column.Name, Column: i, Affinity: parts[0].plan.Columns[i].Expr.Affinity}
+		for _, part := range parts {
+			if expr.Collation, _ = exprCollation(part.plan.Columns[i].Expr); expr.Collation != "" {
+				break
+			}
+		}
+		columns = append(columns, ResultColumn{Expr: expr, Alias: column.Name})
+	}
+	return columns
+}
+
+// compoundOrder finds the result columns sorting the rows of a compound SELECT, which are given by their
+// number, by their name in a SELECT or by the same expression. The names and expressions are looked for
+// from the first SELECT to the last one.
+func compoundOrder(orderBy []OrderTerm, parts []compoundPart, columns []ResultColumn) ([]OrderTerm, error) {
+	terms := []OrderTerm{}
+	for i, term := range orderBy {
+		expr := skipCollate(term.Expr)
+		position := -1
+		if number, isInteger := expr.Value.(int64); expr.Op == "literal" && isInteger {
+			if number < 1 || int(number) > len(columns) {
+				return nil, fmt.Errorf("%d%s ORDER BY term out of range - should be between 1 and %d", i+1, ordinalSuffix(i+1), len(columns))
+			}
+			position = int(number) - 1
+		}
+		for _, part := range parts {
+			if position >= 0 {
+				break
+			}
+			position = slices.IndexFunc(part.plan.Columns, func(column ResultColumn) bool {
+				if expr.Op == "column" && expr.Table == "" && strings.EqualFold(column.Alias, expr.Name) {
+					return true
+				}
+				return formatExpr(column.Expr) == formatExpr(expr)
+			})
+		}
+		if position < 0 {
+			return nil, fmt.Errorf("%d%s ORDER BY term does not match any column in the result set", i+1, ordinalSuffix(i+1))
+		}
+		// the terms are computed from the values of the rows
+		order := columns[position].Expr
+		if term.Expr.Op == "COLLATE" {
+			order = &Expr{Op: "COLLATE", Name: term.Expr.Name, Args: []*Expr{order}}
+		}
+		terms = append(terms, OrderTerm{Expr: order, Descending: term.Descending})
+	}
+	return terms, nil
+}
+
+// runCompound computes the rows of a compound SELECT from left to right, calling visit with the values of
+// each one until visit returns an error. The rows of UNION, INTERSECT and EXCEPT are distinct and sorted,
+// as they are found by reading all the rows before them from a sorter. The rows before the last of these
+// operators are only kept in sorters, as the rows found twice by UNION ALL are then found once, and the
+// rows of the SELECTs after it are given as they are found.
+func (db *DbContext) runCompound(plan *queryPlan, outer []any, visit func(row []any) error) error {
+	last := -1
+	for i, part := range plan.Compound {
+		if part.Op != "" && part.Op != "UNION ALL" {
+			last = i
+		}
+	}
+	stopped := false
+	emit := func(row []any) error {
+		err := visit(row)
+		stopped = err == errStopPlan
+		return err
+	}
+	collations := []string{}
+	for _, column := range plan.Columns {
+		collations = append(collations, column.Expr.Collation)
+	}
+
+	if last >= 0 {
+		rows := &rowSorter{collations: collations}
+		defer func() { rows.close() }()
+		for _, part := range plan.Compound[:last+1] {
+			if part.Op != "INTERSECT" && part.Op != "EXCEPT" {
+				if err := part.run(outer, rows.add); err != nil {
+					return err
+				}
+				continue
+			}
+			right := &rowSorter{collations: collations}
+			defer right.close()
+			if err := part.run(outer, right.add); err != nil {
+				return err
+			}
+			result := &rowSorter{collations: collations}
+			if err := mergeRows(part.Op, rows, right, result.add); err != nil {
+				result.close()
+				return err
+			}
+			rows.close()
+			right.close()
+			rows = result
+		}
+		next := rows.distinct()
+		for {
+			row, err := next()
+			if err == nil && row != nil {
+				err = emit(row)
+			}
+			if stopped {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if row == nil {
+				break
+			}
+		}
+	}
+	for _, part := range plan.Compound[last+1:] {
+		if err := part.run(outer, emit); err != nil || stopped {
+			return err
+		}
+	}
+	return nil
+}
+
+// mergeRows reads the distinct rows of two sorters in order at the same time, calling visit with the rows
+// of left also found on right for INTERSECT, or with the rows of left not found on right for EXCEPT
+func mergeRows(op string, left, right *rowSorter, visit func(row []any) error) error {
+	nextLeft, nextRight := left.distinct(), right.distinct()
+	leftRow, err := nextLeft()
+	if err != nil {
+		return err
+	}
+	rightRow, err := nextRight()
+	for err == nil && leftRow != nil {
+		comparison := -1
+		if rightRow != nil {
+			comparison = left.compare(leftRow, rightRow)
+		}
+		switch {
+		case comparison < 0:
+			if op == "EXCEPT" {
+				err = visit(leftRow)
+			}
+			if err == nil {
+				leftRow, err = nextLeft()
+			}
+		case comparison == 0:
+			if op == "INTERSECT" {
+				err = visit(leftRow)
+			}
+			if err == nil {
+				leftRow, err = nextLeft()
+			}
+			if err == nil {
+				rightRow, err = nextRight()
+			}
+		default:
+			rightRow, err = nextRight()
+		}
+	}
+	return err
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"container/heap"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+)
+
+// commonTable is a table of a WITH clause, known by the SELECT it is defined for, by the subqueries in it
+// and by the tables defined after it. Its SELECT is planned when the table is first read, seeing the
+// tables that were known where it is defined, and all the tables reading it share the rows of query,
+// which are computed once. A recursive table runs step for each row found, which is the row current of
+// the table read by step, and chooses the next row by order.
+type commonTable struct {
+	*CommonTable
+	scope      []*commonTable
+	entry      SchemaEntry
+	query      *subquery
+	recursive  *SelectStatement // the last SELECT of the compound SELECT, when it reads the table
+	unionAll   bool
+	step       *PreparedStatement
+	order      []OrderTerm
+	collations []string
+	current    []any
+	planning   string // "setup" or "step" while planning the SELECTs before the recursive one or that one
+	reads      int
+}
+
+// withTables adds the tables of a WITH clause to the ones known while planning a SELECT, and returns the
+// function that forgets them
+func (db *DbContext) withTables(tables []*CommonTable) (forget func()) {
+	known := db.commonTables
+	for _, table := range tables {
+		defined := &commonTable{CommonTable: table}
+		db.commonTables = append(slices.Clone(db.commonTables), defined)
+		// a table also sees itself, to be read by its recursive SELECT
+		defined.scope = db.commonTables
+	}
+	return func() { db.commonTables = known }
+}
+
+// findCommonTable finds the table of the innermost WITH clause with the name, or nil when there is none
+func (db *DbContext) findCommonTable(name string) *commonTable {
+	for i := len(db.commonTables) - 1; i >= 0; i-- {
+		if strings.EqualFold(db.commonTables[i].Name, name) {
+			return db.commonTables[i]
+		}
+	}
+	return nil
+}
+
+// readCommonTable gives the table and the query for a table of the FROM clause naming a table of a WITH
+// clause, planning its SELECT the first time. A recursive SELECT reads the table of its own FROM clause
+// through the source of the table, as recursive is true for it.
+func (db *DbContext) readCommonTable(table *commonTable, recursive bool) (SchemaEntry, *subquery, error) {
+	switch {
+	case table.planning == "setup":
+		return SchemaEntry{}, nil, fmt.Errorf("circular reference: %s", table.Name)
+	case table.planning == "step" && !recursive:
+		return SchemaEntry{}, nil, fmt.Errorf("multiple recursive references: %s", table.Name)
+	case table.planning == "step":
+		return table.entry, nil, nil
+	case table.query == nil:
+		if err := db.planCommonTable(table); err != nil {
+			return SchemaEntry{}, nil, err
+		}
+	}
+	table.reads++
+	return table.entry, table.query, nil
+}
+
+// planCommonTable plans the SELECT of a table of a WITH clause. When the last SELECT of a compound SELECT
+// reads the table after UNION or UNION ALL, the table is recursive: the SELECTs before it are planned
+// like a SELECT of their own, and the recursive one may read the table once on its FROM clause.
+func (db *DbContext) planCommonTable(table *commonTable) error {
+	known := db.commonTables
+	db.commonTables = table.scope
+	defer func() { db.commonTables, table.planning = known, "" }()
+
+	statement, setup := table.Select, table.Select
+	last := len(statement.Compound) - 1
+	if last >= 0 && (statement.Compound[last].Op == "UNION" || statement.Compound[last].Op == "UNION ALL") &&
+		slices.ContainsFunc(statement.Compound[last].Select.From, func(ref TableRef) bool { return readsTable(ref, table.Name) }) {
+		table.recursive, table.unionAll = statement.Compound[last].Select, statement.Compound[last].Op == "UNION ALL"
+		// the WITH clause, ORDER BY and LIMIT of the compound SELECT are the ones of the table
+		first := *statement
+		first.With, first.Compound, first.OrderBy, first.Limit, first.Offset = nil, statement.Compound[:last], nil, nil, nil
+		setup = &first
+		if statement.With != nil {
+			defer db.withTables(statement.With)()
+		}
+	}
+	table.planning = "setup"
+	plan, err := db.planSelect(setup, nil)
+	if err != nil {
+		return err
+	}
+	if table.Columns != nil && len(table.Columns) != len(plan.Columns) {
+		return fmt.Errorf("table %s has %d values for %d columns", table.Name, len(plan.Columns), len(table.Columns))
+	}
+	table.entry = SchemaEntry{Type: "view", Name: table.Name, WithoutRowid: true, Columns: queryColumns(plan.Columns, table.Columns)}
+	table.query = &subquery{PreparedStatement: &PreparedStatement{SelectStatement: setup, db: db, plan: plan}, Table: table}
+	if table.recursive == nil {
+		return nil
+	}
+
+	if references := slices.DeleteFunc(slices.Clone(table.recursive.From), func(ref TableRef) bool { return !readsTable(ref, table.Name) }); len(references) > 1 {
+		return fmt.Errorf("multiple references to recursive table: %s", table.Name)
+	}
+	table.planning = "step"
+	stepPlan, err := db.planSelect(table.recursive, nil)
+	if err != nil {
+		return err
+	}
+	op := statement.Compound[last].Op
+	if len(stepPlan.Columns) != len(plan.Columns) {
+		return fmt.Errorf("SELECTs to the left and right of %s do not have the same number of result columns", op)
+	}
+	if len(stepPlan.Aggregates) > 0 || len(stepPlan.GroupBy) > 0 {
+		return fmt.Errorf("recursive aggregate queries not supported")
+	}
+	for _, expr := range []*Expr{statement.Limit, statement.Offset} {
+		if err = bindColumns(expr, nil); err != nil {
+			return err
+		}
+	}
+	table.step = &PreparedStatement{SelectStatement: table.recursive, db: db, plan: stepPlan}
+	// the rows are compared and sorted like the ones of the compound SELECT
+	parts := plan.Compound
+	if parts == nil {
+		parts = []compoundPart{{PreparedStatement: table.query.PreparedStatement}}
+	}
+	columns := compoundColumns(append(slices.Clone(parts), compoundPart{Op: op, PreparedStatement: table.step}))
+	for _, column := range columns {
+		table.collations = append(table.collations, column.Expr.Collation)
+	}
+	table.order, err = compoundOrder(statement.OrderBy, append(slices.Clone(parts), compoundPart{Op: op, PreparedStatement: table.step}), columns)
+	return err
+}
+
+// readsTable tells if a table of the FROM clause is the table with the name
+func readsTable(ref TableRef, name string) bool {
+	return ref.Select == nil && ref.Args == nil && strings.EqualFold(ref.Name, name)
+}
+
+// run computes the rows of a recursive table. The rows found wait in a queue, starting with the ones of
+// the SELECTs before the recursive one, and each one taken from the queue is a row of the table and the
+// row read by the recursive SELECT, which adds the rows it finds to the queue. With UNION, the rows found
+// twice are only added once.
+func (table *commonTable) run(visit func(values []any) error) error {
+	limit, offset, err := limitValues(table.Select)
+	if err != nil {
+		return err
+	}
+	queue := &rowQueue{order: table.order}
+	var found *rowSet
+	if !table.unionAll {
+		found = &rowSet{collations: table.collations}
+	}
+	add := func(values []any) error {
+		if found == nil || found.add(values) {
+			return queue.push(values)
+		}
+		return nil
+	}
+	if err = table.query.run(nil, add); err != nil {
+		return err
+	}
+	for queue.Len() > 0 && limit != 0 {
+		table.current = heap.Pop(queue).([]any)
+		if offset > 0 {
+			offset--
+		} else {
+			if err = visit(table.current); err != nil {
+				return err
+			}
+			limit--
+		}
+		if err = table.step.run(nil, add); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rowQueue gives the rows in the order they are pushed, or sorted by the terms of order, which are computed
+// from the values of a row. Rows with the same values of the terms are given in the order they are pushed.
+type rowQueue struct {
+	order  []OrderTerm
+	rows   [][]any
+	keys   [][]any
+	number []int
+	pushed int
+}
+
+func (queue *rowQueue) push(values []any) error {
+	key := []any{}
+	for _, term := range queue.order {
+		value, err := evalExpr(term.Expr, values)
+		if err != nil {
+			return err
+		}
+		key = append(key, value)
+	}
+	heap.Push(queue, rowQueueItem{values, key})
+	return nil
+}
+
+type rowQueueItem struct {
+	values []any
+	key    []any
+}
+
+func (queue *rowQueue) Len() int { return len(queue.rows) }
+
+func (queue *rowQueue) Less(a, b int) bool {
+	for i, term := range queue.order {
+		comparison := compareCollated(queue.keys[a][i], queue.keys[b][i], orderCollation(term.Expr))
+		if term.Descending {
+			comparison = -comparison
+		}
+		if comparison != 0 {
+			return comparison < 0
+		}
+	}
+	return queue.number[a] < queue.number[b]
+}
+
+func (queue *rowQueue) Swap(a, b int) {
+	queue.rows[a], queue.rows[b] = queue.rows[b], queue.rows[a]
+	queue.keys[a], queue.keys[b] = queue.keys[b], queue.keys[a]
+	queue.number[a], queue.number[b] = queue.number[b], queue.number[a]
+}
+
+func (queue *rowQueue) Push(item any) {
+	queue.rows = append(queue.rows, item.(rowQueueItem).values)
+	queue.keys = append(queue.keys, item.(rowQueueItem).key)
+	queue.number = append(queue.number, queue.pushed)
+	queue.pushed++
+}
+
+func (queue *rowQueue) Pop() any {
+	last := len(queue.rows) - 1
+	values := queue.rows[last]
+	queue.rows, queue.keys, queue.number = queue.rows[:last], queue.keys[:last], queue.number[:last]
+	return values
+}
+
+// rowSet keeps distinct rows, comparing their values with the collation of each column. The rows are
+// found by a key that is the same for the rows that compare equal, and compared when they have the same key.
+type rowSet struct {
+	collations []string
+	rows       map[string][][]any
+}
+
+// add adds a row to the set, telling if it was not found
+func (set *rowSet) add(values []any) bool {
+	if set.rows == nil {
+		set.rows = map[string][][]any{}
+	}
+	key := set.key(values)
+	for _, row := range set.rows[key] {
+		if set.compare(row, values) == 0 {
+			return false
+		}
+	}
+	set.rows[key] = append(set.rows[key], values)
+	return true
+}
+
+func (set *rowSet) compare(a, b []any) int {
+	return compareRows(a, b, set.collations)
+}
+
+// key encodes the values like appendRow, with the reals that have an integer value as integers and the
+// texts converted by the key of their collation. The texts of a collation without a key are left out.
+func (set *rowSet) key(values []any) string {
+	key := make([]any, len(values))
+	for i, value := range values {
+		switch v := value.(type) {
+		case float64:
+			if v == math.Trunc(v) && v >= -9223372036854775808.0 && v < 9223372036854775808.0 {
+				value = int64(v)
+			}
+		case string:
+			value = ""
+			if collationKey := findCollationKey(set.collations[i]); collationKey != nil {
+				value = collationKey(v)
+			}
+		}
+		key[i] = value
+	}
+	return string(appendRow(nil, key))
+}
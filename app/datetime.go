@@ -0,0 +1,872 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timeNow is the current time used by 'now', which tests may replace
+var timeNow = time.Now
+
+// Times are kept like SQLite does, as a julian day number in milliseconds, or as the year, month, day,
+// hour, minute and seconds they were given with. Each form is computed from the other when needed.
+const (
+	msPerDay        = 86400000
+	unixEpochJD     = 210866760000000 // 1970-01-01 00:00:00 in julian day milliseconds
+	maxJD           = 464269060799999 // 9999-12-31 23:59:59.999
+	julianDayLimit  = 5373484.5       // the julian day number of the first day after year 9999
+	unixSecondsLow  = -210866760000   // -4713-11-24 12:00:00
+	unixSecondsHigh = 253402300799    // 9999-12-31 23:59:59
+)
+
+// dateTime is a time being computed by the date and time functions
+type dateTime struct {
+	JD                          int64 // julian day number times 86400000
+	Y, M, D                     int   // year, month and day
+	h, m                        int   // hour and minute
+	tz                          int   // timezone offset in minutes
+	s                           float64
+	validJD, validYMD, validHMS bool
+	rawS                        bool // s holds a number given as the time, not converted yet
+	isError, isUTC, isLocal     bool
+	useSubsec                   bool
+	nFloor                      int // days to go back for the 'floor' modifier after adding months or years
+}
+
+// dateFunction and the others below convert the time of their arguments like SQLite's functions of the
+// same name, giving NULL when the time or one of its modifiers is invalid
+func dateFunction(args []any) (any, error) {
+	x, ok := parseDateArgs(args)
+	if !ok {
+		return nil, nil
+	}
+	x.computeYMD()
+	return x.formatDate(), nil
+}
+
+func timeFunction(args []any) (any, error) {
+	x, ok := parseDateArgs(args)
+	if !ok {
+		return nil, nil
+	}
+	x.computeHMS()
+	return x.formatTime(), nil
+}
+
+func datetimeFunction(args []any) (any, error) {
+	x, ok := parseDateArgs(args)
+	if !ok {
+		return nil, nil
+	}
+	x.computeYMDHMS()
+	return x.formatDate() + " " + x.formatTime(), nil
+}
+
+func juliandayFunction(args []any) (any, error) {
+	x, ok := parseDateArgs(args)
+	if !ok {
+		return nil, nil
+	}
+	return float64(x.JD) / msPerDay, nil
+}
+
+func unixepochFunction(args []any) (any, error) {
+	x, ok := parseDateArgs(args)
+	if !ok {
+		return nil, nil
+	}
+	if x.useSubsec {
+		return float64(x.JD-unixEpochJD) / 1000, nil
+	}
+	return x.JD/1000 - unixEpochJD/1000, nil
+}
+
+// strftimeFunction formats the time of the arguments after the first one with the conversions of the
+// format, like %Y-%m-%d. An unknown conversion gives NULL.
+func strftimeFunction(args []any) (any, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	x, ok := parseDateArgs(args[1:])
+	if !ok {
+		return nil, nil
+	}
+	x.computeYMDHMS()
+	format := textValue(args[0])
+	var builder strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			builder.WriteByte(format[i])
+			continue
+		}
+		if i++; i == len(format) {
+			return nil, nil
+		}
+		switch format[i] {
+		case 'd':
+			fmt.Fprintf(&builder, "%02d", x.D)
+		case 'e':
+			fmt.Fprintf(&builder, "%2d", x.D)
+		case 'f':
+			builder.WriteString(printfNumber("%06.3f", min(x.s, 59.999)))
+		case 'F':
+			fmt.Fprintf(&builder, "%04d-%02d-%02d", x.Y, x.M, x.D)
+		case 'G', 'g':
+			// the year of the ISO week, which has the Thursday of the week
+			thursday := dateTime{JD: x.JD + int64(3-x.daysAfterMonday())*msPerDay, validJD: true}
+			thursday.computeYMD()
+			if format[i] == 'g' {
+				fmt.Fprintf(&builder, "%02d", thursday.Y%100)
+			} else {
+				fmt.Fprintf(&builder, "%04d", thursday.Y)
+			}
+		case 'H':
+			fmt.Fprintf(&builder, "%02d", x.h)
+		case 'k':
+			fmt.Fprintf(&builder, "%2d", x.h)
+		case 'I', 'l':
+			hour := x.h
+			if hour > 12 {
+				hour -= 12
+			} else if hour == 0 {
+				hour = 12
+			}
+			if format[i] == 'I' {
+				fmt.Fprintf(&builder, "%02d", hour)
+			} else {
+				fmt.Fprintf(&builder, "%2d", hour)
+			}
+		case 'j':
+			fmt.Fprintf(&builder, "%03d", x.daysAfterJan01()+1)
+		case 'J':
+			builder.WriteString(printfNumber("%.16g", float64(x.JD)/msPerDay))
+		case 'm':
+			fmt.Fprintf(&builder, "%02d", x.M)
+		case 'M':
+			fmt.Fprintf(&builder, "%02d", x.m)
+		case 'p', 'P':
+			meridiem := "AM"
+			if x.h >= 12 {
+				meridiem = "PM"
+			}
+			if format[i] == 'P' {
+				meridiem = lowerASCII(meridiem)
+			}
+			builder.WriteString(meridiem)
+		case 'R':
+			fmt.Fprintf(&builder, "%02d:%02d", x.h, x.m)
+		case 's':
+			if x.useSubsec {
+				builder.WriteString(printfNumber("%.3f", float64(x.JD-unixEpochJD)/1000))
+			} else {
+				fmt.Fprintf(&builder, "%d", x.JD/1000-unixEpochJD/1000)
+			}
+		case 'S':
+			fmt.Fprintf(&builder, "%02d", int(x.s))
+		case 'T':
+			fmt.Fprintf(&builder, "%02d:%02d:%02d", x.h, x.m, int(x.s))
+		case 'u', 'w':
+			day := x.daysAfterSunday()
+			if day == 0 && format[i] == 'u' {
+				day = 7
+			}
+			fmt.Fprintf(&builder, "%d", day)
+		case 'U':
+			fmt.Fprintf(&builder, "%02d", (x.daysAfterJan01()-x.daysAfterSunday()+7)/7)
+		case 'V':
+			thursday := dateTime{JD: x.JD + int64(3-x.daysAfterMonday())*msPerDay, validJD: true}
+			thursday.computeYMD()
+			fmt.Fprintf(&builder, "%02d", thursday.daysAfterJan01()/7+1)
+		case 'W':
+			fmt.Fprintf(&builder, "%02d", (x.daysAfterJan01()-x.daysAfterMonday()+7)/7)
+		case 'Y':
+			fmt.Fprintf(&builder, "%04d", x.Y)
+		case '%':
+			builder.WriteByte('%')
+		default:
+			return nil, nil
+		}
+	}
+	return builder.String(), nil
+}
+
+// timediffFunction writes the time from the second argument to the first one as years, months, days,
+// hours, minutes and seconds, like "+0001-02-03 04:05:06.000"
+func timediffFunction(args []any) (any, error) {
+	d1, ok := parseDateArgs(args[:1])
+	if !ok {
+		return nil, nil
+	}
+	d2, ok := parseDateArgs(args[1:])
+	if !ok {
+		return nil, nil
+	}
+	d1.computeYMDHMS()
+	d2.computeYMDHMS()
+	sign := byte('+')
+	var Y, M int
+	if d1.JD >= d2.JD {
+		Y = d1.Y - d2.Y
+		if Y != 0 {
+			d2.Y, d2.validJD = d1.Y, false
+			d2.computeJD()
+		}
+		M = d1.M - d2.M
+		if M < 0 {
+			Y--
+			M += 12
+		}
+		if M != 0 {
+			d2.M, d2.validJD = d1.M, false
+			d2.computeJD()
+		}
+		for d1.JD < d2.JD {
+			if M--; M < 0 {
+				M = 11
+				Y--
+			}
+			if d2.M--; d2.M < 1 {
+				d2.M = 12
+				d2.Y--
+			}
+			d2.validJD = false
+			d2.computeJD()
+		}
+		d1.JD -= d2.JD
+	} else {
+		sign = '-'
+		Y = d2.Y - d1.Y
+		if Y != 0 {
+			d2.Y, d2.validJD = d1.Y, false
+			d2.computeJD()
+		}
+		M = d2.M - d1.M
+		if M < 0 {
+			Y--
+			M += 12
+		}
+		if M != 0 {
+			d2.M, d2.validJD = d1.M, false
+			d2.computeJD()
+		}
+		for d1.JD > d2.JD {
+			if M--; M < 0 {
+				M = 11
+				Y--
+			}
+			if d2.M++; d2.M > 12 {
+				d2.M = 1
+				d2.Y++
+			}
+			d2.validJD = false
+			d2.computeJD()
+		}
+		d1.JD = d2.JD - d1.JD
+	}
+	// the difference is shown as a time from 0000-01-01 00:00:00
+	d1.JD += 148699540800000
+	d1.clearYMDHMS()
+	d1.computeYMDHMS()
+	return fmt.Sprintf("%c%04d-%02d-%02d %02d:%02d:%s", sign, Y, M, d1.D-1, d1.h, d1.m, printfNumber("%06.3f", d1.s)), nil
+}
+
+// printfNumber formats a number with the printf function, to round it like SQLite
+func printfNumber(format string, value float64) string {
+	text, _ := printfFunction([]any{format, value})
+	return text.(string)
+}
+
+// parseDateArgs reads the time of the first argument, or the current time without arguments, and applies
+// the modifiers of the other ones
+func parseDateArgs(args []any) (*dateTime, bool) {
+	x := &dateTime{}
+	if len(args) == 0 {
+		x.setNow()
+	} else {
+		switch v := args[0].(type) {
+		case nil:
+			return nil, false
+		case int64, float64:
+			x.setRawNumber(realValue(v))
+		default:
+			if !x.parse(textValue(v)) {
+				return nil, false
+			}
+		}
+	}
+	for i, arg := range args[min(len(args), 1):] {
+		if arg == nil || !x.applyModifier(textValue(arg), i+1) {
+			return nil, false
+		}
+	}
+	x.computeJD()
+	if x.isError || x.JD < 0 || x.JD > maxJD {
+		return nil, false
+	}
+	if len(args) == 1 && x.validYMD && x.D > 28 {
+		// a day after the end of the month moves to the next one, like 2023-02-31 to 2023-03-03
+		x.validYMD = false
+	}
+	return x, true
+}
+
+// setNow sets the time to the current one
+func (x *dateTime) setNow() {
+	*x = dateTime{JD: timeNow().UnixMilli() + unixEpochJD, validJD: true, isUTC: true}
+}
+
+// setRawNumber sets the time from a number, which is a julian day number unless a modifier like
+// 'unixepoch' tells otherwise
+func (x *dateTime) setRawNumber(value float64) {
+	x.s, x.rawS = value, true
+	if value >= 0 && value < julianDayLimit {
+		x.JD, x.validJD = int64(value*msPerDay+0.5), true
+	}
+}
+
+// parse reads a time written as YYYY-MM-DD, YYYY-MM-DD HH:MM:SS.SSS, HH:MM:SS.SSS, 'now' or a number,
+// where the seconds and the time may be left out and a timezone may follow the time
+func (x *dateTime) parse(text string) bool {
+	if x.parseYMD(text) || x.parseHMS(text) {
+		return true
+	}
+	if strings.EqualFold(text, "now") {
+		x.setNow()
+		return true
+	}
+	if value, isNumber := parseNumeric(text); isNumber {
+		x.setRawNumber(value)
+		return true
+	}
+	if strings.EqualFold(text, "subsec") || strings.EqualFold(text, "subsecond") {
+		x.setNow()
+		x.useSubsec = true
+		return true
+	}
+	return false
+}
+
+// parseYMD reads a date with an optional time after it
+func (x *dateTime) parseYMD(text string) bool {
+	negative := strings.HasPrefix(text, "-")
+	if negative {
+		text = text[1:]
+	}
+	values, ok := getDigits(text, "40f-21a-21d")
+	if !ok {
+		return false
+	}
+	text = strings.TrimLeft(text[10:], " \t\n\r\fT")
+	if x.parseHMS(text) {
+	} else if text == "" {
+		x.validHMS = false
+	} else {
+		return false
+	}
+	x.validJD, x.validYMD = false, true
+	x.Y, x.M, x.D = values[0], values[1], values[2]
+	if negative {
+		x.Y = -x.Y
+	}
+	x.computeFloor()
+	if x.tz != 0 {
+		x.computeJD()
+	}
+	return true
+}
+
+// parseHMS reads a time of the day as HH:MM, HH:MM:SS or HH:MM:SS.SSS, with an optional timezone
+func (x *dateTime) parseHMS(text string) bool {
+	values, ok := getDigits(text, "20c:20e")
+	if !ok {
+		return false
+	}
+	text = text[5:]
+	seconds := 0.0
+	if strings.HasPrefix(text, ":") {
+		second, ok := getDigits(text[1:], "20e")
+		if !ok {
+			return false
+		}
+		text = text[3:]
+		seconds = float64(second[0])
+		if len(text) > 1 && text[0] == '.' && isDigit(text[1]) {
+			fraction, scale := 0.0, 1.0
+			for text = text[1:]; text != "" && isDigit(text[0]); text = text[1:] {
+				fraction, scale = fraction*10+float64(text[0]-'0'), scale*10
+			}
+			seconds += fraction / scale
+		}
+	}
+	x.validJD, x.rawS, x.validHMS = false, false, true
+	x.h, x.m, x.s = values[0], values[1], seconds
+	return x.parseTimezone(text)
+}
+
+// parseTimezone reads the "+HH:MM", "-HH:MM" or "Z" after a time
+func (x *dateTime) parseTimezone(text string) bool {
+	text = strings.TrimLeft(text, " \t\n\r\f")
+	x.tz = 0
+	switch {
+	case text == "":
+		return true
+	case text[0] == 'Z' || text[0] == 'z':
+		x.isLocal, x.isUTC = false, true
+		text = text[1:]
+	case text[0] == '+' || text[0] == '-':
+		values, ok := getDigits(text[1:], "20b:20e")
+		if !ok {
+			return false
+		}
+		x.tz = values[0]*60 + values[1]
+		if text[0] == '-' {
+			x.tz = -x.tz
+		}
+		text = text[6:]
+	default:
+		return false
+	}
+	return strings.TrimLeft(text, " \t\n\r\f") == ""
+}
+
+// getDigits reads numbers with a fixed count of digits from the start of the text, following a spec
+// like SQLite's: each number is a count of digits, its minimum value and a letter for its maximum value,
+// followed by the separator expected after it.
+func getDigits(text, spec string) ([]int, bool) {
+	limits := map[byte]int{'a': 12, 'b': 14, 'c': 24, 'd': 31, 'e': 59, 'f': 14712}
+	values := []int{}
+	for len(spec) >= 3 {
+		count, low, high := int(spec[0]-'0'), int(spec[1]-'0'), limits[spec[2]]
+		if len(text) < count {
+			return nil, false
+		}
+		value := 0
+		for _, ch := range []byte(text[:count]) {
+			if !isDigit(ch) {
+				return nil, false
+			}
+			value = value*10 + int(ch-'0')
+		}
+		if value < low || value > high {
+			return nil, false
+		}
+		values = append(values, value)
+		text, spec = text[count:], spec[3:]
+		if spec != "" {
+			if text == "" || text[0] != spec[0] {
+				return nil, false
+			}
+			text, spec = text[1:], spec[1:]
+		}
+	}
+	return values, true
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+// computeJD finds the julian day number from the date and time, taking 2000-01-01 when there is no date
+func (x *dateTime) computeJD() {
+	if x.validJD {
+		return
+	}
+	Y, M, D := 2000, 1, 1
+	if x.validYMD {
+		Y, M, D = x.Y, x.M, x.D
+	}
+	if Y < -4713 || Y > 9999 || x.rawS {
+		*x = dateTime{isError: true}
+		return
+	}
+	if M <= 2 {
+		Y--
+		M += 12
+	}
+	A := Y / 100
+	B := 2 - A + A/4
+	X1 := 36525 * (Y + 4716) / 100
+	X2 := 306001 * (M + 1) / 10000
+	x.JD = int64((float64(X1+X2+D+B) - 1524.5) * msPerDay)
+	x.validJD = true
+	if x.validHMS {
+		x.JD += int64(x.h)*3600000 + int64(x.m)*60000 + int64(x.s*1000+0.5)
+		if x.tz != 0 {
+			x.JD -= int64(x.tz) * 60000
+			x.validYMD, x.validHMS, x.tz = false, false, 0
+			x.isUTC, x.isLocal = true, false
+		}
+	}
+}
+
+// computeYMD finds the date from the julian day number
+func (x *dateTime) computeYMD() {
+	if x.validYMD {
+		return
+	}
+	if !x.validJD {
+		x.Y, x.M, x.D = 2000, 1, 1
+	} else if x.JD < 0 || x.JD > maxJD {
+		*x = dateTime{isError: true}
+		return
+	} else {
+		Z := int((x.JD + 43200000) / msPerDay)
+		alpha := int((float64(Z)+32044.75)/36524.25) - 52
+		A := Z + 1 + alpha - (alpha+100)/4 + 25
+		B := A + 1524
+		C := int((float64(B) - 122.1) / 365.25)
+		D := (36525 * (C & 32767)) / 100
+		E := int(float64(B-D) / 30.6001)
+		X1 := int(30.6001 * float64(E))
+		x.D = B - D - X1
+		if E < 14 {
+			x.M = E - 1
+		} else {
+			x.M = E - 13
+		}
+		if x.M > 2 {
+			x.Y = C - 4716
+		} else {
+			x.Y = C - 4715
+		}
+	}
+	x.validYMD = true
+}
+
+// computeHMS finds the time of the day from the julian day number
+func (x *dateTime) computeHMS() {
+	if x.validHMS {
+		return
+	}
+	x.computeJD()
+	dayMs := int((x.JD + 43200000) % msPerDay)
+	x.s = float64(dayMs%60000) / 1000
+	dayMinutes := dayMs / 60000
+	x.m, x.h = dayMinutes%60, dayMinutes/60
+	x.rawS, x.validHMS = false, true
+}
+
+func (x *dateTime) computeYMDHMS() {
+	x.computeYMD()
+	x.computeHMS()
+}
+
+// clearYMDHMS keeps only the julian day number, after changing it
+func (x *dateTime) clearYMDHMS() {
+	x.validYMD, x.validHMS, x.tz = false, false, 0
+}
+
+// computeFloor finds how many days the date is past the end of its month, for the 'floor' modifier
+func (x *dateTime) computeFloor() {
+	switch {
+	case x.D <= 28:
+		x.nFloor = 0
+	case (1<<x.M)&0x15aa != 0:
+		// the months with 31 days
+		x.nFloor = 0
+	case x.M != 2:
+		x.nFloor = 0
+		if x.D == 31 {
+			x.nFloor = 1
+		}
+	case x.Y%4 != 0 || (x.Y%100 == 0 && x.Y%400 != 0):
+		x.nFloor = x.D - 28
+	default:
+		x.nFloor = x.D - 29
+	}
+}
+
+// toLocaltime converts the time from UTC to the local timezone
+func (x *dateTime) toLocaltime() {
+	x.computeJD()
+	local := time.UnixMilli(x.JD - unixEpochJD).Local()
+	*x = dateTime{
+		Y: local.Year(), M: int(local.Month()), D: local.Day(), h: local.Hour(), m: local.Minute(),
+		s:        float64(local.Second()) + float64(x.JD%1000)/1000,
+		validYMD: true, validHMS: true, useSubsec: x.useSubsec,
+	}
+}
+
+// toUTC converts the time from the local timezone to UTC, by guessing the UTC time and correcting the
+// guess with the error of converting it back to local time, like SQLite
+func (x *dateTime) toUTC() {
+	x.computeJD()
+	original, guess, difference := x.JD, x.JD, int64(0)
+	for tries := 0; tries == 0 || (difference != 0 && tries <= 3); tries++ {
+		guess -= difference
+		local := dateTime{JD: guess, validJD: true}
+		local.toLocaltime()
+		local.computeJD()
+		difference = local.JD - original
+	}
+	*x = dateTime{JD: guess, validJD: true, isUTC: true, useSubsec: x.useSubsec}
+}
+
+func (x *dateTime) daysAfterJan01() int {
+	jan01 := *x
+	jan01.validJD, jan01.M, jan01.D = false, 1, 1
+	jan01.computeJD()
+	return int((x.JD - jan01.JD + 43200000) / msPerDay)
+}
+
+func (x *dateTime) daysAfterMonday() int {
+	return int(((x.JD + 43200000) / msPerDay) % 7)
+}
+
+func (x *dateTime) daysAfterSunday() int {
+	return int(((x.JD + 129600000) / msPerDay) % 7)
+}
+
+// dateUnits are the units of modifiers like '+3 days', with the largest amount accepted and their
+// length in seconds. Months and years are added to the date, and only their fraction uses the length.
+var dateUnits = []struct {
+	Name    string
+	Limit   float64
+	Seconds float64
+}{
+	{"second", 4.6427e+14, 1},
+	{"minute", 7.7379e+12, 60},
+	{"hour", 1.2897e+11, 3600},
+	{"day", 5373485, 86400},
+	{"month", 176546, 2592000},
+	{"year", 14713, 31536000},
+}
+
+// applyModifier changes the time with a modifier, telling if it was valid. The modifiers 'unixepoch',
+// 'julianday' and 'auto' are only valid right after a number.
+func (x *dateTime) applyModifier(modifier string, position int) bool {
+	lower := lowerASCII(modifier)
+	switch {
+	case lower == "unixepoch" && x.rawS:
+		if position > 1 {
+			return false
+		}
+		ms := x.s*1000 + unixEpochJD
+		if ms < 0 || ms >= maxJD+1 {
+			return false
+		}
+		x.clearYMDHMS()
+		x.JD, x.validJD, x.rawS = int64(ms+0.5), true, false
+		return true
+	case lower == "julianday":
+		if position > 1 || !x.validJD || !x.rawS {
+			return false
+		}
+		x.rawS = false
+		return true
+	case lower == "auto":
+		if position > 1 {
+			return false
+		}
+		if !x.rawS || x.validJD {
+			x.rawS = false
+		} else if x.s >= unixSecondsLow && x.s <= unixSecondsHigh {
+			x.clearYMDHMS()
+			x.JD, x.validJD, x.rawS = int64(x.s*1000+unixEpochJD+0.5), true, false
+		}
+		return true
+	case lower == "localtime":
+		if !x.isLocal {
+			x.toLocaltime()
+		}
+		x.isUTC, x.isLocal = false, true
+		return true
+	case lower == "utc":
+		if !x.isUTC {
+			x.toUTC()
+		}
+		return true
+	case lower == "subsec" || lower == "subsecond":
+		x.useSubsec = true
+		return true
+	case lower == "ceiling":
+		x.computeJD()
+		x.clearYMDHMS()
+		x.nFloor = 0
+		return true
+	case lower == "floor":
+		x.computeJD()
+		x.JD -= int64(x.nFloor) * msPerDay
+		x.clearYMDHMS()
+		return true
+	case strings.HasPrefix(lower, "weekday "):
+		value, isNumber := parseNumeric(lower[8:])
+		weekday := int(value)
+		if !isNumber || value < 0 || value >= 7 || float64(weekday) != value {
+			return false
+		}
+		x.computeYMDHMS()
+		x.tz, x.validJD = 0, false
+		x.computeJD()
+		day := int((x.JD+129600000)/msPerDay) % 7
+		if day > weekday {
+			day -= 7
+		}
+		x.JD += int64(weekday-day) * msPerDay
+		x.clearYMDHMS()
+		return true
+	case strings.HasPrefix(lower, "start of "):
+		if !x.validJD && !x.validYMD && !x.validHMS {
+			return false
+		}
+		x.computeYMD()
+		x.validHMS, x.h, x.m, x.s = true, 0, 0, 0
+		x.rawS, x.tz, x.validJD = false, 0, false
+		switch lower[9:] {
+		case "month":
+			x.D = 1
+		case "year":
+			x.M, x.D = 1, 1
+		case "day":
+		default:
+			return false
+		}
+		return true
+	case lower != "" && strings.ContainsRune("+-0123456789", rune(lower[0])):
+		return x.applyOffset(lower)
+	}
+	return false
+}
+
+// applyOffset adds an amount of time written as "NNN units", "±HH:MM:SS.SSS" or "±YYYY-MM-DD HH:MM:SS"
+func (x *dateTime) applyOffset(modifier string) bool {
+	sign := modifier[0]
+	n := 1
+	for ; n < len(modifier); n++ {
+		if modifier[n] == ':' || strings.ContainsRune(" \t\n\r\f", rune(modifier[n])) {
+			break
+		}
+		if modifier[n] == '-' {
+			if _, ok := getDigits(modifier[1:], "40f"); n == 5 && ok {
+				break
+			}
+			if _, ok := getDigits(modifier[1:], "50f"); n == 6 && ok {
+				break
+			}
+		}
+	}
+	amount, isNumber := parseNumeric(modifier[:n])
+	if !isNumber {
+		return false
+	}
+	clock := ""
+	if strings.HasPrefix(modifier[n:], ":") {
+		clock = strings.TrimLeft(modifier, "+-")
+	} else if strings.HasPrefix(modifier[n:], "-") {
+		// years, months and days, with months up to 11 and days up to 30, and maybe a time after them
+		if sign != '+' && sign != '-' {
+			return false
+		}
+		values, ok := getDigits(modifier[1:], fmt.Sprintf("%d0f-20a-20d", n-1))
+		if !ok || values[1] >= 12 || values[2] >= 31 {
+			return false
+		}
+		x.computeYMDHMS()
+		x.validJD = false
+		days := values[2]
+		if sign == '-' {
+			x.Y -= values[0]
+			x.M -= values[1]
+			days = -days
+		} else {
+			x.Y += values[0]
+			x.M += values[1]
+		}
+		x.normalizeMonth()
+		x.computeFloor()
+		x.computeJD()
+		x.validHMS, x.validYMD = false, false
+		x.JD += int64(days) * msPerDay
+		rest := modifier[n+6:]
+		if rest == "" {
+			return true
+		}
+		if _, ok := getDigits(rest[1:], "20c:20e"); !ok || !strings.ContainsRune(" \t\n\r\f", rune(rest[0])) {
+			return false
+		}
+		clock = rest[1:]
+	}
+	if clock != "" {
+		// hours, minutes and seconds, keeping only the time of the day
+		offset := dateTime{}
+		if !offset.parseHMS(clock) {
+			return false
+		}
+		offset.computeJD()
+		offset.JD -= 43200000
+		offset.JD -= (offset.JD / msPerDay) * msPerDay
+		if sign == '-' {
+			offset.JD = -offset.JD
+		}
+		x.computeJD()
+		x.clearYMDHMS()
+		x.JD += offset.JD
+		return true
+	}
+
+	// an amount of units, like '+3 days'
+	unit := strings.TrimLeft(modifier[n:], " \t\n\r\f")
+	if len(unit) < 3 || len(unit) > 10 {
+		return false
+	}
+	unit = strings.TrimSuffix(unit, "s")
+	x.computeJD()
+	rounder := 0.5
+	if amount < 0 {
+		rounder = -0.5
+	}
+	x.nFloor = 0
+	defer x.clearYMDHMS()
+	for _, dateUnit := range dateUnits {
+		if dateUnit.Name != unit || amount <= -dateUnit.Limit || amount >= dateUnit.Limit {
+			continue
+		}
+		switch unit {
+		case "month":
+			x.computeYMDHMS()
+			x.M += int(amount)
+			x.normalizeMonth()
+			x.computeFloor()
+			x.validJD = false
+			amount -= float64(int(amount))
+		case "year":
+			x.computeYMDHMS()
+			x.Y += int(amount)
+			x.computeFloor()
+			x.validJD = false
+			amount -= float64(int(amount))
+		}
+		x.computeJD()
+		x.JD += int64(amount*1000*dateUnit.Seconds + rounder)
+		return true
+	}
+	return false
+}
+
+// normalizeMonth moves the months out of 1 to 12 into the year
+func (x *dateTime) normalizeMonth() {
+	years := (x.M - 12) / 12
+	if x.M > 0 {
+		years = (x.M - 1) / 12
+	}
+	x.Y += years
+	x.M -= years * 12
+}
+
+// formatDate writes the date as YYYY-MM-DD, with a minus sign for years before 0
+func (x *dateTime) formatDate() string {
+	if x.Y < 0 {
+		return fmt.Sprintf("-%04d-%02d-%02d", -x.Y, x.M, x.D)
+	}
+	return fmt.Sprintf("%04d-%02d-%02d", x.Y, x.M, x.D)
+}
+
+// formatTime writes the time of the day as HH:MM:SS, with milliseconds for the 'subsec' modifier
+func (x *dateTime) formatTime() string {
+	if x.useSubsec {
+		ms := int(1000*x.s + 0.5)
+		return fmt.Sprintf("%02d:%02d:%02d.%03d", x.h, x.m, ms/1000, ms%1000)
+	}
+	return fmt.Sprintf("%02d:%02d:%02d", x.h, x.m, int(x.s))
+}
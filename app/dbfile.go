@@ -3,11 +3,13 @@ package main
 import (
 	"encoding/binary"
 	"fmt"
-	"io"
 	"log"
 	"math"
 	"os"
 	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"unicode/utf16"
 )
 
@@ -15,6 +17,15 @@ type DbContext struct {
 	File   *os.File
 	Info   *DbInfo
 	Schema []SchemaEntry
+	stats  map[string]*indexStats
+	// parameters are the values set with .parameter, by the name of the parameter
+	parameters map[string]any
+	// pagesRead counts the pages read from the file, to know how much of it a query needed
+	pagesRead int
+	// openViews are the views whose SELECT is being planned, to find the ones defined with themselves
+	openViews []string
+	// commonTables are the tables of the WITH clauses known by the SELECT being planned
+	commonTables []*commonTable
 }
 
 type DbInfo struct {
@@ -48,19 +59,33 @@ type DbInfo struct {
 }
 
 type SchemaEntry struct {
-	Type        string
-	Name        string
-	TableName   string
-	RootPage    int
-	SQL         string
-	Columns     []ColumnDef
-	Constraints []string
+	Type         string
+	Name         string
+	TableName    string
+	RootPage     int
+	SQL          string
+	Columns      []ColumnDef
+	Constraints  []string
+	PrimaryKey   []ColumnDef
+	WithoutRowid bool
+	Where        *Expr // the rows on a partial index, with its columns unbound
 }
 
+// ColumnDef is a column of a table, or of an index or primary key with the sort order as its Type. Default
+// is the expression of its DEFAULT constraint, if any. Generated columns have their expression on Generated,
+// and are only found on the records when they are Stored.
 type ColumnDef struct {
 	Name        string
 	Type        string
 	Constraints []string
+	Default     *Expr
+	Generated   *Expr
+	Stored      bool
+}
+
+// isVirtual tells if the column is generated when read, and not found on the records
+func (column ColumnDef) isVirtual() bool {
+	return column.Generated != nil && !column.Stored
 }
 
 type PageHeader struct {
@@ -86,6 +111,18 @@ type TableRawRecord struct {
 	Data  []byte
 }
 
+// BtreeCell is a single cell from a b-tree page, decoded according to the page type
+type BtreeCell struct {
+	Offset        int
+	Size          int
+	LeftChildPage uint32
+	Rowid         int64
+	PayloadSize   int64
+	PayloadOffset int
+	LocalPayload  []byte
+	OverflowPage  uint32
+}
+
 type InteriorTableEntry struct {
 	childPage uint32
 	key       int64
@@ -96,6 +133,19 @@ type InteriorIndexEntry struct {
 	keyPayload []byte
 }
 
+// KeyColumn tells how a column of a b-tree key is sorted
+type KeyColumn struct {
+	Descending bool
+	Collation  string
+}
+
+// KeyRange limits the keys visited on a b-tree. The bounds are compared with the first columns of
+// the keys, and a nil bound leaves that side of the range open.
+type KeyRange struct {
+	Low, High                   []any
+	LowInclusive, HighInclusive bool
+}
+
 // ====================================
 // reading initial database information
 // ====================================
@@ -181,17 +231,18 @@ func (db *DbContext) readSchema() {
 		switch entry.Type {
 		case "table":
 			db.Info.NumberOfTables++
-			_, entry.Columns, entry.Constraints, err = parseCreateTable(entry.SQL)
+			_, entry.Columns, entry.Constraints, entry.PrimaryKey, err = parseCreateTable(entry.SQL)
 			if err != nil {
 				log.Fatalf("error parsing schema for table %q: %v", entry.Name, err)
 			}
+			entry.WithoutRowid = slices.Contains(entry.Constraints, "WITHOUT ROWID")
 		case "trigger":
 			db.Info.NumberOfTriggers++
 		case "view":
 			db.Info.NumberOfViews++
 		case "index":
 			db.Info.NumberOfIndexes++
-			_, _, entry.Columns, err = parseCreateIndex(entry.SQL)
+			_, _, entry.Columns, entry.Where, err = parseCreateIndex(entry.SQL)
 			if err != nil {
 				log.Fatalf("error parsing schema for index %q: %v", entry.Name, err)
 			}
@@ -199,10 +250,207 @@ func (db *DbContext) readSchema() {
 		schema = append(schema, entry)
 		schemaSize += len(entry.SQL)
 	}
+	// the indexes of PRIMARY KEY and UNIQUE constraints have no SQL, and are named sqlite_autoindex_TABLE_N
+	for i, entry := range schema {
+		if entry.Type != "index" || entry.SQL != "" {
+			continue
+		}
+		number, err := strconv.Atoi(entry.Name[strings.LastIndex(entry.Name, "_")+1:])
+		tableNumber := slices.IndexFunc(schema, func(table SchemaEntry) bool {
+			return table.Type == "table" && strings.EqualFold(table.Name, entry.TableName)
+		})
+		if err != nil || tableNumber == -1 {
+			continue
+		}
+		if indexes := autoindexColumns(schema[tableNumber]); number >= 1 && number <= len(indexes) {
+			schema[i].Columns = indexes[number-1]
+		}
+	}
 	db.Info.SchemaSize = uint32(schemaSize)
 	db.Schema = schema
 }
 
+// autoindexColumns finds the columns of the indexes created for the PRIMARY KEY and UNIQUE constraints of
+// a table, in the order they are numbered: the constraints of the columns first, and then the ones of the
+// table. Like SQLite, a constraint on the same columns as an earlier one has no index, and the primary key
+// aliased with the rowid has none. The primary key of a table without rowid is numbered, but it is the
+// table itself.
+func autoindexColumns(table SchemaEntry) [][]ColumnDef {
+	indexes := [][]ColumnDef{}
+	add := func(columns []ColumnDef) {
+		for _, index := range indexes {
+			if slices.EqualFunc(index, columns, func(a, b ColumnDef) bool { return strings.EqualFold(a.Name, b.Name) }) {
+				return
+			}
+		}
+		indexes = append(indexes, columns)
+	}
+	aliased := aliasedRowidColumn(table.Columns) >= 0
+	constraintKind := func(constraint string) string {
+		words := strings.Fields(strings.ToUpper(constraint))
+		if len(words) > 2 && words[0] == "CONSTRAINT" {
+			words = words[2:]
+		}
+		if len(words) > 0 {
+			return words[0]
+		}
+		return ""
+	}
+	// a primary key of the table is also added to the constraints of its columns
+	tablePrimaryKey := slices.ContainsFunc(table.Constraints, func(constraint string) bool { return constraintKind(constraint) == "PRIMARY" })
+	for _, column := range table.Columns {
+		for _, constraint := range column.Constraints {
+			switch constraintKind(constraint) {
+			case "PRIMARY":
+				if !aliased && !tablePrimaryKey {
+					add(table.PrimaryKey)
+				}
+			case "UNIQUE":
+				add([]ColumnDef{{Name: column.Name, Type: "ASC"}})
+			}
+		}
+	}
+	for _, constraint := range table.Constraints {
+		switch constraintKind(constraint) {
+		case "PRIMARY":
+			if !aliased {
+				add(table.PrimaryKey)
+			}
+		case "UNIQUE":
+			t := NewTokenizer(constraint)
+			for !t.AtEnd() && !t.Match("UNIQUE") {
+				t.Advance()
+			}
+			if columns, err := parseIndexedColumns(t); err == nil {
+				add(columns)
+			}
+		}
+	}
+	return indexes
+}
+
+// integer primary keys are stored as null and aliased with the rowid
+func aliasedRowidColumn(columns []ColumnDef) int {
+	aliasedColumn := -1
+	for columnNumber, columnDef := range columns {
+		if isPrimaryKeyColumn(columnDef) {
+			if aliasedColumn >= 0 || !strings.EqualFold(columnDef.Type, "INTEGER") {
+				// the rowid is only aliased by a primary key with a single column
+				return -1
+			}
+			aliasedColumn = columnNumber
+		}
+	}
+	return aliasedColumn
+}
+
+// recordColumns finds the table column stored on each field of the records. Tables without rowid
+// store the primary key columns first, followed by the other columns. Virtual columns are not stored.
+func recordColumns(table SchemaEntry) []int {
+	order := []int{}
+	if table.WithoutRowid {
+		for _, primaryKeyColumn := range table.PrimaryKey {
+			for number, column := range table.Columns {
+				if strings.EqualFold(column.Name, primaryKeyColumn.Name) {
+					order = append(order, number)
+				}
+			}
+		}
+	}
+	for number, column := range table.Columns {
+		if !slices.Contains(order, number) && !column.isVirtual() {
+			order = append(order, number)
+		}
+	}
+	return order
+}
+
+// virtualColumns finds the virtual columns of the table, in an order where each one comes after the
+// virtual columns used by its expression. The expressions are bound to the columns of the table followed
+// by the rowid.
+func virtualColumns(table SchemaEntry) []int {
+	sources := []tableSource{{Name: table.Name, Table: table}}
+	pending := []int{}
+	for number, column := range table.Columns {
+		if column.isVirtual() {
+			// the schema was checked by SQLite when the table was created
+			_ = bindColumns(column.Generated, sources)
+			pending = append(pending, number)
+		}
+	}
+	ordered := []int{}
+	for len(pending) > 0 {
+		ready := slices.IndexFunc(pending, func(number int) bool {
+			used := appendColumns(nil, table.Columns[number].Generated, sources[0])
+			return !slices.ContainsFunc(used, func(position int) bool { return position != number && slices.Contains(pending, position) })
+		})
+		if ready == -1 {
+			// columns depending on each other are refused by SQLite
+			return append(ordered, pending...)
+		}
+		ordered = append(ordered, pending[ready])
+		pending = slices.Delete(pending, ready, ready+1)
+	}
+	return ordered
+}
+
+// computeVirtualColumns sets the values of the virtual columns of a record read from the table, converted
+// to the affinity of the column
+func computeVirtualColumns(table SchemaEntry, record TableRecord, virtual []int) {
+	row := append(slices.Clone(record.Columns), record.Rowid)
+	for number, column := range table.Columns {
+		// the reals stored as integers are used as reals
+		if integer, isInteger := row[number].(int64); isInteger && columnAffinity(column.Type) == "REAL" {
+			row[number] = float64(integer)
+		}
+	}
+	if aliasedPKColumnNumber := aliasedRowidColumn(table.Columns); aliasedPKColumnNumber >= 0 && !table.WithoutRowid {
+		row[aliasedPKColumnNumber] = record.Rowid
+	}
+	for _, number := range virtual {
+		value, _ := evalExpr(table.Columns[number].Generated, row)
+		row[number] = storedValue(columnAffinity(table.Columns[number].Type), value)
+		record.Columns[number] = row[number]
+	}
+}
+
+// columnDefaults finds the default value of each column of the table, which is NULL when it has none
+func columnDefaults(table SchemaEntry) []any {
+	defaults := make([]any, len(table.Columns))
+	for i, column := range table.Columns {
+		if column.Default != nil {
+			defaults[i], _ = evalExpr(column.Default, nil)
+		}
+	}
+	return defaults
+}
+
+func isPrimaryKeyColumn(column ColumnDef) bool {
+	for _, constraint := range column.Constraints {
+		if strings.Contains(strings.ToUpper(constraint), "PRIMARY KEY") {
+			return true
+		}
+	}
+	return false
+}
+
+// columnAffinity determines the type affinity of a column from its declared type, following
+// the rules from https://www.sqlite.org/datatype3.html#determination_of_column_affinity
+func columnAffinity(declaredType string) string {
+	declaredType = strings.ToUpper(declaredType)
+	switch {
+	case strings.Contains(declaredType, "INT"):
+		return "INTEGER"
+	case strings.Contains(declaredType, "CHAR") || strings.Contains(declaredType, "CLOB") || strings.Contains(declaredType, "TEXT"):
+		return "TEXT"
+	case strings.Contains(declaredType, "BLOB") || declaredType == "":
+		return "BLOB"
+	case strings.Contains(declaredType, "REAL") || strings.Contains(declaredType, "FLOA") || strings.Contains(declaredType, "DOUB"):
+		return "REAL"
+	}
+	return "NUMERIC"
+}
+
 // ====================================
 // retrieval strategies
 // ====================================
@@ -238,37 +486,51 @@ func (db *DbContext) fullTableScan(rootPage int) []TableRecord {
 	return tableData
 }
 
-func (db *DbContext) indexedTableScan(rootPage, filterIndexPage int, filterValue any, indexSortOrder int) []TableRecord {
-	var rowids []int64
-	var tableData []TableRecord
-	db.walkBtreeIndexPages(filterIndexPage, filterValue, indexSortOrder, &rowids)
-	slices.Sort(rowids)
-	for _, rowid := range rowids {
+// indexedTableScan reads the rows of a table found on a range of an index, searching the table for the
+// rowid at the end of each key
+func (db *DbContext) indexedTableScan(rootPage, indexPage int, keyRange KeyRange, keyColumns []KeyColumn, reverse bool, visit func(row TableRecord) bool) bool {
+	return db.scanIndexRange(indexPage, keyRange, keyColumns, reverse, func(key []any) bool {
 		// starting from table root page, binary search for each rowid and retrieve only the filtered records
 		// implement the most dumb form (may retrieve pages multiple times)
+		rowid := key[len(key)-1].(int64)
 		record := db.getRecordByRowid(rootPage, rowid)
 		if record == nil {
 			log.Fatal("unexpected missing rowid: ", rowid)
 		}
-		tableData = append(tableData, *record)
-	}
-	return tableData
+		return visit(*record)
+	})
 }
 
-// ====================================
-// reading and decoding btree pages
-// ====================================
-
 func (db *DbContext) getPage(pageNumber int) (header PageHeader, page []byte) {
+	page, err := db.readPage(pageNumber)
+	if err != nil {
+		log.Fatal(err)
+	}
+	header, err = db.parsePageHeader(pageNumber, page)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return
+}
+
+func (db *DbContext) readPage(pageNumber int) ([]byte, error) {
 	info := db.Info
 	if pageNumber < 1 {
-		log.Fatal("invalid page number:", pageNumber)
+		return nil, fmt.Errorf("invalid page number: %d", pageNumber)
 	}
 
-	_, err := db.File.Seek(int64(pageNumber-1)*int64(info.DatabasePageSize), io.SeekStart)
+	db.pagesRead++
+	page := make([]byte, info.DatabasePageSize)
+	_, err := db.File.ReadAt(page, int64(pageNumber-1)*int64(info.DatabasePageSize))
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("reading page %d: %w", pageNumber, err)
 	}
+	return page, nil
+}
+
+func (db *DbContext) parsePageHeader(pageNumber int, page []byte) (header PageHeader, err error) {
+	info := db.Info
 
 	pageOffset := 0
 	if pageNumber == 1 {
@@ -276,12 +538,6 @@ func (db *DbContext) getPage(pageNumber int) (header PageHeader, page []byte) {
 		pageOffset += 100
 	}
 
-	page = make([]byte, info.DatabasePageSize)
-	_, err = db.File.Read(page)
-	if err != nil {
-		log.Fatal(err)
-	}
-
 	// These constants and calculations are described in detail on the spec
 	// https://www.sqlite.org/fileformat2.html#b_tree_pages
 	header.MinOverflowPayloadSize = ((info.UsablePageSize - 12) * 32 / 255) - 23
@@ -293,7 +549,7 @@ func (db *DbContext) getPage(pageNumber int) (header PageHeader, page []byte) {
 	case 0x05, 0x0d:
 		header.MaxOverflowPayloadSize = info.UsablePageSize - 35
 	default:
-		log.Fatal("page ", pageNumber, " has invalid type: ", header.PageType)
+		return header, fmt.Errorf("page %d has invalid type: %d", pageNumber, header.PageType)
 	}
 
 	// parsing b-tree page header
@@ -310,21 +566,16 @@ func (db *DbContext) getPage(pageNumber int) (header PageHeader, page []byte) {
 		header.RightMostPointer = readBigEndianUint32(page[pageOffset+8 : pageOffset+12])
 		header.CellPointerArrayOffset += 4
 	}
-	header.UnallocatedRegionSize = header.StartOfCellContentArea - (header.CellPointerArrayOffset + uint32(header.CellCount)*2)
 
 	// account for the db header if needed
 	header.CellPointerArrayOffset += uint32(pageOffset)
 
-	if debugMode {
-		fmt.Printf("---------- page header ----------\n")
-		fmt.Printf("pageType:               %v\n", header.PageType)
-		fmt.Printf("firstFreeBlock:         %v\n", header.FirstFreeBlock)
-		fmt.Printf("cellCount:              %v\n", header.CellCount)
-		fmt.Printf("startOfCellContentArea: %v\n", header.StartOfCellContentArea)
-		fmt.Printf("fragmentedFreeBytes:    %v\n", header.FragmentedFreeBytes)
-		fmt.Printf("rightMostPointer:       %v\n", header.RightMostPointer)
-		fmt.Printf("unallocatedRegionSize:  %v\n", header.UnallocatedRegionSize)
+	cellPointerArrayEnd := header.CellPointerArrayOffset + uint32(header.CellCount)*2
+	if header.StartOfCellContentArea < cellPointerArrayEnd || header.StartOfCellContentArea > uint32(len(page)) {
+		return header, fmt.Errorf("page %d has invalid cell content area: %d", pageNumber, header.StartOfCellContentArea)
 	}
+	header.UnallocatedRegionSize = header.StartOfCellContentArea - cellPointerArrayEnd
+
 	return
 }
 
@@ -337,19 +588,63 @@ func getCellOffsets(pageHeader PageHeader, page []byte) (offsets []int) {
 	return
 }
 
-func getInteriorTableEntries(pageHeader PageHeader, page []byte) (entries []InteriorTableEntry) {
-	if debugMode {
-		fmt.Printf("cell\tpointer\tpage\tkey\n")
+// parseCell decodes the cell at offset, checking that it fits on the page
+func (db *DbContext) parseCell(pageHeader PageHeader, page []byte, offset int) (cell BtreeCell, err error) {
+	usableSize := int(db.Info.UsablePageSize)
+	cell.Offset = offset
+	if offset < 0 || offset >= usableSize {
+		return cell, fmt.Errorf("cell offset out of page limits: %d", offset)
+	}
+	if pageHeader.PageType == 0x02 || pageHeader.PageType == 0x05 {
+		if offset+4 > usableSize {
+			return cell, fmt.Errorf("truncated cell at offset: %d", cell.Offset)
+		}
+		cell.LeftChildPage = readBigEndianUint32(page[offset : offset+4])
+		offset += 4
+	}
+	if pageHeader.PageType != 0x05 {
+		payloadSize, bytes, ok := tryReadBigEndianVarint(page[offset:usableSize])
+		if !ok || payloadSize < 0 {
+			return cell, fmt.Errorf("invalid payload size at offset: %d", cell.Offset)
+		}
+		cell.PayloadSize = payloadSize
+		offset += bytes
 	}
-	for cell, cellPointer := range getCellOffsets(pageHeader, page) {
+	if pageHeader.PageType == 0x05 || pageHeader.PageType == 0x0d {
+		rowid, bytes, ok := tryReadBigEndianVarint(page[offset:usableSize])
+		if !ok {
+			return cell, fmt.Errorf("invalid rowid at offset: %d", cell.Offset)
+		}
+		cell.Rowid = rowid
+		offset += bytes
+	}
+	if pageHeader.PageType != 0x05 {
+		cell.PayloadOffset = offset
+		localSize := cell.PayloadSize
+		if cell.PayloadSize > int64(pageHeader.MaxOverflowPayloadSize) {
+			localSize, _ = db.calcOverflowSizes(pageHeader, cell.PayloadSize)
+			if int64(offset)+localSize+4 > int64(usableSize) {
+				return cell, fmt.Errorf("payload exceeds page limits at offset: %d", cell.Offset)
+			}
+			cell.OverflowPage = readBigEndianUint32(page[offset+int(localSize):])
+			offset += 4
+		} else if int64(offset)+localSize > int64(usableSize) {
+			return cell, fmt.Errorf("payload exceeds page limits at offset: %d", cell.Offset)
+		}
+		cell.LocalPayload = page[cell.PayloadOffset : cell.PayloadOffset+int(localSize)]
+		offset += int(localSize)
+	}
+	cell.Size = offset - cell.Offset
+	return
+}
+
+func getInteriorTableEntries(pageHeader PageHeader, page []byte) (entries []InteriorTableEntry) {
+	for _, cellPointer := range getCellOffsets(pageHeader, page) {
 		offset := cellPointer
 		leftChildPage := readBigEndianUint32(page[offset : offset+4])
 		offset += 4
 		key, bytes := readBigEndianVarint(page[offset:])
 		offset += bytes
-		if debugMode {
-			fmt.Printf("%v\t%04x\t%v\t%v\n", cell, cellPointer, leftChildPage, key)
-		}
 		entries = append(entries, InteriorTableEntry{leftChildPage, key})
 	}
 	entries = append(entries, InteriorTableEntry{pageHeader.RightMostPointer, -1})
@@ -358,10 +653,7 @@ func getInteriorTableEntries(pageHeader PageHeader, page []byte) (entries []Inte
 }
 
 func (db *DbContext) getLeafTableRecords(pageHeader PageHeader, page []byte) (tableData []TableRecord) {
-	if debugMode {
-		fmt.Printf("cell\tpointer\tpayload\trowid\tcontent\n")
-	}
-	for cell, cellPointer := range getCellOffsets(pageHeader, page) {
+	for _, cellPointer := range getCellOffsets(pageHeader, page) {
 		offset := cellPointer
 		payloadSize, bytes := readBigEndianVarint(page[offset:])
 		offset += bytes
@@ -373,9 +665,6 @@ func (db *DbContext) getLeafTableRecords(pageHeader PageHeader, page []byte) (ta
 		} else {
 			record = page[offset : offset+int(payloadSize)]
 		}
-		if debugMode {
-			fmt.Printf("%v\t%04x\t%v\t%v\t", cell, cellPointer, payloadSize, rowid)
-		}
 
 		columnData := db.parseRecordFormat(record)
 		tableData = append(tableData, TableRecord{Rowid: rowid, Columns: columnData})
@@ -402,11 +691,7 @@ func (db *DbContext) getLeafTableRawRecords(pageHeader PageHeader, page []byte)
 }
 
 func (db *DbContext) getInteriorIndexEntries(pageHeader PageHeader, page []byte) (entries []InteriorIndexEntry) {
-	if debugMode {
-		fmt.Printf("cell\tpointer\tpage\tpayload\n")
-	}
-
-	for cell, cellPointer := range getCellOffsets(pageHeader, page) {
+	for _, cellPointer := range getCellOffsets(pageHeader, page) {
 		offset := cellPointer
 		leftChildPage := readBigEndianUint32(page[offset : offset+4])
 		offset += 4
@@ -418,9 +703,6 @@ func (db *DbContext) getInteriorIndexEntries(pageHeader PageHeader, page []byte)
 		} else {
 			keyPayload = page[offset : offset+int(payloadSize)]
 		}
-		if debugMode {
-			fmt.Printf("%v\t%04x\t%v\t%q\n", cell, cellPointer, leftChildPage, keyPayload)
-		}
 		entries = append(entries, InteriorIndexEntry{leftChildPage, keyPayload})
 	}
 	entries = append(entries, InteriorIndexEntry{pageHeader.RightMostPointer, nil})
@@ -429,11 +711,7 @@ func (db *DbContext) getInteriorIndexEntries(pageHeader PageHeader, page []byte)
 }
 
 func (db *DbContext) getLeafIndexEntries(pageHeader PageHeader, page []byte) (records [][]byte) {
-	// reading each cell pointer array
-	if debugMode {
-		fmt.Printf("cell\tpointer\tkey\n")
-	}
-	for cell, cellPointer := range getCellOffsets(pageHeader, page) {
+	for _, cellPointer := range getCellOffsets(pageHeader, page) {
 		offset := cellPointer
 		payloadSize, bytes := readBigEndianVarint(page[offset:])
 		offset += bytes
@@ -443,9 +721,6 @@ func (db *DbContext) getLeafIndexEntries(pageHeader PageHeader, page []byte) (re
 		} else {
 			keyPayload = page[offset : offset+int(payloadSize)]
 		}
-		if debugMode {
-			fmt.Printf("%v\t%04x\t%v\n", cell, cellPointer, keyPayload)
-		}
 		records = append(records, keyPayload)
 	}
 
@@ -453,12 +728,27 @@ func (db *DbContext) getLeafIndexEntries(pageHeader PageHeader, page []byte) (re
 }
 
 func (db *DbContext) parseRecordFormat(record []byte) []any {
+	columnData, _, err := db.decodeRecord(record)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return columnData
+}
+
+// decodeRecord parses the record format, returning the column values and the number of bytes used
+func (db *DbContext) decodeRecord(record []byte) ([]any, int, error) {
 	// determine column type and lenghts from record header
-	recordHeaderSize, bytes := readBigEndianVarint(record)
+	recordHeaderSize, bytes, ok := tryReadBigEndianVarint(record)
+	if !ok || recordHeaderSize < int64(bytes) || recordHeaderSize > int64(len(record)) {
+		return nil, 0, fmt.Errorf("invalid record header size: %d", recordHeaderSize)
+	}
 	index := bytes
 	columnTypeLengths := [][2]int{}
 	for index < int(recordHeaderSize) {
-		typeCode, bytes := readBigEndianVarint(record[index:recordHeaderSize])
+		typeCode, bytes, ok := tryReadBigEndianVarint(record[index:recordHeaderSize])
+		if !ok {
+			return nil, 0, fmt.Errorf("truncated record header")
+		}
 		var typeLength [2]int
 		switch typeCode {
 		case 0:
@@ -481,16 +771,14 @@ func (db *DbContext) parseRecordFormat(record []byte) []any {
 			typeLength = [2]int{8, 0}
 		case 9:
 			typeLength = [2]int{9, 0}
-		case 10, 11:
-			typeLength = [2]int{int(typeCode), 1}
 		default:
 			if typeCode < 12 {
-				log.Fatal("invalid column type code: ", typeCode)
+				return nil, 0, fmt.Errorf("invalid column type code: %d", typeCode)
 			}
 			if typeCode%2 == 0 {
-				typeLength = [2]int{12, (int(typeCode) - 12) / 2}
+				typeLength = [2]int{12, int((typeCode - 12) / 2)}
 			} else {
-				typeLength = [2]int{13, (int(typeCode) - 13) / 2}
+				typeLength = [2]int{13, int((typeCode - 13) / 2)}
 			}
 		}
 		columnTypeLengths = append(columnTypeLengths, typeLength)
@@ -501,11 +789,17 @@ func (db *DbContext) parseRecordFormat(record []byte) []any {
 
 	columnData := []any{}
 	for _, typeLength := range columnTypeLengths {
+		if typeLength[1] > len(record)-index {
+			return nil, 0, fmt.Errorf("record body exceeds payload size")
+		}
 		switch typeLength[0] {
 		case 0:
 			columnData = append(columnData, nil)
 		case 1:
 			integer := readBigEndianInt(record[index : index+typeLength[1]])
+			// sign extension for integers smaller than 8 bytes
+			shift := 64 - 8*typeLength[1]
+			integer = integer << shift >> shift
 			columnData = append(columnData, integer)
 		case 2:
 			bits := readBigEndianInt(record[index : index+typeLength[1]])
@@ -521,28 +815,25 @@ func (db *DbContext) parseRecordFormat(record []byte) []any {
 			switch db.Info.TextEncoding {
 			case 1: // utf-8
 				columnData = append(columnData, string(record[index:index+typeLength[1]]))
-			case 2: // utf-16 big endian
+			case 2: // utf-16 little endian
 				utf16str := []uint16{}
-				for i := index; i < index+typeLength[1]; i += 2 {
+				for i := index; i+1 < index+typeLength[1]; i += 2 {
 					utf16str = append(utf16str, binary.LittleEndian.Uint16(record[i:i+2]))
 				}
 				columnData = append(columnData, string(utf16.Decode(utf16str)))
 			case 3: // utf-16 big endian
 				utf16str := []uint16{}
-				for i := index; i < index+typeLength[1]; i += 2 {
+				for i := index; i+1 < index+typeLength[1]; i += 2 {
 					utf16str = append(utf16str, binary.BigEndian.Uint16(record[i:i+2]))
 				}
 				columnData = append(columnData, string(utf16.Decode(utf16str)))
 			default:
-				log.Fatal("unknown text encoding: ", db.Info.TextEncoding)
+				return nil, 0, fmt.Errorf("unknown text encoding: %d", db.Info.TextEncoding)
 			}
 		}
 		index += typeLength[1]
 	}
-	if debugMode {
-		fmt.Printf("%#v\n", columnData)
-	}
-	return columnData
+	return columnData, index, nil
 }
 
 // ====================================
@@ -550,26 +841,33 @@ func (db *DbContext) parseRecordFormat(record []byte) []any {
 // ====================================
 
 func (db *DbContext) getDataWithOverflow(pageHeader PageHeader, page []byte, offset int, payloadSize int64) (record []byte) {
+	record, err := db.readDataWithOverflow(pageHeader, page, offset, payloadSize)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return
+}
+
+func (db *DbContext) readDataWithOverflow(pageHeader PageHeader, page []byte, offset int, payloadSize int64) (record []byte, err error) {
 	chunkSize, remainingSize := db.calcOverflowSizes(pageHeader, payloadSize)
+	if offset+int(chunkSize)+4 > len(page) {
+		return nil, fmt.Errorf("overflow payload exceeds page limits")
+	}
 	record = slices.Clone(page[offset : offset+int(chunkSize)])
 	overflowPage := int(readBigEndianUint32(page[offset+int(chunkSize):]))
-	if debugMode {
-		fmt.Fprintf(os.Stderr, "total payload size: %d\n", payloadSize)
-		fmt.Fprintf(os.Stderr, "min/max: %d - %d\n", pageHeader.MinOverflowPayloadSize, pageHeader.MaxOverflowPayloadSize)
-		fmt.Fprintf(os.Stderr, "chunk/remaining: %d - %d\n", chunkSize, remainingSize)
-		fmt.Fprintf(os.Stderr, "overflow first page: %d\n", overflowPage)
-		// fmt.Fprintf(os.Stderr, "this chunk data: %q\n", record)
-	}
 	for overflowPage != 0 {
-		next, data := db.getOverflowPage(overflowPage)
+		next, data, err := db.getOverflowPage(overflowPage)
+		if err != nil {
+			return nil, err
+		}
 		size := min(int64(len(data)), remainingSize)
 		record = append(record, data[:size]...)
 		remainingSize -= size
 		if next == 0 && remainingSize > 0 {
-			log.Fatal("missing link on overflow chain!")
+			return nil, fmt.Errorf("missing link on overflow chain!")
 		}
 		if next != 0 && remainingSize == 0 {
-			log.Fatal("unexpected next link on overflow chain")
+			return nil, fmt.Errorf("unexpected next link on overflow chain")
 		}
 		overflowPage = next
 	}
@@ -590,26 +888,13 @@ func (db *DbContext) calcOverflowSizes(pageHeader PageHeader, payloadSize int64)
 	return
 }
 
-func (db *DbContext) getOverflowPage(pageNumber int) (next int, data []byte) {
-	info := db.Info
-	if pageNumber < 1 {
-		log.Fatal("invalid page number:", pageNumber)
-	}
-
-	_, err := db.File.Seek(int64(pageNumber-1)*int64(info.DatabasePageSize), io.SeekStart)
+func (db *DbContext) getOverflowPage(pageNumber int) (next int, data []byte, err error) {
+	page, err := db.readPage(pageNumber)
 	if err != nil {
-		log.Fatal(err)
+		return
 	}
-
-	page := make([]byte, info.DatabasePageSize)
-	_, err = db.File.Read(page)
-	if err != nil {
-		log.Fatal(err)
-	}
-
 	next = int(readBigEndianUint32(page[0:4]))
-	data = page[4:]
-
+	data = page[4:db.Info.UsablePageSize]
 	return
 }
 
@@ -648,59 +933,182 @@ func (db *DbContext) walkBtreeTablePages(page int, tableDataPtr *[]TableRecord)
 	}
 }
 
-func (db *DbContext) walkBtreeIndexPages(page int, filterValue any, indexSortOrder int, rowids *[]int64) {
+// scanIndexRange visits in order the keys of an index b-tree that are inside the range, starting from the
+// first one found with a binary search on each page, or from the last one when reverse is set. It stops
+// when visit returns false, which is also returned to tell the caller to stop.
+func (db *DbContext) scanIndexRange(page int, keyRange KeyRange, keyColumns []KeyColumn, reverse bool, visit func(key []any) bool) bool {
 	header, data := db.getPage(page)
 	if header.PageType == 0x02 {
 		entries := db.getInteriorIndexEntries(header, data)
-		lo, hi := 0, len(entries)-1
-		for lo <= hi {
-			mid := (lo + hi) / 2
-			if mid == len(entries)-1 {
-				// right-most child
-				lo = mid
-				break
+		if reverse {
+			// the child pages after the first key past the range only have keys past it too
+			last := sort.Search(len(entries)-1, func(i int) bool {
+				return keyRange.after(db.parseRecordFormat(entries[i].keyPayload), keyColumns)
+			})
+			for i := last; i >= 0; i-- {
+				if !db.scanIndexRange(int(entries[i].childPage), keyRange, keyColumns, reverse, visit) {
+					return false
+				}
+				if i == 0 {
+					break
+				}
+				key := db.parseRecordFormat(entries[i-1].keyPayload)
+				if keyRange.before(key, keyColumns) || !visit(key) {
+					return false
+				}
 			}
-			key := db.parseRecordFormat(entries[mid].keyPayload)
-			if len(key) > 2 {
-				log.Fatal("multi-key index not implemented!")
+			return true
+		}
+		// every key on a child page comes before the key of its entry, the last entry is the right-most child
+		first := sort.Search(len(entries)-1, func(i int) bool {
+			return !keyRange.before(db.parseRecordFormat(entries[i].keyPayload), keyColumns)
+		})
+		for _, entry := range entries[first:] {
+			if !db.scanIndexRange(int(entry.childPage), keyRange, keyColumns, reverse, visit) {
+				return false
 			}
-			if compareAny(key[0], filterValue) == 0 {
-				// NOTE: the interior page itself also point to a valid row that is NOT on the leaf page!
-				*rowids = append(*rowids, key[1].(int64))
-				lo = mid
+			if entry.keyPayload == nil {
 				break
-			} else if indexSortOrder*compareAny(filterValue, key[0]) < 0 {
-				hi = mid - 1
-			} else {
-				lo = mid + 1
 			}
-		}
-		// NOTE: how to properly check for keys that have records on more than one page?
-		for i := lo; i <= lo+1 && i < len(entries); i++ {
-			db.walkBtreeIndexPages(int(entries[i].childPage), filterValue, indexSortOrder, rowids)
+			// NOTE: the interior page itself also point to a valid row that is NOT on the leaf page!
+			key := db.parseRecordFormat(entry.keyPayload)
+			if keyRange.after(key, keyColumns) || !visit(key) {
+				return false
+			}
 		}
 	} else if header.PageType == 0x0a {
 		entries := db.getLeafIndexEntries(header, data)
-		lo, hi := 0, len(entries)-1
-		for lo <= hi {
-			mid := (lo + hi) / 2
-			key := db.parseRecordFormat(entries[mid])
-			if indexSortOrder*compareAny(filterValue, key[0]) <= 0 {
-				hi = mid - 1
-			} else {
-				lo = mid + 1
+		if reverse {
+			last := sort.Search(len(entries), func(i int) bool {
+				return keyRange.after(db.parseRecordFormat(entries[i]), keyColumns)
+			})
+			for i := last - 1; i >= 0; i-- {
+				key := db.parseRecordFormat(entries[i])
+				if keyRange.before(key, keyColumns) || !visit(key) {
+					return false
+				}
 			}
+			return true
 		}
-		for i := lo; i < len(entries); i++ {
-			key := db.parseRecordFormat(entries[i])
-			if indexSortOrder*compareAny(key[0], filterValue) > 0 {
-				break
+		first := sort.Search(len(entries), func(i int) bool {
+			return !keyRange.before(db.parseRecordFormat(entries[i]), keyColumns)
+		})
+		for _, entry := range entries[first:] {
+			key := db.parseRecordFormat(entry)
+			if keyRange.after(key, keyColumns) || !visit(key) {
+				return false
 			}
-			*rowids = append(*rowids, key[1].(int64))
 		}
 	} else {
 		log.Fatal("unexpected page type when walking index btree: ", header.PageType)
 	}
+	return true
+}
+
+// scanTableRange visits in order the rows of a table b-tree with a rowid inside the range, from the last
+// one when reverse is set
+func (db *DbContext) scanTableRange(page int, keyRange KeyRange, reverse bool, visit func(row TableRecord) bool) bool {
+	header, data := db.getPage(page)
+	if header.PageType == 0x05 {
+		entries := getInteriorTableEntries(header, data)
+		if reverse {
+			// each child page has the rowids after the key of the entry before it
+			last := sort.Search(len(entries)-1, func(i int) bool {
+				return keyRange.after([]any{entries[i].key}, nil)
+			})
+			for i := last; i >= 0; i-- {
+				if !db.scanTableRange(int(entries[i].childPage), keyRange, reverse, visit) {
+					return false
+				}
+				if i > 0 && keyRange.before([]any{entries[i-1].key}, nil) {
+					return false
+				}
+			}
+			return true
+		}
+		// each child page has the rowids up to the key of its entry, the last entry is the right-most child
+		first := sort.Search(len(entries)-1, func(i int) bool {
+			return !keyRange.before([]any{entries[i].key}, nil)
+		})
+		for i, entry := range entries[first:] {
+			if !db.scanTableRange(int(entry.childPage), keyRange, reverse, visit) {
+				return false
+			}
+			if first+i < len(entries)-1 && keyRange.after([]any{entry.key}, nil) {
+				return false
+			}
+		}
+	} else if header.PageType == 0x0d {
+		records := db.getLeafTableRawRecords(header, data)
+		if reverse {
+			last := sort.Search(len(records), func(i int) bool {
+				return keyRange.after([]any{records[i].Rowid}, nil)
+			})
+			for i := last - 1; i >= 0; i-- {
+				if keyRange.before([]any{records[i].Rowid}, nil) {
+					return false
+				}
+				if !visit(TableRecord{records[i].Rowid, db.parseRecordFormat(records[i].Data)}) {
+					return false
+				}
+			}
+			return true
+		}
+		first := sort.Search(len(records), func(i int) bool {
+			return !keyRange.before([]any{records[i].Rowid}, nil)
+		})
+		for _, record := range records[first:] {
+			if keyRange.after([]any{record.Rowid}, nil) {
+				return false
+			}
+			if !visit(TableRecord{record.Rowid, db.parseRecordFormat(record.Data)}) {
+				return false
+			}
+		}
+	} else {
+		log.Fatal("unexpected page type when walking table btree: ", header.PageType)
+	}
+	return true
+}
+
+// before tells if the key comes before the start of the range
+func (keyRange KeyRange) before(key []any, keyColumns []KeyColumn) bool {
+	if keyRange.Low == nil {
+		return false
+	}
+	comparison := compareKeys(key, keyRange.Low, keyColumns)
+	return comparison < 0 || (comparison == 0 && !keyRange.LowInclusive)
+}
+
+// after tells if the key comes after the end of the range
+func (keyRange KeyRange) after(key []any, keyColumns []KeyColumn) bool {
+	if keyRange.High == nil {
+		return false
+	}
+	comparison := compareKeys(key, keyRange.High, keyColumns)
+	return comparison > 0 || (comparison == 0 && !keyRange.HighInclusive)
+}
+
+// compareKeys compares the first columns of a key with a prefix, in the order of the b-tree
+func compareKeys(key []any, prefix []any, keyColumns []KeyColumn) int {
+	for i, value := range prefix {
+		var keyValue any
+		if i < len(key) {
+			keyValue = key[i]
+		}
+		keyColumn := KeyColumn{}
+		if i < len(keyColumns) {
+			keyColumn = keyColumns[i]
+		}
+		comparison := compareCollated(keyValue, value, keyColumn.Collation)
+		if keyColumn.Descending {
+			comparison = -comparison
+		}
+		if comparison != 0 {
+			return comparison
+		}
+	}
+	return 0
 }
 
 func (db *DbContext) getRecordByRowid(page int, rowid int64) *TableRecord {
@@ -746,52 +1154,13 @@ func (db *DbContext) getRecordByRowid(page int, rowid int64) *TableRecord {
 	return nil
 }
 
-func (db *DbContext) getRecordByPK(page int, pkColumnNumber int, key any) *TableRecord {
-	header, data := db.getPage(page)
-	if header.PageType == 0x02 {
-		entries := db.getInteriorIndexEntries(header, data)
-		lo, hi := 0, len(entries)-1
-		for lo <= hi {
-			mid := (lo + hi) / 2
-			if mid == len(entries)-1 {
-				// right-most child
-				lo = mid
-				break
-			}
-			columns := db.parseRecordFormat(entries[mid].keyPayload)
-			if compareAny(key, columns[pkColumnNumber]) == 0 {
-				return &TableRecord{Rowid: -1, Columns: columns}
-			} else if compareAny(key, columns[pkColumnNumber]) < 0 {
-				hi = mid - 1
-			} else {
-				lo = mid + 1
-			}
-		}
-		return db.getRecordByPK(int(entries[lo].childPage), pkColumnNumber, key)
-	} else if header.PageType == 0x0a {
-		entries := db.getLeafIndexEntries(header, data)
-		lo, hi := 0, len(entries)-1
-		for lo <= hi {
-			mid := (lo + hi) / 2
-			columns := db.parseRecordFormat(entries[mid])
-			if compareAny(key, columns[pkColumnNumber]) == 0 {
-				return &TableRecord{Rowid: -1, Columns: columns}
-			} else if compareAny(key, columns[pkColumnNumber]) < 0 {
-				hi = mid - 1
-			} else {
-				lo = mid + 1
-			}
-		}
-	} else if header.PageType == 0x0d {
-		// NOTE: in my tests, rows were not sorted. Is this expected?
-		records := db.getLeafTableRecords(header, data)
-		for _, record := range records {
-			if compareAny(key, record.Columns[pkColumnNumber]) == 0 {
-				return &record
-			}
-		}
-	} else {
-		log.Fatal("unexpected page type when walking table btree: ", header.PageType)
-	}
-	return nil
+// getRecordByPK finds a row of a table without rowid, where the records are stored as the keys of an index
+func (db *DbContext) getRecordByPK(page int, key []any, keyColumns []KeyColumn) *TableRecord {
+	var record *TableRecord
+	keyRange := KeyRange{Low: key, High: key, LowInclusive: true, HighInclusive: true}
+	db.scanIndexRange(page, keyRange, keyColumns, false, func(columns []any) bool {
+		record = &TableRecord{Rowid: -1, Columns: columns}
+		return false
+	})
+	return record
 }
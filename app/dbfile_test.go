@@ -0,0 +1,24 @@
+package main
+
+import "testing"
+
+func TestCompareKeys(t *testing.T) {
+	keyColumns := []KeyColumn{{Collation: "NOCASE"}, {Descending: true, Collation: "BINARY"}}
+	tests := []struct {
+		key, prefix []any
+		expected    int
+	}{
+		{[]any{"abc", int64(1)}, []any{"ABC"}, 0},
+		{[]any{"abc", int64(1)}, []any{"ABD"}, -1},
+		{[]any{"abc", int64(1)}, []any{"abc", int64(2)}, 1},
+		{[]any{"abc", int64(3)}, []any{"abc", 2.5}, -1},
+		{[]any{nil, int64(1)}, []any{int64(5)}, -1},
+		{[]any{"abc", nil}, []any{"abc", "x"}, 1},
+		{[]any{int64(1)}, []any{"1"}, -1},
+	}
+	for _, test := range tests {
+		if result := compareKeys(test.key, test.prefix, keyColumns); result != test.expected {
+			t.Errorf("key: %v - prefix: %v - expected: %d - got: %d", test.key, test.prefix, test.expected, result)
+		}
+	}
+}
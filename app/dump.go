@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// Dump writes the schema and contents of the database as SQL text that can be replayed
+// with sqlite3 to rebuild it. If pattern is not empty, only tables with a name matching it
+// (using LIKE) are included, together with their indexes and triggers.
+func (db *DbContext) Dump(writer io.Writer, pattern string) {
+	matches := func(name string) bool {
+		return pattern == "" || matchLike(pattern, name, 0)
+	}
+
+	fmt.Fprintln(writer, "PRAGMA foreign_keys=OFF;")
+	fmt.Fprintln(writer, "BEGIN TRANSACTION;")
+
+	// same order used by sqlite3: tables in schema order, but sqlite_sequence goes last
+	tables := []SchemaEntry{}
+	for _, entry := range db.Schema {
+		if entry.Type == "table" && entry.SQL != "" && matches(entry.Name) {
+			tables = append(tables, entry)
+		}
+	}
+	slices.SortStableFunc(tables, func(a, b SchemaEntry) int {
+		aSequence, bSequence := a.Name == "sqlite_sequence", b.Name == "sqlite_sequence"
+		if aSequence == bSequence {
+			return 0
+		} else if aSequence {
+			return 1
+		}
+		return -1
+	})
+
+	writableSchema := false
+	for _, entry := range tables {
+		upperSQL := strings.ToUpper(entry.SQL)
+		switch {
+		case entry.Name == "sqlite_sequence":
+			// created automatically with the AUTOINCREMENT tables
+		case matchLike("sqlite_stat_", entry.Name, 0):
+			fmt.Fprintln(writer, "ANALYZE sqlite_schema;")
+		case strings.HasPrefix(entry.Name, "sqlite_"):
+			continue
+		case strings.HasPrefix(upperSQL, "CREATE VIRTUAL TABLE"):
+			// virtual tables can't be created directly on an existing schema and have no data of their own
+			if !writableSchema {
+				fmt.Fprintln(writer, "PRAGMA writable_schema=ON;")
+				writableSchema = true
+			}
+			fmt.Fprintf(writer, "INSERT INTO sqlite_schema(type,name,tbl_name,rootpage,sql)VALUES('table',%s,%s,0,%s);\n",
+				quoteLiteral(entry.Name), quoteLiteral(entry.Name), quoteLiteral(entry.SQL))
+			continue
+		case strings.HasPrefix(upperSQL, "CREATE TABLE '") || strings.HasPrefix(upperSQL, "CREATE TABLE \""):
+			fmt.Fprintf(writer, "CREATE TABLE IF NOT EXISTS %s;\n", entry.SQL[len("CREATE TABLE "):])
+		default:
+			fmt.Fprintf(writer, "%s;\n", entry.SQL)
+		}
+		db.dumpTableRows(writer, entry)
+	}
+
+	for _, entry := range db.Schema {
+		if (entry.Type == "index" || entry.Type == "trigger" || entry.Type == "view") && entry.SQL != "" && matches(entry.TableName) {
+			fmt.Fprintf(writer, "%s;\n", entry.SQL)
+		}
+	}
+
+	if writableSchema {
+		fmt.Fprintln(writer, "PRAGMA writable_schema=OFF;")
+	}
+	fmt.Fprintln(writer, "COMMIT;")
+}
+
+func (db *DbContext) dumpTableRows(writer io.Writer, entry SchemaEntry) {
+	aliasedPKColumnNumber := -1
+	if !entry.WithoutRowid {
+		aliasedPKColumnNumber = aliasedRowidColumn(entry.Columns)
+	}
+	tableName := quoteIdentifier(entry.Name)
+	for _, row := range db.retrieveRows(entry, accessPath{Kind: "scan"}) {
+		values := []string{}
+		for i, value := range row.Columns {
+			if i == aliasedPKColumnNumber {
+				value = row.Rowid
+			}
+			if i < len(entry.Columns) {
+				// generated columns are computed again when the rows are inserted
+				if entry.Columns[i].Generated != nil {
+					continue
+				}
+				if integer, isInteger := value.(int64); isInteger && columnAffinity(entry.Columns[i].Type) == "REAL" {
+					value = float64(integer)
+				}
+			}
+			values = append(values, quoteLiteral(value))
+		}
+		fmt.Fprintf(writer, "INSERT INTO %s VALUES(%s);\n", tableName, strings.Join(values, ","))
+	}
+}
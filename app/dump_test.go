@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDump(t *testing.T) {
+	db := NewDbContext("../sample.db")
+	defer db.Close()
+
+	result := new(bytes.Buffer)
+	db.Dump(result, "")
+	lines := strings.Split(strings.TrimSpace(result.String()), "\n")
+	if lines[0] != "PRAGMA foreign_keys=OFF;" || lines[1] != "BEGIN TRANSACTION;" || lines[len(lines)-1] != "COMMIT;" {
+		t.Errorf("dump is not wrapped in a transaction:\n%s", result.String())
+	}
+	expected := []string{
+		"INSERT INTO apples VALUES(1,'Granny Smith','Light Green');",
+		"INSERT INTO oranges VALUES(4,'Clementine','usually seedless, great for snacking');",
+		"INSERT INTO sqlite_sequence VALUES('oranges',6);",
+	}
+	for _, text := range expected {
+		if !strings.Contains(result.String(), text) {
+			t.Errorf("result does not contain text: %q", text)
+		}
+	}
+	if strings.Contains(result.String(), "CREATE TABLE sqlite_sequence") {
+		t.Errorf("result must not create sqlite_sequence")
+	}
+
+	result.Reset()
+	db.Dump(result, "app%")
+	if !strings.Contains(result.String(), "CREATE TABLE apples") {
+		t.Errorf("result does not contain table: %q", "apples")
+	}
+	if strings.Contains(result.String(), "oranges") {
+		t.Errorf("result must not contain table: %q", "oranges")
+	}
+}
+
+func TestQuoteLiteral(t *testing.T) {
+	tests := []struct {
+		value    any
+		expected string
+	}{
+		{nil, "NULL"},
+		{int64(-42), "-42"},
+		{1.0, "1.0"},
+		{0.1, "0.1"},
+		{1e300, "1e+300"},
+		{"it's", "'it''s'"},
+		{"a\nb", `replace('a\nb','\n',char(10))`},
+		{[]byte{0x00, 0xff}, "X'00ff'"},
+	}
+	for _, test := range tests {
+		if result := quoteLiteral(test.value); result != test.expected {
+			t.Errorf("expected: %s - got: %s\n", test.expected, result)
+		}
+	}
+}
@@ -0,0 +1,877 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type SelectStatement struct {
+	Explain    bool
+	Distinct   bool
+	Columns    []ResultColumn
+	From       []TableRef
+	Where      *Expr
+	GroupBy    []*Expr
+	Having     *Expr
+	OrderBy    []OrderTerm
+	Limit      *Expr
+	Offset     *Expr
+	Parameters []*Expr
+	With       []*CommonTable
+	Compound   []CompoundSelect
+	Windows    []*WindowDef
+}
+
+// CompoundSelect is a SELECT of a compound SELECT after the first one, joined to the ones before it by
+// UNION, UNION ALL, INTERSECT or EXCEPT
+type CompoundSelect struct {
+	Op     string
+	Select *SelectStatement
+}
+
+// CommonTable is a table defined by the WITH clause of a SELECT, with the names given to its columns if
+// any. When the last SELECT of a compound SELECT reads the table, after UNION or UNION ALL, it is run for
+// each row found, which is the only row of the table, and the rows it finds are added to the table. Then
+// the ORDER BY of the compound SELECT chooses the row used next, and its LIMIT and OFFSET are the ones of
+// the table.
+type CommonTable struct {
+	Name    string
+	Columns []string
+	Select  *SelectStatement
+}
+
+// ResultColumn is an expression on the list of a SELECT, where "*" and "table.*" are kept as an Expr
+// with Op "*" and the table on Table
+type ResultColumn struct {
+	Expr  *Expr
+	Alias string
+}
+
+// OrderTerm is an expression of the ORDER BY clause. The rows of a GROUP BY term may be read in either
+// direction, as they only need the equal values together, so the direction of a Grouping term is ignored.
+type OrderTerm struct {
+	Expr       *Expr
+	Descending bool
+	Grouping   bool
+}
+
+// WindowDef is the window of a window function, written after OVER or named by the WINDOW clause. A window
+// written after OVER as a name only has the Name of a window of the WINDOW clause. Otherwise it may start
+// with the name of one of them on Base, giving its PARTITION BY and ORDER BY terms. Without a frame, the
+// frame is RANGE BETWEEN UNBOUNDED PRECEDING AND CURRENT ROW.
+type WindowDef struct {
+	Name        string
+	Base        string
+	PartitionBy []*Expr
+	OrderBy     []OrderTerm
+	Frame       *WindowFrame
+}
+
+// WindowFrame is the frame of a window, with Unit ROWS, RANGE or GROUPS. Exclude is "CURRENT ROW",
+// "GROUP", "TIES" or empty for EXCLUDE NO OTHERS.
+type WindowFrame struct {
+	Unit       string
+	Start, End FrameBound
+	Exclude    string
+}
+
+// FrameBound is a bound of a window frame, of the kind "UNBOUNDED PRECEDING", "PRECEDING", "CURRENT ROW",
+// "FOLLOWING" or "UNBOUNDED FOLLOWING", with the offset of PRECEDING and FOLLOWING
+type FrameBound struct {
+	Kind   string
+	Offset *Expr
+}
+
+// TableRef is a table on the FROM clause. Join is "" for the first table or one separated by commas,
+// otherwise it is INNER, CROSS or LEFT, with the join condition on On or the columns listed on Using.
+// A table-valued function has the expressions of its arguments on Args, and a subquery has its SELECT
+// on Select.
+type TableRef struct {
+	Name   string
+	Alias  string
+	Join   string
+	On     *Expr
+	Using  []string
+	Args   []*Expr
+	Select *SelectStatement
+}
+
+// Expr is a node of an expression tree. Op is "literal", "column", "function", "aggregate", "window",
+// "parameter", "*", a comparison operator (=, <>, <, <=, >, >=), an arithmetic operator (+, -, *, /, %, ||,
+// &, |, <<, >>, ~, where + - and ~ may have a single operand), the JSON operators -> and ->>, COLLATE, CASE,
+// CAST, AND, OR, NOT, IS, BETWEEN, IN, LIKE, GLOB, REGEXP or ISNULL; Not negates the last seven. The "*" of
+// all the columns has no operands. Aggregates are functions like MAX whose result is computed from all the
+// rows and kept on Column, and so are the window functions called with OVER, which have their window on
+// Window. An aggregate called with DISTINCT has Distinct set, and only uses each value once, and its
+// FILTER clause is on Filter. Parameters
+// have their number on Column and the value bound to them on Value. COLLATE has the name of the collation
+// on Name, and CAST the type on Name. CASE has its WHEN and THEN expressions followed by the ELSE one, after
+// the operand compared with the WHEN ones when it has one, so that it has an even number of operands. LIKE
+// has the ESCAPE expression as a third operand when it has one.
+// Columns have the affinity of their declared type on Affinity and the collation of their definition on
+// Collation, and other expressions have none. A "subquery" giving a single value, EXISTS and the IN of the
+// rows of a subquery have its SELECT on Select, planned on Query.
+type Expr struct {
+	Op        string
+	Value     any
+	Table     string
+	Name      string
+	Column    int
+	Affinity  string
+	Collation string
+	Not       bool
+	Distinct  bool
+	Args      []*Expr
+	Filter    *Expr
+	Select    *SelectStatement
+	Query     *subquery
+	Window    *WindowDef
+}
+
+// bindColumns resolves the column names used by the expression to their position on the rows being
+// filtered, where each table has its columns followed by its rowid, starting at the offset of the table.
+// The tables of a query hide the tables of the queries around it that have the same column. A subquery
+// must have been planned before, and gets the affinity of its column.
+func bindColumns(expr *Expr, sources []tableSource) error {
+	if expr == nil {
+		return nil
+	}
+	if expr.Op == "function" {
+		if err := checkFunction(expr); err != nil {
+			return err
+		}
+	}
+	if expr.Op == "column" {
+		expr.Column = -1
+		name := expr.Name
+		if expr.Table != "" {
+			name = expr.Table + "." + expr.Name
+		}
+		depth := 0
+		for _, source := range sources {
+			if expr.Table != "" && !strings.EqualFold(expr.Table, source.Name) {
+				continue
+			}
+			if expr.Table == "" && slices.ContainsFunc(source.Using, func(name string) bool { return strings.EqualFold(name, expr.Name) }) {
+				continue
+			}
+			column := slices.IndexFunc(source.Table.Columns, func(column ColumnDef) bool {
+				return strings.EqualFold(expr.Name, column.Name)
+			})
+			if column == -1 && isRowidAlias(expr.Name) && !source.Table.WithoutRowid {
+				column = len(source.Table.Columns)
+			}
+			if column == -1 {
+				continue
+			}
+			if expr.Column >= 0 && source.Depth > depth {
+				break
+			}
+			if expr.Column >= 0 {
+				return fmt.Errorf("ambiguous column name: %s", name)
+			}
+			expr.Column, depth = source.Offset+column, source.Depth
+			expr.Affinity, expr.Collation = "INTEGER", ""
+			if column < len(source.Table.Columns) {
+				expr.Affinity = columnAffinity(source.Table.Columns[column].Type)
+				expr.Collation = collationName(source.Table.Columns[column].Constraints)
+			}
+		}
+		if expr.Column == -1 {
+			return fmt.Errorf("no such column: %s", name)
+		}
+	}
+	for _, arg := range operands(expr) {
+		if err := bindColumns(arg, sources); err != nil {
+			return err
+		}
+	}
+	if expr.Op == "COLLATE" {
+		if findCollation(expr.Name) == nil {
+			return fmt.Errorf("no such collation sequence: %s", expr.Name)
+		}
+		// the operand keeps its affinity
+		expr.Affinity = expr.Args[0].Affinity
+	}
+	if expr.Op == "subquery" {
+		expr.Affinity = expr.Query.plan.Columns[0].Expr.Affinity
+	}
+	if expr.Op == "CAST" {
+		expr.Affinity = columnAffinity(expr.Name)
+	}
+	return nil
+}
+
+// isStar tells if the expression is the "*" of all the columns, and not a multiplication
+func isStar(expr *Expr) bool {
+	return expr.Op == "*" && len(expr.Args) == 0
+}
+
+func isRowidAlias(name string) bool {
+	return strings.EqualFold(name, "rowid") || strings.EqualFold(name, "_rowid_") || strings.EqualFold(name, "oid")
+}
+
+// evalExpr computes the value of the expression for a row. Logical operators follow SQL's three-valued
+// logic, with nil for unknown and int64 1 and 0 for true and false.
+func evalExpr(expr *Expr, row []any) (any, error) {
+	switch expr.Op {
+	case "literal", "parameter":
+		return expr.Value, nil
+	case "column", "aggregate", "window":
+		return row[expr.Column], nil
+	case "function":
+		return callFunction(expr, row)
+	case "COLLATE":
+		// the collation only changes how the value is compared
+		return evalExpr(expr.Args[0], row)
+	case "subquery":
+		return expr.Query.value(row)
+	case "CASE":
+		return evalCase(expr, row)
+	case "EXISTS":
+		exists, err := expr.Query.exists(row)
+		if err != nil {
+			return nil, err
+		}
+		return boolValue(exists), nil
+	case "->", "->>":
+		// -> gives the JSON of the element, and ->> its SQL value
+		node, err := evalArrow(expr, row)
+		if err != nil || node == nil {
+			return nil, err
+		} else if expr.Op == "->" {
+			return node.String(), nil
+		}
+		return node.sqlValue(), nil
+	}
+
+	args := make([]any, len(expr.Args))
+	for i, arg := range expr.Args {
+		value, err := evalExpr(arg, row)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+		// AND and OR don't need the right side when the left side already decides the result
+		if i == 0 && (expr.Op == "AND" || expr.Op == "OR") && value != nil && isTrue(value) == (expr.Op == "OR") {
+			return boolValue(isTrue(value)), nil
+		}
+	}
+
+	var result any
+	switch expr.Op {
+	case "AND":
+		if (args[0] != nil && !isTrue(args[0])) || (args[1] != nil && !isTrue(args[1])) {
+			return int64(0), nil
+		} else if args[0] == nil || args[1] == nil {
+			return nil, nil
+		}
+		return int64(1), nil
+	case "OR":
+		if (args[0] != nil && isTrue(args[0])) || (args[1] != nil && isTrue(args[1])) {
+			return int64(1), nil
+		} else if args[0] == nil || args[1] == nil {
+			return nil, nil
+		}
+		return int64(0), nil
+	case "NOT":
+		if args[0] == nil {
+			return nil, nil
+		}
+		return boolValue(!isTrue(args[0])), nil
+	case "=", "<>", "<", "<=", ">", ">=":
+		if args[0] == nil || args[1] == nil {
+			return nil, nil
+		}
+		a, b := applyAffinity(comparisonAffinity(expr.Args[0], expr.Args[1]), args[0], args[1])
+		return boolValue(compareWith(expr.Op, compareCollated(a, b, comparisonCollation(expr.Args[0], expr.Args[1])))), nil
+	case "IS":
+		// NULL is the same as NULL, and not the same as any other value
+		if args[0] == nil || args[1] == nil {
+			result = args[0] == nil && args[1] == nil
+			break
+		}
+		a, b := applyAffinity(comparisonAffinity(expr.Args[0], expr.Args[1]), args[0], args[1])
+		result = compareCollated(a, b, comparisonCollation(expr.Args[0], expr.Args[1])) == 0
+	case "ISNULL":
+		result = args[0] == nil
+	case "CAST":
+		return castValue(expr.Name, args[0]), nil
+	case "+", "-", "*", "/", "%":
+		if len(args) == 1 && expr.Op == "+" {
+			return args[0], nil
+		} else if len(args) == 1 {
+			// the unary minus subtracts from zero
+			return arithmetic(expr.Op, int64(0), args[0]), nil
+		}
+		return arithmetic(expr.Op, args[0], args[1]), nil
+	case "&", "|", "<<", ">>":
+		return bitwise(expr.Op, args[0], args[1]), nil
+	case "~":
+		if args[0] == nil {
+			return nil, nil
+		}
+		return ^integerValue(args[0]), nil
+	case "||":
+		if args[0] == nil || args[1] == nil {
+			return nil, nil
+		}
+		return textValue(args[0]) + textValue(args[1]), nil
+	case "BETWEEN":
+		if args[0] == nil {
+			return nil, nil
+		}
+		var low, high any
+		if args[1] != nil {
+			a, b := applyAffinity(comparisonAffinity(expr.Args[0], expr.Args[1]), args[0], args[1])
+			low = compareCollated(a, b, comparisonCollation(expr.Args[0], expr.Args[1])) >= 0
+		}
+		if args[2] != nil {
+			a, b := applyAffinity(comparisonAffinity(expr.Args[0], expr.Args[2]), args[0], args[2])
+			high = compareCollated(a, b, comparisonCollation(expr.Args[0], expr.Args[2])) <= 0
+		}
+		if low == false || high == false {
+			result = false
+		} else if low == nil || high == nil {
+			return nil, nil
+		} else {
+			result = true
+		}
+	case "IN":
+		if expr.Query != nil {
+			found, err := expr.Query.contains(expr.Args[0], args[0], row)
+			if err != nil || found == nil {
+				return nil, err
+			}
+			result = found
+			break
+		}
+		// an empty list holds no value, not even NULL
+		if len(args) == 1 {
+			result = false
+			break
+		}
+		if args[0] == nil {
+			return nil, nil
+		}
+		foundNull := false
+		// the values on the list take the affinity and the collation of the left operand
+		collation := orderCollation(expr.Args[0])
+		for _, item := range args[1:] {
+			if item == nil {
+				foundNull = true
+			} else if a, b := applyAffinity(expr.Args[0].Affinity, args[0], item); compareCollated(a, b, collation) == 0 {
+				result = true
+				break
+			}
+		}
+		if result == nil {
+			if foundNull {
+				return nil, nil
+			}
+			result = false
+		}
+	case "LIKE", "GLOB", "REGEXP":
+		// the operators are the functions of the same name, which take the pattern first
+		args[0], args[1] = args[1], args[0]
+		matched, err := scalarFunctions[expr.Op].Call(args)
+		if err != nil || matched == nil {
+			return nil, err
+		}
+		result = isTrue(matched)
+	default:
+		return nil, fmt.Errorf("unsupported operator: %s", expr.Op)
+	}
+	return boolValue(result.(bool) != expr.Not), nil
+}
+
+// evalCase computes the THEN expression of the first WHEN expression that is true, or that is equal to
+// the operand when there is one, and the ELSE expression when there is none. The expressions after it are
+// not computed.
+func evalCase(expr *Expr, row []any) (any, error) {
+	args := expr.Args
+	var operand *Expr
+	var value any
+	if len(args)%2 == 0 {
+		operand, args = args[0], args[1:]
+		var err error
+		if value, err = evalExpr(operand, row); err != nil {
+			return nil, err
+		}
+	}
+	for i := 0; i+1 < len(args); i += 2 {
+		when, err := evalExpr(args[i], row)
+		if err != nil {
+			return nil, err
+		}
+		matched := false
+		if operand == nil {
+			matched = when != nil && isTrue(when)
+		} else if value != nil && when != nil {
+			a, b := applyAffinity(comparisonAffinity(operand, args[i]), value, when)
+			matched = compareCollated(a, b, comparisonCollation(operand, args[i])) == 0
+		}
+		if matched {
+			return evalExpr(args[i+1], row)
+		}
+	}
+	return evalExpr(args[len(args)-1], row)
+}
+
+// compareWith applies a comparison operator to the result of a comparison function
+func compareWith(op string, comparison int) bool {
+	switch op {
+	case "=":
+		return comparison == 0
+	case "<>":
+		return comparison != 0
+	case "<":
+		return comparison < 0
+	case "<=":
+		return comparison <= 0
+	case ">":
+		return comparison > 0
+	case ">=":
+		return comparison >= 0
+	}
+	return false
+}
+
+// boolValue converts a Go bool to the integer used by SQL
+func boolValue(value bool) any {
+	if value {
+		return int64(1)
+	}
+	return int64(0)
+}
+
+// isTrue tells if a non-null value is considered true when used as a condition
+func isTrue(value any) bool {
+	switch v := value.(type) {
+	case int64:
+		return v != 0
+	case float64:
+		return v != 0
+	case string:
+		return numericPrefix(v) != 0
+	case []byte:
+		return numericPrefix(string(v)) != 0
+	}
+	return false
+}
+
+// numericPrefix converts the longest prefix of the text that looks like a number, ignoring leading spaces
+func numericPrefix(text string) float64 {
+	value, _ := parseNumeric(text)
+	return value
+}
+
+// parseNumeric converts the longest prefix of the text that looks like a number, ignoring leading spaces,
+// telling if the rest of the text has only spaces
+func parseNumeric(text string) (float64, bool) {
+	text = strings.TrimLeft(text, " \t\n\r")
+	end := numericPrefixLength(text)
+	value, _ := strconv.ParseFloat(text[:end], 64)
+	return value, end > 0 && strings.TrimRight(text[end:], " \t\n\r") == ""
+}
+
+// numericPrefixLength finds the length of the longest prefix of the text that looks like a number
+func numericPrefixLength(text string) int {
+	end := 0
+	for i, seenDigit, seenDot, seenExp := 0, false, false, false; i < len(text); i++ {
+		ch := text[i]
+		switch {
+		case ch >= '0' && ch <= '9':
+			seenDigit = true
+			end = i + 1
+		case (ch == '+' || ch == '-') && (i == 0 || text[i-1] == 'e' || text[i-1] == 'E'):
+		case ch == '.' && !seenDot && !seenExp:
+			seenDot = true
+			if seenDigit {
+				end = i + 1
+			}
+		case (ch == 'e' || ch == 'E') && seenDigit && !seenExp:
+			seenExp = true
+		default:
+			i = len(text)
+		}
+	}
+	return end
+}
+
+// numericValue converts a value to a number the same way SQLite does for arithmetic. A text is read as
+// the number at its start, which is an integer unless it has a decimal point or an exponent or doesn't
+// fit in 64 bits.
+func numericValue(value any) any {
+	switch v := value.(type) {
+	case int64, float64:
+		return v
+	case nil:
+		return nil
+	}
+	text := strings.TrimLeft(textValue(value), " \t\n\r")
+	end := numericPrefixLength(text)
+	if end == 0 {
+		return int64(0)
+	}
+	if integer, err := strconv.ParseInt(text[:end], 10, 64); err == nil {
+		return integer
+	}
+	real, _ := strconv.ParseFloat(text[:end], 64)
+	return real
+}
+
+// comparisonAffinity is the affinity applied to the operands of a comparison: numeric when one of them
+// is a column with a numeric affinity, the affinity of the only column otherwise, and none for two
+// columns without a numeric affinity
+func comparisonAffinity(left, right *Expr) string {
+	isNumeric := func(affinity string) bool {
+		return affinity == "INTEGER" || affinity == "REAL" || affinity == "NUMERIC"
+	}
+	switch {
+	case left.Affinity != "" && right.Affinity != "":
+		if isNumeric(left.Affinity) || isNumeric(right.Affinity) {
+			return "NUMERIC"
+		}
+		return ""
+	case left.Affinity != "":
+		return left.Affinity
+	}
+	return right.Affinity
+}
+
+// applyAffinity converts two values before comparing them. A numeric affinity converts the texts that
+// look like numbers to numbers, and the TEXT affinity converts a number to text when compared to a text.
+func applyAffinity(affinity string, a, b any) (any, any) {
+	switch affinity {
+	case "INTEGER", "REAL", "NUMERIC":
+		return numericAffinity(a), numericAffinity(b)
+	case "TEXT":
+		_, aIsText := a.(string)
+		_, bIsText := b.(string)
+		if aIsText && !bIsText && b != nil {
+			return a, textAffinity(b)
+		} else if bIsText && !aIsText && a != nil {
+			return textAffinity(a), b
+		}
+	}
+	return a, b
+}
+
+// storedValue converts a value to the affinity of the column storing it. Numeric affinities convert the
+// texts that look like numbers, with INTEGER and NUMERIC keeping the integral reals as integers and REAL
+// keeping integers as reals. TEXT converts numbers to text.
+func storedValue(affinity string, value any) any {
+	switch affinity {
+	case "INTEGER", "NUMERIC":
+		value = numericAffinity(value)
+		if real, isReal := value.(float64); isReal && real == math.Trunc(real) && real >= -9223372036854775808.0 && real < 9223372036854775808.0 {
+			return int64(real)
+		}
+	case "REAL":
+		value = numericAffinity(value)
+		if integer, isInteger := value.(int64); isInteger {
+			return float64(integer)
+		}
+	case "TEXT":
+		return textAffinity(value)
+	}
+	return value
+}
+
+// castValue converts a value to a type like CAST, with the affinity of a column declared with the type.
+// Unlike the affinity of a column, it converts all the values but NULL: a text becomes the number at its
+// start, or 0, and a number becomes a text. A text becomes an integer from its digits only, and a number
+// from a text is an integer when it has no fractional part.
+func castValue(typeName string, value any) any {
+	if value == nil {
+		return nil
+	}
+	switch columnAffinity(typeName) {
+	case "INTEGER":
+		if _, isReal := value.(float64); isReal {
+			return integerValue(value)
+		} else if _, isInteger := value.(int64); isInteger {
+			return value
+		}
+		text := strings.TrimLeft(textValue(value), " \t\n\r")
+		end := 0
+		for end < len(text) && (isDigit(text[end]) || end == 0 && (text[end] == '-' || text[end] == '+')) {
+			end++
+		}
+		// a number too large gives the largest integer with its sign
+		integer, _ := strconv.ParseInt(text[:end], 10, 64)
+		return integer
+	case "REAL":
+		return realValue(value)
+	case "NUMERIC":
+		switch value.(type) {
+		case int64, float64:
+			return value
+		}
+		number := numericValue(textValue(value))
+		if real, isReal := number.(float64); isReal && real == math.Trunc(real) && real >= -9223372036854775808.0 && real < 9223372036854775808.0 {
+			return int64(real)
+		}
+		return number
+	case "TEXT":
+		return textValue(value)
+	}
+	if _, isBlob := value.([]byte); isBlob {
+		return value
+	}
+	return []byte(textValue(value))
+}
+
+// textAffinity converts a number to text, keeping other values as they are
+func textAffinity(value any) any {
+	switch value.(type) {
+	case int64, float64:
+		return textValue(value)
+	}
+	return value
+}
+
+// numericAffinity converts a text that has only a number, and maybe spaces around it, to that number.
+// Other values are kept as they are.
+func numericAffinity(value any) any {
+	if text, isText := value.(string); isText {
+		if _, isNumber := parseNumeric(text); isNumber {
+			return numericValue(text)
+		}
+	}
+	return value
+}
+
+// arithmetic applies the operators + - * / and % like SQLite. Integers give integers, unless the result
+// overflows and becomes a real. Dividing by zero gives NULL, and the remainder of reals is the remainder
+// of their integer parts.
+func arithmetic(op string, left, right any) any {
+	if left == nil || right == nil {
+		return nil
+	}
+	left, right = numericValue(left), numericValue(right)
+	a, leftIsInteger := left.(int64)
+	b, rightIsInteger := right.(int64)
+	if leftIsInteger && rightIsInteger {
+		switch op {
+		case "+":
+			if result := a + b; (result > a) == (b > 0) {
+				return result
+			}
+		case "-":
+			if result := a - b; (result < a) == (b > 0) {
+				return result
+			}
+		case "*":
+			if result := a * b; a == 0 || (result/a == b && !(a == -1 && b == math.MinInt64) && !(b == -1 && a == math.MinInt64)) {
+				return result
+			}
+		case "/":
+			if b == 0 {
+				return nil
+			}
+			if a != math.MinInt64 || b != -1 {
+				return a / b
+			}
+		case "%":
+			if b == 0 {
+				return nil
+			}
+			if b == -1 {
+				return int64(0)
+			}
+			return a % b
+		}
+	}
+	x, y := realValue(left), realValue(right)
+	var result float64
+	switch op {
+	case "+":
+		result = x + y
+	case "-":
+		result = x - y
+	case "*":
+		result = x * y
+	case "/":
+		if y == 0 {
+			return nil
+		}
+		result = x / y
+	case "%":
+		a, b := integerValue(x), integerValue(y)
+		if b == 0 {
+			return nil
+		}
+		if b == -1 {
+			b = 1
+		}
+		result = float64(a % b)
+	}
+	if math.IsNaN(result) {
+		return nil
+	}
+	return result
+}
+
+// bitwise applies the operators & | << and >> to the integer values of the operands. Shifting by a
+// negative amount shifts the other way.
+func bitwise(op string, left, right any) any {
+	if left == nil || right == nil {
+		return nil
+	}
+	a, b := integerValue(left), integerValue(right)
+	switch op {
+	case "&":
+		return a & b
+	case "|":
+		return a | b
+	}
+	if b < 0 {
+		op = map[string]string{"<<": ">>", ">>": "<<"}[op]
+		if b > -64 {
+			b = -b
+		} else {
+			b = 64
+		}
+	}
+	switch {
+	case b >= 64 && (a >= 0 || op == "<<"):
+		return int64(0)
+	case b >= 64:
+		return int64(-1)
+	case op == "<<":
+		return a << b
+	}
+	return a >> b
+}
+
+// textValue converts a value to text the same way SQLite does when a string is required
+func textValue(value any) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case float64:
+		return formatReal(v)
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// formatReal formats a floating point value with up to 15 significant digits, always showing it as a real
+func formatReal(value float64) string {
+	if math.IsInf(value, 1) {
+		return "Inf"
+	} else if math.IsInf(value, -1) {
+		return "-Inf"
+	}
+	text := strconv.FormatFloat(value, 'g', 15, 64)
+	mantissa, exponent, found := strings.Cut(text, "e")
+	if !strings.ContainsAny(mantissa, ".IN") {
+		mantissa += ".0"
+	}
+	if found {
+		return mantissa + "e" + exponent
+	}
+	return mantissa
+}
+
+// formatExpr writes the expression back as SQL, with parentheses around every operation
+func formatExpr(expr *Expr) string {
+	args := []string{}
+	for _, arg := range expr.Args {
+		args = append(args, formatExpr(arg))
+	}
+	not := ""
+	if expr.Not {
+		not = "NOT "
+	}
+	switch expr.Op {
+	case "literal":
+		return quoteLiteral(expr.Value)
+	case "parameter":
+		return expr.Name
+	case "column":
+		if expr.Table != "" {
+			return expr.Table + "." + expr.Name
+		}
+		return expr.Name
+	case "*":
+		if isStar(expr) && expr.Table != "" {
+			return expr.Table + ".*"
+		} else if isStar(expr) {
+			return "*"
+		}
+	case "function", "aggregate", "window":
+		distinct, filter := "", ""
+		if expr.Distinct {
+			distinct = "DISTINCT "
+		}
+		if expr.Filter != nil {
+			filter = " FILTER (WHERE " + formatExpr(expr.Filter) + ")"
+		}
+		if expr.Window != nil {
+			return expr.Name + "(" + distinct + strings.Join(args, ", ") + ")" + filter + " OVER " + formatWindow(expr.Window)
+		}
+		return expr.Name + "(" + distinct + strings.Join(args, ", ") + ")" + filter
+	case "NOT":
+		return "(NOT " + args[0] + ")"
+	case "-", "+", "~":
+		if len(args) == 1 {
+			return "(" + expr.Op + args[0] + ")"
+		}
+	case "COLLATE":
+		return "(" + args[0] + " COLLATE " + expr.Name + ")"
+	case "CAST":
+		return "CAST(" + args[0] + " AS " + expr.Name + ")"
+	case "CASE":
+		text := "CASE"
+		if len(args)%2 == 0 {
+			text, args = text+" "+args[0], args[1:]
+		}
+		for i := 0; i+1 < len(args); i += 2 {
+			text += " WHEN " + args[i] + " THEN " + args[i+1]
+		}
+		return text + " ELSE " + args[len(args)-1] + " END"
+	case "IS":
+		return fmt.Sprintf("(%s IS %s%s)", args[0], not, args[1])
+	case "LIKE":
+		if len(args) == 3 {
+			return fmt.Sprintf("(%s %sLIKE %s ESCAPE %s)", args[0], not, args[1], args[2])
+		}
+	case "ISNULL":
+		if expr.Not {
+			return "(" + args[0] + " NOTNULL)"
+		}
+		return "(" + args[0] + " ISNULL)"
+	case "BETWEEN":
+		return fmt.Sprintf("(%s %sBETWEEN %s AND %s)", args[0], not, args[1], args[2])
+	case "IN":
+		if expr.Select != nil {
+			return fmt.Sprintf("(%s %sIN (SELECT ...))", args[0], not)
+		}
+		return fmt.Sprintf("(%s %sIN (%s))", args[0], not, strings.Join(args[1:], ", "))
+	case "subquery":
+		return "(SELECT ...)"
+	case "EXISTS":
+		return "EXISTS (SELECT ...)"
+	}
+	return fmt.Sprintf("(%s %s%s %s)", args[0], not, expr.Op, args[1])
+}
+
+// andTerms splits an expression into the parts joined by AND, which must all be true for a row to be selected
+func andTerms(expr *Expr) []*Expr {
+	if expr == nil {
+		return nil
+	}
+	if expr.Op == "AND" {
+		return append(andTerms(expr.Args[0]), andTerms(expr.Args[1])...)
+	}
+	return []*Expr{expr}
+}
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// scalarFunction computes the result of a function for a row from the values of its arguments. MaxArgs
+// is -1 when the number of arguments is unlimited.
+type scalarFunction struct {
+	MinArgs, MaxArgs int
+	Call             func(args []any) (any, error)
+}
+
+// maxLength is the largest string or blob a function may build, the default SQLITE_MAX_LENGTH
+const maxLength = 1000000000
+
+var errTooBig = errors.New("string or blob too big")
+
+// scalarFunctions are the functions available on expressions, by their name in upper case
+var scalarFunctions = map[string]scalarFunction{
+	"LENGTH":            {1, 1, lengthFunction},
+	"OCTET_LENGTH":      {1, 1, octetLengthFunction},
+	"LOWER":             {1, 1, func(args []any) (any, error) { return mapText(args[0], lowerASCII), nil }},
+	"UPPER":             {1, 1, func(args []any) (any, error) { return mapText(args[0], upperASCII), nil }},
+	"SUBSTR":            {2, 3, substrFunction},
+	"SUBSTRING":         {2, 3, substrFunction},
+	"TRIM":              {1, 2, trimFunction(strings.Trim)},
+	"LTRIM":             {1, 2, trimFunction(strings.TrimLeft)},
+	"RTRIM":             {1, 2, trimFunction(strings.TrimRight)},
+	"REPLACE":           {3, 3, replaceFunction},
+	"INSTR":             {2, 2, instrFunction},
+	"PRINTF":            {1, -1, printfFunction},
+	"FORMAT":            {1, -1, printfFunction},
+	"QUOTE":             {1, 1, quoteFunction},
+	"HEX":               {1, 1, hexFunction},
+	"UNHEX":             {1, 2, unhexFunction},
+	"CHAR":              {0, -1, charFunction},
+	"UNICODE":           {1, 1, unicodeFunction},
+	"CONCAT":            {1, -1, concatFunction},
+	"CONCAT_WS":         {2, -1, concatWsFunction},
+	"LIKE":              {2, 3, likeFunction},
+	"GLOB":              {2, 2, globFunction},
+	"REGEXP":            {2, 2, regexpFunction},
+	"SOUNDEX":           {1, 1, soundexFunction},
+	"DATE":              {0, -1, dateFunction},
+	"TIME":              {0, -1, timeFunction},
+	"DATETIME":          {0, -1, datetimeFunction},
+	"JULIANDAY":         {0, -1, juliandayFunction},
+	"UNIXEPOCH":         {0, -1, unixepochFunction},
+	"STRFTIME":          {1, -1, strftimeFunction},
+	"TIMEDIFF":          {2, 2, timediffFunction},
+	"ABS":               {1, 1, absFunction},
+	"ROUND":             {1, 2, roundFunction},
+	"MIN":               {2, -1, extremumFunction(-1)},
+	"MAX":               {2, -1, extremumFunction(1)},
+	"SIGN":              {1, 1, signFunction},
+	"RANDOM":            {0, 0, randomFunction},
+	"RANDOMBLOB":        {1, 1, randomblobFunction},
+	"CEIL":              {1, 1, roundingFunction(math.Ceil)},
+	"CEILING":           {1, 1, roundingFunction(math.Ceil)},
+	"FLOOR":             {1, 1, roundingFunction(math.Floor)},
+	"TRUNC":             {1, 1, roundingFunction(math.Trunc)},
+	"SQRT":              {1, 1, realFunction(math.Sqrt)},
+	"EXP":               {1, 1, realFunction(math.Exp)},
+	"LN":                {1, 1, logFunction(math.Log)},
+	"LOG":               {1, 2, logFunction(math.Log10)},
+	"LOG10":             {1, 1, logFunction(math.Log10)},
+	"LOG2":              {1, 1, logFunction(math.Log2)},
+	"ACOS":              {1, 1, realFunction(math.Acos)},
+	"ASIN":              {1, 1, realFunction(math.Asin)},
+	"ATAN":              {1, 1, realFunction(math.Atan)},
+	"COS":               {1, 1, realFunction(math.Cos)},
+	"SIN":               {1, 1, realFunction(math.Sin)},
+	"TAN":               {1, 1, realFunction(math.Tan)},
+	"ACOSH":             {1, 1, realFunction(math.Acosh)},
+	"ASINH":             {1, 1, realFunction(math.Asinh)},
+	"ATANH":             {1, 1, realFunction(math.Atanh)},
+	"COSH":              {1, 1, realFunction(math.Cosh)},
+	"SINH":              {1, 1, realFunction(math.Sinh)},
+	"TANH":              {1, 1, realFunction(math.Tanh)},
+	"DEGREES":           {1, 1, realFunction(func(x float64) float64 { return x * 180 / math.Pi })},
+	"RADIANS":           {1, 1, realFunction(func(x float64) float64 { return x * math.Pi / 180 })},
+	"POW":               {2, 2, realFunction2(math.Pow)},
+	"POWER":             {2, 2, realFunction2(math.Pow)},
+	"ATAN2":             {2, 2, realFunction2(math.Atan2)},
+	"MOD":               {2, 2, realFunction2(math.Mod)},
+	"PI":                {0, 0, func(args []any) (any, error) { return math.Pi, nil }},
+	"JSON":              {1, 1, jsonText(jsonFunction)},
+	"JSON_VALID":        {1, 2, jsonValidFunction},
+	"JSON_EXTRACT":      {1, -1, jsonExtractFunction},
+	"JSON_TYPE":         {1, 2, jsonPathFunction(func(node *jsonNode) any { return node.Type })},
+	"JSON_ARRAY_LENGTH": {1, 2, jsonPathFunction(jsonArrayLength)},
+	"JSON_ARRAY":        {0, -1, jsonText(jsonArrayFunction)},
+	"JSON_OBJECT":       {0, -1, jsonText(jsonObjectFunction)},
+}
+
+// tableFunction is a table-valued function used on the FROM clause. Its columns are declared like the ones
+// of a table, where the HIDDEN ones are not part of "*". Rows gives the rows for the values of the arguments
+// until visit returns false.
+type tableFunction struct {
+	SQL              string
+	MinArgs, MaxArgs int
+	Rows             func(args []any, visit func(record TableRecord) bool) error
+}
+
+// tableFunctions are the table-valued functions, by their name in upper case
+var tableFunctions = map[string]tableFunction{
+	"JSON_EACH": {"CREATE TABLE json_each(key, value, type, atom, id, parent, fullkey, path, json HIDDEN, root HIDDEN)", 1, 2, jsonEachFunction(false)},
+	"JSON_TREE": {"CREATE TABLE json_tree(key, value, type, atom, id, parent, fullkey, path, json HIDDEN, root HIDDEN)", 1, 2, jsonEachFunction(true)},
+}
+
+// checkFunction makes sure the function exists and is called with the right number of arguments. The
+// window functions are only called with OVER.
+func checkFunction(expr *Expr) error {
+	if expr.Distinct && isAggregate(expr) && len(expr.Args) != 1 {
+		return fmt.Errorf("DISTINCT aggregates must have exactly one argument")
+	}
+	if expr.Distinct && expr.Window != nil {
+		return fmt.Errorf("DISTINCT is not supported for window functions")
+	}
+	if _, found := windowFunctions[expr.Name]; found && expr.Filter != nil && expr.Window != nil {
+		return fmt.Errorf("FILTER clause may only be used with aggregate window functions")
+	}
+	if expr.Window != nil {
+		return checkWindowFunction(expr)
+	}
+	if isAggregate(expr) {
+		return nil
+	}
+	if _, found := windowFunctions[expr.Name]; found {
+		return fmt.Errorf("misuse of window function %s()", strings.ToLower(expr.Name))
+	}
+	if expr.Filter != nil {
+		return fmt.Errorf("FILTER may not be used with non-aggregate %s()", strings.ToLower(expr.Name))
+	}
+	function, found := scalarFunctions[expr.Name]
+	if _, aggregate := aggregateFunctions[expr.Name]; !found && aggregate {
+		return fmt.Errorf("wrong number of arguments to function %s()", strings.ToLower(expr.Name))
+	}
+	if !found {
+		return fmt.Errorf("no such function: %s", strings.ToLower(expr.Name))
+	}
+	if len(expr.Args) < function.MinArgs || (function.MaxArgs >= 0 && len(expr.Args) > function.MaxArgs) {
+		return fmt.Errorf("wrong number of arguments to function %s()", strings.ToLower(expr.Name))
+	}
+	return nil
+}
+
+// callFunction evaluates the arguments of a function on the row and calls it. The JSON functions get the
+// JSON returned by other functions as it is.
+func callFunction(expr *Expr, row []any) (any, error) {
+	if err := checkFunction(expr); err != nil {
+		return nil, err
+	}
+	evaluate := evalExpr
+	if strings.HasPrefix(expr.Name, "JSON") {
+		evaluate = evalJSON
+	}
+	args := make([]any, len(expr.Args))
+	for i, arg := range expr.Args {
+		value, err := evaluate(arg, row)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = value
+	}
+	return scalarFunctions[expr.Name].Call(args)
+}
+
+// integerValue converts a value to an integer the same way SQLite does when an integer is required, using
+// the number at the start of a text
+func integerValue(value any) int64 {
+	switch v := value.(type) {
+	case int64:
+		return v
+	case float64:
+		if v >= math.MaxInt64 {
+			return math.MaxInt64
+		} else if v <= math.MinInt64 {
+			return math.MinInt64
+		}
+		return int64(v)
+	case string, []byte:
+		text := strings.TrimLeft(textValue(v), " \t\n\r")
+		end := 0
+		for end < len(text) && (text[end] >= '0' && text[end] <= '9' || (end == 0 && (text[end] == '-' || text[end] == '+'))) {
+			end++
+		}
+		if integer, err := strconv.ParseInt(text[:end], 10, 64); err == nil && (end == len(text) || !strings.ContainsRune(".eE", rune(text[end]))) {
+			return integer
+		}
+		return integerValue(numericPrefix(text))
+	}
+	return 0
+}
+
+// realValue converts a value to a floating point number the same way SQLite does when a real is required
+func realValue(value any) float64 {
+	switch v := value.(type) {
+	case int64:
+		return float64(v)
+	case float64:
+		return v
+	case string, []byte:
+		return numericPrefix(textValue(v))
+	}
+	return 0
+}
+
+// mapText applies a function to the text of a value, keeping NULL
+func mapText(value any, function func(string) string) any {
+	if value == nil {
+		return nil
+	}
+	return function(textValue(value))
+}
+
+// lengthFunction counts the characters of a text before the first NUL, or the bytes of a blob
+func lengthFunction(args []any) (any, error) {
+	switch v := args[0].(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return int64(len(v)), nil
+	}
+	text, _, _ := strings.Cut(textValue(args[0]), "\x00")
+	return int64(utf8.RuneCountInString(text)), nil
+}
+
+// octetLengthFunction counts the bytes of the text or blob of a value
+func octetLengthFunction(args []any) (any, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	return int64(len(textValue(args[0]))), nil
+}
+
+// substrFunction finds the part of a text starting at a character, or of a blob starting at a byte, counted
+// from 1 or from the end when negative. A negative length takes the characters before the start.
+func substrFunction(args []any) (any, error) {
+	for _, arg := range args {
+		if arg == nil {
+			return nil, nil
+		}
+	}
+	blob, isBlob := args[0].([]byte)
+	var chars []rune
+	length := int64(len(blob))
+	if !isBlob {
+		chars = []rune(textValue(args[0]))
+		length = int64(len(chars))
+	}
+	start, count := integerValue(args[1]), int64(math.MaxInt32)
+	negative := false
+	if len(args) == 3 {
+		count = integerValue(args[2])
+		if count < 0 {
+			count, negative = -count, true
+		}
+	}
+	if start < 0 {
+		start += length
+		if start < 0 {
+			count = max(count+start, 0)
+			start = 0
+		}
+	} else if start > 0 {
+		start--
+	} else if count > 0 {
+		count--
+	}
+	if negative {
+		start -= count
+		if start < 0 {
+			count += start
+			start = 0
+		}
+	}
+	start = min(start, length)
+	end := start + min(count, length-start)
+	if isBlob {
+		return blob[start:end], nil
+	}
+	return string(chars[start:end]), nil
+}
+
+// trimFunction removes the characters of the second argument, or spaces, using a function like strings.Trim
+func trimFunction(trim func(string, string) string) func(args []any) (any, error) {
+	return func(args []any) (any, error) {
+		characters := " "
+		if len(args) == 2 {
+			if args[1] == nil {
+				return nil, nil
+			}
+			characters = textValue(args[1])
+		}
+		if args[0] == nil {
+			return nil, nil
+		}
+		return trim(textValue(args[0]), characters), nil
+	}
+}
+
+// replaceFunction replaces every occurrence of a text by another
+func replaceFunction(args []any) (any, error) {
+	if args[0] == nil || args[1] == nil || args[2] == nil {
+		return nil, nil
+	}
+	text, old := textValue(args[0]), textValue(args[1])
+	if old == "" {
+		return args[0], nil
+	}
+	return strings.ReplaceAll(text, old, textValue(args[2])), nil
+}
+
+// instrFunction finds the position of the first occurrence of a text, counted in characters from 1,
+// or in bytes when both are blobs, or 0 when not found
+func instrFunction(args []any) (any, error) {
+	if args[0] == nil || args[1] == nil {
+		return nil, nil
+	}
+	haystack, haystackIsBlob := args[0].([]byte)
+	needle, needleIsBlob := args[1].([]byte)
+	if haystackIsBlob && needleIsBlob {
+		return int64(strings.Index(string(haystack), string(needle)) + 1), nil
+	}
+	text := textValue(args[0])
+	position := strings.Index(text, textValue(args[1]))
+	if position < 0 {
+		return int64(0), nil
+	}
+	return int64(utf8.RuneCountInString(text[:position]) + 1), nil
+}
+
+// quoteFunction writes a value as an SQL literal
+func quoteFunction(args []any) (any, error) {
+	switch v := args[0].(type) {
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'", nil
+	case []byte:
+		return "X'" + strings.ToUpper(hex.EncodeToString(v)) + "'", nil
+	}
+	return quoteLiteral(args[0]), nil
+}
+
+// hexFunction writes the bytes of a blob, or of the text of a value, in upper case hexadecimal
+func hexFunction(args []any) (any, error) {
+	return strings.ToUpper(hex.EncodeToString([]byte(textValue(args[0])))), nil
+}
+
+// unhexFunction reads a blob from hexadecimal digits, ignoring the characters of the second argument
+// between bytes. It is NULL when the text has other characters.
+func unhexFunction(args []any) (any, error) {
+	if args[0] == nil || (len(args) == 2 && args[1] == nil) {
+		return nil, nil
+	}
+	ignored := ""
+	if len(args) == 2 {
+		ignored = textValue(args[1])
+	}
+	text := textValue(args[0])
+	blob := []byte{}
+	for len(text) > 0 {
+		if ch, size := utf8.DecodeRuneInString(text); strings.ContainsRune(ignored, ch) {
+			text = text[size:]
+			continue
+		}
+		if len(text) < 2 {
+			return nil, nil
+		}
+		value, err := hex.DecodeString(text[:2])
+		if err != nil {
+			return nil, nil
+		}
+		blob = append(blob, value...)
+		text = text[2:]
+	}
+	return blob, nil
+}
+
+// charFunction builds a text from the code points of the characters
+func charFunction(args []any) (any, error) {
+	var builder strings.Builder
+	for _, arg := range args {
+		code := integerValue(arg)
+		if code < 0 || code > utf8.MaxRune {
+			code = utf8.RuneError
+		}
+		builder.WriteRune(rune(code))
+	}
+	return builder.String(), nil
+}
+
+// unicodeFunction finds the code point of the first character of a text
+func unicodeFunction(args []any) (any, error) {
+	text := textValue(args[0])
+	if text == "" {
+		return nil, nil
+	}
+	ch, _ := utf8.DecodeRuneInString(text)
+	return int64(ch), nil
+}
+
+// concatFunction joins the texts of the values that are not NULL
+func concatFunction(args []any) (any, error) {
+	var builder strings.Builder
+	for _, arg := range args {
+		builder.WriteString(textValue(arg))
+	}
+	return builder.String(), nil
+}
+
+// concatWsFunction joins the texts of the values that are not NULL with the separator of the first argument
+func concatWsFunction(args []any) (any, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	texts := []string{}
+	for _, arg := range args[1:] {
+		if arg != nil {
+			texts = append(texts, textValue(arg))
+		}
+	}
+	return strings.Join(texts, textValue(args[0])), nil
+}
+
+// likeFunction is the LIKE operator with the pattern first: like(pattern, text [, escape])
+func likeFunction(args []any) (any, error) {
+	var escape rune
+	if len(args) == 3 {
+		if args[2] == nil {
+			return nil, nil
+		}
+		escapeText := textValue(args[2])
+		if utf8.RuneCountInString(escapeText) != 1 {
+			return nil, fmt.Errorf("ESCAPE expression must be a single character")
+		}
+		escape, _ = utf8.DecodeRuneInString(escapeText)
+	}
+	if args[0] == nil || args[1] == nil {
+		return nil, nil
+	}
+	return boolValue(matchLike(textValue(args[0]), textValue(args[1]), escape)), nil
+}
+
+// globFunction is the GLOB operator with the pattern first: glob(pattern, text)
+func globFunction(args []any) (any, error) {
+	if args[0] == nil || args[1] == nil {
+		return nil, nil
+	}
+	return boolValue(matchGlob(textValue(args[0]), textValue(args[1]))), nil
+}
+
+// compiledRegexps keeps the patterns used by REGEXP, as the same pattern is usually matched with many rows
+var compiledRegexps = map[string]*regexp.Regexp{}
+
+// regexpFunction is the REGEXP operator with the pattern first: regexp(pattern, text). The pattern has the
+// syntax of Go's regexp package, and matches when it is found anywhere in the text.
+func regexpFunction(args []any) (any, error) {
+	if args[0] == nil || args[1] == nil {
+		return nil, nil
+	}
+	pattern := textValue(args[0])
+	compiled, found := compiledRegexps[pattern]
+	if !found {
+		var err error
+		if compiled, err = regexp.Compile(pattern); err != nil {
+			return nil, err
+		}
+		if len(compiledRegexps) >= 100 {
+			clear(compiledRegexps)
+		}
+		compiledRegexps[pattern] = compiled
+	}
+	return boolValue(compiled.MatchString(textValue(args[1]))), nil
+}
+
+// soundexCodes are the digits of the letters A to Z used by soundex, where 0 is not written
+const soundexCodes = "01230120022455012623010202"
+
+// soundexFunction encodes the sound of a name as a letter and three digits, like SQLite
+func soundexFunction(args []any) (any, error) {
+	text := textValue(args[0])
+	code := func(ch byte) byte {
+		if ch >= 'a' && ch <= 'z' {
+			ch -= 'a' - 'A'
+		}
+		if ch >= 'A' && ch <= 'Z' {
+			return soundexCodes[ch-'A']
+		}
+		return '0'
+	}
+	isLetter := func(ch byte) bool { return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') }
+	i := 0
+	for i < len(text) && !isLetter(text[i]) {
+		i++
+	}
+	if i == len(text) {
+		return "?000", nil
+	}
+	result := []byte{upperASCII(text[i : i+1])[0]}
+	previous := code(text[i])
+	for i++; i < len(text) && len(result) < 4; i++ {
+		if current := code(text[i]); current != '0' {
+			if current != previous {
+				result = append(result, current)
+			}
+			previous = current
+		} else {
+			previous = '0'
+		}
+	}
+	for len(result) < 4 {
+		result = append(result, '0')
+	}
+	return string(result), nil
+}
+
+// matchGlob tells if the text matches a GLOB pattern, where "*" matches any text, "?" one character and
+// "[...]" one of a list of characters, with ranges like "a-z" and "^" to negate the list
+func matchGlob(pattern, text string) bool {
+	p, s := []rune(pattern), []rune(text)
+	for len(p) > 0 {
+		switch p[0] {
+		case '*':
+			for len(p) > 0 && p[0] == '*' {
+				p = p[1:]
+			}
+			if len(p) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchGlob(string(p), string(s[i:])) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+			p, s = p[1:], s[1:]
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := 1
+			if end < len(p) && p[end] == '^' {
+				end++
+			}
+			if end < len(p) && p[end] == ']' {
+				end++
+			}
+			for end < len(p) && p[end] != ']' {
+				end++
+			}
+			if end == len(p) {
+				return false
+			}
+			if !matchGlobClass(p[1:end], s[0]) {
+				return false
+			}
+			p, s = p[end+1:], s[1:]
+		default:
+			if len(s) == 0 || p[0] != s[0] {
+				return false
+			}
+			p, s = p[1:], s[1:]
+		}
+	}
+	return len(s) == 0
+}
+
+// matchGlobClass tells if a character is in the list of a "[...]" of a GLOB pattern
+func matchGlobClass(class []rune, ch rune) bool {
+	negate := len(class) > 0 && class[0] == '^'
+	if negate {
+		class = class[1:]
+	}
+	found := false
+	for i := 0; i < len(class); i++ {
+		if i+2 < len(class) && class[i+1] == '-' {
+			found = found || (ch >= class[i] && ch <= class[i+2])
+			i += 2
+		} else {
+			found = found || ch == class[i]
+		}
+	}
+	return found != negate
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestStringFunctions(t *testing.T) {
+	db := NewDbContext("testdata/indexes.db")
+	defer db.Close()
+
+	tests := []struct{ query, expected string }{
+		{"select length('héllo'), length(x'0001'), length(NULL), length(12.5), upper('abcé'), lower('ABC')", "5|2||4|ABCé|abc\n"},
+		{"select substr('hello', 2), substr('hello', 2, 2), substr('hello', -3), substr('hello', 0, 2), substr('hello', -10, 8), substr('hello', 3, -2), substr('héllo', 2, 2)", "ello|el|llo|h|hel|he|él\n"},
+		{"select hex(substr(x'010203', 2)), substring(123456, 2, 3), substr(NULL, 1)", "0203|234|\n"},
+		{"select substr('abc', 2, 9223372036854775807), quote(substr('abc', 9223372036854775807, 9223372036854775807)), substr('abc', 2, -9223372036854775808)", "bc|''|a\n"},
+		{"select trim('  ab  '), ltrim('xxabxx','x'), rtrim('xxabxx','x'), trim('abcba','ab'), replace('aaa','a','bb'), replace('abc','','x')", "ab|abxx|xxab|c|bbbbbb|abc\n"},
+		{"select instr('héllo','l'), instr(x'0102',x'02'), instr('abc', NULL), instr('abc', 'd')", "3|2||0\n"},
+		{"select quote('it''s'), quote(1), quote(1.5), quote(x'ab'), quote(NULL)", "'it''s'|1|1.5|X'AB'|NULL\n"},
+		{"select hex('é'), hex(x'00ff'), hex(12), unhex('414243'), unhex('41 42', ' '), unhex('4g')", "C3A9|00FF|3132|ABC|AB|\n"},
+		{"select char(72,105,233), unicode('é'), unicode(''), concat('a',NULL,1), concat_ws(',', 'a', NULL, 'b'), concat_ws(NULL,'a')", "Hié|233||a1|a,b|\n"},
+		{"select like('a%','ABC'), like('a\\%', 'a%', '\\'), glob('a*','abc'), glob('[a-c]?','bx'), glob('[^a]*','abc'), glob('A*', 'abc')", "1|1|1|1|0|0\n"},
+		{"select soundex('Robert'), soundex('Tymczak'), soundex('Pfister'), soundex('Ashcraft'), soundex('')", "R163|T522|P236|A226|?000\n"},
+		{"select upper(kind), length(kind), substr(kind, 1, 3) from events where id < 3", "KIND-1|6|kin\nKIND-2|6|kin\n"},
+	}
+	for _, test := range tests {
+		result := new(bytes.Buffer)
+		if err := db.HandleSelect(test.query, result); err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+	}
+
+	for query, message := range map[string]string{
+		"select nosuch(1)":                   "no such function: nosuch",
+		"select substr('a')":                 "wrong number of arguments to function substr()",
+		"select like('a', 'a', 'ab')":        "ESCAPE expression must be a single character",
+		"select length(kind, 1) from events": "wrong number of arguments to function length()",
+	} {
+		if err := db.HandleSelect(query, new(bytes.Buffer)); err == nil || err.Error() != message {
+			t.Errorf("query: %s - expected error: %s - got: %v", query, message, err)
+		}
+	}
+}
+
+func TestPrintf(t *testing.T) {
+	tests := []struct {
+		args     []any
+		expected any
+	}{
+		{[]any{"%d|%5d|%-5d|%05d|%+d|% d|%.3d|%,d", int64(42), int64(42), int64(42), int64(-42), int64(42), int64(42), int64(7), int64(1234567)}, "42|   42|42   |-0042|+42| 42|007|1,234,567"},
+		{[]any{"%x|%X|%#x|%o|%#o|%u", int64(255), int64(255), int64(255), int64(8), int64(8), int64(-1)}, "ff|FF|0xff|10|010|18446744073709551615"},
+		{[]any{"%f|%.2f|%10.3f|%-10.1f|%010.2f", 3.14159, 2.675, -1.5, 2.25, -3.5}, "3.141590|2.67|    -1.500|2.3       |-000003.50"},
+		{[]any{"%e|%.3E|%g|%G|%g|%g|%#g|%.0f", 12345.678, 0.00012, 0.0001, 1e-5, 100000.0, 1e6, 1.5, 2.5}, "1.234568e+04|1.200E-04|0.0001|1E-05|100000|1e+06|1.50000|3"},
+		{[]any{"%.20f|%!.20f|%f", 0.1, 0.1, 1e-300}, "0.10000000000000000000|0.1000000000000000055|0.000000"},
+		{[]any{"%s|%.2s|%5s|%-5s|%!.3s|%q|%Q|%Q|%w|%c|%.3c|%%", "héllo", "abc", "ab", "ab", "héllo", "it's", "it's", nil, `a"b`, "xyz", "x"}, `héllo|ab|   ab|ab   |hél|it''s|'it''s'|NULL|a""b|x|xxx|%`},
+		{[]any{"%*d|%-*d|%.*f|%d|%s", int64(5), int64(1), int64(4), int64(2), int64(2), 3.14159, "12abc", 3.7}, "    1|2   |3.14|12|3.7"},
+		{[]any{"%d and %s"}, "0 and "},
+		{[]any{"%s %k %s", "a", "b"}, "a "},
+		{[]any{"%lld %ld|%5lu|%lf|%llx|%lc|%lll", int64(5), int64(6), int64(7), 1.5, int64(255), "xy", int64(1)}, "5 6|    7|1.500000|ff|x|"},
+		{[]any{"[%c|%.3c|%-3%|%05%] 100%", "", ""}, "[||%  |    %] 100%"},
+		{[]any{nil}, nil},
+	}
+	for _, test := range tests {
+		result, err := printfFunction(test.args)
+		if err != nil {
+			t.Errorf("args: %v - error: %v", test.args, err)
+		} else if result != test.expected {
+			t.Errorf("args: %v - expected: %q - got: %q", test.args, test.expected, result)
+		}
+	}
+
+	for _, args := range [][]any{
+		{"%*d", int64(9999999999), int64(1)},
+		{"%9223372036854775807d", int64(1)},
+		{"%.*s", int64(math.MinInt64), "a"},
+	} {
+		if _, err := printfFunction(args); err != errTooBig {
+			t.Errorf("args: %v - expected error: %v - got: %v", args, errTooBig, err)
+		}
+	}
+}
+
+func TestDateTimeFunctions(t *testing.T) {
+	db := NewDbContext("testdata/indexes.db")
+	defer db.Close()
+	defer func(now func() time.Time) { timeNow = now }(timeNow)
+	timeNow = func() time.Time { return time.Date(2024, 3, 15, 14, 5, 7, 250000000, time.UTC) }
+
+	tests := []struct{ query, expected string }{
+		{"select date('2024-03-15'), time('12:34:56.789'), datetime('2024-03-15 12:34:56'), unixepoch('2024-03-15 12:00:00'), julianday('2024-03-15') = 2460384.5", "2024-03-15|12:34:56|2024-03-15 12:34:56|1710504000|1\n"},
+		{"select date('2023-02-31'), datetime('2024-03-15T10:00:00Z'), datetime('2024-03-15 10:00:00+02:00'), datetime('2024-03-15 10:00 -05:30')", "2023-03-03|2024-03-15 10:00:00|2024-03-15 08:00:00|2024-03-15 15:30:00\n"},
+		{"select date(2460384.5), datetime(1710000000, 'unixepoch'), datetime(1710000000.5, 'unixepoch', 'subsec'), datetime('1710000000', 'unixepoch'), date(1710000000, 'auto'), date(2460384.5, 'auto')", "2024-03-15|2024-03-09 16:00:00|2024-03-09 16:00:00.500|2024-03-09 16:00:00|2024-03-09|2024-03-15\n"},
+		{"select date('2024-01-31', '+1 month'), date('2024-01-31', '+1 month', 'floor'), date('2024-02-29', '+1 year'), date('2023-03-31', '-1 month'), datetime('2024-03-15 10:00', '+1.5 hours'), datetime('2024-03-15', '-90 minutes')", "2024-03-02|2024-02-29|2025-03-01|2023-03-03|2024-03-15 11:30:00|2024-03-14 22:30:00\n"},
+		{"select date('2024-03-15', 'start of month'), date('2024-03-15', 'start of year'), date('2024-03-15', 'weekday 0'), date('2024-03-15', 'weekday 5'), date('2024-03-15', 'start of month', '+1 month', '-1 day')", "2024-03-01|2024-01-01|2024-03-17|2024-03-15|2024-03-31\n"},
+		{"select datetime('2024-03-15 10:00', '+01:30'), datetime('2024-03-15 10:00', '-01:30:15.5'), datetime('2024-03-15 10:00', '+0001-02-03'), datetime('2024-03-15 10:00', '-0001-02-03 04:05')", "2024-03-15 11:30:00|2024-03-15 08:29:44|2025-05-18 10:00:00|2023-01-12 05:55:00\n"},
+		{"select strftime('%Y-%m-%d %H:%M:%f %j %w %u %U %W %V %G %s %e %l %p %R %T %F %%', '2024-03-15 14:05:07.25'), strftime('%Q', 'now')", "2024-03-15 14:05:07.250 075 5 5 10 11 11 2024 1710511507 15  2 PM 14:05 14:05:07 2024-03-15 %|\n"},
+		{"select timediff('2024-03-15', '2023-01-01'), timediff('2023-01-01', '2024-03-15 12:30:45.5'), timediff('2024-03-01', '2024-01-31')", "+0001-02-14 00:00:00.000|-0001-02-14 12:30:45.500|+0000-00-30 00:00:00.000\n"},
+		{"select datetime('now'), datetime('now', 'subsec'), date(), unixepoch('now', 'subsec') = 1710511507.25", "2024-03-15 14:05:07|2024-03-15 14:05:07.250|2024-03-15|1\n"},
+		{"select current_date, current_time, current_timestamp, true, false", "2024-03-15|14:05:07|2024-03-15 14:05:07|1|0\n"},
+		{"select date('junk'), date(NULL), date('2024-13-01'), date('2024-03-15', 'bogus'), date('-0044-03-15'), date(0), date(-1)", "||||-0044-03-15|-4713-11-24|\n"},
+	}
+	for _, test := range tests {
+		result := new(bytes.Buffer)
+		if err := db.HandleSelect(test.query, result); err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+	}
+}
+
+func TestArithmetic(t *testing.T) {
+	db := NewDbContext("testdata/indexes.db")
+	defer db.Close()
+
+	tests := []struct{ query, expected string }{
+		{"select 5.5 % 2, 7 % 2.5, -7 % 3, 7 % -3, 5 % 0, 5.0/0, 7/2, 7.0/2, 9223372036854775807 + 1, -9223372036854775808 - 1", "1.0|1.0|-1|1|||3|3.5|9.22337203685478e+18|-9.22337203685478e+18\n"},
+		{"select '12abc' + 1, '1.5x' + 1, 'abc' + 1, '1e3' + 0, 1 || 2, 'a' || NULL, -'1.5', 0.0/0.0, 1e308 * 10", "13|2.5|1|1000.0|12||-1.5||Inf\n"},
+		{"select ~5, 6 & 3, 6 | 3, 1 << 64, -8 >> 1, 8 >> -1, 2 + 3 * 4 - 10 / 3, 1 - 2 - 3, 2 * 3 || 4", "-6|2|7|0|-4|16|11|-4|68\n"},
+		{"select id * 2 + 1, id / 2, id % 3, -id from events where id - 1 < 3 order by id", "3|0|1|-1\n5|1|2|-2\n7|1|0|-3\n"},
+	}
+	for _, test := range tests {
+		result := new(bytes.Buffer)
+		if err := db.HandleSelect(test.query, result); err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+	}
+}
+
+func TestMathFunctions(t *testing.T) {
+	db := NewDbContext("testdata/indexes.db")
+	defer db.Close()
+
+	tests := []struct{ query, expected string }{
+		{"select abs(-5), abs(-5.5), abs('-3'), abs(NULL), round(2.5), round(-2.5), round(1.2345, 2), round(1.005, 2), round('3.7')", "5|5.5|3.0||3.0|-3.0|1.23|1.0|4.0\n"},
+		{"select max(1, 2.5, '3'), min(1, 2.5, 'a'), max(1, NULL), sign(-3), sign('2'), sign('x'), length(randomblob(0)), length(randomblob(5))", "3|1||-1|1||1|5\n"},
+		{"select ceil(1.2), ceil(5), floor('2.5'), trunc(-1.7), ceil('x'), sqrt(16), sqrt(-1), ln(0), log(100), log(2, 8), log(1, 8), log2(8)", "2.0|5|2.0|-1.0||4.0|||2.0|3.0||3.0\n"},
+		{"select pi(), pow(2, 10), mod(7.5, 2), mod(1, 0), degrees(pi()), cos(0), atan2(0, 1)", "3.14159265358979|1024.0|1.5||180.0|1.0|0.0\n"},
+		{"select max(id), max(id, 3) from events where id = 1", "1|3\n"},
+	}
+	for _, test := range tests {
+		result := new(bytes.Buffer)
+		if err := db.HandleSelect(test.query, result); err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+	}
+
+	for query, message := range map[string]string{
+		"select abs(-9223372036854775808)":       "integer overflow",
+		"select randomblob(9223372036854775807)": "string or blob too big",
+	} {
+		if err := db.HandleSelect(query, new(bytes.Buffer)); err == nil || err.Error() != message {
+			t.Errorf("query: %s - expected error: %s - got: %v", query, message, err)
+		}
+	}
+}
+
+func TestJSONFunctions(t *testing.T) {
+	db := NewDbContext("testdata/indexes.db")
+	defer db.Close()
+
+	tests := []struct{ query, expected string }{
+		{`select json(' [1 , 2.0 ,1E3, -0] '), json('"aA\n"'), json(5), json(NULL), json_valid('{"a":1,}'), json_valid('[1'), json_valid('{"a":[]}')`, `[1,2.0,1E3,-0]|"aA\n"|5||0|0|1` + "\n"},
+		{`select json_extract('{"a":[1,{"b":"x\ty"}]}', '$.a[1].b'), json_extract('[1,2,3]', '$[#-1]'), json_extract('[1,2,3]', '$[#]'), json_extract('{"a b":1}', '$."a b"'), json_extract('{"a":{"b":2}}', '$.a'), json_extract('[true,null]', '$[0]', '$[1]', '$[2]')`, "x\ty|3||1|{\"b\":2}|[true,null,null]\n"},
+		{`select json_extract('[1e400, 12345678901234567890123]', '$[0]'), json_extract('[1e400, 12345678901234567890123]', '$[1]'), json_extract('{"a":1}', '$.a[')`, "Inf|1.23456789012346e+22|\n"},
+		{`select '{"a":[5,6]}' -> '$.a', '{"a":"x"}' -> 'a', '{"a":"x"}' ->> 'a', '[5,6]' -> 1, '[5,6]' ->> -1, '{"a b":1}' ->> 'a b', '{"a":1}' -> 'b', '{"a":null}' -> 'a'`, "[5,6]|\"x\"|x|6|6|1||null\n"},
+		{`select json_type('[1]'), json_type('{"a":1.0}', '$.a'), json_type('1', '$.x'), json_array_length('[1,2]'), json_array_length('{}'), json_array_length('[1,[2,3]]', '$[1]')`, "array|real||2|0|2\n"},
+		{`select json_object('a', 1, 'b', 1.5, 'c', 'x"y', 'd', NULL, 'e', json_array(1, 2), 'f', '[1]', 'g', 1e400)`, `{"a":1,"b":1.5,"c":"x\"y","d":null,"e":[1,2],"f":"[1]","g":9.0e+999}` + "\n"},
+		{`select json_array(json('{"x":1}'), json_extract('{"a":"[1]"}', '$.a'), json_extract('{"a":[1]}', '$.a'), '[1]' -> 0, 100.0)`, `[{"x":1},"[1]",[1],1,100.0]` + "\n"},
+		{"select json_group_array(id), json_group_object(kind, id) from events where id < 4", `[1,2,3]|{"kind-1":1,"kind-2":2,"kind-3":3}` + "\n"},
+		{"select json_group_array(json_array(id)), json_group_object('a', 1) from events where id < 0", "[]|{}\n"},
+		{"select json_object('k', kind) ->> 'k' from events where id = 1", "kind-1\n"},
+	}
+	for _, test := range tests {
+		result := new(bytes.Buffer)
+		if err := db.HandleSelect(test.query, result); err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+	}
+
+	for query, message := range map[string]string{
+		"select json('[1')":                      "malformed JSON",
+		`select json_extract('{"a":1}', 'a')`:    "bad JSON path: 'a'",
+		"select json_object('a')":                "json_object() requires an even number of arguments",
+		"select json_object(1, 2)":               "json_object() labels must be TEXT",
+		"select * from json_each('[1]', '$', 1)": "wrong number of arguments to function json_each()",
+	} {
+		if err := db.HandleSelect(query, new(bytes.Buffer)); err == nil || err.Error() != message {
+			t.Errorf("query: %s - expected error: %s - got: %v", query, message, err)
+		}
+	}
+}
+
+func TestJSONTableFunctions(t *testing.T) {
+	db := NewDbContext("testdata/indexes.db")
+	defer db.Close()
+
+	tests := []struct{ query, expected string }{
+		{`select * from json_each('[10,"a",{"b":[1,2]},null,1.5e3]')`, "0|10|integer|10|2||$[0]|$\n1|a|text|a|5||$[1]|$\n2|{\"b\":[1,2]}|object||7||$[2]|$\n3||null||15||$[3]|$\n4|1500.0|real|1500.0|16||$[4]|$\n"},
+		{`select * from json_tree('{"a":[1,{"x y":2}],"b":"sA"}')`, "|{\"a\":[1,{\"x y\":2}],\"b\":\"sA\"}|object||0||$|$\na|[1,{\"x y\":2}]|array||2|0|$.a|$\n0|1|integer|1|5|2|$.a[0]|$.a\n1|{\"x y\":2}|object||7|2|$.a[1]|$.a\nx y|2|integer|2|8|7|$.a[1].\"x y\"|$.a[1]\nb|sA|text|sA|14|0|$.b|$\n"},
+		{`select key, value, id, fullkey, path, json, root from json_each('{"a":[1,{"x y":2}],"b":3}', '$.a')`, "0|1|5|$.a[0]|$.a|{\"a\":[1,{\"x y\":2}],\"b\":3}|$.a\n1|{\"x y\":2}|7|$.a[1]|$.a|{\"a\":[1,{\"x y\":2}],\"b\":3}|$.a\n"},
+		{`select * from json_tree('{"a":{"b":[1,2]}}', '$.a')`, "a|{\"b\":[1,2]}|object||1||$.a|$\nb|[1,2]|array||4|1|$.a.b|$.a\n0|1|integer|1|7|4|$.a.b[0]|$.a.b\n1|2|integer|2|9|4|$.a.b[1]|$.a.b\n"},
+		{"select * from json_each('5')", "|5|integer|5|0||$|$\n"},
+		{`select count(*) from json_each('{"a":1}', '$.b'), json_each(NULL)`, "0\n"},
+		{"select e.id, j.value from events e, json_each(json_array(e.id, e.id * 2)) j where e.id < 3 order by e.id, j.key", "1|1\n1|2\n2|2\n2|4\n"},
+		{"select json_group_array(value) from json_each('[3,1,2]') where value > 1", "[3,2]\n"},
+		{"explain query plan select * from json_each('[1]', '$') j", "QUERY PLAN\n`--SCAN j VIRTUAL TABLE INDEX 3:\n"},
+	}
+	for _, test := range tests {
+		result := new(bytes.Buffer)
+		if err := db.HandleSelect(test.query, result); err != nil {
+			t.Errorf("query: %s - error: %v", test.query, err)
+		} else if result.String() != test.expected {
+			t.Errorf("query: %s - expected: %q - got: %q", test.query, test.expected, result.String())
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var pageTypeNames = map[uint8]string{
+	0x02: "index interior",
+	0x05: "table interior",
+	0x0a: "index leaf",
+	0x0d: "table leaf",
+}
+
+// PrintPage writes the decoded header, cell pointer array and cells of a b-tree page, followed
+// by a hex dump of the whole page
+func (db *DbContext) PrintPage(writer io.Writer, pageNumber int) error {
+	page, err := db.readPage(pageNumber)
+	if err != nil {
+		return err
+	}
+	header, err := db.parsePageHeader(pageNumber, page)
+	if err != nil {
+		// overflow and freelist pages don't have a header
+		fmt.Fprintf(writer, "page %d is not a b-tree page: %v\n", pageNumber, err)
+		fmt.Fprintf(writer, "---------- hex dump ----------\n")
+		hexDump(writer, page)
+		return nil
+	}
+
+	fmt.Fprintf(writer, "---------- page header ----------\n")
+	fmt.Fprintf(writer, "pageType:               %v (%s)\n", header.PageType, pageTypeNames[header.PageType])
+	fmt.Fprintf(writer, "firstFreeBlock:         %v\n", header.FirstFreeBlock)
+	fmt.Fprintf(writer, "cellCount:              %v\n", header.CellCount)
+	fmt.Fprintf(writer, "startOfCellContentArea: %v\n", header.StartOfCellContentArea)
+	fmt.Fprintf(writer, "fragmentedFreeBytes:    %v\n", header.FragmentedFreeBytes)
+	fmt.Fprintf(writer, "rightMostPointer:       %v\n", header.RightMostPointer)
+	fmt.Fprintf(writer, "unallocatedRegionSize:  %v\n", header.UnallocatedRegionSize)
+	visited := map[int]bool{}
+	for freeblock := int(header.FirstFreeBlock); freeblock != 0 && freeblock+4 <= len(page) && !visited[freeblock]; {
+		visited[freeblock] = true
+		fmt.Fprintf(writer, "freeblock:              %04x (%d bytes)\n", freeblock, readBigEndianUint16(page[freeblock+2:]))
+		freeblock = int(readBigEndianUint16(page[freeblock:]))
+	}
+
+	fmt.Fprintf(writer, "---------- cell pointer array ----------\n")
+	fmt.Fprintf(writer, "cell\tpointer\tsize\tpage\trowid\tpayload\toverflow\n")
+	cells := []BtreeCell{}
+	for i, offset := range getCellOffsets(header, page) {
+		cell, err := db.parseCell(header, page, offset)
+		if err != nil {
+			fmt.Fprintf(writer, "%v\t%04x\t%v\n", i, offset, err)
+			continue
+		}
+		cells = append(cells, cell)
+		fmt.Fprintf(writer, "%v\t%04x\t%v\t%v\t%v\t%v\t%v\n", i, offset, cell.Size, cell.LeftChildPage, cell.Rowid, cell.PayloadSize, cell.OverflowPage)
+	}
+
+	fmt.Fprintf(writer, "---------- cells ----------\n")
+	for i, cell := range cells {
+		fmt.Fprintf(writer, "%v\t", i)
+		switch header.PageType {
+		case 0x05:
+			fmt.Fprintf(writer, "child page %d, rowid <= %d\n", cell.LeftChildPage, cell.Rowid)
+			continue
+		case 0x02:
+			fmt.Fprintf(writer, "child page %d, key ", cell.LeftChildPage)
+		case 0x0d:
+			fmt.Fprintf(writer, "rowid %d, record ", cell.Rowid)
+		case 0x0a:
+			fmt.Fprintf(writer, "key ")
+		}
+		payload := cell.LocalPayload
+		if cell.OverflowPage != 0 {
+			payload, err = db.readDataWithOverflow(header, page, cell.PayloadOffset, cell.PayloadSize)
+			if err != nil {
+				fmt.Fprintln(writer, err)
+				continue
+			}
+		}
+		columns, _, err := db.decodeRecord(payload)
+		if err != nil {
+			fmt.Fprintln(writer, err)
+			continue
+		}
+		fmt.Fprintln(writer, formatRecord(columns))
+	}
+
+	fmt.Fprintf(writer, "---------- hex dump ----------\n")
+	hexDump(writer, page)
+	return nil
+}
+
+// PrintBtree writes the shape of the b-tree used by a table or index, one page per line. With
+// dot enabled, the output is a Graphviz graph with the links between pages, including overflow chains.
+func (db *DbContext) PrintBtree(writer io.Writer, name string, dot bool) error {
+	rootPage := 0
+	if strings.EqualFold(name, "sqlite_schema") || strings.EqualFold(name, "sqlite_master") {
+		rootPage = 1
+	}
+	for _, entry := range db.Schema {
+		if strings.EqualFold(entry.Name, name) && entry.RootPage > 0 {
+			rootPage = entry.RootPage
+			break
+		}
+	}
+	if rootPage == 0 {
+		return fmt.Errorf("no such table or index: %s", name)
+	}
+
+	if dot {
+		fmt.Fprintf(writer, "digraph %s {\n", quoteIdentifier(name))
+		fmt.Fprintln(writer, "\tnode [shape=box];")
+	}
+	db.printBtreePage(writer, rootPage, 0, dot)
+	if dot {
+		fmt.Fprintln(writer, "}")
+	}
+	return nil
+}
+
+func (db *DbContext) printBtreePage(writer io.Writer, pageNumber int, depth int, dot bool) {
+	page, err := db.readPage(pageNumber)
+	var header PageHeader
+	if err == nil {
+		header, err = db.parsePageHeader(pageNumber, page)
+	}
+	if err != nil {
+		// a damaged page is shown with its error, as the pages below it can't be found
+		if dot {
+			label := strings.ReplaceAll(err.Error(), `"`, `\"`)
+			fmt.Fprintf(writer, "\tpage%d [label=\"page %d\\ndamaged\\n%s\", color=red];\n", pageNumber, pageNumber, label)
+		} else {
+			fmt.Fprintf(writer, "%spage %d: damaged, %v\n", strings.Repeat("  ", depth), pageNumber, err)
+		}
+		return
+	}
+	cells := []BtreeCell{}
+	for _, offset := range getCellOffsets(header, page) {
+		cell, err := db.parseCell(header, page, offset)
+		if err == nil {
+			cells = append(cells, cell)
+		}
+	}
+
+	// show the range of keys stored on the page
+	keyRange := ""
+	if len(cells) > 0 {
+		first, last := cells[0], cells[len(cells)-1]
+		switch header.PageType {
+		case 0x05:
+			keyRange = fmt.Sprintf("keys %d..%d", first.Rowid, last.Rowid)
+		case 0x0d:
+			keyRange = fmt.Sprintf("rowid %d..%d", first.Rowid, last.Rowid)
+		case 0x02, 0x0a:
+			keyRange = fmt.Sprintf("key %s..%s", db.describeKey(header, page, first), db.describeKey(header, page, last))
+		}
+	}
+
+	if dot {
+		label := fmt.Sprintf("page %d\\n%s\\n%d cells", pageNumber, pageTypeNames[header.PageType], header.CellCount)
+		if keyRange != "" {
+			label += "\\n" + strings.ReplaceAll(keyRange, `"`, `\"`)
+		}
+		fmt.Fprintf(writer, "\tpage%d [label=\"%s\"];\n", pageNumber, label)
+	} else {
+		fmt.Fprintf(writer, "%spage %d: %s, %d cells", strings.Repeat("  ", depth), pageNumber, pageTypeNames[header.PageType], header.CellCount)
+		if keyRange != "" {
+			fmt.Fprintf(writer, ", %s", keyRange)
+		}
+		fmt.Fprintln(writer)
+	}
+
+	for i, cell := range cells {
+		if cell.OverflowPage != 0 {
+			db.printOverflowChain(writer, pageNumber, i, int(cell.OverflowPage), depth+1, dot)
+		}
+		if cell.LeftChildPage != 0 {
+			if dot {
+				fmt.Fprintf(writer, "\tpage%d -> page%d [label=\"%d\"];\n", pageNumber, cell.LeftChildPage, i)
+			}
+			db.printBtreePage(writer, int(cell.LeftChildPage), depth+1, dot)
+		}
+	}
+	if header.RightMostPointer != 0 {
+		if dot {
+			fmt.Fprintf(writer, "\tpage%d -> page%d [label=\"right\"];\n", pageNumber, header.RightMostPointer)
+		}
+		db.printBtreePage(writer, int(header.RightMostPointer), depth+1, dot)
+	}
+}
+
+func (db *DbContext) printOverflowChain(writer io.Writer, pageNumber int, cell int, overflowPage int, depth int, dot bool) {
+	chain := []string{}
+	previous := fmt.Sprintf("page%d", pageNumber)
+	edgeLabel := fmt.Sprintf("cell %d", cell)
+	visited := map[int]bool{}
+	for overflowPage != 0 && !visited[overflowPage] {
+		visited[overflowPage] = true
+		chain = append(chain, fmt.Sprint(overflowPage))
+		next, _, err := db.getOverflowPage(overflowPage)
+		if dot {
+			fmt.Fprintf(writer, "\tpage%d [label=\"page %d\\noverflow\", style=dashed];\n", overflowPage, overflowPage)
+			fmt.Fprintf(writer, "\t%s -> page%d [label=\"%s\", style=dashed];\n", previous, overflowPage, edgeLabel)
+		}
+		if err != nil {
+			break
+		}
+		previous, edgeLabel = fmt.Sprintf("page%d", overflowPage), "next"
+		overflowPage = next
+	}
+	if !dot {
+		fmt.Fprintf(writer, "%scell %d overflow: %s\n", strings.Repeat("  ", depth), cell, strings.Join(chain, " -> "))
+	}
+}
+
+// describeKey shows the values on an index key, reading the overflow pages if needed
+func (db *DbContext) describeKey(header PageHeader, page []byte, cell BtreeCell) string {
+	payload := cell.LocalPayload
+	if cell.OverflowPage != 0 {
+		var err error
+		payload, err = db.readDataWithOverflow(header, page, cell.PayloadOffset, cell.PayloadSize)
+		if err != nil {
+			return "?"
+		}
+	}
+	columns, _, err := db.decodeRecord(payload)
+	if err != nil {
+		return "?"
+	}
+	return formatRecord(columns)
+}
+
+// formatRecord shows the values as SQL literals, shortening the long ones
+func formatRecord(columns []any) string {
+	values := []string{}
+	for _, value := range columns {
+		literal := quoteLiteral(value)
+		if len(literal) > 40 {
+			literal = fmt.Sprintf("%s...(%d bytes)", literal[:32], len(literal))
+		}
+		values = append(values, literal)
+	}
+	return "(" + strings.Join(values, ",") + ")"
+}
+
+// hexDump writes the data in the same format used by "hexdump -C", replacing repeated lines with "*"
+func hexDump(writer io.Writer, data []byte) {
+	var previous []byte
+	skipping := false
+	for offset := 0; offset < len(data); offset += 16 {
+		line := data[offset:min(offset+16, len(data))]
+		if previous != nil && bytes.Equal(line, previous) {
+			if !skipping {
+				fmt.Fprintln(writer, "*")
+				skipping = true
+			}
+			continue
+		}
+		previous, skipping = line, false
+		hexBytes := []string{}
+		text := []byte{}
+		for _, b := range line {
+			hexBytes = append(hexBytes, fmt.Sprintf("%02x", b))
+			if b >= 0x20 && b < 0x7f {
+				text = append(text, b)
+			} else {
+				text = append(text, '.')
+			}
+		}
+		hexText := strings.Join(hexBytes, " ")
+		if len(hexBytes) > 8 {
+			hexText = strings.Join(hexBytes[:8], " ") + "  " + strings.Join(hexBytes[8:], " ")
+		}
+		fmt.Fprintf(writer, "%08x  %-49s |%s|\n", offset, hexText, text)
+	}
+	fmt.Fprintf(writer, "%08x\n", len(data))
+}
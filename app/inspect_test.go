@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPrintPage(t *testing.T) {
+	db := NewDbContext("../sample.db")
+	defer db.Close()
+
+	result := new(bytes.Buffer)
+	if err := db.PrintPage(result, 2); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"pageType:               13 (table leaf)",
+		"rowid 1, record (NULL,'Granny Smith','Light Green')",
+		"---------- hex dump ----------",
+	}
+	for _, text := range expected {
+		if !strings.Contains(result.String(), text) {
+			t.Errorf("result does not contain text: %q", text)
+		}
+	}
+
+	if err := db.PrintPage(result, 999); err == nil {
+		t.Errorf("expected error reading page past the end of the file")
+	}
+}
+
+func TestPrintBtree(t *testing.T) {
+	db := NewDbContext("../superheroes.db")
+	defer db.Close()
+
+	result := new(bytes.Buffer)
+	if err := db.PrintBtree(result, "superheroes", false); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"page 2: table interior, 108 cells",
+		"  page 4: table leaf, 57 cells, rowid 1..57",
+	}
+	for _, text := range expected {
+		if !strings.Contains(result.String(), text) {
+			t.Errorf("result does not contain text: %q", text)
+		}
+	}
+
+	result.Reset()
+	if err := db.PrintBtree(result, "superheroes", true); err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{
+		"digraph superheroes {",
+		"page2 -> page4",
+	}
+	for _, text := range expected {
+		if !strings.Contains(result.String(), text) {
+			t.Errorf("result does not contain text: %q", text)
+		}
+	}
+
+	if err := db.PrintBtree(result, "missing", false); err == nil {
+		t.Errorf("expected error for missing table")
+	}
+}
+
+func TestPrintBtreeWithDamagedPage(t *testing.T) {
+	db := NewDbContext("testdata/recover.db")
+	defer db.Close()
+
+	result := new(bytes.Buffer)
+	if err := db.PrintBtree(result, "items", false); err != nil {
+		t.Fatal(err)
+	}
+	expected := "page 2: table interior, 2 cells, keys 229..444\n" +
+		"  page 5: table leaf, 98 cells, rowid 1..99\n" +
+		"  page 6: table leaf, 143 cells, rowid 301..444\n" +
+		"  page 9: damaged, page 9 has invalid type: 255\n"
+	if result.String() != expected {
+		t.Errorf("expected: %q - got: %q", expected, result.String())
+	}
+}
@@ -0,0 +1,824 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// jsonNode is a parsed JSON value. Type is the name given by json_type: null, true, false, integer, real,
+// text, array or object. Numbers and texts keep the text they were written with, texts with their escapes
+// and without the quotes, so they are written back the same way. Objects have the labels of their members
+// on Labels, written like texts.
+type jsonNode struct {
+	Type     string
+	Text     string
+	Children []*jsonNode
+	Labels   []string
+	// encodedSize caches the size in JSONB
+	encodedSize int
+}
+
+// jsonMaxDepth is the deepest nesting of arrays and objects accepted, as in SQLite
+const jsonMaxDepth = 1000
+
+var errMalformedJSON = errors.New("malformed JSON")
+
+// jsonFunctions are the functions that return JSON, giving the node of the result so that it's inserted
+// as JSON and not as a text when used as an argument of another JSON function
+var jsonFunctions = map[string]func(args []any) (*jsonNode, error){
+	"JSON":         jsonFunction,
+	"JSON_ARRAY":   jsonArrayFunction,
+	"JSON_OBJECT":  jsonObjectFunction,
+	"JSON_EXTRACT": jsonExtractNode,
+}
+
+// parseJSON reads a JSON text as defined by RFC 8259
+func parseJSON(text string) (*jsonNode, error) {
+	parser := jsonParser{text: text}
+	node, err := parser.parseValue(0)
+	if err != nil {
+		return nil, err
+	}
+	if parser.skipSpaces(); parser.position < len(text) {
+		return nil, errMalformedJSON
+	}
+	return node, nil
+}
+
+type jsonParser struct {
+	text     string
+	position int
+}
+
+func (parser *jsonParser) skipSpaces() {
+	for parser.position < len(parser.text) && strings.IndexByte(" \t\n\r", parser.text[parser.position]) >= 0 {
+		parser.position++
+	}
+}
+
+func (parser *jsonParser) parseValue(depth int) (*jsonNode, error) {
+	if depth > jsonMaxDepth {
+		return nil, errMalformedJSON
+	}
+	parser.skipSpaces()
+	if parser.position == len(parser.text) {
+		return nil, errMalformedJSON
+	}
+	text := parser.text[parser.position:]
+	switch ch := text[0]; {
+	case ch == '{' || ch == '[':
+		node := &jsonNode{Type: "array"}
+		end := byte(']')
+		if ch == '{' {
+			node.Type, end = "object", '}'
+		}
+		parser.position++
+		if parser.skipSpaces(); parser.position < len(parser.text) && parser.text[parser.position] == end {
+			parser.position++
+			return node, nil
+		}
+		for {
+			if node.Type == "object" {
+				if parser.skipSpaces(); parser.position == len(parser.text) || parser.text[parser.position] != '"' {
+					return nil, errMalformedJSON
+				}
+				label, err := parser.parseString()
+				if err != nil {
+					return nil, err
+				}
+				if parser.skipSpaces(); parser.position == len(parser.text) || parser.text[parser.position] != ':' {
+					return nil, errMalformedJSON
+				}
+				parser.position++
+				node.Labels = append(node.Labels, label)
+			}
+			child, err := parser.parseValue(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			node.Children = append(node.Children, child)
+			parser.skipSpaces()
+			if parser.position == len(parser.text) {
+				return nil, errMalformedJSON
+			}
+			parser.position++
+			switch parser.text[parser.position-1] {
+			case ',':
+				continue
+			case end:
+				return node, nil
+			}
+			return nil, errMalformedJSON
+		}
+	case ch == '"':
+		text, err := parser.parseString()
+		return &jsonNode{Type: "text", Text: text}, err
+	case ch == '-' || isDigit(ch):
+		return parser.parseNumber()
+	}
+	for _, literal := range []string{"null", "true", "false"} {
+		if strings.HasPrefix(text, literal) {
+			parser.position += len(literal)
+			return &jsonNode{Type: literal}, nil
+		}
+	}
+	return nil, errMalformedJSON
+}
+
+// parseString reads a text between double quotes, returning it with its escapes
+func (parser *jsonParser) parseString() (string, error) {
+	start := parser.position + 1
+	for i := start; i < len(parser.text); i++ {
+		switch ch := parser.text[i]; {
+		case ch == '"':
+			parser.position = i + 1
+			return parser.text[start:i], nil
+		case ch < 0x20:
+			return "", errMalformedJSON
+		case ch == '\\':
+			i++
+			if i == len(parser.text) {
+				return "", errMalformedJSON
+			}
+			switch parser.text[i] {
+			case '"', '\\', '/', 'b', 'f', 'n', 'r', 't':
+			case 'u':
+				if i+4 >= len(parser.text) {
+					return "", errMalformedJSON
+				}
+				if _, err := strconv.ParseUint(parser.text[i+1:i+5], 16, 16); err != nil {
+					return "", errMalformedJSON
+				}
+				i += 4
+			default:
+				return "", errMalformedJSON
+			}
+		}
+	}
+	return "", errMalformedJSON
+}
+
+// parseNumber reads an integer, or a real when it has a fraction or an exponent
+func (parser *jsonParser) parseNumber() (*jsonNode, error) {
+	text := parser.text
+	start, i := parser.position, parser.position
+	digits := func() bool {
+		first := i
+		for i < len(text) && isDigit(text[i]) {
+			i++
+		}
+		return i > first
+	}
+	if text[i] == '-' {
+		i++
+	}
+	if i < len(text) && text[i] == '0' {
+		i++
+	} else if !digits() {
+		return nil, errMalformedJSON
+	}
+	node := &jsonNode{Type: "integer"}
+	if i < len(text) && text[i] == '.' {
+		i++
+		if !digits() {
+			return nil, errMalformedJSON
+		}
+		node.Type = "real"
+	}
+	if i < len(text) && (text[i] == 'e' || text[i] == 'E') {
+		i++
+		if i < len(text) && (text[i] == '+' || text[i] == '-') {
+			i++
+		}
+		if !digits() {
+			return nil, errMalformedJSON
+		}
+		node.Type = "real"
+	}
+	node.Text, parser.position = text[start:i], i
+	return node, nil
+}
+
+// String writes the node as JSON without spaces
+func (node *jsonNode) String() string {
+	var builder strings.Builder
+	node.write(&builder)
+	return builder.String()
+}
+
+func (node *jsonNode) write(builder *strings.Builder) {
+	switch node.Type {
+	case "null", "true", "false":
+		builder.WriteString(node.Type)
+	case "integer", "real":
+		builder.WriteString(node.Text)
+	case "text":
+		builder.WriteString(`"` + node.Text + `"`)
+	case "array", "object":
+		open, end := "[", "]"
+		if node.Type == "object" {
+			open, end = "{", "}"
+		}
+		builder.WriteString(open)
+		for i, child := range node.Children {
+			if i > 0 {
+				builder.WriteByte(',')
+			}
+			if node.Type == "object" {
+				builder.WriteString(`"` + node.Labels[i] + `":`)
+			}
+			child.write(builder)
+		}
+		builder.WriteString(end)
+	}
+}
+
+// sqlValue converts the node to the value returned to SQL: texts without their escapes, numbers, 1 and 0
+// for true and false, and the JSON text of arrays and objects
+func (node *jsonNode) sqlValue() any {
+	switch node.Type {
+	case "true":
+		return int64(1)
+	case "false":
+		return int64(0)
+	case "integer":
+		if integer, err := strconv.ParseInt(node.Text, 10, 64); err == nil {
+			return integer
+		}
+		// too large for an integer
+		real, _ := strconv.ParseFloat(node.Text, 64)
+		return real
+	case "real":
+		real, _ := strconv.ParseFloat(node.Text, 64)
+		return real
+	case "text":
+		return unescapeJSON(node.Text)
+	case "array", "object":
+		return node.String()
+	}
+	return nil
+}
+
+// unescapeJSON replaces the escapes of a JSON text by the characters they stand for
+func unescapeJSON(text string) string {
+	if !strings.Contains(text, `\`) {
+		return text
+	}
+	var builder strings.Builder
+	for i := 0; i < len(text); i++ {
+		if text[i] != '\\' || i+1 == len(text) {
+			builder.WriteByte(text[i])
+			continue
+		}
+		i++
+		switch text[i] {
+		case 'b':
+			builder.WriteByte('\b')
+		case 'f':
+			builder.WriteByte('\f')
+		case 'n':
+			builder.WriteByte('\n')
+		case 'r':
+			builder.WriteByte('\r')
+		case 't':
+			builder.WriteByte('\t')
+		case 'u':
+			code, _ := strconv.ParseUint(text[i+1:i+5], 16, 16)
+			i += 4
+			ch := rune(code)
+			// a pair of surrogates is a single character
+			if utf16.IsSurrogate(ch) && i+6 < len(text) && text[i+1:i+3] == `\u` {
+				if low, err := strconv.ParseUint(text[i+3:i+7], 16, 16); err == nil && utf16.DecodeRune(ch, rune(low)) != utf8.RuneError {
+					ch = utf16.DecodeRune(ch, rune(low))
+					i += 6
+				}
+			}
+			builder.WriteRune(ch)
+		default:
+			builder.WriteByte(text[i])
+		}
+	}
+	return builder.String()
+}
+
+// escapeJSON adds the escapes needed to write a text between double quotes in JSON
+func escapeJSON(text string) string {
+	var builder strings.Builder
+	for i := 0; i < len(text); i++ {
+		switch ch := text[i]; {
+		case ch == '"' || ch == '\\':
+			builder.WriteString(`\` + string(ch))
+		case ch == '\b':
+			builder.WriteString(`\b`)
+		case ch == '\f':
+			builder.WriteString(`\f`)
+		case ch == '\n':
+			builder.WriteString(`\n`)
+		case ch == '\r':
+			builder.WriteString(`\r`)
+		case ch == '\t':
+			builder.WriteString(`\t`)
+		case ch < 0x20:
+			fmt.Fprintf(&builder, `\u%04x`, ch)
+		default:
+			builder.WriteByte(ch)
+		}
+	}
+	return builder.String()
+}
+
+// jsonFromValue converts an SQL value to JSON, where a text is a JSON text unless it's the result of a
+// function that returns JSON, given as a node
+func jsonFromValue(value any) (*jsonNode, error) {
+	switch v := value.(type) {
+	case *jsonNode:
+		return v, nil
+	case nil:
+		return &jsonNode{Type: "null"}, nil
+	case int64:
+		return &jsonNode{Type: "integer", Text: strconv.FormatInt(v, 10)}, nil
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return &jsonNode{Type: "null"}, nil
+		case math.IsInf(v, 1):
+			return &jsonNode{Type: "real", Text: "9.0e+999"}, nil
+		case math.IsInf(v, -1):
+			return &jsonNode{Type: "real", Text: "-9.0e+999"}, nil
+		}
+		return &jsonNode{Type: "real", Text: formatReal(v)}, nil
+	case string:
+		return &jsonNode{Type: "text", Text: escapeJSON(v)}, nil
+	}
+	return nil, errors.New("JSON cannot hold BLOB values")
+}
+
+// jsonArgument reads the JSON given to a function, which is NULL for a NULL value
+func jsonArgument(value any) (*jsonNode, error) {
+	switch v := value.(type) {
+	case *jsonNode:
+		return v, nil
+	case nil:
+		return nil, nil
+	case []byte:
+		return nil, errMalformedJSON
+	}
+	return parseJSON(textValue(value))
+}
+
+// size is the number of bytes of the node in the JSONB format of SQLite, whose offsets are the ids given
+// by json_each and json_tree. The header of each element is longer for larger elements.
+func (node *jsonNode) size() int {
+	if node.encodedSize == 0 {
+		node.encodedSize = jsonbSize(len(node.Text) + node.payloadSize())
+	}
+	return node.encodedSize
+}
+
+// payloadSize is the number of bytes of the children of an array or object in JSONB
+func (node *jsonNode) payloadSize() int {
+	payload := 0
+	for i, child := range node.Children {
+		payload += child.size()
+		if node.Type == "object" {
+			payload += jsonbSize(len(node.Labels[i]))
+		}
+	}
+	return payload
+}
+
+// jsonbSize is the size of a JSONB element with its header
+func jsonbSize(payload int) int {
+	switch {
+	case payload <= 11:
+		return payload + 1
+	case payload <= 0xff:
+		return payload + 2
+	case payload <= 0xffff:
+		return payload + 3
+	}
+	return payload + 5
+}
+
+// childOffsets finds the offset in JSONB of each child of an array or object at the offset. The offsets
+// of the members of an object are the ones of their labels.
+func (node *jsonNode) childOffsets(offset int) []int {
+	// the children follow the header of the node
+	offset += node.size() - node.payloadSize()
+	offsets := make([]int, len(node.Children))
+	for i, child := range node.Children {
+		offsets[i] = offset
+		if node.Type == "object" {
+			offset += jsonbSize(len(node.Labels[i]))
+		}
+		offset += child.size()
+	}
+	return offsets
+}
+
+// jsonMatch is the element found by a path, with its offset in JSONB and the position on the path where
+// its last step starts. The id of a member of an object is the offset of its label.
+type jsonMatch struct {
+	Node     *jsonNode
+	Offset   int
+	ID       int
+	LastStep int
+}
+
+// lookup finds the element of a path like "$.a[2].b", where "[#-1]" is the last element of an array and a
+// label may be written between double quotes. Like SQLite, the path is only read up to an element that
+// is missing, giving a nil node, and an error is returned if it's not valid up to there.
+func (node *jsonNode) lookup(path string) (jsonMatch, error) {
+	errBadPath := fmt.Errorf("bad JSON path: %s", quoteLiteral(path))
+	if !strings.HasPrefix(path, "$") {
+		return jsonMatch{}, errBadPath
+	}
+	match := jsonMatch{Node: node, LastStep: len(path)}
+	for i := 1; i < len(path); {
+		start := i
+		var child int
+		switch path[i] {
+		case '.':
+			var label string
+			if i++; i < len(path) && path[i] == '"' {
+				end := strings.IndexByte(path[i+1:], '"')
+				if end < 0 {
+					return jsonMatch{}, errBadPath
+				}
+				label, i = path[i+1:i+1+end], i+end+2
+			} else {
+				end := i
+				for end < len(path) && path[end] != '.' && path[end] != '[' {
+					end++
+				}
+				if end == i {
+					return jsonMatch{}, errBadPath
+				}
+				label, i = path[i:end], end
+			}
+			if match.Node.Type != "object" {
+				return jsonMatch{}, nil
+			}
+			child = slices.IndexFunc(match.Node.Labels, func(text string) bool { return unescapeJSON(text) == label })
+			if child < 0 {
+				return jsonMatch{}, nil
+			}
+		case '[':
+			if match.Node.Type != "array" {
+				return jsonMatch{}, nil
+			}
+			end := i + 1
+			for end < len(path) && isDigit(path[end]) {
+				end++
+			}
+			if end > i+1 && end < len(path) && path[end] == ']' {
+				child, _ = strconv.Atoi(path[i+1 : end])
+			} else if i+1 < len(path) && path[i+1] == '#' {
+				child, end = len(match.Node.Children), i+2
+				if end+1 < len(path) && path[end] == '-' && isDigit(path[end+1]) {
+					for end++; end < len(path) && isDigit(path[end]); end++ {
+					}
+					count, _ := strconv.Atoi(path[i+3 : end])
+					child -= count
+				}
+				if end == len(path) || path[end] != ']' {
+					return jsonMatch{}, errBadPath
+				}
+			} else {
+				return jsonMatch{}, errBadPath
+			}
+			i = end + 1
+			if child < 0 || child >= len(match.Node.Children) {
+				return jsonMatch{}, nil
+			}
+		default:
+			return jsonMatch{}, errBadPath
+		}
+		id := match.Node.childOffsets(match.Offset)[child]
+		offset := id
+		if match.Node.Type == "object" {
+			// the value of a member follows its label
+			offset += jsonbSize(len(match.Node.Labels[child]))
+		}
+		match = jsonMatch{Node: match.Node.Children[child], Offset: offset, ID: id, LastStep: start}
+	}
+	return match, nil
+}
+
+// jsonFunction checks a JSON text and writes it without spaces
+func jsonFunction(args []any) (*jsonNode, error) {
+	return jsonArgument(args[0])
+}
+
+// jsonValidFunction tells if a text is valid JSON. The flags may ask for JSON text with 1 or 2, or for the
+// JSONB format with 4 or 8, which is never valid here as blobs are not read as JSONB.
+func jsonValidFunction(args []any) (any, error) {
+	flags := int64(1)
+	if len(args) == 2 {
+		if args[1] == nil {
+			return nil, nil
+		}
+		if flags = integerValue(args[1]); flags < 1 || flags > 15 {
+			return nil, errors.New("FLAGS parameter to json_valid() must be between 1 and 15")
+		}
+	}
+	switch args[0].(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return int64(0), nil
+	}
+	_, err := jsonArgument(args[0])
+	return boolValue(err == nil && flags&3 != 0), nil
+}
+
+// jsonArrayFunction makes an array of its arguments
+func jsonArrayFunction(args []any) (*jsonNode, error) {
+	node := &jsonNode{Type: "array", Children: make([]*jsonNode, len(args))}
+	for i, arg := range args {
+		var err error
+		if node.Children[i], err = jsonFromValue(arg); err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+// jsonObjectFunction makes an object of its arguments, which are pairs of a label and a value
+func jsonObjectFunction(args []any) (*jsonNode, error) {
+	if len(args)%2 != 0 {
+		return nil, errors.New("json_object() requires an even number of arguments")
+	}
+	node := &jsonNode{Type: "object"}
+	for i := 0; i < len(args); i += 2 {
+		label, isText := args[i].(string)
+		if !isText {
+			return nil, errors.New("json_object() labels must be TEXT")
+		}
+		value, err := jsonFromValue(args[i+1])
+		if err != nil {
+			return nil, err
+		}
+		node.Labels = append(node.Labels, escapeJSON(label))
+		node.Children = append(node.Children, value)
+	}
+	return node, nil
+}
+
+// jsonExtractNode finds the element of a path, or makes an array of the elements of many paths, where
+// the missing ones are null
+func jsonExtractNode(args []any) (*jsonNode, error) {
+	root, err := jsonArgument(args[0])
+	if err != nil || root == nil || len(args) == 1 {
+		return nil, err
+	}
+	array := &jsonNode{Type: "array"}
+	for _, path := range args[1:] {
+		if path == nil {
+			return nil, nil
+		}
+		match, err := root.lookup(textValue(path))
+		if err != nil {
+			return nil, err
+		}
+		if len(args) == 2 {
+			return match.Node, nil
+		}
+		if match.Node == nil {
+			match.Node = &jsonNode{Type: "null"}
+		}
+		array.Children = append(array.Children, match.Node)
+	}
+	return array, nil
+}
+
+// jsonExtractFunction is json_extract, which gives the SQL value of the element of a single path
+func jsonExtractFunction(args []any) (any, error) {
+	node, err := jsonExtractNode(args)
+	if err != nil || node == nil {
+		return nil, err
+	} else if len(args) > 2 {
+		return node.String(), nil
+	}
+	return node.sqlValue(), nil
+}
+
+// jsonText gives the text of the JSON returned by a function
+func jsonText(function func(args []any) (*jsonNode, error)) func(args []any) (any, error) {
+	return func(args []any) (any, error) {
+		node, err := function(args)
+		if err != nil || node == nil {
+			return nil, err
+		}
+		return node.String(), nil
+	}
+}
+
+// jsonPathFunction applies a function to the element found by the optional path, giving NULL when the
+// JSON or the path is NULL, or when the element is missing
+func jsonPathFunction(function func(node *jsonNode) any) func(args []any) (any, error) {
+	return func(args []any) (any, error) {
+		root, err := jsonArgument(args[0])
+		if err != nil || root == nil {
+			return nil, err
+		}
+		if len(args) == 2 {
+			if args[1] == nil {
+				return nil, nil
+			}
+			match, err := root.lookup(textValue(args[1]))
+			if err != nil || match.Node == nil {
+				return nil, err
+			}
+			root = match.Node
+		}
+		return function(root), nil
+	}
+}
+
+// jsonArrayLength is the number of elements of an array, or 0 for other elements
+func jsonArrayLength(node *jsonNode) any {
+	if node.Type != "array" {
+		return int64(0)
+	}
+	return int64(len(node.Children))
+}
+
+// arrowPath converts the right side of the -> and ->> operators to a path. Besides a path, it may be a
+// label for "$.label" or a number for "$[number]", where a negative number counts from the end.
+func arrowPath(value any) string {
+	if integer, isInteger := value.(int64); isInteger {
+		if integer < 0 {
+			return fmt.Sprintf("$[#%d]", integer)
+		}
+		return fmt.Sprintf("$[%d]", integer)
+	}
+	path := textValue(value)
+	isDigits := func(text string) bool {
+		return text != "" && strings.Trim(text, "0123456789") == ""
+	}
+	switch {
+	case strings.HasPrefix(path, "$"):
+		return path
+	case isDigits(path):
+		return "$[" + path + "]"
+	case strings.HasPrefix(path, "-") && isDigits(path[1:]):
+		return "$[#" + path + "]"
+	case isAlphanumeric(path):
+		return "$." + path
+	case len(path) >= 3 && path[0] == '[' && path[len(path)-1] == ']':
+		return "$" + path
+	}
+	return `$."` + path + `"`
+}
+
+// isAlphanumeric tells if a text only has ASCII letters and digits
+func isAlphanumeric(text string) bool {
+	for i := 0; i < len(text); i++ {
+		if !isDigit(text[i]) && (text[i]|0x20 < 'a' || text[i]|0x20 > 'z') {
+			return false
+		}
+	}
+	return text != ""
+}
+
+// evalArrow computes the -> operator, which gives the JSON of the element found by the path on its right
+// side, or NULL if it's missing
+func evalArrow(expr *Expr, row []any) (*jsonNode, error) {
+	root, err := evalJSON(expr.Args[0], row)
+	if err != nil {
+		return nil, err
+	}
+	path, err := evalExpr(expr.Args[1], row)
+	if err != nil || root == nil || path == nil {
+		return nil, err
+	}
+	return jsonExtractNode([]any{root, arrowPath(path)})
+}
+
+// evalJSON evaluates an argument of a JSON function. The functions and operators that return JSON give
+// their node, so that their result is not taken as a text.
+func evalJSON(expr *Expr, row []any) (any, error) {
+	var node *jsonNode
+	var err error
+	switch {
+	case expr.Op == "function" && jsonFunctions[expr.Name] != nil:
+		if err = checkFunction(expr); err != nil {
+			return nil, err
+		}
+		args := make([]any, len(expr.Args))
+		for i, arg := range expr.Args {
+			if args[i], err = evalJSON(arg, row); err != nil {
+				return nil, err
+			}
+		}
+		node, err = jsonFunctions[expr.Name](args)
+	case expr.Op == "->":
+		node, err = evalArrow(expr, row)
+	case expr.Op == "aggregate" && strings.HasPrefix(expr.Name, "JSON_GROUP_"):
+		node, err = parseJSON(row[expr.Column].(string))
+	default:
+		return evalExpr(expr, row)
+	}
+	if err != nil || node == nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+// jsonEachFunction gives the rows of json_each, one for each element of the array or object found by the
+// path, or a single one for other elements. The rows of json_tree also have the element itself and all
+// the elements inside it.
+func jsonEachFunction(recursive bool) func(args []any, visit func(record TableRecord) bool) error {
+	return func(args []any, visit func(record TableRecord) bool) error {
+		root, err := jsonArgument(args[0])
+		if err != nil || root == nil {
+			return err
+		}
+		path := "$"
+		if len(args) == 2 {
+			if args[1] == nil {
+				return nil
+			}
+			path = textValue(args[1])
+		}
+		match, err := root.lookup(path)
+		if err != nil || match.Node == nil {
+			return err
+		}
+		input := args[0]
+		if node, isNode := input.(*jsonNode); isNode {
+			input = node.String()
+		}
+
+		rowid := int64(0)
+		emit := func(key any, node *jsonNode, id int, parent any, fullKey, parentPath string) bool {
+			value := node.sqlValue()
+			var atom any
+			if node.Type != "array" && node.Type != "object" {
+				atom = value
+			}
+			record := TableRecord{Rowid: rowid, Columns: []any{key, value, node.Type, atom, int64(id), parent, fullKey, parentPath, input, path}}
+			rowid++
+			return visit(record)
+		}
+		var walk func(node *jsonNode, id int, offset int, fullKey string) bool
+		walk = func(node *jsonNode, id int, offset int, fullKey string) bool {
+			var parent any
+			if recursive {
+				parent = int64(id)
+			}
+			for i, offset := range node.childOffsets(offset) {
+				child, childKey := node.Children[i], fullKey
+				var key any
+				if node.Type == "array" {
+					key = int64(i)
+					childKey += fmt.Sprintf("[%d]", i)
+				} else {
+					label := node.Labels[i]
+					key = unescapeJSON(label)
+					if !isAlphanumeric(label) || isDigit(label[0]) {
+						childKey += `."` + label + `"`
+					} else {
+						childKey += "." + label
+					}
+				}
+				valueOffset := offset
+				if node.Type == "object" {
+					valueOffset += jsonbSize(len(node.Labels[i]))
+				}
+				if !emit(key, child, offset, parent, childKey, fullKey) {
+					return false
+				}
+				if recursive && (child.Type == "array" || child.Type == "object") && !walk(child, offset, valueOffset, childKey) {
+					return false
+				}
+			}
+			return true
+		}
+
+		if !recursive && (match.Node.Type == "array" || match.Node.Type == "object") {
+			walk(match.Node, match.Offset, match.Offset, path)
+			return nil
+		}
+		// the first row is the element of the path, whose key comes from the last step of the path
+		var key any
+		if step := path[match.LastStep:]; strings.HasPrefix(step, "[") {
+			index, _ := strconv.ParseInt(strings.Trim(step, "[]"), 10, 64)
+			key = index
+		} else if step != "" {
+			key = strings.Trim(step[1:], `"`)
+		}
+		parentPath := path[:max(match.LastStep, 1)]
+		if emit(key, match.Node, match.ID, nil, path, parentPath) && recursive && (match.Node.Type == "array" || match.Node.Type == "object") {
+			walk(match.Node, match.ID, match.Offset, path)
+		}
+		return nil
+	}
+}
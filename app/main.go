@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -34,7 +35,11 @@ func main() {
 }
 
 func execute(db *DbContext, command string) error {
-	switch command {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		args = []string{""}
+	}
+	switch args[0] {
 	case ".dbinfo":
 		db.PrintDbInfo(os.Stdout)
 	case ".tables":
@@ -43,7 +48,51 @@ func execute(db *DbContext, command string) error {
 		db.PrintIndexes(os.Stdout)
 	case ".schema":
 		db.PrintSchema(os.Stdout)
+	case ".dump":
+		if len(args) > 2 {
+			return fmt.Errorf("usage: .dump [table-pattern]")
+		}
+		pattern := ""
+		if len(args) == 2 {
+			pattern = args[1]
+		}
+		db.Dump(os.Stdout, pattern)
+	case ".recover":
+		db.Recover(os.Stdout)
+	case ".page":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: .page N")
+		}
+		pageNumber, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid page number: %s", args[1])
+		}
+		return db.PrintPage(os.Stdout, pageNumber)
+	case ".btree":
+		if len(args) == 2 {
+			return db.PrintBtree(os.Stdout, args[1], false)
+		} else if len(args) == 3 && args[2] == "--dot" {
+			return db.PrintBtree(os.Stdout, args[1], true)
+		}
+		return fmt.Errorf("usage: .btree NAME [--dot]")
+	case ".analyze":
+		if len(args) == 1 {
+			db.PrintSpaceUsage(os.Stdout)
+		} else if len(args) == 2 && args[1] == "--sql" {
+			db.PrintSpaceUsageSQL(os.Stdout)
+		} else {
+			return fmt.Errorf("usage: .analyze [--sql]")
+		}
+	case ".parameter":
+		return parameterCommand(db, command, args)
 	default:
+		// views are only added to the schema known by the connection, as the database is not written
+		switch strings.ToUpper(args[0]) {
+		case "CREATE":
+			return db.CreateView(command)
+		case "DROP":
+			return db.DropView(command)
+		}
 		if strings.Contains(strings.ToUpper(command), "SELECT") {
 			err := db.HandleSelect(command, os.Stdout)
 			if err != nil {
@@ -71,3 +120,22 @@ func repl(db *DbContext) {
 		fmt.Print("> ")
 	}
 }
+
+// parameterCommand runs the subcommands of .parameter, where the value given to set is the rest of the line
+func parameterCommand(db *DbContext, command string, args []string) error {
+	switch {
+	case len(args) >= 4 && args[1] == "set":
+		value := strings.TrimSpace(command[strings.Index(command, args[2])+len(args[2]):])
+		return db.SetParameter(args[2], value)
+	case len(args) == 3 && args[1] == "unset":
+		db.UnsetParameter(args[2])
+	case len(args) == 2 && args[1] == "list":
+		db.PrintParameters(os.Stdout)
+	case len(args) == 2 && args[1] == "clear":
+		db.UnsetParameter("")
+	case len(args) == 2 && args[1] == "init":
+	default:
+		return fmt.Errorf("usage: .parameter clear|init|list|set NAME VALUE|unset NAME")
+	}
+	return nil
+}
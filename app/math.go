@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"math"
+	"strconv"
+)
+
+// isNumber tells whether a value is an integer or a real once a text that has only a number is converted
+func isNumber(value any) bool {
+	switch numericAffinity(value).(type) {
+	case int64, float64:
+		return true
+	}
+	return false
+}
+
+// realResult is a real result of a function, where NaN is NULL like in SQLite
+func realResult(value float64) any {
+	if math.IsNaN(value) {
+		return nil
+	}
+	return value
+}
+
+// absFunction is the absolute value of an integer, or of a real for any other value
+func absFunction(args []any) (any, error) {
+	switch v := args[0].(type) {
+	case nil:
+		return nil, nil
+	case int64:
+		if v == math.MinInt64 {
+			return nil, errors.New("integer overflow")
+		}
+		return max(v, -v), nil
+	}
+	return math.Abs(realValue(args[0])), nil
+}
+
+// roundFunction rounds a number to a number of decimal digits, half away from zero, and always gives a real
+func roundFunction(args []any) (any, error) {
+	digits := int64(0)
+	if len(args) == 2 {
+		if args[1] == nil {
+			return nil, nil
+		}
+		digits = min(max(integerValue(args[1]), 0), 30)
+	}
+	if args[0] == nil {
+		return nil, nil
+	}
+	value := realValue(args[0])
+	// reals this large have no decimal digits
+	if value < -4503599627370496 || value > 4503599627370496 {
+		return value, nil
+	}
+	if digits == 0 {
+		return float64(int64(value + math.Copysign(0.5, value))), nil
+	}
+	text, _ := printfFunction([]any{"%!.*f", digits, value})
+	rounded, _ := strconv.ParseFloat(text.(string), 64)
+	return rounded, nil
+}
+
+// extremumFunction gives the smallest argument for -1, or the largest one for 1, or NULL when one of
+// them is NULL
+func extremumFunction(direction int) func(args []any) (any, error) {
+	return func(args []any) (any, error) {
+		result := args[0]
+		for _, arg := range args {
+			if arg == nil {
+				return nil, nil
+			}
+			if compareValues(arg, result)*direction > 0 {
+				result = arg
+			}
+		}
+		return result, nil
+	}
+}
+
+// signFunction is -1, 0 or 1 for a negative, zero or positive number, and NULL for other values
+func signFunction(args []any) (any, error) {
+	if !isNumber(args[0]) {
+		return nil, nil
+	}
+	value := realValue(args[0])
+	switch {
+	case value < 0:
+		return int64(-1), nil
+	case value > 0:
+		return int64(1), nil
+	}
+	return int64(0), nil
+}
+
+// randomFunction is a random integer
+func randomFunction(args []any) (any, error) {
+	var buf [8]byte
+	rand.Read(buf[:])
+	return int64(binary.BigEndian.Uint64(buf[:])), nil
+}
+
+// randomblobFunction is a blob of random bytes, with at least one byte
+func randomblobFunction(args []any) (any, error) {
+	length := integerValue(args[0])
+	if length > maxLength {
+		return nil, errTooBig
+	}
+	blob := make([]byte, max(length, 1))
+	rand.Read(blob)
+	return blob, nil
+}
+
+// roundingFunction rounds a real to an integral real, keeps integers and gives NULL for other values
+func roundingFunction(round func(float64) float64) func(args []any) (any, error) {
+	return func(args []any) (any, error) {
+		switch v := numericAffinity(args[0]).(type) {
+		case int64:
+			return v, nil
+		case float64:
+			return round(v), nil
+		}
+		return nil, nil
+	}
+}
+
+// realFunction applies a function of a real to a number, and gives NULL for other values
+func realFunction(function func(float64) float64) func(args []any) (any, error) {
+	return func(args []any) (any, error) {
+		if !isNumber(args[0]) {
+			return nil, nil
+		}
+		return realResult(function(realValue(args[0]))), nil
+	}
+}
+
+// realFunction2 applies a function of two reals to two numbers, and gives NULL for other values
+func realFunction2(function func(float64, float64) float64) func(args []any) (any, error) {
+	return func(args []any) (any, error) {
+		if !isNumber(args[0]) || !isNumber(args[1]) {
+			return nil, nil
+		}
+		return realResult(function(realValue(args[0]), realValue(args[1]))), nil
+	}
+}
+
+// logFunction is a logarithm of a positive number. With two arguments, the first one is the base,
+// which must be larger than 1.
+func logFunction(logarithm func(float64) float64) func(args []any) (any, error) {
+	return func(args []any) (any, error) {
+		for _, arg := range args {
+			if !isNumber(arg) || realValue(arg) <= 0 {
+				return nil, nil
+			}
+		}
+		if len(args) == 1 {
+			return realResult(logarithm(realValue(args[0]))), nil
+		}
+		base := math.Log(realValue(args[0]))
+		if base <= 0 {
+			return nil, nil
+		}
+		return realResult(math.Log(realValue(args[1])) / base), nil
+	}
+}
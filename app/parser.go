@@ -1,12 +1,15 @@
 package main
 
 import (
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"slices"
 	"strconv"
 	"strings"
 )
 
-func parseCreateTable(sql string) (tableName string, columns []ColumnDef, constraints []string, err error) {
+func parseCreateTable(sql string) (tableName string, columns []ColumnDef, constraints []string, primaryKey []ColumnDef, err error) {
 	t := NewTokenizer(sql)
 	if t.AtEnd() {
 		return
@@ -62,14 +65,19 @@ func parseCreateTable(sql string) (tableName string, columns []ColumnDef, constr
 		if t.Match("PRIMARY") || t.Match("UNIQUE") || t.Match("CHECK") || t.Match("FOREIGN") {
 			// TODO: parse syntax for each constraint type
 			constraint = append(constraint, t.Previous())
-			for !t.AtEnd() {
-				token := t.Peek()
-				if token == "," || token == ")" {
-					break
+			if strings.EqualFold(t.Previous(), "PRIMARY") {
+				start := t.Current
+				err = t.MustMatch("KEY")
+				if err != nil {
+					return
 				}
-				constraint = append(constraints, token)
-				t.Advance()
+				primaryKey, err = parseIndexedColumns(t)
+				if err != nil {
+					return
+				}
+				t.Current = start
 			}
+			constraint = append(constraint, readConstraintTokens(t)...)
 			constraints = append(constraints, strings.Join(constraint, " "))
 		} else if len(constraint) > 0 {
 			err = fmt.Errorf("invalid constraint: %s", t.Peek())
@@ -86,18 +94,35 @@ func parseCreateTable(sql string) (tableName string, columns []ColumnDef, constr
 				if token == "," || token == ")" {
 					break
 				}
-				if t.Match("PRIMARY") || t.Match("CONSTRAINT") || t.Match("UNIQUE") || t.Match("CHECK") || t.Match("REFERENCES") || t.Match("NOT") || t.Match("NULL") || t.Match("DEFAULT") || t.Match("COLLATE") || t.Match("GENERATED") {
-					constraint = append(constraints, t.Previous())
+				if t.Match("DEFAULT") {
+					start := t.Current
+					column.Default, err = parseDefault(t)
+					if err != nil {
+						return
+					}
+					constraint = append([]string{"DEFAULT"}, t.Tokens[start:t.Current]...)
+					column.Constraints = append(column.Constraints, strings.Join(constraint, " "))
+				} else if t.Match("GENERATED") || t.Match("AS") {
+					start := t.Current - 1
+					if err = parseGenerated(t, &column); err != nil {
+						return
+					}
+					column.Constraints = append(column.Constraints, strings.Join(t.Tokens[start:t.Current], " "))
+				} else if t.Match("PRIMARY") || t.Match("CONSTRAINT") || t.Match("UNIQUE") || t.Match("CHECK") || t.Match("REFERENCES") || t.Match("NOT") || t.Match("NULL") || t.Match("COLLATE") {
 					// TODO: parse syntax for each constraint type
-					for !t.AtEnd() {
-						token := t.Peek()
-						if token == "," || token == ")" {
-							break
-						}
-						constraint = append(constraint, token)
+					constraint = append([]string{t.Previous()}, readConstraintTokens(t, "DEFAULT", "GENERATED", "AS")...)
+					column.Constraints = append(column.Constraints, strings.Join(constraint, " "))
+				} else if t.Match("(") {
+					// size of the type, like VARCHAR(20) or DECIMAL(10, 2)
+					size := "("
+					for !t.AtEnd() && !t.Match(")") {
+						size += t.Peek()
 						t.Advance()
 					}
-					column.Constraints = append(column.Constraints, strings.Join(constraint, " "))
+					if len(typeTokens) == 0 {
+						typeTokens = append(typeTokens, "")
+					}
+					typeTokens[len(typeTokens)-1] += size + ")"
 				} else {
 					var typeToken string
 					typeToken, err = t.MustGetIdentifier()
@@ -122,17 +147,43 @@ func parseCreateTable(sql string) (tableName string, columns []ColumnDef, constr
 		}
 	}
 
-	// if primary key is defined on table level, add it to the proper column
-	// TODO: handle multi-column PKs
-	for i := 0; i < len(constraints); i++ {
-		if strings.ToUpper(constraints[i]) == "PRIMARY" && strings.ToUpper(constraints[i+1]) == "KEY" {
-			columnName := strings.Trim(constraints[i+2], "()")
-			for j := range columns {
-				if columns[j].Name == columnName {
-					columns[j].Constraints = append(columns[j].Constraints, "PRIMARY", "KEY")
+	// table options are kept with the constraints
+	for !t.AtEnd() && !t.Match(";") {
+		if t.Match("WITHOUT") {
+			err = t.MustMatch("ROWID")
+			if err != nil {
+				return
+			}
+			constraints = append(constraints, "WITHOUT ROWID")
+		} else if t.Match("STRICT") {
+			constraints = append(constraints, "STRICT")
+		} else if !t.Match(",") {
+			err = fmt.Errorf("syntax error near %q", t.Peek())
+			return
+		}
+	}
+
+	for _, column := range columns {
+		for _, constraint := range column.Constraints {
+			words := strings.Fields(strings.ToUpper(constraint))
+			for i := 0; i+1 < len(words); i++ {
+				if words[i] == "PRIMARY" && words[i+1] == "KEY" {
+					primaryKeyColumn := ColumnDef{Name: column.Name, Type: "ASC"}
+					if i+2 < len(words) && words[i+2] == "DESC" {
+						primaryKeyColumn.Type = "DESC"
+					}
+					primaryKey = []ColumnDef{primaryKeyColumn}
 				}
 			}
-			i += 2
+		}
+	}
+
+	// if primary key is defined on table level, add it to the proper columns
+	for _, primaryKeyColumn := range primaryKey {
+		for i := range columns {
+			if strings.EqualFold(columns[i].Name, primaryKeyColumn.Name) && !isPrimaryKeyColumn(columns[i]) {
+				columns[i].Constraints = append(columns[i].Constraints, "PRIMARY KEY")
+			}
 		}
 	}
 
@@ -144,7 +195,32 @@ func parseCreateTable(sql string) (tableName string, columns []ColumnDef, constr
 	return
 }
 
-func parseCreateIndex(sql string) (indexName, tableName string, columns []ColumnDef, err error) {
+// readConstraintTokens reads the tokens until the comma or parenthesis that ends a column definition
+// or constraint, including any expression in parentheses found on the way. It also stops before the
+// keywords that start another constraint parsed on its own, unless they follow SET, as in ON DELETE
+// SET DEFAULT.
+func readConstraintTokens(t *Tokenizer, stops ...string) (tokens []string) {
+	depth := 0
+	for !t.AtEnd() {
+		token := t.Peek()
+		if depth == 0 && (token == "," || token == ")") {
+			break
+		} else if depth == 0 && slices.ContainsFunc(stops, func(stop string) bool { return strings.EqualFold(token, stop) }) &&
+			!strings.EqualFold(t.Previous(), "SET") {
+			break
+		} else if token == "(" {
+			depth++
+		} else if token == ")" {
+			depth--
+		}
+		tokens = append(tokens, token)
+		t.Advance()
+	}
+	return
+}
+
+// parseCreateIndex reads the statement of an index, with the WHERE clause of a partial index on where
+func parseCreateIndex(sql string) (indexName, tableName string, columns []ColumnDef, where *Expr, err error) {
 	t := NewTokenizer(sql)
 	if t.AtEnd() {
 		return
@@ -180,6 +256,16 @@ func parseCreateIndex(sql string) (indexName, tableName string, columns []Column
 	if err != nil {
 		return
 	}
+	columns, err = parseIndexedColumns(t)
+	if err == nil && t.Match("WHERE") {
+		where, err = parseExpr(t)
+	}
+	return
+}
+
+// parseIndexedColumns reads the list of columns of an index or of a primary key, with the sort order
+// stored as the column type and the collation as a constraint
+func parseIndexedColumns(t *Tokenizer) (columns []ColumnDef, err error) {
 	err = t.MustMatch("(")
 	if err != nil {
 		return
@@ -216,71 +302,1126 @@ func parseCreateIndex(sql string) (indexName, tableName string, columns []Column
 	return
 }
 
-func parseSelectStatement(sql string) (tableName string, columns []string, filterColumnName string, filterValue any, err error) {
+// parseDefault reads the default value of a column, which is a literal, a signed number or an expression
+// in parentheses. A name is taken as a text.
+func parseDefault(t *Tokenizer) (*Expr, error) {
+	if t.Match("(") {
+		expr, err := parseExpr(t)
+		if err != nil {
+			return nil, err
+		}
+		return expr, t.MustMatch(")")
+	}
+	expr, err := parseUnary(t)
+	if err != nil {
+		return nil, err
+	}
+	if expr.Op == "column" && expr.Table == "" {
+		return &Expr{Op: "literal", Value: expr.Name}, nil
+	}
+	return expr, nil
+}
+
+// parseGenerated reads the expression of a generated column after GENERATED or AS, and whether it is
+// STORED on the records or computed when read, as VIRTUAL columns are
+func parseGenerated(t *Tokenizer, column *ColumnDef) (err error) {
+	if strings.EqualFold(t.Previous(), "GENERATED") {
+		if err = t.MustMatch("ALWAYS"); err != nil {
+			return
+		}
+		if err = t.MustMatch("AS"); err != nil {
+			return
+		}
+	}
+	if err = t.MustMatch("("); err != nil {
+		return
+	}
+	if column.Generated, err = parseExpr(t); err != nil {
+		return
+	}
+	if err = t.MustMatch(")"); err != nil {
+		return
+	}
+	if t.Match("STORED") {
+		column.Stored = true
+	} else {
+		t.Match("VIRTUAL")
+	}
+	return
+}
+
+// maxParameterNumber is the largest number of a parameter, as in SQLite
+const maxParameterNumber = 32766
+
+func parseSelectStatement(sql string) (statement *SelectStatement, err error) {
+	t := NewTokenizer(sql)
+	explain := false
+	if t.Match("EXPLAIN") {
+		err = t.MustMatch("QUERY")
+		if err != nil {
+			return
+		}
+		err = t.MustMatch("PLAN")
+		if err != nil {
+			return
+		}
+		explain = true
+	}
+	statement, err = parseSelect(t)
+	if err != nil {
+		return
+	}
+	t.Match(";")
+	if !t.AtEnd() {
+		return nil, fmt.Errorf("syntax error near %q", t.Peek())
+	}
+	statement.Explain = explain
+	statement.Parameters = t.Parameters
+	return
+}
+
+// parseCreateView reads the name of a view, the names given to its columns if any, its SELECT and whether
+// it is only created when there is no view with the name
+func parseCreateView(sql string) (name string, columns []string, statement *SelectStatement, ifNotExists bool, err error) {
+	t := NewTokenizer(sql)
+	if err = t.MustMatch("CREATE"); err != nil {
+		return
+	}
+	if !t.Match("TEMP") {
+		t.Match("TEMPORARY")
+	}
+	if err = t.MustMatch("VIEW"); err != nil {
+		return
+	}
+	if t.Match("IF") {
+		if err = t.MustMatch("NOT"); err != nil {
+			return
+		}
+		if err = t.MustMatch("EXISTS"); err != nil {
+			return
+		}
+		ifNotExists = true
+	}
+	if name, err = parseQualifiedName(t); err != nil {
+		return
+	}
+	if t.Match("(") {
+		for {
+			var column string
+			if column, err = t.MustGetIdentifier(); err != nil {
+				return
+			}
+			columns = append(columns, column)
+			if !t.Match(",") {
+				break
+			}
+		}
+		if err = t.MustMatch(")"); err != nil {
+			return
+		}
+	}
+	if err = t.MustMatch("AS"); err != nil {
+		return
+	}
+	if statement, err = parseSelect(t); err != nil {
+		return
+	}
+	t.Match(";")
+	if !t.AtEnd() {
+		err = fmt.Errorf("syntax error near %q", t.Peek())
+	} else if len(t.Parameters) > 0 {
+		err = fmt.Errorf("parameters are not allowed in views")
+	}
+	return
+}
+
+// parseDropView reads the name of the view to drop, and whether it is fine when there is no such view
+func parseDropView(sql string) (name string, ifExists bool, err error) {
 	t := NewTokenizer(sql)
+	if err = t.MustMatch("DROP"); err != nil {
+		return
+	}
+	if err = t.MustMatch("VIEW"); err != nil {
+		return
+	}
+	if t.Match("IF") {
+		if err = t.MustMatch("EXISTS"); err != nil {
+			return
+		}
+		ifExists = true
+	}
+	if name, err = parseQualifiedName(t); err != nil {
+		return
+	}
+	t.Match(";")
+	if !t.AtEnd() {
+		err = fmt.Errorf("syntax error near %q", t.Peek())
+	}
+	return
+}
+
+// parseQualifiedName reads a name that may be preceded by the name of its schema, which is ignored as
+// there is only the main database
+func parseQualifiedName(t *Tokenizer) (name string, err error) {
+	if name, err = t.MustGetIdentifier(); err != nil || !t.Match(".") {
+		return
+	}
+	return t.MustGetIdentifier()
+}
+
+// isSelect tells if the next token starts a SELECT, which may start with its WITH clause
+func isSelect(t *Tokenizer) bool {
+	return strings.EqualFold(t.Peek(), "SELECT") || strings.EqualFold(t.Peek(), "WITH")
+}
+
+// compoundOperator reads the operator joining two SELECTs of a compound SELECT, or returns "" when there
+// is none
+func compoundOperator(t *Tokenizer) string {
+	if t.Match("UNION") {
+		if t.Match("ALL") {
+			return "UNION ALL"
+		}
+		return "UNION"
+	}
+	if t.Match("INTERSECT") || t.Match("EXCEPT") {
+		return strings.ToUpper(t.Previous())
+	}
+	return ""
+}
+
+// parseSelect reads a SELECT up to the end of its last clause. A compound SELECT has the SELECTs after
+// the first one on Compound, and its ORDER BY and LIMIT are kept on the first one.
+func parseSelect(t *Tokenizer) (statement *SelectStatement, err error) {
+	var with []*CommonTable
+	if t.Match("WITH") {
+		if with, err = parseWith(t); err != nil {
+			return
+		}
+	}
+	if statement, err = parseSelectCore(t); err != nil {
+		return
+	}
+	statement.With = with
+	for op := compoundOperator(t); op != ""; op = compoundOperator(t) {
+		var next *SelectStatement
+		if next, err = parseSelectCore(t); err != nil {
+			return
+		}
+		statement.Compound = append(statement.Compound, CompoundSelect{Op: op, Select: next})
+	}
+	if err = parseOrderLimit(t, statement); err != nil {
+		return
+	}
+	if start := t.Current; compoundOperator(t) != "" {
+		// the ORDER BY and LIMIT of a compound SELECT are written after its last SELECT
+		clause := "ORDER BY"
+		if statement.OrderBy == nil {
+			clause = "LIMIT"
+		}
+		err = fmt.Errorf("%s clause should come after %s not before", clause, strings.ToUpper(strings.Join(t.Tokens[start:t.Current], " ")))
+	}
+	return
+}
+
+// parseSelectCore reads a SELECT up to the end of its WHERE clause
+func parseSelectCore(t *Tokenizer) (statement *SelectStatement, err error) {
+	statement = &SelectStatement{}
 	err = t.MustMatch("SELECT")
 	if err != nil {
 		return
 	}
+	if statement.Distinct = t.Match("DISTINCT"); !statement.Distinct {
+		t.Match("ALL")
+	}
 	for {
-		if t.Match("COUNT") {
-			err = t.MustMatch("(")
+		column := ResultColumn{}
+		if t.Match("*") {
+			column.Expr = &Expr{Op: "*"}
+		} else if t.Peek() != "" && t.Current+2 < len(t.Tokens) && t.Tokens[t.Current+1] == "." && t.Tokens[t.Current+2] == "*" {
+			column.Expr = &Expr{Op: "*", Table: t.Peek()}
+			t.Current += 3
+		} else {
+			column.Expr, err = parseExpr(t)
+			if err != nil {
+				return
+			}
+			column.Alias, err = parseAlias(t)
+			if err != nil {
+				return
+			}
+		}
+		statement.Columns = append(statement.Columns, column)
+		if !t.Match(",") {
+			break
+		}
+	}
+	if !t.Match("FROM") {
+		// without FROM the columns are computed once
+		return statement, parseWhere(t, statement)
+	}
+	for {
+		table := TableRef{}
+		if len(statement.From) > 0 {
+			switch {
+			case t.Match(","):
+			case t.Match("JOIN"):
+				table.Join = "INNER"
+			case t.Match("INNER") || t.Match("CROSS"):
+				table.Join = strings.ToUpper(t.Previous())
+				err = t.MustMatch("JOIN")
+			case t.Match("LEFT"):
+				table.Join = "LEFT"
+				t.Match("OUTER")
+				err = t.MustMatch("JOIN")
+			default:
+				return statement, parseWhere(t, statement)
+			}
+			if err != nil {
+				return
+			}
+		}
+		if t.Match("(") {
+			// a subquery is named by its alias, or by its position on the FROM clause
+			if table.Select, err = parseSelect(t); err != nil {
+				return
+			}
+			if err = t.MustMatch(")"); err != nil {
+				return
+			}
+			table.Name = fmt.Sprintf("(subquery-%d)", len(statement.From)+1)
+		} else if table.Name, err = parseQualifiedName(t); err != nil {
+			return
+		} else if t.Match("(") {
+			// the arguments of a table-valued function, like json_each(doc)
+			table.Args = []*Expr{}
+			for !t.Match(")") {
+				if len(table.Args) > 0 {
+					if err = t.MustMatch(","); err != nil {
+						return
+					}
+				}
+				var arg *Expr
+				if arg, err = parseExpr(t); err != nil {
+					return
+				}
+				table.Args = append(table.Args, arg)
+			}
+		}
+		table.Alias, err = parseAlias(t)
+		if err != nil {
+			return
+		}
+		if table.Join != "" && t.Match("ON") {
+			table.On, err = parseExpr(t)
 			if err != nil {
 				return
 			}
-			err = t.MustMatch("*")
+		} else if table.Join != "" && t.Match("USING") {
+			err = t.MustMatch("(")
 			if err != nil {
 				return
 			}
+			for {
+				var name string
+				name, err = t.MustGetIdentifier()
+				if err != nil {
+					return
+				}
+				table.Using = append(table.Using, name)
+				if !t.Match(",") {
+					break
+				}
+			}
 			err = t.MustMatch(")")
 			if err != nil {
 				return
 			}
-			columns = append(columns, "COUNT(*)")
+		}
+		statement.From = append(statement.From, table)
+	}
+}
+
+// parseWith reads the tables of a WITH clause. RECURSIVE is optional, as a table is recursive when the
+// last SELECT of its compound SELECT reads the table.
+func parseWith(t *Tokenizer) (tables []*CommonTable, err error) {
+	t.Match("RECURSIVE")
+	for {
+		table := &CommonTable{}
+		if table.Name, err = t.MustGetIdentifier(); err != nil {
+			return
+		}
+		if slices.ContainsFunc(tables, func(previous *CommonTable) bool { return strings.EqualFold(previous.Name, table.Name) }) {
+			return nil, fmt.Errorf("duplicate WITH table name: %s", table.Name)
+		}
+		if t.Match("(") {
+			for {
+				var column string
+				if column, err = t.MustGetIdentifier(); err != nil {
+					return
+				}
+				table.Columns = append(table.Columns, column)
+				if !t.Match(",") {
+					break
+				}
+			}
+			if err = t.MustMatch(")"); err != nil {
+				return
+			}
+		}
+		if err = t.MustMatch("AS"); err != nil {
+			return
+		}
+		if t.Match("NOT") {
+			err = t.MustMatch("MATERIALIZED")
 		} else {
-			var column string
-			column, err = t.MustGetIdentifier()
-			if err != nil {
+			t.Match("MATERIALIZED")
+		}
+		if err != nil {
+			return
+		}
+		if err = t.MustMatch("("); err != nil {
+			return
+		}
+		if table.Select, err = parseSelect(t); err != nil {
+			return
+		}
+		if err = t.MustMatch(")"); err != nil {
+			return
+		}
+		tables = append(tables, table)
+		if !t.Match(",") {
+			return
+		}
+	}
+}
+
+// parseWhere reads the WHERE, GROUP BY and HAVING clauses if any, and then the windows named by the WINDOW
+// clause
+func parseWhere(t *Tokenizer, statement *SelectStatement) (err error) {
+	if t.Match("WHERE") {
+		if statement.Where, err = parseExpr(t); err != nil {
+			return
+		}
+	}
+	if t.Match("GROUP") {
+		if err = t.MustMatch("BY"); err != nil {
+			return
+		}
+		for {
+			var expr *Expr
+			if expr, err = parseExpr(t); err != nil {
 				return
 			}
-			columns = append(columns, column)
+			statement.GroupBy = append(statement.GroupBy, expr)
+			if !t.Match(",") {
+				break
+			}
+		}
+	}
+	if t.Match("HAVING") {
+		if statement.Having, err = parseExpr(t); err != nil {
+			return
+		}
+	}
+	if !t.Match("WINDOW") {
+		return
+	}
+	for {
+		var name string
+		if name, err = t.MustGetIdentifier(); err != nil {
+			return
 		}
+		if err = t.MustMatch("AS"); err != nil {
+			return
+		}
+		if err = t.MustMatch("("); err != nil {
+			return
+		}
+		var window *WindowDef
+		if window, err = parseWindow(t); err != nil {
+			return
+		}
+		window.Name = name
+		statement.Windows = append(statement.Windows, window)
 		if !t.Match(",") {
-			break
+			return
 		}
 	}
-	err = t.MustMatch("FROM")
-	if err != nil {
+}
+
+// parseWindow reads the definition of a window after its opening parenthesis: the name of the window it
+// is based on, PARTITION BY, ORDER BY and the frame, which are all optional
+func parseWindow(t *Tokenizer) (window *WindowDef, err error) {
+	window = &WindowDef{}
+	switch strings.ToUpper(t.Peek()) {
+	case "PARTITION", "ORDER", "ROWS", "RANGE", "GROUPS", ")":
+	default:
+		if window.Base, err = t.MustGetIdentifier(); err != nil {
+			return
+		}
+	}
+	if t.Match("PARTITION") {
+		if err = t.MustMatch("BY"); err != nil {
+			return
+		}
+		for {
+			var expr *Expr
+			if expr, err = parseExpr(t); err != nil {
+				return
+			}
+			window.PartitionBy = append(window.PartitionBy, expr)
+			if !t.Match(",") {
+				break
+			}
+		}
+	}
+	if t.Match("ORDER") {
+		if err = t.MustMatch("BY"); err != nil {
+			return
+		}
+		if window.OrderBy, err = parseOrderTerms(t); err != nil {
+			return
+		}
+	}
+	if t.Match("ROWS") || t.Match("RANGE") || t.Match("GROUPS") {
+		if window.Frame, err = parseFrame(t, strings.ToUpper(t.Previous())); err != nil {
+			return
+		}
+	}
+	return window, t.MustMatch(")")
+}
+
+// parseFrame reads the bounds of a window frame after its unit, and then the EXCLUDE clause. A frame with a
+// single bound ends at the current row. Like SQLite, a frame can't start after the rows where it ends.
+func parseFrame(t *Tokenizer, unit string) (frame *WindowFrame, err error) {
+	frame = &WindowFrame{Unit: unit, End: FrameBound{Kind: "CURRENT ROW"}}
+	if t.Match("BETWEEN") {
+		if frame.Start, err = parseFrameBound(t, "PRECEDING"); err != nil {
+			return
+		}
+		if err = t.MustMatch("AND"); err != nil {
+			return
+		}
+		if frame.End, err = parseFrameBound(t, "FOLLOWING"); err != nil {
+			return
+		}
+	} else if frame.Start, err = parseFrameBound(t, "PRECEDING"); err != nil {
 		return
 	}
-	tableName, err = t.MustGetIdentifier()
-	if err != nil {
+	start, end := frame.Start.Kind, frame.End.Kind
+	if start == "CURRENT ROW" && end == "PRECEDING" || start == "FOLLOWING" && (end == "PRECEDING" || end == "CURRENT ROW") {
+		return nil, fmt.Errorf("unsupported frame specification")
+	}
+	if t.Match("EXCLUDE") {
+		switch {
+		case t.Match("NO"):
+			err = t.MustMatch("OTHERS")
+		case t.Match("CURRENT"):
+			frame.Exclude, err = "CURRENT ROW", t.MustMatch("ROW")
+		case t.Match("GROUP") || t.Match("TIES"):
+			frame.Exclude = strings.ToUpper(t.Previous())
+		default:
+			err = fmt.Errorf("syntax error near %q", t.Peek())
+		}
+	}
+	return
+}
+
+// parseFrameBound reads a bound of a window frame, where UNBOUNDED is only followed by PRECEDING for the
+// start of the frame and by FOLLOWING for its end
+func parseFrameBound(t *Tokenizer, unbounded string) (bound FrameBound, err error) {
+	if t.Match("UNBOUNDED") {
+		bound.Kind = "UNBOUNDED " + unbounded
+		return bound, t.MustMatch(unbounded)
+	}
+	if t.Match("CURRENT") {
+		bound.Kind = "CURRENT ROW"
+		return bound, t.MustMatch("ROW")
+	}
+	if bound.Offset, err = parseExpr(t); err != nil {
 		return
 	}
-	if t.Match("WHERE") {
-		filterColumnName, err = t.MustGetIdentifier()
+	if !t.Match("PRECEDING") && !t.Match("FOLLOWING") {
+		return bound, fmt.Errorf("syntax error near %q", t.Peek())
+	}
+	bound.Kind = strings.ToUpper(t.Previous())
+	return
+}
+
+// parseOrderLimit reads the ORDER BY and LIMIT clauses if any
+func parseOrderLimit(t *Tokenizer, statement *SelectStatement) (err error) {
+	if t.Match("ORDER") {
+		err = t.MustMatch("BY")
+		if err != nil {
+			return
+		}
+		statement.OrderBy, err = parseOrderTerms(t)
+		if err != nil {
+			return
+		}
+	}
+	if t.Match("LIMIT") {
+		statement.Limit, err = parseExpr(t)
+		if err != nil {
+			return
+		}
+		if t.Match("OFFSET") {
+			statement.Offset, err = parseExpr(t)
+		} else if t.Match(",") {
+			// "LIMIT offset, limit" has them the other way around
+			statement.Offset = statement.Limit
+			statement.Limit, err = parseExpr(t)
+		}
 		if err != nil {
 			return
 		}
-		t.MustMatch("=")
-		var value string
-		value, err = t.MustGetIdentifier()
+	}
+	return
+}
+
+// parseOrderTerms reads the terms of an ORDER BY clause after ORDER BY
+func parseOrderTerms(t *Tokenizer) (terms []OrderTerm, err error) {
+	for {
+		term := OrderTerm{}
+		term.Expr, err = parseExpr(t)
 		if err != nil {
 			return
 		}
-		if value[0] == '\'' {
-			filterValue = strings.Trim(value, "'")
+		if t.Match("DESC") {
+			term.Descending = true
 		} else {
-			filterValue, err = strconv.ParseInt(value, 10, 64)
+			t.Match("ASC")
+		}
+		terms = append(terms, term)
+		if !t.Match(",") {
+			return
+		}
+	}
+}
+
+// parseAlias reads the optional name given to a column or table, with or without AS before it
+func parseAlias(t *Tokenizer) (string, error) {
+	if t.Match("AS") {
+		return t.MustGetIdentifier()
+	}
+	token := t.Peek()
+	if token == "" || strings.ContainsAny(token[:1], ",;()=<>!*.'") || isKeyword(token) {
+		return "", nil
+	}
+	t.Advance()
+	return token, nil
+}
+
+// isKeyword tells if the word has a meaning on a SELECT statement, so it can't be used as an alias
+func isKeyword(token string) bool {
+	switch strings.ToUpper(token) {
+	case "FROM", "WHERE", "JOIN", "INNER", "CROSS", "LEFT", "OUTER", "ON", "USING", "GROUP", "HAVING", "ORDER",
+		"LIMIT", "OFFSET", "UNION", "INTERSECT", "EXCEPT", "AND", "OR", "NOT", "IS", "IN", "LIKE", "BETWEEN",
+		"ISNULL", "NOTNULL", "NATURAL", "WINDOW", "GLOB", "REGEXP", "ESCAPE", "WHEN", "THEN", "ELSE", "END":
+		return true
+	}
+	return false
+}
+
+// parseExpr reads an expression, from the lowest to the highest precedence operators: OR, AND, NOT,
+// equality (including IS, BETWEEN, IN, LIKE, GLOB, REGEXP and IS NULL), < <= > >=, & | << >>, + -,
+// * / %, || and then the unary operators - + ~ on single operands
+func parseExpr(t *Tokenizer) (*Expr, error) {
+	left, err := parseAnd(t)
+	if err != nil {
+		return nil, err
+	}
+	for t.Match("OR") {
+		right, err := parseAnd(t)
+		if err != nil {
+			return nil, err
+		}
+		left = &Expr{Op: "OR", Args: []*Expr{left, right}}
+	}
+	return left, nil
+}
+
+func parseAnd(t *Tokenizer) (*Expr, error) {
+	left, err := parseNot(t)
+	if err != nil {
+		return nil, err
+	}
+	for t.Match("AND") {
+		right, err := parseNot(t)
+		if err != nil {
+			return nil, err
+		}
+		left = &Expr{Op: "AND", Args: []*Expr{left, right}}
+	}
+	return left, nil
+}
+
+func parseNot(t *Tokenizer) (*Expr, error) {
+	if t.Match("NOT") {
+		operand, err := parseNot(t)
+		if err != nil {
+			return nil, err
+		}
+		return &Expr{Op: "NOT", Args: []*Expr{operand}}, nil
+	}
+	return parseComparison(t)
+}
+
+func parseComparison(t *Tokenizer) (*Expr, error) {
+	left, err := parseRelational(t)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case t.Match("=") || t.Match("==") || t.Match("!=") || t.Match("<>"):
+			op := t.Previous()
+			switch op {
+			case "==":
+				op = "="
+			case "!=":
+				op = "<>"
+			}
+			right, err := parseRelational(t)
 			if err != nil {
-				if _, isNumError := err.(*strconv.NumError); isNumError {
-					filterValue, err = strconv.ParseFloat(value, 64)
+				return nil, err
+			}
+			left = &Expr{Op: op, Args: []*Expr{left, right}}
+		case t.Match("ISNULL"):
+			left = &Expr{Op: "ISNULL", Args: []*Expr{left}}
+		case t.Match("NOTNULL"):
+			left = &Expr{Op: "ISNULL", Not: true, Args: []*Expr{left}}
+		case t.Match("IS"):
+			not := t.Match("NOT")
+			if t.Match("NULL") {
+				left = &Expr{Op: "ISNULL", Not: not, Args: []*Expr{left}}
+				continue
+			}
+			// IS DISTINCT FROM is IS NOT, and IS NOT DISTINCT FROM is IS
+			if t.Match("DISTINCT") {
+				if err := t.MustMatch("FROM"); err != nil {
+					return nil, err
 				}
+				not = !not
+			}
+			right, err := parseRelational(t)
+			if err != nil {
+				return nil, err
+			}
+			left = &Expr{Op: "IS", Not: not, Args: []*Expr{left, right}}
+		default:
+			// the other operators may be negated with a NOT before them
+			start := t.Current
+			not := t.Match("NOT")
+			switch {
+			case not && t.Match("NULL"):
+				left = &Expr{Op: "ISNULL", Not: true, Args: []*Expr{left}}
+			case t.Match("BETWEEN"):
+				low, err := parseRelational(t)
 				if err != nil {
-					return
+					return nil, err
+				}
+				if err := t.MustMatch("AND"); err != nil {
+					return nil, err
+				}
+				high, err := parseRelational(t)
+				if err != nil {
+					return nil, err
+				}
+				left = &Expr{Op: "BETWEEN", Not: not, Args: []*Expr{left, low, high}}
+			case t.Match("IN"):
+				if err := t.MustMatch("("); err != nil {
+					return nil, err
+				}
+				if isSelect(t) {
+					statement, err := parseSelect(t)
+					if err != nil {
+						return nil, err
+					}
+					left = &Expr{Op: "IN", Not: not, Args: []*Expr{left}, Select: statement}
+					if err := t.MustMatch(")"); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				args := []*Expr{left}
+				for !t.Match(")") {
+					if len(args) > 1 {
+						if err := t.MustMatch(","); err != nil {
+							return nil, err
+						}
+					}
+					item, err := parseExpr(t)
+					if err != nil {
+						return nil, err
+					}
+					args = append(args, item)
+				}
+				left = &Expr{Op: "IN", Not: not, Args: args}
+			case t.Match("LIKE") || t.Match("GLOB") || t.Match("REGEXP"):
+				op := strings.ToUpper(t.Previous())
+				pattern, err := parseRelational(t)
+				if err != nil {
+					return nil, err
 				}
+				left = &Expr{Op: op, Not: not, Args: []*Expr{left, pattern}}
+				if op == "LIKE" && t.Match("ESCAPE") {
+					escape, err := parseRelational(t)
+					if err != nil {
+						return nil, err
+					}
+					left.Args = append(left.Args, escape)
+				}
+			default:
+				t.Current = start
+				return left, nil
 			}
 		}
 	}
-	return
+}
+
+// parseBinary reads operands separated by the operators of the same precedence, which apply from left
+// to right
+func parseBinary(t *Tokenizer, operators []string, parseOperand func(*Tokenizer) (*Expr, error)) (*Expr, error) {
+	left, err := parseOperand(t)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := ""
+		for _, operator := range operators {
+			if t.Match(operator) {
+				op = operator
+				break
+			}
+		}
+		if token := t.Peek(); op == "" && slices.Contains(operators, "+") && len(token) > 1 && (token[0] == '-' || token[0] == '+') {
+			// the sign of a number after an operand, like in "a -1", is the operator
+			op = token[:1]
+			t.Tokens[t.Current] = token[1:]
+		}
+		if op == "" {
+			return left, nil
+		}
+		right, err := parseOperand(t)
+		if err != nil {
+			return nil, err
+		}
+		left = &Expr{Op: op, Args: []*Expr{left, right}}
+	}
+}
+
+func parseRelational(t *Tokenizer) (*Expr, error) {
+	return parseBinary(t, []string{"<=", ">=", "<", ">"}, parseBitwise)
+}
+
+func parseBitwise(t *Tokenizer) (*Expr, error) {
+	return parseBinary(t, []string{"&", "|", "<<", ">>"}, parseAdditive)
+}
+
+func parseAdditive(t *Tokenizer) (*Expr, error) {
+	return parseBinary(t, []string{"+", "-"}, parseMultiplicative)
+}
+
+func parseMultiplicative(t *Tokenizer) (*Expr, error) {
+	return parseBinary(t, []string{"*", "/", "%"}, parseConcat)
+}
+
+func parseConcat(t *Tokenizer) (*Expr, error) {
+	return parseBinary(t, []string{"||", "->", "->>"}, parseCollate)
+}
+
+// parseCollate reads an operand followed by COLLATE and the name of a collation
+func parseCollate(t *Tokenizer) (*Expr, error) {
+	expr, err := parseUnary(t)
+	if err != nil {
+		return nil, err
+	}
+	for t.Match("COLLATE") {
+		name, err := t.MustGetIdentifier()
+		if err != nil {
+			return nil, err
+		}
+		expr = &Expr{Op: "COLLATE", Name: name, Args: []*Expr{expr}}
+	}
+	return expr, nil
+}
+
+// parseUnary reads an operand with the unary operators before it. A minus sign before a number is
+// part of the number, so that -9223372036854775808 is an integer.
+func parseUnary(t *Tokenizer) (*Expr, error) {
+	if !t.Match("-") && !t.Match("+") && !t.Match("~") {
+		return parseOperand(t)
+	}
+	op := t.Previous()
+	if token := t.Peek(); op == "-" && token != "" && (isDigit(token[0]) || token[0] == '.') {
+		t.Advance()
+		value, err := parseNumber("-" + token)
+		if err != nil {
+			return nil, err
+		}
+		return &Expr{Op: "literal", Value: value}, nil
+	}
+	operand, err := parseUnary(t)
+	if err != nil {
+		return nil, err
+	}
+	return &Expr{Op: op, Args: []*Expr{operand}}, nil
+}
+
+func parseOperand(t *Tokenizer) (*Expr, error) {
+	if t.Match("(") {
+		if isSelect(t) {
+			// a scalar subquery, giving the first column of its first row
+			statement, err := parseSelect(t)
+			if err != nil {
+				return nil, err
+			}
+			return &Expr{Op: "subquery", Select: statement}, t.MustMatch(")")
+		}
+		expr, err := parseExpr(t)
+		if err != nil {
+			return nil, err
+		}
+		return expr, t.MustMatch(")")
+	}
+	token, err := t.MustGetIdentifier()
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case token == "," || token == "(" || token == ")" || token == ";":
+		return nil, fmt.Errorf("syntax error near %q", token)
+	case token[0] == '\'':
+		return &Expr{Op: "literal", Value: unquoteString(token)}, nil
+	case strings.EqualFold(token, "X") && strings.HasPrefix(t.Peek(), "'"):
+		blob, err := hex.DecodeString(unquoteString(t.Peek()))
+		if err != nil {
+			return nil, fmt.Errorf("unrecognized token: \"%s%s\"", token, t.Peek())
+		}
+		t.Advance()
+		return &Expr{Op: "literal", Value: blob}, nil
+	case strings.EqualFold(token, "NULL"):
+		return &Expr{Op: "literal"}, nil
+	case strings.EqualFold(token, "TRUE") || strings.EqualFold(token, "FALSE"):
+		return &Expr{Op: "literal", Value: boolValue(strings.EqualFold(token, "TRUE"))}, nil
+	case strings.EqualFold(token, "CURRENT_DATE") || strings.EqualFold(token, "CURRENT_TIME") || strings.EqualFold(token, "CURRENT_TIMESTAMP"):
+		// the current date and time in UTC, like the date and time functions give them
+		name := map[string]string{"CURRENT_DATE": "DATE", "CURRENT_TIME": "TIME", "CURRENT_TIMESTAMP": "DATETIME"}[strings.ToUpper(token)]
+		return &Expr{Op: "function", Name: name, Args: []*Expr{{Op: "literal", Value: "now"}}}, nil
+	case strings.ContainsRune("?:@$", rune(token[0])):
+		return parseParameter(t, token)
+	case strings.ContainsRune("+-.0123456789", rune(token[0])):
+		value, err := parseNumber(token)
+		if err != nil {
+			return nil, err
+		}
+		return &Expr{Op: "literal", Value: value}, nil
+	case strings.EqualFold(token, "CASE"):
+		return parseCase(t)
+	case strings.EqualFold(token, "CAST") && t.Match("("):
+		return parseCast(t)
+	case strings.EqualFold(token, "EXISTS") && t.Match("("):
+		statement, err := parseSelect(t)
+		if err != nil {
+			return nil, err
+		}
+		return &Expr{Op: "EXISTS", Select: statement}, t.MustMatch(")")
+	case t.Match("("):
+		function := &Expr{Op: "function", Name: strings.ToUpper(token)}
+		if t.Match("*") {
+			function.Args = append(function.Args, &Expr{Op: "*"})
+			if err := t.MustMatch(")"); err != nil {
+				return nil, err
+			}
+		} else {
+			function.Distinct = t.Match("DISTINCT")
+			for !t.Match(")") {
+				if len(function.Args) > 0 {
+					if err := t.MustMatch(","); err != nil {
+						return nil, err
+					}
+				}
+				arg, err := parseExpr(t)
+				if err != nil {
+					return nil, err
+				}
+				function.Args = append(function.Args, arg)
+			}
+		}
+		if t.Match("FILTER") {
+			if err := t.MustMatch("("); err != nil {
+				return nil, err
+			}
+			if err := t.MustMatch("WHERE"); err != nil {
+				return nil, err
+			}
+			filter, err := parseExpr(t)
+			if err != nil {
+				return nil, err
+			}
+			function.Filter = filter
+			if err := t.MustMatch(")"); err != nil {
+				return nil, err
+			}
+		}
+		if t.Match("OVER") {
+			// a window function, with its window or the name of one of the WINDOW clause
+			if !t.Match("(") {
+				name, err := t.MustGetIdentifier()
+				function.Window = &WindowDef{Name: name}
+				return function, err
+			}
+			window, err := parseWindow(t)
+			function.Window = window
+			return function, err
+		}
+		return function, nil
+	case t.Match("."):
+		name, err := t.MustGetIdentifier()
+		if err != nil {
+			return nil, err
+		}
+		return &Expr{Op: "column", Table: token, Name: name}, nil
+	}
+	return &Expr{Op: "column", Name: token}, nil
+}
+
+// parseCase reads a CASE expression after CASE. With an operand, each WHEN expression is compared with
+// it, otherwise each one is a condition. The expression without ELSE has an ELSE NULL.
+func parseCase(t *Tokenizer) (*Expr, error) {
+	expr := &Expr{Op: "CASE"}
+	if !t.Match("WHEN") {
+		operand, err := parseExpr(t)
+		if err != nil {
+			return nil, err
+		}
+		expr.Args = append(expr.Args, operand)
+		if err := t.MustMatch("WHEN"); err != nil {
+			return nil, err
+		}
+	}
+	for {
+		when, err := parseExpr(t)
+		if err != nil {
+			return nil, err
+		}
+		if err := t.MustMatch("THEN"); err != nil {
+			return nil, err
+		}
+		then, err := parseExpr(t)
+		if err != nil {
+			return nil, err
+		}
+		expr.Args = append(expr.Args, when, then)
+		if !t.Match("WHEN") {
+			break
+		}
+	}
+	otherwise := &Expr{Op: "literal"}
+	if t.Match("ELSE") {
+		var err error
+		if otherwise, err = parseExpr(t); err != nil {
+			return nil, err
+		}
+	}
+	expr.Args = append(expr.Args, otherwise)
+	return expr, t.MustMatch("END")
+}
+
+// parseCast reads CAST(expr AS type) after its opening parenthesis. The type is written like the type of
+// a column, with its size if any.
+func parseCast(t *Tokenizer) (*Expr, error) {
+	operand, err := parseExpr(t)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.MustMatch("AS"); err != nil {
+		return nil, err
+	}
+	typeTokens := []string{}
+	for !t.Match(")") {
+		if t.Match("(") {
+			size := "("
+			for !t.AtEnd() && !t.Match(")") {
+				size += t.Peek()
+				t.Advance()
+			}
+			if len(typeTokens) == 0 {
+				typeTokens = append(typeTokens, "")
+			}
+			typeTokens[len(typeTokens)-1] += size + ")"
+			continue
+		}
+		typeToken, err := t.MustGetIdentifier()
+		if err != nil {
+			return nil, err
+		}
+		typeTokens = append(typeTokens, typeToken)
+	}
+	return &Expr{Op: "CAST", Name: strings.Join(typeTokens, " "), Args: []*Expr{operand}}, nil
+}
+
+// parseParameter numbers a parameter like SQLite: "?NNN" has the number NNN, a name has the same number
+// every time it's used, and the others are numbered after the largest number used before them
+func parseParameter(t *Tokenizer, token string) (*Expr, error) {
+	parameter := &Expr{Op: "parameter", Name: token}
+	largest := 0
+	for _, previous := range t.Parameters {
+		if len(token) > 1 && previous.Name == token {
+			parameter.Column = previous.Column
+		}
+		largest = max(largest, previous.Column)
+	}
+	if token[0] == '?' && len(token) > 1 {
+		number, err := strconv.Atoi(token[1:])
+		if err != nil || number < 1 || number > maxParameterNumber {
+			return nil, fmt.Errorf("variable number must be between ?1 and ?%d", maxParameterNumber)
+		}
+		parameter.Column = number
+	} else if token != "?" && len(token) == 1 {
+		return nil, fmt.Errorf("unrecognized token: %q", token)
+	} else if parameter.Column == 0 {
+		parameter.Column = largest + 1
+	}
+	t.Parameters = append(t.Parameters, parameter)
+	return parameter, nil
+}
+
+// parseNumber converts a numeric literal to int64, or to float64 if it doesn't fit or isn't an integer.
+// A hexadecimal literal like 0xff is an integer of 64 bits at most, taken as two's complement like SQLite,
+// so that 0xffffffffffffffff is -1.
+func parseNumber(token string) (any, error) {
+	digits := strings.TrimLeft(token, "+-")
+	if len(digits) > 1 && digits[0] == '0' && (digits[1] == 'x' || digits[1] == 'X') {
+		value, err := strconv.ParseUint(digits[2:], 16, 64)
+		negative := strings.HasPrefix(token, "-")
+		if errors.Is(err, strconv.ErrRange) || negative && value == 1<<63 {
+			return nil, fmt.Errorf("hex literal too big: %s", token)
+		} else if err != nil {
+			return nil, fmt.Errorf("unrecognized token: %q", token)
+		}
+		if negative {
+			return -int64(value), nil
+		}
+		return int64(value), nil
+	}
+	if value, err := strconv.ParseInt(token, 10, 64); err == nil {
+		return value, nil
+	}
+	value, err := strconv.ParseFloat(token, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		// a number too large for a real is an infinity, and a number too small is zero
+		return value, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("syntax error near %q", token)
+	}
+	return value, nil
+}
+
+// unquoteString removes the quotes from a string literal, and turns each doubled quote inside it into a single one
+func unquoteString(token string) string {
+	token = strings.TrimPrefix(token, "'")
+	token = strings.TrimSuffix(token, "'")
+	return strings.ReplaceAll(token, "''", "'")
 }
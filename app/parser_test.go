@@ -1,11 +1,14 @@
 package main
 
 import (
+	"fmt"
+	"slices"
+	"strings"
 	"testing"
 )
 
 func TestParseCreateIndex(t *testing.T) {
-	indexName, tableName, columns, _ := parseCreateIndex("create index idx on tab (a, b desc, c asc)")
+	indexName, tableName, columns, where, _ := parseCreateIndex("create index idx on tab (a, b desc, c asc) where a > 1 and b is not null")
 	if indexName != "idx" {
 		t.Errorf("expected index name: %q - got: %q\n", "idx", indexName)
 	}
@@ -22,22 +25,346 @@ func TestParseCreateIndex(t *testing.T) {
 			t.Errorf("expected column order: %q - got: %q\n", order, columns[i].Type)
 		}
 	}
+	if expected := "((a > 1) AND (b NOTNULL))"; where == nil || formatExpr(where) != expected {
+		t.Errorf("expected index WHERE clause: %q - got: %v\n", expected, where)
+	}
+}
+
+func TestParseCreateTable(t *testing.T) {
+	tests := []struct {
+		sql        string
+		columns    []string
+		primaryKey []string
+		aliased    int
+	}{
+		{"create table t (id integer primary key, name varchar(20) not null, price decimal(10, 2))", []string{"id", "name", "price"}, []string{"id ASC"}, 0},
+		{"create table t (a text, b integer, c, primary key (b, a desc))", []string{"a", "b", "c"}, []string{"b ASC", "a DESC"}, -1},
+		{"create table t (a integer, b, constraint pk primary key (a), check (b > 0 and (b < 10)))", []string{"a", "b"}, []string{"a ASC"}, 0},
+		{"create table t (a, b integer check (b in (1, 2)) constraint k primary key desc)", []string{"a", "b"}, []string{"b DESC"}, 1},
+	}
+	for _, test := range tests {
+		_, columns, _, primaryKey, err := parseCreateTable(test.sql)
+		if err != nil {
+			t.Fatalf("sql: %s - error: %v", test.sql, err)
+		}
+		names := []string{}
+		for _, column := range columns {
+			names = append(names, column.Name)
+		}
+		if slices.Compare(names, test.columns) != 0 {
+			t.Errorf("sql: %s - expected columns: %q - got: %q", test.sql, test.columns, names)
+		}
+		keys := []string{}
+		for _, column := range primaryKey {
+			keys = append(keys, column.Name+" "+column.Type)
+		}
+		if slices.Compare(keys, test.primaryKey) != 0 {
+			t.Errorf("sql: %s - expected primary key: %q - got: %q", test.sql, test.primaryKey, keys)
+		}
+		if aliased := aliasedRowidColumn(columns); aliased != test.aliased {
+			t.Errorf("sql: %s - expected rowid alias: %d - got: %d", test.sql, test.aliased, aliased)
+		}
+	}
+}
+
+func TestParseDefaults(t *testing.T) {
+	sql := "create table t (a integer not null default -1, b default 'x' collate nocase, c default (1 + 2), d default current_timestamp, e default abc, f references p(id) on delete set default)"
+	_, columns, _, _, err := parseCreateTable(sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"-1", "'x'", "(1 + 2)", "DATETIME('now')", "'abc'", ""}
+	for i, column := range columns {
+		result := ""
+		if column.Default != nil {
+			result = formatExpr(column.Default)
+		}
+		if result != expected[i] {
+			t.Errorf("column: %s - expected default: %s - got: %s", column.Name, expected[i], result)
+		}
+	}
+	if collation := collationName(columns[1].Constraints); collation != "nocase" {
+		t.Errorf("expected collation after the default: nocase - got: %q", collation)
+	}
+}
+
+func TestParseGeneratedColumns(t *testing.T) {
+	sql := "create table t (a integer, b text generated always as (a * 2) stored, c as (b || 'x') not null, d int not null as (1) virtual, e)"
+	_, columns, _, _, err := parseCreateTable(sql)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"", "(a * 2) STORED", "(b || 'x')", "1", ""}
+	for i, column := range columns {
+		result := ""
+		if column.Generated != nil {
+			result = formatExpr(column.Generated)
+		}
+		if column.Stored {
+			result += " STORED"
+		}
+		if result != expected[i] {
+			t.Errorf("column: %s - expected generated: %s - got: %s", column.Name, expected[i], result)
+		}
+	}
+	if order := recordColumns(SchemaEntry{Columns: columns}); slices.Compare(order, []int{0, 1, 4}) != 0 {
+		t.Errorf("expected the virtual columns not to be stored - got fields: %v", order)
+	}
+}
+
+func TestParseTableOptions(t *testing.T) {
+	_, _, constraints, _, err := parseCreateTable("create table t (a text primary key, b) strict, without rowid;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slices.Compare(constraints, []string{"STRICT", "WITHOUT ROWID"}) != 0 {
+		t.Errorf("expected table options: %q - got: %q", []string{"STRICT", "WITHOUT ROWID"}, constraints)
+	}
+	if _, _, _, _, err = parseCreateTable("create table t (a) without"); err == nil {
+		t.Errorf("expected error for incomplete WITHOUT ROWID")
+	}
+}
+
+func TestParseCreateView(t *testing.T) {
+	name, columns, statement, ifNotExists, err := parseCreateView("create temp view if not exists main.v(a, b) as select x, y from t where x > 1;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "v" || slices.Compare(columns, []string{"a", "b"}) != 0 || !ifNotExists {
+		t.Errorf("expected view v(a, b) - got: %s%q %v", name, columns, ifNotExists)
+	}
+	if len(statement.Columns) != 2 || statement.From[0].Name != "t" || formatExpr(statement.Where) != "(x > 1)" {
+		t.Errorf("expected the SELECT of the view - got: %#v", statement)
+	}
+	if _, _, _, _, err = parseCreateView("create view v as select ?"); err == nil {
+		t.Errorf("expected error for a parameter on a view")
+	}
+	if name, ifExists, err := parseDropView("drop view if exists v"); err != nil || name != "v" || !ifExists {
+		t.Errorf("expected to drop view v if it exists - got: %s %v %v", name, ifExists, err)
+	}
+}
+
+func TestParseWith(t *testing.T) {
+	statement, err := parseSelectStatement("with recursive a(x) as (select 1 union all select x + 1 from a limit 3), b as materialized (select * from a) select * from b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statement.With) != 2 || statement.With[0].Name != "a" || slices.Compare(statement.With[0].Columns, []string{"x"}) != 0 {
+		t.Fatalf("expected the tables a(x) and b - got: %#v", statement.With)
+	}
+	if compound := statement.With[0].Select; len(compound.Compound) != 1 || compound.Compound[0].Op != "UNION ALL" || compound.Compound[0].Select.From[0].Name != "a" || formatExpr(compound.Limit) != "3" {
+		t.Errorf("expected the recursive SELECT of a - got: %#v", compound)
+	}
+	for _, sql := range []string{"with a as (select 1), a as (select 2) select 1", "with a as (select 1 limit 1 union select 2) select 1", "with a select 1"} {
+		if _, err = parseSelectStatement(sql); err == nil {
+			t.Errorf("sql: %s - expected error", sql)
+		}
+	}
 }
 
 func TestParseSelectStatement(t *testing.T) {
-	tableName, columnNames, filterColumn, filterValue, _ := parseSelectStatement("select a, b, c, *, count(*) from tab where x = '123'")
-	if tableName != "tab" {
-		t.Errorf("expected table name: %q - got: %q\n", "tab", tableName)
+	statement, _ := parseSelectStatement("select a, b, c, *, count(*) from tab where x = '123'")
+	if statement.From[0].Name != "tab" {
+		t.Errorf("expected table name: %q - got: %q\n", "tab", statement.From[0].Name)
 	}
 	for i, name := range []string{"a", "b", "c", "*", "COUNT(*)"} {
-		if columnNames[i] != name {
-			t.Errorf("expected column name: %q - got: %q\n", name, columnNames[i])
+		if formatExpr(statement.Columns[i].Expr) != name {
+			t.Errorf("expected column name: %q - got: %q\n", name, formatExpr(statement.Columns[i].Expr))
+		}
+	}
+	where := statement.Where
+	if where.Op != "=" || where.Args[0].Name != "x" {
+		t.Errorf("expected filter column name: %q - got: %#v\n", "x", where)
+	}
+	if compareValues("123", where.Args[1].Value) != 0 {
+		t.Errorf("expected filter value: %q - got: %q\n", "123", where.Args[1].Value)
+	}
+}
+
+func TestParseDistinct(t *testing.T) {
+	for query, distinct := range map[string]bool{"select distinct a from t": true, "select all a from t": false, "select a from t": false} {
+		statement, err := parseSelectStatement(query)
+		if err != nil {
+			t.Fatal(err)
 		}
+		if statement.Distinct != distinct || formatExpr(statement.Columns[0].Expr) != "a" {
+			t.Errorf("query: %s - expected DISTINCT: %v - got: %v", query, distinct, statement.Distinct)
+		}
+	}
+}
+
+func TestParseWindows(t *testing.T) {
+	statement, err := parseSelectStatement("select row_number() over v from t where a > 1 window w as (partition by b), v as (w order by c range 2 preceding) order by 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(statement.Windows) != 2 || statement.Windows[0].Name != "w" || statement.Windows[1].Base != "w" || statement.Where == nil || len(statement.OrderBy) != 1 {
+		t.Errorf("expected the windows of the WINDOW clause - got: %#v", statement)
+	}
+	window := *statement.Windows[1]
+	window.Name = ""
+	if window := formatWindow(&window); window != "(w ORDER BY c RANGE BETWEEN 2 PRECEDING AND CURRENT ROW)" {
+		t.Errorf("expected the window with its frame - got: %s", window)
+	}
+	statement, err = parseSelectStatement("select sum(a) filter (where b > 1) over (order by c) from t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := formatExpr(statement.Columns[0].Expr); sum != "SUM(a) FILTER (WHERE (b > 1)) OVER (ORDER BY c)" {
+		t.Errorf("expected the aggregate with its FILTER clause - got: %s", sum)
+	}
+	for _, query := range []string{
+		"select count(*) over (rows between current row and 1 preceding) from t",
+		"select count(*) over (rows between 1 following and current row) from t",
+		"select count(*) over (rows unbounded following) from t",
+		"select count(*) over (exclude ties) from t",
+		"select count(*) filter (b > 1) from t",
+	} {
+		if _, err := parseSelectStatement(query); err == nil {
+			t.Errorf("query: %s - expected error", query)
+		}
+	}
+}
+
+func TestParseGroupBy(t *testing.T) {
+	statement, err := parseSelectStatement("select a, count(distinct b) from t where c > 1 group by a collate nocase, 2 having count(*) > 1 window w as () order by 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	groupBy := []string{}
+	for _, expr := range statement.GroupBy {
+		groupBy = append(groupBy, formatExpr(expr))
+	}
+	if slices.Compare(groupBy, []string{"(a COLLATE nocase)", "2"}) != 0 || formatExpr(statement.Having) != "(COUNT(*) > 1)" || len(statement.Windows) != 1 {
+		t.Errorf("expected the GROUP BY and HAVING clauses - got: %#v", statement)
 	}
-	if filterColumn != "x" {
-		t.Errorf("expected filter column name: %q - got: %q\n", "x", filterColumn)
+	if count := formatExpr(statement.Columns[1].Expr); count != "COUNT(DISTINCT b)" {
+		t.Errorf("expected the DISTINCT aggregate - got: %s", count)
+	}
+}
+
+func TestParseCompoundSelect(t *testing.T) {
+	statement, err := parseSelectStatement("select a from t union select b from u intersect select c from v union all select 1 except select 2 order by 1 desc limit 3")
+	if err != nil {
+		t.Fatal(err)
 	}
-	if compareAny("123", filterValue) != 0 {
-		t.Errorf("expected filter value: %q - got: %q\n", "123", filterValue)
+	operators := []string{}
+	for _, next := range statement.Compound {
+		operators = append(operators, next.Op)
+	}
+	if slices.Compare(operators, []string{"UNION", "INTERSECT", "UNION ALL", "EXCEPT"}) != 0 {
+		t.Errorf("expected the operators of the compound SELECT - got: %q", operators)
+	}
+	if len(statement.OrderBy) != 1 || formatExpr(statement.Limit) != "3" || statement.Compound[3].Select.OrderBy != nil {
+		t.Errorf("expected ORDER BY and LIMIT on the first SELECT - got: %#v", statement)
+	}
+	if _, err = parseSelectStatement("select 1 order by 1 union select 2"); err == nil || err.Error() != "ORDER BY clause should come after UNION not before" {
+		t.Errorf("expected error for ORDER BY before UNION - got: %v", err)
+	}
+}
+
+func TestParseJoins(t *testing.T) {
+	statement, err := parseSelectStatement("explain query plan select a.x y, b.*, c.z as w from main.t1 a, t2 as b join t3 c on c.k = a.k left outer join t4 using (k, j) where a.x > 1 order by 1, b.y desc limit 10 offset 5;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !statement.Explain {
+		t.Errorf("expected EXPLAIN QUERY PLAN")
+	}
+	columns := []string{}
+	for _, column := range statement.Columns {
+		columns = append(columns, formatExpr(column.Expr)+" "+column.Alias)
+	}
+	if expected := []string{"a.x y", "b.* ", "c.z w"}; slices.Compare(columns, expected) != 0 {
+		t.Errorf("expected columns: %q - got: %q", expected, columns)
+	}
+	tables := []string{}
+	for _, table := range statement.From {
+		on := ""
+		if table.On != nil {
+			on = formatExpr(table.On)
+		}
+		tables = append(tables, fmt.Sprintf("%s|%s|%s|%s|%s", table.Join, table.Name, table.Alias, on, strings.Join(table.Using, ",")))
+	}
+	expected := []string{"|t1|a||", "|t2|b||", "INNER|t3|c|(c.k = a.k)|", "LEFT|t4|||k,j"}
+	if slices.Compare(tables, expected) != 0 {
+		t.Errorf("expected tables: %q - got: %q", expected, tables)
+	}
+	if statement.Where == nil || formatExpr(statement.Where) != "(a.x > 1)" {
+		t.Errorf("expected where: %s - got: %#v", "(a.x > 1)", statement.Where)
+	}
+	orderBy := []string{}
+	for _, term := range statement.OrderBy {
+		orderBy = append(orderBy, fmt.Sprintf("%s %v", formatExpr(term.Expr), term.Descending))
+	}
+	if expected := []string{"1 false", "b.y true"}; slices.Compare(orderBy, expected) != 0 {
+		t.Errorf("expected order by: %q - got: %q", expected, orderBy)
+	}
+	if statement.Limit == nil || statement.Offset == nil || formatExpr(statement.Limit) != "10" || formatExpr(statement.Offset) != "5" {
+		t.Errorf("expected limit 10 offset 5 - got: %#v %#v", statement.Limit, statement.Offset)
+	}
+}
+
+func TestParseParameters(t *testing.T) {
+	statement, err := parseSelectStatement("select ?, ?5, :a, ? from t where x = :a and y = @b limit $c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parameters := []string{}
+	for _, parameter := range statement.Parameters {
+		parameters = append(parameters, fmt.Sprintf("%s=%d", parameter.Name, parameter.Column))
+	}
+	if expected := []string{"?=1", "?5=5", ":a=6", "?=7", ":a=6", "@b=8", "$c=9"}; slices.Compare(parameters, expected) != 0 {
+		t.Errorf("expected parameters: %q - got: %q", expected, parameters)
+	}
+	for _, query := range []string{"select ?0 from t", "select ?32767 from t", "select : from t"} {
+		if _, err := parseSelectStatement(query); err == nil {
+			t.Errorf("query: %s - expected error", query)
+		}
+	}
+}
+
+func TestParseExpr(t *testing.T) {
+	tests := []struct {
+		source   string
+		expected string
+	}{
+		{"a = 1 AND b < 2 OR c >= 'x'", "(((a = 1) AND (b < 2)) OR (c >= 'x'))"},
+		{"NOT a BETWEEN 1 AND 5 AND b", "((NOT (a BETWEEN 1 AND 5)) AND b)"},
+		{"a NOT IN (1, 2.5, NULL)", "(a NOT IN (1, 2.5, NULL))"},
+		{"a LIKE 'it''s%' AND b IS NOT NULL", "((a LIKE 'it''s%') AND (b NOTNULL))"},
+		{"substr(x'00ff', 2) = X''", "(SUBSTR(X'00ff', 2) = X'')"},
+		{"a + b * c - d / 2 % e", "((a + (b * c)) - ((d / 2) % e))"},
+		{"-a || b = c < d << 1 & 3", "(((-a) || b) = (c < ((d << 1) & 3)))"},
+		{"1+2-3 = ~x", "(((1 + 2) - 3) = (~x))"},
+		{"a -> '$.b' ->> 0 || c = 1", "((((a -> '$.b') ->> 0) || c) = 1)"},
+		{"-a COLLATE nocase || b = c COLLATE rtrim", "((((-a) COLLATE nocase) || b) = (c COLLATE rtrim))"},
+		{"a IN (SELECT b FROM t) AND NOT EXISTS (SELECT 1) OR (SELECT 2) > 1", "(((a IN (SELECT ...)) AND (NOT EXISTS (SELECT ...))) OR ((SELECT ...) > 1))"},
+		{"CASE WHEN a THEN 1 WHEN b > 2 THEN 'x' END || 'y'", "(CASE WHEN a THEN 1 WHEN (b > 2) THEN 'x' ELSE NULL END || 'y')"},
+		{"case a + 1 when 2 then b else c end = cast(d as varchar(10))", "(CASE (a + 1) WHEN 2 THEN b ELSE c END = CAST(d AS varchar(10)))"},
+		{"a IS b AND a IS NOT 1 AND a IS DISTINCT FROM b AND a IS NOT DISTINCT FROM NULL", "((((a IS b) AND (a IS NOT 1)) AND (a IS NOT b)) AND (a IS NULL))"},
+		{"a NOT LIKE 'x!%' ESCAPE '!' OR a GLOB 'a*' OR a NOT REGEXP '^b' || c", "(((a NOT LIKE 'x!%' ESCAPE '!') OR (a GLOB 'a*')) OR (a NOT REGEXP ('^b' || c)))"},
+		{"count(*) over (partition by a, b order by c desc rows between 1 preceding and unbounded following exclude ties) + rank() over w", "(COUNT(*) OVER (PARTITION BY a, b ORDER BY c DESC ROWS BETWEEN 1 PRECEDING AND UNBOUNDED FOLLOWING EXCLUDE TIES) + RANK() OVER w)"},
+		{"sum(x) over (base order by y groups current row exclude no others) - lag(x, 2) over ()", "(SUM(x) OVER (base ORDER BY y GROUPS BETWEEN CURRENT ROW AND CURRENT ROW) - LAG(x, 2) OVER ())"},
+		{"0xff + -0x10 * 0XFFFFFFFFFFFFFFFF - 0x8000000000000000", "((255 + (-16 * -1)) - -9223372036854775808)"},
+	}
+	for _, test := range tests {
+		expr, err := parseExpr(NewTokenizer(test.source))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result := formatExpr(expr); result != test.expected {
+			t.Errorf("expected: %s - got: %s\n", test.expected, result)
+		}
+	}
+	for source, message := range map[string]string{
+		"0x":                   `unrecognized token: "0x"`,
+		"0x1g":                 `unrecognized token: "0x1g"`,
+		"0x10000000000000000":  "hex literal too big: 0x10000000000000000",
+		"- 0x8000000000000000": "hex literal too big: -0x8000000000000000",
+	} {
+		if _, err := parseExpr(NewTokenizer(source)); err == nil || err.Error() != message {
+			t.Errorf("source: %s - expected error: %s - got: %v", source, message, err)
+		}
 	}
 }
@@ -0,0 +1,2209 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// tableSource is a table on the FROM clause. Its columns are found on the rows of the join starting at
+// Offset, followed by its rowid. The ON clause of a LEFT JOIN is kept with the table, and the columns
+// of USING are found on the table before it when not qualified. Needed has the positions of the columns
+// used by the query, which decide if an index has all the data to answer it. A table-valued function has
+// its rows given by Function for the values of Args, which may use the tables before it. A view or a
+// subquery has its rows given by the SELECT of Query. A recursive table of a WITH clause, read by its
+// recursive SELECT, has the single row on the current row of Recursive. The tables of the queries around
+// a subquery, which it may use, have the number of queries between them on Depth.
+type tableSource struct {
+	Name      string
+	Table     SchemaEntry
+	Offset    int
+	LeftJoin  bool
+	On        []*Expr
+	Using     []string
+	Needed    []int
+	Function  *tableFunction
+	Args      []*Expr
+	Query     *subquery
+	Reals     []int
+	Depth     int
+	Recursive *commonTable
+}
+
+// fill copies a row of the table to the row of the join, or NULLs when there is no row. Columns with the
+// REAL affinity get back the reals that were stored as integers.
+func (source tableSource) fill(row []any, record *TableRecord) {
+	values := row[source.Offset : source.Offset+len(source.Table.Columns)+1]
+	clear(values)
+	if record == nil {
+		return
+	}
+	copy(values, record.Columns)
+	for _, column := range source.Reals {
+		if integer, isInteger := values[column].(int64); isInteger {
+			values[column] = float64(integer)
+		}
+	}
+	if !source.Table.WithoutRowid {
+		values[len(source.Table.Columns)] = record.Rowid
+		if aliasedPKColumnNumber := aliasedRowidColumn(source.Table.Columns); aliasedPKColumnNumber >= 0 {
+			values[aliasedPKColumnNumber] = record.Rowid
+		}
+	}
+}
+
+// queryPlan reads the tables of a query in the order of its steps, each step reading a table for each row
+// produced by the previous ones. The rows must be sorted by OrderBy unless the first step reads them
+// in that order. The aggregates are computed from all the rows into slots after the columns of the
+// tables. MinMax is a single MIN or MAX, found on the first row when the rows are read in order.
+// Rows is the estimated number of rows of the result. Subqueries are the ones of the expressions. A
+// compound SELECT has the plans of its SELECTs on Compound, and no tables. The rows of SELECT DISTINCT
+// are compared with the last one when Distinct is "ordered", as they are read or sorted in the order of
+// the result columns, and with all the ones before when it is "unordered". It is empty when the rows are
+// distinct anyway. The window functions are computed by Windows from all the rows after the aggregates,
+// into slots after theirs, and then the rows are in the order of the first window, so Sorted tells if
+// that is the order of ORDER BY. With GROUP BY, the aggregates are computed for each group of rows with
+// the same GroupBy values, read or sorted in that order, and Having filters the groups.
+type queryPlan struct {
+	Sources    []tableSource
+	Steps      []planStep
+	Columns    []ResultColumn
+	OrderBy    []OrderTerm
+	Sorted     bool
+	Aggregates []*Expr
+	MinMax     bool
+	GroupBy    []OrderTerm
+	Having     []*Expr
+	Filters    []*Expr // the terms of a SELECT without FROM, which has no step to check them
+	Width      int
+	Cost       float64
+	Rows       float64
+	Subqueries []*subquery
+	Compound   []compoundPart
+	Distinct   string
+	Windows    []*windowPass
+
+	GroupSorted    bool // the rows are read in the order of GroupBy
+	SortedDistinct bool // the values of a single aggregate called with DISTINCT are read in order
+}
+
+// planStep reads a table with an access path. The rows found must match the ON clause of a LEFT JOIN,
+// or get NULLs when none does, and then the terms of the WHERE clause that can be checked at this step.
+type planStep struct {
+	Source  int
+	Path    accessPath
+	On      []*Expr
+	Filters []*Expr
+}
+
+// accessPath is the strategy chosen to retrieve the rows of a table: a full "scan", a "rowid" range scan
+// on the table b-tree, an "index" range scan followed by rowid lookups, a "pk" range scan on the b-tree
+// of a table without rowid, or an "automatic" range scan on an index built in memory the first time the
+// table is read, which costs Setup once. The ranges of keys are found from the terms on each part of the key when the
+// table is read, as they may depend on the values of the tables read before. An index without parts is
+// read entirely. A covering index has all the columns needed, so the table is not read. A reverse path
+// reads the keys from the last one, to give the rows in the opposite order of the key.
+type accessPath struct {
+	Kind       string
+	Index      string
+	IndexPage  int
+	KeyColumns []KeyColumn
+	Parts      []keyPart
+	KeyRanges  []KeyRange
+	Order      []orderKey
+	Covering   bool
+	Ordered    bool
+	Reverse    bool
+	Rows       float64
+	Cost       float64
+	Setup      float64
+	Automatic  *automaticIndex
+}
+
+// automaticIndex has the rows of a table sorted by the values of some of its columns, like an index with
+// all the columns of the table, for the tables of a join with no index on the columns compared with the
+// tables before them
+type automaticIndex struct {
+	Columns []int
+	Records []TableRecord
+	Built   bool
+}
+
+// orderKey is a column of the key that sorts the rows read by an access path
+type orderKey struct {
+	Columns    []int
+	Descending bool
+	Collation  string
+}
+
+// keyPart is a column of a b-tree key limited by terms of the WHERE clause. The rowid part has both the
+// rowid and the column that is an alias for it, and no collation.
+type keyPart struct {
+	Name         string
+	Columns      []int
+	Affinity     string
+	Collation    string
+	Terms        []*Expr
+	Equality     bool
+	Choices      int
+	Lower, Upper bool
+}
+
+// resolveSources finds the tables of the FROM clause and binds the expressions of the join conditions,
+// which may use the tables of the queries around them. The conditions of inner joins are returned to be
+// used like the terms of the WHERE clause.
+func (db *DbContext) resolveSources(from []TableRef, outer []tableSource) (sources []tableSource, terms []*Expr, err error) {
+	offset := scopeWidth(outer)
+	for _, ref := range from {
+		source := tableSource{Name: ref.Name, Offset: offset, LeftJoin: ref.Join == "LEFT", Using: ref.Using}
+		if ref.Alias != "" {
+			source.Name = ref.Alias
+		}
+		if table := db.findCommonTable(ref.Name); table != nil && ref.Select == nil && ref.Args == nil {
+			// the recursive SELECT of a table reads it on its own FROM clause, which is the same slice
+			recursive := table.planning == "step" && &from[0] == &table.recursive.From[0]
+			if source.Table, source.Query, err = db.readCommonTable(table, recursive); err != nil {
+				return nil, nil, err
+			}
+			if recursive {
+				source.Recursive = table
+			}
+		}
+		if strings.EqualFold(ref.Name, "sqlite_schema") || strings.EqualFold(ref.Name, "sqlite_master") {
+			source.Table = SchemaEntry{Type: "table", Name: ref.Name, RootPage: 1}
+			// sqlite_schema has no table definition - this is the one from the docs: https://www.sqlite.org/fileformat.html#storage_of_the_sql_database_schema
+			_, source.Table.Columns, _, _, _ = parseCreateTable("CREATE TABLE sqlite_schema(type text, name text, tbl_name text, rootpage integer, sql text);")
+		}
+		for _, entry := range db.Schema {
+			if ref.Select != nil || source.Table.Name != "" {
+				break
+			}
+			if entry.Type == "table" && strings.EqualFold(ref.Name, entry.Name) && ref.Args == nil {
+				source.Table = entry
+				break
+			}
+			if entry.Type == "view" && strings.EqualFold(ref.Name, entry.Name) && ref.Args == nil {
+				if source.Table, source.Query, err = db.planView(entry); err != nil {
+					return nil, nil, err
+				}
+				break
+			}
+		}
+		if function, found := tableFunctions[strings.ToUpper(ref.Name)]; found && ref.Args != nil {
+			if len(ref.Args) < function.MinArgs || len(ref.Args) > function.MaxArgs {
+				return nil, nil, fmt.Errorf("wrong number of arguments to function %s()", strings.ToLower(ref.Name))
+			}
+			source.Function, source.Args = &function, ref.Args
+			source.Table = SchemaEntry{Type: "table", Name: ref.Name, SQL: function.SQL}
+			_, source.Table.Columns, _, _, _ = parseCreateTable(function.SQL)
+			for _, arg := range ref.Args {
+				if err = db.bindExpr(arg, append(slices.Clone(sources), outer...)); err != nil {
+					return nil, nil, err
+				}
+			}
+		} else if ref.Select != nil {
+			// a subquery can't use the tables before it, only the ones of the queries around it
+			if source.Query, err = db.planSubquery(ref.Select, outer); err != nil {
+				return nil, nil, err
+			}
+			source.Table = SchemaEntry{Type: "view", Name: source.Name, WithoutRowid: true, Columns: queryColumns(source.Query.plan.Columns, nil)}
+		} else if source.Table.RootPage == 0 && source.Query == nil && source.Recursive == nil {
+			return nil, nil, fmt.Errorf("no such table: %s", ref.Name)
+		}
+		for i, column := range source.Table.Columns {
+			if columnAffinity(column.Type) == "REAL" {
+				source.Reals = append(source.Reals, i)
+			}
+		}
+		sources = append(sources, source)
+		offset += len(source.Table.Columns) + 1
+
+		conditions := andTerms(ref.On)
+		for _, name := range ref.Using {
+			// the column of USING is compared with the first table before it that has the column
+			left := &Expr{Op: "column", Name: name}
+			for _, previous := range sources[:len(sources)-1] {
+				if bindColumns(&Expr{Op: "column", Table: previous.Name, Name: name}, sources) == nil {
+					left.Table = previous.Name
+					break
+				}
+			}
+			if left.Table == "" {
+				return nil, nil, fmt.Errorf("cannot join using column %s - column not present in both tables", name)
+			}
+			conditions = append(conditions, &Expr{Op: "=", Args: []*Expr{left, {Op: "column", Table: source.Name, Name: name}}})
+		}
+		for _, condition := range conditions {
+			if err = db.bindExpr(condition, append(slices.Clone(sources), outer...)); err != nil {
+				return nil, nil, err
+			}
+		}
+		if source.LeftJoin {
+			sources[len(sources)-1].On = conditions
+		} else {
+			terms = append(terms, conditions...)
+		}
+	}
+	return
+}
+
+// planSelect resolves the tables and columns used by the statement and plans how to read them. The
+// result columns are expanded, and the terms of ORDER BY may use their aliases or positions. A subquery
+// may also use the tables of the queries around it, given by outer.
+func (db *DbContext) planSelect(statement *SelectStatement, outer []tableSource) (*queryPlan, error) {
+	if statement.With != nil {
+		defer db.withTables(statement.With)()
+	}
+	if len(statement.Compound) > 0 {
+		return db.planCompound(statement, outer)
+	}
+	sources, terms, err := db.resolveSources(statement.From, outer)
+	if err != nil {
+		return nil, err
+	}
+	scope := append(slices.Clone(sources), outer...)
+	if err = db.bindExpr(statement.Where, scope); err != nil {
+		return nil, err
+	}
+	if err = misusedWindow(statement.Where); err != nil {
+		return nil, err
+	}
+	if found := findAggregates(statement.Where, nil); len(found) > 0 {
+		return nil, fmt.Errorf("misuse of aggregate function %s()", strings.ToLower(found[0].Name))
+	}
+	terms = append(andTerms(statement.Where), terms...)
+	for _, column := range statement.Columns {
+		if err = resolveWindows(column.Expr, statement.Windows); err != nil {
+			return nil, err
+		}
+	}
+	for _, term := range statement.OrderBy {
+		if err = resolveWindows(term.Expr, statement.Windows); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, column := range statement.Columns {
+		if err = db.planSubqueries(column.Expr, scope); err != nil {
+			return nil, err
+		}
+	}
+	columns, err := expandColumns(statement.Columns, scope)
+	if err != nil {
+		return nil, err
+	}
+	orderBy := []OrderTerm{}
+	for i, term := range statement.OrderBy {
+		expr := skipCollate(term.Expr)
+		if number, isInteger := expr.Value.(int64); expr.Op == "literal" && isInteger {
+			// a number is the position of a result column
+			if number < 1 || int(number) > len(columns) {
+				return nil, fmt.Errorf("%d%s ORDER BY term out of range - should be between 1 and %d", i+1, ordinalSuffix(i+1), len(columns))
+			}
+			expr = columns[number-1].Expr
+		} else if alias := slices.IndexFunc(columns, func(column ResultColumn) bool {
+			return expr.Op == "column" && expr.Table == "" && strings.EqualFold(column.Alias, expr.Name)
+		}); alias >= 0 {
+			expr = columns[alias].Expr
+		} else {
+			expr = term.Expr
+		}
+		if expr != skipCollate(term.Expr) && term.Expr.Op == "COLLATE" {
+			// the result column is sorted with the collation given to its number or alias
+			expr = &Expr{Op: "COLLATE", Name: term.Expr.Name, Args: []*Expr{expr}}
+		}
+		if err = db.bindExpr(expr, scope); err != nil {
+			return nil, err
+		}
+		orderBy = append(orderBy, OrderTerm{Expr: expr, Descending: term.Descending})
+	}
+	groupBy := []*Expr{}
+	for i, expr := range statement.GroupBy {
+		if number, isInteger := skipCollate(expr).Value.(int64); skipCollate(expr).Op == "literal" && isInteger {
+			if number < 1 || int(number) > len(columns) {
+				return nil, fmt.Errorf("%d%s GROUP BY term out of range - should be between 1 and %d", i+1, ordinalSuffix(i+1), len(columns))
+			}
+			if expr.Op == "COLLATE" {
+				expr = &Expr{Op: "COLLATE", Name: expr.Name, Args: []*Expr{columns[number-1].Expr}}
+			} else {
+				expr = columns[number-1].Expr
+			}
+		}
+		if err = db.bindWithAliases(expr, scope, columns); err != nil {
+			return nil, err
+		}
+		if len(findAggregates(expr, nil)) > 0 {
+			return nil, fmt.Errorf("aggregate functions are not allowed in the GROUP BY clause")
+		}
+		groupBy = append(groupBy, expr)
+	}
+	if err = db.bindWithAliases(statement.Having, scope, columns); err != nil {
+		return nil, err
+	}
+	if err = misusedWindow(append(slices.Clone(groupBy), statement.Having)...); err != nil {
+		return nil, err
+	}
+	// like SQLite, the terms of HAVING that have the same value for all the rows of a group filter the rows
+	// before they are grouped, as terms of WHERE
+	having := []*Expr{}
+	for _, term := range andTerms(statement.Having) {
+		if len(groupBy) > 0 && groupTerm(term, groupBy) {
+			terms = append(terms, term)
+		} else {
+			having = append(having, term)
+		}
+	}
+
+	// the columns used by the query, to know which indexes have all of them
+	used := []*Expr{}
+	used = append(used, terms...)
+	for _, column := range columns {
+		used = append(used, column.Expr)
+	}
+	for _, term := range orderBy {
+		used = append(used, term.Expr)
+	}
+	used = append(append(used, groupBy...), having...)
+	for i := range sources {
+		for _, expr := range append(used, sources[i].On...) {
+			sources[i].Needed = appendColumns(sources[i].Needed, expr, sources[i])
+		}
+	}
+
+	aggregates := []*Expr{}
+	windows := []*Expr{}
+	for _, column := range columns {
+		aggregates = findAggregates(column.Expr, aggregates)
+		windows = findWindows(column.Expr, windows)
+	}
+	// the query is an aggregate when its result columns call aggregates, or with GROUP BY, which gives a row
+	// for each group even without them. Only then can ORDER BY and HAVING call them too.
+	aggregated := len(aggregates) > 0 || len(groupBy) > 0
+	if statement.Having != nil && !aggregated {
+		return nil, fmt.Errorf("HAVING clause on a non-aggregate query")
+	}
+	for _, term := range orderBy {
+		if found := findAggregates(term.Expr, nil); len(found) > 0 && !aggregated {
+			return nil, fmt.Errorf("misuse of aggregate: %s()", strings.ToLower(found[0].Name))
+		}
+		aggregates = findAggregates(term.Expr, aggregates)
+		windows = findWindows(term.Expr, windows)
+	}
+	for _, term := range having {
+		aggregates = findAggregates(term, aggregates)
+	}
+	if err = misusedWindow(aggregates...); err != nil {
+		return nil, err
+	}
+	// the arguments and FILTER clause of an aggregate are computed for each row, so they can't call another
+	for _, aggregate := range aggregates {
+		for _, operand := range operands(aggregate) {
+			if found := findAggregates(operand, nil); len(found) > 0 {
+				return nil, fmt.Errorf("misuse of aggregate function %s()", strings.ToLower(found[0].Name))
+			}
+		}
+	}
+	for _, expr := range []*Expr{statement.Limit, statement.Offset} {
+		if err = bindColumns(expr, nil); err != nil {
+			return nil, err
+		}
+	}
+	passes := planWindows(windows)
+	if !aggregated && len(passes) > 0 {
+		// the tables are read in the order of the window computed first
+		plan := db.planJoin(sources, terms, passes[len(passes)-1].order())
+		plan.Columns, plan.OrderBy, plan.Subqueries = columns, orderBy, statementSubqueries(statement)
+		plan.Width = max(plan.Width, scopeWidth(outer))
+		plan.addWindows(passes, plan.Sorted)
+		plan.Sorted = orderPrefix(orderBy, passes[0].order())
+		if statement.Distinct {
+			plan.Distinct = "unordered"
+		}
+		return plan, nil
+	}
+	if !aggregated && statement.Distinct {
+		return db.planDistinct(sources, terms, columns, orderBy, statement, outer), nil
+	}
+	if !aggregated {
+		plan := db.planJoin(sources, terms, orderBy)
+		plan.Columns, plan.OrderBy, plan.Subqueries = columns, orderBy, statementSubqueries(statement)
+		plan.Width = max(plan.Width, scopeWidth(outer))
+		return plan, nil
+	}
+
+	// without GROUP BY, the aggregates give a single row, so it's not sorted. The MIN or MAX of a column is
+	// the first row when reading them in the order of the column, skipping NULLs, and the values of a single
+	// aggregate called with DISTINCT are read in order when possible, like SQLite does, unless the aggregate
+	// has a FILTER clause.
+	single := len(groupBy) == 0 && len(aggregates) == 1 && aggregates[0].Filter == nil && skipCollate(aggregates[0].Args[0]).Op == "column" && len(sources) == 1
+	minMax := single && (aggregates[0].Name == "MIN" || aggregates[0].Name == "MAX")
+	var order []OrderTerm
+	if minMax || single && aggregates[0].Distinct {
+		order = []OrderTerm{{Expr: aggregates[0].Args[0], Descending: aggregates[0].Name == "MAX"}}
+	}
+	var plan *queryPlan
+	if len(groupBy) > 0 {
+		// like SQLite, the groups are found in the order of ORDER BY, or of the window computed first, when it
+		// has the same terms as GROUP BY and the rows can be read in that order, so that it is the order of
+		// the result
+		order = groupOrder(groupBy)
+		resultOrder := orderBy
+		if len(passes) > 0 {
+			resultOrder = passes[len(passes)-1].order()
+		}
+		sameTerms := slices.EqualFunc(resultOrder, groupBy, func(term OrderTerm, expr *Expr) bool { return formatExpr(term.Expr) == formatExpr(expr) })
+		if sameTerms {
+			if plan = db.planJoin(sources, terms, resultOrder); plan.Sorted {
+				order = resultOrder
+			} else {
+				plan = nil
+			}
+		}
+	}
+	if plan == nil {
+		plan = db.planJoin(sources, terms, order)
+	}
+	plan.Columns, plan.Aggregates, plan.MinMax, plan.Subqueries = columns, aggregates, minMax, statementSubqueries(statement)
+	plan.SortedDistinct = single && aggregates[0].Distinct && plan.Sorted
+	plan.Width = max(plan.Width, scopeWidth(outer))
+	for i, aggregate := range aggregates {
+		aggregate.Op, aggregate.Column = "aggregate", plan.Width+i
+	}
+	plan.Width += len(aggregates)
+	if len(groupBy) > 0 {
+		plan.GroupBy, plan.Having, plan.OrderBy = order, having, orderBy
+		plan.GroupSorted, plan.Sorted = plan.Sorted, plan.Sorted && !order[0].Grouping
+	} else {
+		plan.Having, plan.Rows = having, 1
+	}
+	if len(passes) > 0 {
+		// the single row is in the order of any window, and the groups when they are read in its order
+		plan.addWindows(passes, len(groupBy) == 0 || plan.Sorted)
+		if len(groupBy) > 0 {
+			plan.Sorted = orderPrefix(orderBy, passes[0].order())
+		}
+	}
+	if statement.Distinct {
+		plan.Distinct = "unordered"
+	}
+	return plan, nil
+}
+
+// groupOrder is the order the rows of a query with GROUP BY are read in so that the rows of each group are
+// found together, which is the order of the GROUP BY terms in any order and direction
+func groupOrder(groupBy []*Expr) []OrderTerm {
+	order := []OrderTerm{}
+	for _, expr := range groupBy {
+		order = append(order, OrderTerm{Expr: expr, Grouping: true})
+	}
+	return order
+}
+
+// groupTerm tells if the expression only uses the GROUP BY terms compared as binary, so that it has the
+// same value for all the rows of a group
+func groupTerm(expr *Expr, groupBy []*Expr) bool {
+	if slices.ContainsFunc(groupBy, func(term *Expr) bool {
+		return orderCollation(term) == "BINARY" && formatExpr(term) == formatExpr(expr)
+	}) {
+		return true
+	}
+	if expr.Op == "column" || expr.Query != nil || expr.Window != nil || isAggregate(expr) {
+		return false
+	}
+	return !slices.ContainsFunc(operands(expr), func(arg *Expr) bool { return !groupTerm(arg, groupBy) })
+}
+
+// bindWithAliases binds the expression like bindExpr, where the names that are not columns of the tables are
+// the aliases of the result columns, as SQLite allows on GROUP BY and HAVING
+func (db *DbContext) bindWithAliases(expr *Expr, scope []tableSource, columns []ResultColumn) error {
+	var substitute func(expr *Expr)
+	substitute = func(expr *Expr) {
+		if expr == nil {
+			return
+		}
+		if expr.Op == "column" && expr.Table == "" && bindColumns(&Expr{Op: "column", Name: expr.Name}, scope) != nil {
+			if alias := slices.IndexFunc(columns, func(column ResultColumn) bool { return strings.EqualFold(column.Alias, expr.Name) }); alias >= 0 {
+				*expr = *columns[alias].Expr
+				return
+			}
+		}
+		for _, arg := range operands(expr) {
+			substitute(arg)
+		}
+	}
+	substitute(expr)
+	return db.bindExpr(expr, scope)
+}
+
+// planDistinct plans a SELECT DISTINCT. Its rows are distinct anyway when they have the rowid or the
+// primary key of its only table. Otherwise, like SQLite, the tables may be read in the order of the result
+// columns to find the equal rows one after the other, and an ORDER BY sorting the result columns finds them
+// the same way.
+func (db *DbContext) planDistinct(sources []tableSource, terms []*Expr, columns []ResultColumn, orderBy []OrderTerm, statement *SelectStatement, outer []tableSource) *queryPlan {
+	unique := hasUniqueKey(sources, columns)
+	order := orderBy
+	if len(orderBy) == 0 && !unique {
+		for _, column := range columns {
+			order = append(order, OrderTerm{Expr: column.Expr})
+		}
+	}
+	sortsColumns := len(order) == len(columns) && !slices.ContainsFunc(order, func(term OrderTerm) bool {
+		return !slices.ContainsFunc(columns, func(column ResultColumn) bool { return formatExpr(column.Expr) == formatExpr(term.Expr) })
+	})
+	// the rows sorted by ORDER BY have the equal rows one after the other when it sorts the result columns
+	// in their order
+	sortedColumns := len(orderBy) == len(columns)
+	for i, term := range orderBy {
+		sortedColumns = sortedColumns && !term.Descending && formatExpr(term.Expr) == formatExpr(columns[i].Expr)
+	}
+	plan := db.planJoin(sources, terms, order)
+	switch {
+	case unique:
+	case sortsColumns && plan.Sorted || sortedColumns:
+		plan.Distinct = "ordered"
+	default:
+		plan.Distinct = "unordered"
+	}
+	plan.Sorted = plan.Sorted || len(orderBy) == 0
+	plan.Columns, plan.OrderBy, plan.Subqueries = columns, orderBy, statementSubqueries(statement)
+	plan.Width = max(plan.Width, scopeWidth(outer))
+	return plan
+}
+
+// hasUniqueKey tells if the result columns have the rowid of the only table, or all the columns of its
+// primary key for a table without rowid
+func hasUniqueKey(sources []tableSource, columns []ResultColumn) bool {
+	if len(sources) != 1 || sources[0].Table.RootPage == 0 {
+		return false
+	}
+	source := sources[0]
+	isColumn := func(position int) bool {
+		return slices.ContainsFunc(columns, func(column ResultColumn) bool {
+			return column.Expr.Op == "column" && column.Expr.Column == source.Offset+position
+		})
+	}
+	if !source.Table.WithoutRowid {
+		return isColumn(len(source.Table.Columns)) || aliasedRowidColumn(source.Table.Columns) >= 0 && isColumn(aliasedRowidColumn(source.Table.Columns))
+	}
+	for _, key := range source.Table.PrimaryKey {
+		if !isColumn(slices.IndexFunc(source.Table.Columns, func(column ColumnDef) bool { return strings.EqualFold(column.Name, key.Name) })) {
+			return false
+		}
+	}
+	return true
+}
+
+// appendColumns adds the positions of the columns of the table used by the expression
+func appendColumns(positions []int, expr *Expr, source tableSource) []int {
+	for _, column := range exprColumns(expr) {
+		if column >= source.Offset && column <= source.Offset+len(source.Table.Columns) && !slices.Contains(positions, column) {
+			positions = append(positions, column)
+		}
+	}
+	for _, arg := range operands(expr) {
+		positions = appendColumns(positions, arg, source)
+	}
+	return positions
+}
+
+// exprColumns finds the positions of the columns read by the node of an expression, which are the ones of
+// the queries around a subquery used by it
+func exprColumns(expr *Expr) []int {
+	if expr.Op == "column" {
+		return []int{expr.Column}
+	} else if expr.Query != nil {
+		return expr.Query.Outer
+	}
+	return nil
+}
+
+// ordinalSuffix is the suffix used on the English ordinal of a number, like 1st or 2nd
+func ordinalSuffix(number int) string {
+	switch {
+	case number%100 >= 11 && number%100 <= 13:
+		return "th"
+	case number%10 == 1:
+		return "st"
+	case number%10 == 2:
+		return "nd"
+	case number%10 == 3:
+		return "rd"
+	}
+	return "th"
+}
+
+// sourceMask finds the tables used by an expression, with a bit for each position on the FROM clause
+func sourceMask(expr *Expr, sources []tableSource) uint64 {
+	mask := uint64(0)
+	for _, column := range exprColumns(expr) {
+		for number, source := range sources {
+			if column >= source.Offset && column <= source.Offset+len(source.Table.Columns) {
+				mask |= 1 << number
+			}
+		}
+	}
+	for _, arg := range expr.Args {
+		mask |= sourceMask(arg, sources)
+	}
+	return mask
+}
+
+// planJoin chooses the order of the tables and the access path for each one with the lowest estimated
+// cost. Each table costs the cost of its access path for each row estimated for the tables before it.
+// A LEFT JOIN must come after the tables before it on the FROM clause, and the ones after it after it.
+// The first table may be read in the order of the ORDER BY clause to avoid sorting the rows.
+func (db *DbContext) planJoin(sources []tableSource, terms []*Expr, orderBy []OrderTerm) *queryPlan {
+	masks := make([]uint64, len(terms))
+	for i, term := range terms {
+		masks[i] = sourceMask(term, sources)
+	}
+	argMasks := make([]uint64, len(sources))
+	for number, source := range sources {
+		for _, arg := range source.Args {
+			argMasks[number] |= sourceMask(arg, sources)
+		}
+	}
+	canPlace := func(number int, placed uint64) bool {
+		// the arguments of a table-valued function need the tables they use
+		if argMasks[number]&^placed != 0 {
+			return false
+		}
+		for before := 0; before < number; before++ {
+			if placed&(1<<before) == 0 && (sources[number].LeftJoin || sources[before].LeftJoin) {
+				return false
+			}
+		}
+		return true
+	}
+
+	type choice struct {
+		path    accessPath
+		outRows float64
+	}
+	choices := map[[2]uint64][]choice{}
+	choose := func(number int, placed uint64) []choice {
+		key := [2]uint64{uint64(number), placed}
+		if found, ok := choices[key]; ok {
+			return found
+		}
+		available := placed | 1<<number
+		usable := []*Expr{}
+		filters := 0
+		for i, term := range terms {
+			if masks[i]&^available == 0 && masks[i]&(1<<number) != 0 {
+				usable = append(usable, term)
+				filters++
+			}
+		}
+		if sources[number].LeftJoin {
+			usable = sources[number].On
+		}
+		var order []OrderTerm
+		if placed == 0 {
+			order = orderBy
+		}
+		paths := []accessPath{db.bestAccessPath(sources, number, usable, placed, order)}
+		// building an index is only worth it when the table is read many times, which is decided by the
+		// cost of the whole join
+		if path, ok := db.automaticIndexPath(sources, number, usable, placed); ok && len(order) == 0 {
+			paths = append(paths, path)
+		}
+		found := []choice{}
+		for _, path := range paths {
+			pathFilters := filters
+			for _, part := range path.Parts {
+				for _, term := range part.Terms {
+					if slices.Contains(usable, term) && !sources[number].LeftJoin {
+						pathFilters--
+					}
+				}
+			}
+			// each term that is only checked after reading the row is guessed to keep a quarter of them
+			outRows := path.Rows * math.Pow(0.25, float64(max(pathFilters, 0)))
+			if sources[number].LeftJoin {
+				outRows = max(outRows, 1)
+			}
+			found = append(found, choice{path, outRows})
+		}
+		choices[key] = found
+		return found
+	}
+
+	var best *queryPlan
+	order, paths := []int{}, []accessPath{}
+	var search func(placed uint64, outerRows, cost float64)
+	search = func(placed uint64, outerRows, cost float64) {
+		if best != nil && cost >= best.Cost {
+			return
+		}
+		if len(order) == len(sources) {
+			best = &queryPlan{Sources: sources, Cost: cost, Rows: outerRows}
+			for i, number := range order {
+				best.Steps = append(best.Steps, planStep{Source: number, Path: paths[i]})
+			}
+			return
+		}
+		for number := range sources {
+			if placed&(1<<number) != 0 || !canPlace(number, placed) {
+				continue
+			}
+			for _, found := range choose(number, placed) {
+				order, paths = append(order, number), append(paths, found.path)
+				search(placed|1<<number, outerRows*found.outRows, cost+found.path.Setup+outerRows*found.path.Cost)
+				order, paths = order[:len(order)-1], paths[:len(paths)-1]
+			}
+		}
+	}
+	search(0, 1, 0)
+	best.Sorted = len(orderBy) == 0 || len(best.Steps) == 0 || best.Steps[0].Path.Ordered
+
+	// each term is checked on the first step where all the tables it uses were read
+	placed := uint64(0)
+	for i := range best.Steps {
+		step := &best.Steps[i]
+		before := placed
+		placed |= 1 << step.Source
+		step.On = sources[step.Source].On
+		for j, term := range terms {
+			if masks[j]&^placed == 0 && (i == 0 || masks[j]&^before != 0) {
+				step.Filters = append(step.Filters, term)
+			}
+		}
+	}
+	if len(sources) == 0 {
+		best.Filters = terms
+	}
+	for _, source := range sources {
+		best.Width = max(best.Width, source.Offset+len(source.Table.Columns)+1)
+	}
+	return best
+}
+
+// bestAccessPath estimates the cost of reading a table by its rowid, primary key or indexes, using the
+// terms that compare its columns with values known before reading it, and compares them with a full scan.
+// When the rows must be sorted, the paths that read them in order avoid the cost of sorting.
+func (db *DbContext) bestAccessPath(sources []tableSource, number int, terms []*Expr, placed uint64, orderBy []OrderTerm) accessPath {
+	source := sources[number]
+	if source.Function != nil {
+		// like SQLite, a table-valued function is guessed to give 25 rows
+		path := accessPath{Kind: "function", Rows: 25, Cost: 25}
+		if len(orderBy) > 0 {
+			path.Cost += 2 * path.Rows * (math.Log2(path.Rows+1) + 1)
+		}
+		return path
+	}
+	if source.Recursive != nil {
+		return accessPath{Kind: "query", Rows: 1, Cost: 1}
+	}
+	if source.Query != nil {
+		// the rows of a view cost what its query costs, and are read from memory when read again
+		path := accessPath{Kind: "query", Rows: max(source.Query.plan.Rows, 1)}
+		path.Cost = source.Query.plan.Cost + path.Rows
+		if len(orderBy) > 0 {
+			path.Cost += 2 * path.Rows * (math.Log2(path.Rows+1) + 1)
+		}
+		return path
+	}
+	table := source.Table
+	usable := func(expr *Expr) bool {
+		return sourceMask(expr, sources)&^placed == 0
+	}
+	stats := db.statistics()
+	tableRows := db.tableRows(table)
+	seekCost := math.Log2(tableRows+1) + 1
+	tableWidth := rowWidth(table.Columns)
+
+	var best accessPath
+	consider := func(path accessPath, unique bool) {
+		path.Rows = tableRows
+		if len(path.Parts) > 0 {
+			path.Rows = db.estimateRows(path, stats[strings.ToLower(path.Index)], tableRows, unique)
+			seeks := 1.0
+			for _, part := range path.Parts {
+				if part.Equality {
+					seeks *= float64(part.Choices)
+				}
+			}
+			path.Cost = seeks * seekCost
+		}
+		switch {
+		case path.Kind == "index" && path.Covering:
+			// a smaller index has more keys on each page
+			path.Cost += path.Rows * rowWidth(orderColumns(path.Order, source)) / tableWidth
+		case path.Kind == "index":
+			// each row found on the index is then searched on the table
+			path.Cost += path.Rows + path.Rows*seekCost
+		default:
+			path.Cost += path.Rows
+		}
+		if len(orderBy) > 0 {
+			path.Ordered, path.Reverse = path.providesOrder(orderBy)
+			if !path.Ordered {
+				// sorting writes each row to a temporary b-tree and reads them back
+				path.Cost += 2 * path.Rows * (math.Log2(path.Rows+1) + 1)
+			}
+		}
+		if best.Kind == "" || path.Cost < best.Cost {
+			best = path
+		}
+	}
+
+	rowidOrder := []orderKey{}
+	if !table.WithoutRowid {
+		rowidColumns := []int{source.Offset + len(table.Columns)}
+		if aliasedPKColumnNumber := aliasedRowidColumn(table.Columns); aliasedPKColumnNumber >= 0 {
+			rowidColumns = append(rowidColumns, source.Offset+aliasedPKColumnNumber)
+		}
+		rowidOrder = append(rowidOrder, orderKey{Columns: rowidColumns, Collation: "BINARY"})
+		consider(accessPath{Kind: "scan", Order: rowidOrder}, false)
+		if part, ok := newKeyPart(keyPart{Name: "rowid", Columns: rowidColumns, Affinity: "INTEGER"}, terms, usable); ok {
+			consider(accessPath{Kind: "rowid", Parts: []keyPart{part}, Order: rowidOrder}, true)
+		}
+	}
+	indexPath := func(kind, name string, page int, indexColumns []ColumnDef) (accessPath, bool) {
+		path := accessPath{Kind: kind, Index: name, IndexPage: page, KeyColumns: indexKeyColumns(table.Columns, indexColumns)}
+		// the key is followed by the rowid, or by the columns of the primary key not found on the index
+		path.Order = slices.Clone(rowidOrder)
+		for i := len(table.PrimaryKey) - 1; i >= 0 && table.WithoutRowid; i-- {
+			if !slices.ContainsFunc(indexColumns, func(column ColumnDef) bool { return strings.EqualFold(column.Name, table.PrimaryKey[i].Name) }) {
+				path.Order = append(keyOrder(source, table.PrimaryKey[i:i+1], indexKeyColumns(table.Columns, table.PrimaryKey[i:i+1])), path.Order...)
+			}
+		}
+		path.Order = append(keyOrder(source, indexColumns, path.KeyColumns), path.Order...)
+		// like SQLite, the values of virtual columns are not taken from indexes
+		path.Covering = true
+		for _, position := range source.Needed {
+			path.Covering = path.Covering && slices.ContainsFunc(path.Order, func(key orderKey) bool { return slices.Contains(key.Columns, position) }) &&
+				!(position-source.Offset < len(table.Columns) && table.Columns[position-source.Offset].isVirtual())
+		}
+		for i, indexColumn := range indexColumns {
+			columnNumber := slices.IndexFunc(table.Columns, func(column ColumnDef) bool {
+				return strings.EqualFold(column.Name, indexColumn.Name)
+			})
+			if columnNumber == -1 {
+				break
+			}
+			part, ok := newKeyPart(keyPart{
+				Name:      table.Columns[columnNumber].Name,
+				Columns:   []int{source.Offset + columnNumber},
+				Affinity:  columnAffinity(table.Columns[columnNumber].Type),
+				Collation: path.KeyColumns[i].Collation,
+			}, terms, usable)
+			if !ok {
+				break
+			}
+			path.Parts = append(path.Parts, part)
+			if !part.Equality {
+				break
+			}
+		}
+		unique := len(path.Parts) > 0 && len(path.Parts) == len(indexColumns) && path.Parts[len(path.Parts)-1].Equality
+		return path, unique
+	}
+	if table.WithoutRowid {
+		// the table itself is stored as an index on the primary key
+		path, unique := indexPath("pk", table.Name, table.RootPage, table.PrimaryKey)
+		consider(accessPath{Kind: "scan", Order: path.Order}, false)
+		if len(path.Parts) > 0 {
+			consider(path, unique)
+		}
+	}
+	for _, entry := range db.Schema {
+		// the columns of an index are unknown when its SQL can't be read, and a partial index only has the
+		// rows of the query when the query only wants rows matching its WHERE clause
+		if entry.Type == "index" && strings.EqualFold(entry.TableName, table.Name) && len(entry.Columns) > 0 &&
+			(entry.Where == nil || impliesAll(append(slices.Clone(terms), source.On...), entry.Where, source)) {
+			path, unique := indexPath("index", entry.Name, entry.RootPage, entry.Columns)
+			// reading all of an index is only useful when it has all the columns or the order needed
+			if ordered, _ := path.providesOrder(orderBy); len(path.Parts) > 0 || path.Covering || (len(orderBy) > 0 && ordered) {
+				consider(path, unique && strings.HasPrefix(strings.ToUpper(entry.SQL), "CREATE UNIQUE") || unique && entry.SQL == "")
+			}
+		}
+	}
+	return best
+}
+
+// tableRows guesses the number of rows of a table from the statistics of the table or its indexes, or
+// from the size of its b-tree
+func (db *DbContext) tableRows(table SchemaEntry) float64 {
+	stats := db.statistics()
+	tableRows := 0.0
+	if tableStats := stats[strings.ToLower(table.Name)]; tableStats != nil {
+		tableRows = tableStats.Rows
+	}
+	for _, entry := range db.Schema {
+		if indexStats := stats[strings.ToLower(entry.Name)]; entry.Type == "index" && indexStats != nil && strings.EqualFold(entry.TableName, table.Name) {
+			tableRows = max(tableRows, indexStats.Rows)
+		}
+	}
+	if tableRows == 0 {
+		tableRows = db.estimateTableRows(table.RootPage)
+	}
+	return tableRows
+}
+
+// automaticIndexPath finds the columns of a table compared for equality with the values of the tables read
+// before it, to search them on an automatic index. Like SQLite, building the index costs 7 times a sort of
+// the rows of the table, and each search is guessed to find 20 rows, as nothing is known about the values.
+func (db *DbContext) automaticIndexPath(sources []tableSource, number int, terms []*Expr, placed uint64) (accessPath, bool) {
+	source := sources[number]
+	if placed == 0 || source.Function != nil || source.Recursive != nil || source.Query != nil || source.Table.WithoutRowid {
+		return accessPath{}, false
+	}
+	usable := func(expr *Expr) bool {
+		return sourceMask(expr, sources)&^placed == 0
+	}
+	table := source.Table
+	// like SQLite, an index of the table that can be searched for a value is always better
+	for _, entry := range db.Schema {
+		if entry.Type != "index" || !strings.EqualFold(entry.TableName, table.Name) || len(entry.Columns) == 0 || entry.Where != nil {
+			continue
+		}
+		columnNumber := slices.IndexFunc(table.Columns, func(column ColumnDef) bool { return strings.EqualFold(column.Name, entry.Columns[0].Name) })
+		if columnNumber < 0 {
+			continue
+		}
+		if part, ok := newKeyPart(keyPart{
+			Columns:   []int{source.Offset + columnNumber},
+			Affinity:  columnAffinity(table.Columns[columnNumber].Type),
+			Collation: indexKeyColumns(table.Columns, entry.Columns)[0].Collation,
+		}, terms, usable); ok && part.Equality {
+			return accessPath{}, false
+		}
+	}
+
+	path := accessPath{Kind: "automatic", Automatic: &automaticIndex{}}
+	joined := false
+	for columnNumber, column := range table.Columns {
+		if columnNumber == aliasedRowidColumn(table.Columns) {
+			// the rowid is already searched on the table b-tree
+			continue
+		}
+		// the keys are sorted with the collation of the column, or the one of a comparison with it
+		collations := []string{indexKeyColumns(table.Columns, []ColumnDef{column})[0].Collation}
+		for _, term := range terms {
+			if len(term.Args) == 2 {
+				collations = append(collations, comparisonCollation(term.Args[0], term.Args[1]))
+			}
+		}
+		var part keyPart
+		for _, collation := range collations {
+			part = keyPart{
+				Name:      column.Name,
+				Columns:   []int{source.Offset + columnNumber},
+				Affinity:  columnAffinity(column.Type),
+				Collation: collation,
+			}
+			if part, _ = newKeyPart(part, terms, usable); part.Equality {
+				break
+			}
+		}
+		if !part.Equality {
+			continue
+		}
+		for _, term := range part.Terms {
+			joined = joined || sourceMask(term, sources)&placed != 0
+		}
+		path.Parts = append(path.Parts, part)
+		path.KeyColumns = append(path.KeyColumns, KeyColumn{Collation: part.Collation})
+		path.Automatic.Columns = append(path.Automatic.Columns, columnNumber)
+	}
+	if !joined {
+		return accessPath{}, false
+	}
+	tableRows := db.tableRows(table)
+	path.Rows = 20
+	path.Cost = math.Log2(tableRows+1) + path.Rows
+	path.Setup = 7 * tableRows * math.Log2(tableRows+1)
+	return path, true
+}
+
+// impliesAll tells if the terms being true make the WHERE clause of a partial index true: like SQLite, each
+// part of the clause joined by AND must be implied by one of the terms
+func impliesAll(terms []*Expr, where *Expr, source tableSource) bool {
+	for _, part := range andTerms(where) {
+		if !slices.ContainsFunc(terms, func(term *Expr) bool { return implies(term, part, source) }) {
+			return false
+		}
+	}
+	return true
+}
+
+// implies tells if the term being true makes the expression on the columns of the table true, when they
+// are the same expression, when one side of an OR is implied, or when the expression is "x IS NOT NULL"
+// and the term compares x, as a comparison with NULL is never true
+func implies(term, expr *Expr, source tableSource) bool {
+	switch {
+	case sameIndexExpr(term, expr, source):
+		return true
+	case expr.Op == "OR":
+		return implies(term, expr.Args[0], source) || implies(term, expr.Args[1], source)
+	case expr.Op == "ISNULL" && expr.Not:
+		switch term.Op {
+		case "=", "<>", "<", "<=", ">", ">=", "BETWEEN", "LIKE", "GLOB", "REGEXP":
+		case "IN":
+			// NULL NOT IN () is true
+			if term.Not {
+				return false
+			}
+		default:
+			return false
+		}
+		return slices.ContainsFunc(term.Args, func(arg *Expr) bool { return sameIndexExpr(arg, expr.Args[0], source) })
+	}
+	return false
+}
+
+// sameIndexExpr tells if a bound expression of the query is the same as an expression of a partial index,
+// whose columns are the ones of the table with the name
+func sameIndexExpr(bound, expr *Expr, source tableSource) bool {
+	if bound.Op != expr.Op || bound.Not != expr.Not || len(bound.Args) != len(expr.Args) || bound.Query != nil || expr.Select != nil {
+		return false
+	}
+	switch expr.Op {
+	case "column":
+		columnNumber := slices.IndexFunc(source.Table.Columns, func(column ColumnDef) bool { return strings.EqualFold(column.Name, expr.Name) })
+		return columnNumber >= 0 && bound.Column == source.Offset+columnNumber
+	case "literal":
+		return quoteLiteral(bound.Value) == quoteLiteral(expr.Value)
+	case "function", "COLLATE", "CAST":
+		if !strings.EqualFold(bound.Name, expr.Name) {
+			return false
+		}
+	}
+	for i := range expr.Args {
+		if !sameIndexExpr(bound.Args[i], expr.Args[i], source) {
+			return false
+		}
+	}
+	return true
+}
+
+// keyOrder finds the positions of the columns of a key on the rows of the join
+func keyOrder(source tableSource, keyColumns []ColumnDef, sortOrders []KeyColumn) []orderKey {
+	order := []orderKey{}
+	for i, keyColumn := range keyColumns {
+		columnNumber := slices.IndexFunc(source.Table.Columns, func(column ColumnDef) bool {
+			return strings.EqualFold(column.Name, keyColumn.Name)
+		})
+		if columnNumber == -1 {
+			break
+		}
+		order = append(order, orderKey{Columns: []int{source.Offset + columnNumber}, Descending: sortOrders[i].Descending, Collation: sortOrders[i].Collation})
+	}
+	return order
+}
+
+// orderColumns finds the table columns stored on the keys, leaving out the rowid
+func orderColumns(order []orderKey, source tableSource) []ColumnDef {
+	columns := []ColumnDef{}
+	for _, key := range order {
+		for _, position := range key.Columns {
+			if position-source.Offset < len(source.Table.Columns) {
+				columns = append(columns, source.Table.Columns[position-source.Offset])
+				break
+			}
+		}
+	}
+	return columns
+}
+
+// providesOrder tells if the rows are read in the order of the ORDER BY clause, or in the opposite order
+// when reverse is returned. The key columns that have a single value are not considered, as they don't
+// change the order of the rows, and neither is the direction of the Grouping terms.
+func (path accessPath) providesOrder(orderBy []OrderTerm) (ordered bool, reverse bool) {
+	fixed := func(k int) bool {
+		return k < len(path.Parts) && path.Parts[k].Equality && path.Parts[k].Choices == 1
+	}
+	keyOf := func(term OrderTerm) int {
+		if skipCollate(term.Expr).Op != "column" {
+			return -1
+		}
+		position := skipCollate(term.Expr).Column
+		return slices.IndexFunc(path.Order, func(key orderKey) bool { return slices.Contains(key.Columns, position) })
+	}
+	orderBy = slices.Clone(orderBy)
+	k := 0
+	directed := false
+	for i, term := range orderBy {
+		if term.Grouping {
+			// the Grouping terms are last, and can be in any order, so the one of the next key is taken first
+			for ; k < len(path.Order) && fixed(k); k++ {
+			}
+			if j := slices.IndexFunc(orderBy[i:], func(term OrderTerm) bool { return keyOf(term) == k }); j > 0 {
+				orderBy[i], orderBy[i+j] = orderBy[i+j], orderBy[i]
+				term = orderBy[i]
+			}
+		}
+		if skipCollate(term.Expr).Op != "column" {
+			return false, false
+		}
+		key := keyOf(term)
+		if key >= 0 && fixed(key) && strings.EqualFold(path.Order[key].Collation, orderCollation(term.Expr)) {
+			continue
+		}
+		for ; k < len(path.Order) && fixed(k); k++ {
+		}
+		if k == len(path.Order) || k != key || !strings.EqualFold(path.Order[k].Collation, orderCollation(term.Expr)) {
+			return false, false
+		}
+		// the first term with a direction decides it, all the others must follow it
+		if !term.Grouping {
+			if !directed {
+				reverse, directed = path.Order[k].Descending != term.Descending, true
+			} else if reverse != (path.Order[k].Descending != term.Descending) {
+				return false, false
+			}
+		}
+		k++
+	}
+	return true, reverse
+}
+
+// rowWidth guesses the size of a row with the columns, counting integers as 1, reals as 2 and others as 4,
+// plus 1 for the rowid
+func rowWidth(columns []ColumnDef) float64 {
+	width := 1.0
+	for _, column := range columns {
+		switch columnAffinity(column.Type) {
+		case "INTEGER":
+			width++
+		case "REAL":
+			width += 2
+		default:
+			width += 4
+		}
+	}
+	return width
+}
+
+// newKeyPart finds the terms that limit the values of a column of a key, and how they limit it
+func newKeyPart(part keyPart, terms []*Expr, usable func(*Expr) bool) (keyPart, bool) {
+	part.Choices = 1
+	for _, term := range terms {
+		op, values, ok := keyConstraint(term, part, usable)
+		if !ok {
+			continue
+		}
+		part.Terms = append(part.Terms, term)
+		switch op {
+		case "=", "IS":
+			part.Equality, part.Choices = true, 1
+		case "IN":
+			if !part.Equality && term.Query != nil {
+				// like SQLite, a subquery is guessed to give 25 values
+				part.Equality, part.Choices = true, 25
+			} else if !part.Equality {
+				part.Equality, part.Choices = true, len(values)
+			}
+		case ">", ">=":
+			part.Lower = true
+		case "<", "<=":
+			part.Upper = true
+		case "BETWEEN", "LIKE", "GLOB":
+			part.Lower, part.Upper = true, true
+		}
+	}
+	return part, len(part.Terms) > 0
+}
+
+// estimateRows guesses the number of rows found by the access path. Without statistics, each value of
+// an index is guessed to have 10 rows, halved for each of its other columns, and a range keeps a quarter
+// of the rows for each of its bounds. The samples of sqlite_stat4 are used when the values are known.
+func (db *DbContext) estimateRows(path accessPath, stats *indexStats, tableRows float64, unique bool) float64 {
+	equalities, choices := 0, 1.0
+	for _, part := range path.Parts {
+		if part.Equality {
+			equalities++
+			choices *= float64(part.Choices)
+		}
+	}
+	var keyRanges []KeyRange
+	constant := true
+	for _, part := range path.Parts {
+		for _, term := range part.Terms {
+			_, _, ok := keyConstraint(term, part, isConstant)
+			constant = constant && ok
+		}
+	}
+	if constant && stats != nil && len(stats.Samples) > 0 {
+		keyRanges, _ = path.keyRanges(nil)
+	}
+
+	rows := tableRows
+	if equalities > 0 {
+		perKey := 10 / math.Pow(2, float64(equalities-1))
+		if unique && equalities == len(path.Parts) {
+			perKey = 1
+		} else if path.Kind == "rowid" {
+			perKey = 1
+		} else if stats != nil && len(stats.EqRows) >= equalities {
+			perKey = stats.EqRows[equalities-1]
+		}
+		rows = min(perKey, tableRows) * choices
+		if keyRanges != nil && equalities == len(path.Parts) {
+			rows = 0
+			for _, keyRange := range keyRanges {
+				rows += stats.sampleRows(keyRange.Low, path.KeyColumns, perKey)
+			}
+		}
+	}
+	if last := path.Parts[len(path.Parts)-1]; !last.Equality {
+		if keyRanges != nil && equalities == 0 && !path.KeyColumns[0].Descending {
+			rows = 0
+			for _, keyRange := range keyRanges {
+				rows += stats.rowsBelow(keyRange.High, !keyRange.HighInclusive, path.KeyColumns, tableRows) -
+					stats.rowsBelow(keyRange.Low, keyRange.LowInclusive, path.KeyColumns, tableRows)
+			}
+		} else {
+			if last.Lower {
+				rows /= 4
+			}
+			if last.Upper {
+				rows /= 4
+			}
+		}
+	}
+	return max(rows, 1)
+}
+
+// hasColumns tells if the expression reads any column
+func hasColumns(expr *Expr) bool {
+	return expr.Op == "column" || slices.ContainsFunc(expr.Args, hasColumns)
+}
+
+// isConstant tells if the value of the expression is known when planning, without columns or parameters
+func isConstant(expr *Expr) bool {
+	return expr.Op != "column" && expr.Op != "parameter" && expr.Select == nil && !slices.ContainsFunc(expr.Args, func(arg *Expr) bool { return !isConstant(arg) })
+}
+
+// usesColumns tells if the expression reads any of the columns
+func usesColumns(expr *Expr, columns []int) bool {
+	if slices.ContainsFunc(exprColumns(expr), func(column int) bool { return slices.Contains(columns, column) }) {
+		return true
+	}
+	return slices.ContainsFunc(expr.Args, func(arg *Expr) bool { return usesColumns(arg, columns) })
+}
+
+// sampleRows finds the rows for a key on the samples, or uses the average when the key wasn't sampled
+func (stats *indexStats) sampleRows(key []any, keyColumns []KeyColumn, average float64) float64 {
+	for _, sample := range stats.Samples {
+		if len(sample.Key) >= len(key) && len(sample.EqRows) >= len(key) && compareKeys(sample.Key[:len(key)], key, keyColumns) == 0 {
+			return sample.EqRows[len(key)-1]
+		}
+	}
+	return average
+}
+
+// rowsBelow estimates the rows with the first column of the key smaller than the bound, or also equal to
+// it when inclusive, from the first sample that is not smaller than the bound. A nil bound is after all rows.
+func (stats *indexStats) rowsBelow(bound []any, inclusive bool, keyColumns []KeyColumn, tableRows float64) float64 {
+	if bound == nil {
+		if inclusive {
+			return 0
+		}
+		return tableRows
+	}
+	for _, sample := range stats.Samples {
+		if len(sample.Key) == 0 || len(sample.LtRows) == 0 {
+			continue
+		}
+		comparison := compareKeys(sample.Key[:1], bound[:1], keyColumns)
+		if comparison == 0 && inclusive {
+			return sample.LtRows[0] + sample.EqRows[0]
+		} else if comparison >= 0 {
+			return sample.LtRows[0]
+		}
+	}
+	return tableRows
+}
+
+// keyRanges finds the ranges of keys to search, using equality on the first parts of the key and then a
+// range on the last one. The values compared with the columns are taken from the row of the join.
+func (path accessPath) keyRanges(row []any) ([]KeyRange, error) {
+	keyRanges := []KeyRange{{LowInclusive: true, HighInclusive: true}}
+	for i, part := range path.Parts {
+		valueRanges, err := columnRanges(part, row)
+		if err != nil {
+			return nil, err
+		}
+		if path.KeyColumns != nil && path.KeyColumns[i].Descending {
+			valueRanges = reverseRanges(valueRanges)
+		}
+		extended := []KeyRange{}
+		for _, prefix := range keyRanges {
+			for _, valueRange := range valueRanges {
+				keyRange := prefix
+				if valueRange.Low != nil {
+					keyRange.Low, keyRange.LowInclusive = append(slices.Clone(prefix.Low), valueRange.Low...), valueRange.LowInclusive
+				}
+				if valueRange.High != nil {
+					keyRange.High, keyRange.HighInclusive = append(slices.Clone(prefix.High), valueRange.High...), valueRange.HighInclusive
+				}
+				extended = append(extended, keyRange)
+			}
+		}
+		keyRanges = extended
+	}
+	return keyRanges, nil
+}
+
+// errStopPlan is returned by the visit function of runPlan when no more rows are needed
+var errStopPlan = errors.New("stop reading rows")
+
+// runPlan reads the rows of the join, calling visit with each one that matches all the terms, until visit
+// returns an error. Returning errStopPlan stops reading without an error. The rows of a subquery start
+// with the values of the row of the queries around it, given by outer.
+func (db *DbContext) runPlan(plan *queryPlan, outer []any, visit func(row []any) error) error {
+	row := make([]any, plan.Width)
+	copy(row, outer)
+	match, err := allTrue(plan.Filters, row)
+	if err == nil && match {
+		err = db.runStep(plan, 0, row, visit)
+	}
+	if err == errStopPlan {
+		return nil
+	}
+	return err
+}
+
+func (db *DbContext) runStep(plan *queryPlan, level int, row []any, visit func(row []any) error) error {
+	if level == len(plan.Steps) {
+		return visit(row)
+	}
+	step := plan.Steps[level]
+	source := plan.Sources[step.Source]
+	path := step.Path
+	var err error
+	if path.KeyRanges, err = path.keyRanges(row); err != nil {
+		return err
+	}
+	matched := false
+	visitRecord := func(record TableRecord) bool {
+		source.fill(row, &record)
+		var match bool
+		if match, err = allTrue(step.On, row); err != nil || !match {
+			return err == nil
+		}
+		matched = true
+		if match, err = allTrue(step.Filters, row); err == nil && match {
+			err = db.runStep(plan, level+1, row, visit)
+		}
+		return err == nil
+	}
+	if source.Function != nil {
+		// the arguments are evaluated like the ones of JSON functions, as the JSON functions are the only
+		// table-valued ones
+		args := make([]any, len(source.Args))
+		for i, arg := range source.Args {
+			if args[i], err = evalJSON(arg, row); err != nil {
+				return err
+			}
+		}
+		if functionErr := source.Function.Rows(args, visitRecord); err == nil {
+			err = functionErr
+		}
+	} else if source.Recursive != nil {
+		visitRecord(TableRecord{Columns: source.Recursive.current})
+	} else if source.Query != nil {
+		// the first table is read once, the others are read again for each row before them
+		if queryErr := source.Query.visit(level > 0, row, visitRecord); err == nil {
+			err = queryErr
+		}
+	} else {
+		db.visitRows(source.Table, path, visitRecord)
+	}
+	if err != nil {
+		return err
+	}
+	if source.LeftJoin && !matched {
+		source.fill(row, nil)
+		if match, err := allTrue(step.Filters, row); err != nil {
+			return err
+		} else if match {
+			return db.runStep(plan, level+1, row, visit)
+		}
+	}
+	return nil
+}
+
+// allTrue tells if all the terms are true for the row. NULL is not true.
+func allTrue(terms []*Expr, row []any) (bool, error) {
+	for _, term := range terms {
+		value, err := evalExpr(term, row)
+		if err != nil {
+			return false, err
+		}
+		if value == nil || !isTrue(value) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// writeQueryPlan writes the steps of the plan in the format of EXPLAIN QUERY PLAN
+func writeQueryPlan(writer io.Writer, plan *queryPlan) {
+	fmt.Fprintln(writer, "QUERY PLAN")
+	writePlanLines(writer, plan, "", map[*subquery]bool{})
+}
+
+// writePlanLines writes the lines of a plan as the branches of a tree, starting with the queries of the
+// views and subqueries read like tables, and with the subqueries of the expressions after the tables. The
+// lines of a query have the lines of its own plan under them. The tables of WITH clauses are written
+// where they are first read, as written has the ones already written.
+func writePlanLines(writer io.Writer, plan *queryPlan, indent string, written map[*subquery]bool) {
+	lines := []string{}
+	queries := map[int]*subquery{}
+	for i, step := range plan.Steps {
+		if source := plan.Sources[step.Source]; source.Query != nil && !written[source.Query] {
+			// the first table gives the rows as they are computed, the others keep them to read them again
+			kind := "MATERIALIZE "
+			if i == 0 && (source.Query.Table == nil || source.Query.Table.reads == 1) {
+				kind = "CO-ROUTINE "
+			}
+			queries[len(lines)] = source.Query
+			written[source.Query] = true
+			lines = append(lines, kind+source.Table.Name)
+		}
+	}
+	// the values of the aggregates called with DISTINCT are kept on a b-tree, unless they are read in order
+	distinctLines := []string{}
+	for _, aggregate := range plan.Aggregates {
+		if aggregate.Distinct && !plan.SortedDistinct {
+			distinctLines = append(distinctLines, fmt.Sprintf("USE TEMP B-TREE FOR %s(DISTINCT)", strings.ToLower(aggregate.Name)))
+		}
+	}
+	if len(plan.GroupBy) == 0 {
+		lines = append(lines, distinctLines...)
+	}
+	for _, step := range plan.Steps {
+		line := step.describe(plan.Sources[step.Source])
+		if plan.MinMax && len(step.Path.Parts) == 0 {
+			// the b-tree is searched for the first or last key, even when it must be read entirely
+			line = strings.Replace(line, "SCAN", "SEARCH", 1)
+			if step.Path.Kind == "scan" && plan.Sources[step.Source].Table.WithoutRowid {
+				line += " USING PRIMARY KEY"
+			}
+		}
+		if step.Path.Kind == "automatic" && slices.ContainsFunc(step.Path.Parts, func(part keyPart) bool { return part.Affinity != "TEXT" }) {
+			// SQLite also puts the keys of an automatic index on a bloom filter, to skip the searches of keys
+			// that are not found, which is shown on the plan even though the keys are searched directly here.
+			// All texts have the same hash on its filters, so it is only used when a column may have numbers.
+			lines = append(lines, fmt.Sprintf("BLOOM FILTER ON %s (%s)", plan.Sources[step.Source].Name, strings.Join(step.Path.constraints(), " AND ")))
+		}
+		lines = append(lines, line)
+	}
+	if len(plan.GroupBy) > 0 && !plan.GroupSorted {
+		lines = append(lines, "USE TEMP B-TREE FOR GROUP BY")
+	}
+	if len(plan.GroupBy) > 0 {
+		lines = append(lines, distinctLines...)
+	}
+	if len(plan.Compound) > 0 {
+		writeCompoundLines(writer, plan, indent, written)
+		return
+	}
+	if len(plan.Sources) == 0 {
+		lines = append(lines, "SCAN CONSTANT ROW")
+	}
+	var steps []string
+	var stepQueries map[int]*subquery
+	if len(plan.Windows) > 0 {
+		// the rows of the tables are read by the co-routine of the first window
+		steps, lines = lines, []string{"CO-ROUTINE (subquery-2)", "SCAN (subquery-2)"}
+		stepQueries, queries = queries, map[int]*subquery{}
+	}
+	for i, query := range plan.Subqueries {
+		line := fmt.Sprintf("%s SUBQUERY %d", query.Kind, i+1)
+		if len(query.Outer) > 0 {
+			line = "CORRELATED " + line
+		}
+		queries[len(lines)] = query
+		lines = append(lines, line)
+	}
+	// the rows sorted in the order of the result columns are made distinct as they are sorted
+	if plan.Distinct == "unordered" || plan.Distinct == "ordered" && !plan.Sorted {
+		lines = append(lines, "USE TEMP B-TREE FOR DISTINCT")
+	}
+	if len(plan.OrderBy) > 0 && !plan.Sorted && plan.Distinct != "ordered" {
+		lines = append(lines, "USE TEMP B-TREE FOR ORDER BY")
+	}
+	writeBranches(writer, lines, indent, func(i int, indent string) {
+		if len(plan.Windows) > 0 && i == 0 {
+			writeWindowLines(writer, plan, 0, steps, stepQueries, indent, written)
+		} else if query := queries[i]; query != nil {
+			writeQueryLines(writer, query, indent, written)
+		}
+	})
+}
+
+// writeWindowLines writes the lines of the co-routine giving the rows sorted for a window, like SQLite
+// does. The rows of the last window are read from the tables, and the rows of each other window are read
+// from the co-routine of the next one.
+func writeWindowLines(writer io.Writer, plan *queryPlan, pass int, steps []string, queries map[int]*subquery, indent string, written map[*subquery]bool) {
+	lines := slices.Clone(steps)
+	last := pass == len(plan.Windows)-1
+	if !last {
+		name := fmt.Sprintf("(subquery-%d)", pass+3)
+		lines = []string{"CO-ROUTINE " + name, "SCAN " + name}
+	}
+	if !plan.Windows[pass].Sorted {
+		lines = append(lines, "USE TEMP B-TREE FOR ORDER BY")
+	}
+	writeBranches(writer, lines, indent, func(i int, indent string) {
+		if !last && i == 0 {
+			writeWindowLines(writer, plan, pass+1, steps, queries, indent, written)
+		} else if query := queries[i]; last && query != nil {
+			writeQueryLines(writer, query, indent, written)
+		}
+	})
+}
+
+// writeQueryLines writes the lines of the plan of a query. A table of a WITH clause with a second SELECT
+// has the plans of both SELECTs under the lines telling how they are used.
+func writeQueryLines(writer io.Writer, query *subquery, indent string, written map[*subquery]bool) {
+	table := query.Table
+	if table == nil || table.step == nil {
+		writePlanLines(writer, query.plan, indent, written)
+		return
+	}
+	plans := []*queryPlan{query.plan, table.step.plan}
+	writeBranches(writer, []string{"SETUP", "RECURSIVE STEP"}, indent, func(i int, indent string) {
+		writePlanLines(writer, plans[i], indent, written)
+	})
+}
+
+// writeCompoundLines writes the lines of the SELECTs of a compound SELECT under the operators joining
+// them, followed by the sorting of the rows
+func writeCompoundLines(writer io.Writer, plan *queryPlan, indent string, written map[*subquery]bool) {
+	lines := []string{"COMPOUND QUERY"}
+	if len(plan.OrderBy) > 0 {
+		lines = append(lines, "USE TEMP B-TREE FOR ORDER BY")
+	}
+	writeBranches(writer, lines, indent, func(i int, indent string) {
+		if i > 0 {
+			return
+		}
+		operators := []string{}
+		for _, part := range plan.Compound {
+			switch part.Op {
+			case "":
+				operators = append(operators, "LEFT-MOST SUBQUERY")
+			case "UNION ALL":
+				operators = append(operators, part.Op)
+			default:
+				operators = append(operators, part.Op+" USING TEMP B-TREE")
+			}
+		}
+		writeBranches(writer, operators, indent, func(i int, indent string) {
+			writePlanLines(writer, plan.Compound[i].plan, indent, written)
+		})
+	})
+}
+
+// writeBranches writes lines as the branches of a tree, calling children to write the lines under each one
+func writeBranches(writer io.Writer, lines []string, indent string, children func(i int, indent string)) {
+	for i, line := range lines {
+		prefix, branch := "|--", "|  "
+		if i == len(lines)-1 {
+			prefix, branch = "`--", "   "
+		}
+		fmt.Fprintln(writer, indent+prefix+line)
+		children(i, indent+branch)
+	}
+}
+
+// describe tells how the step reads its table, like "SEARCH t USING INDEX idx (a=? AND b>?)"
+func (step planStep) describe(source tableSource) string {
+	path := step.Path
+	constraints := path.constraints()
+	text := "SEARCH " + source.Name
+	switch path.Kind {
+	case "rowid":
+		text += " USING INTEGER PRIMARY KEY"
+	case "index":
+		if path.Covering {
+			text += " USING COVERING INDEX " + path.Index
+		} else {
+			text += " USING INDEX " + path.Index
+		}
+	case "pk":
+		text += " USING PRIMARY KEY"
+	case "automatic":
+		// the automatic index has all the columns of the table
+		text += " USING AUTOMATIC COVERING INDEX"
+	case "function":
+		// the index number of SQLite has a bit for each argument used
+		text = fmt.Sprintf("SCAN %s VIRTUAL TABLE INDEX %d:", source.Name, 1<<len(source.Args)-1)
+	default:
+		text = "SCAN " + source.Name
+	}
+	if len(path.Parts) == 0 {
+		text = strings.Replace(text, "SEARCH", "SCAN", 1)
+	}
+	if len(constraints) > 0 {
+		text += " (" + strings.Join(constraints, " AND ") + ")"
+	}
+	if source.LeftJoin {
+		text += " LEFT-JOIN"
+	}
+	return text
+}
+
+// constraints describes how the values of each part of the key are limited
+func (path accessPath) constraints() []string {
+	constraints := []string{}
+	for _, part := range path.Parts {
+		if part.Equality {
+			constraints = append(constraints, part.Name+"=?")
+			continue
+		}
+		if part.Lower {
+			constraints = append(constraints, part.Name+">?")
+		}
+		if part.Upper {
+			constraints = append(constraints, part.Name+"<?")
+		}
+	}
+	return constraints
+}
+
+// indexKeyColumns finds the sort order and collation of each column of an index. The collation comes from
+// the index definition or from the table column, using BINARY when none is given.
+func indexKeyColumns(tableColumns []ColumnDef, indexColumns []ColumnDef) []KeyColumn {
+	keyColumns := []KeyColumn{}
+	for _, indexColumn := range indexColumns {
+		keyColumn := KeyColumn{Descending: strings.EqualFold(indexColumn.Type, "DESC"), Collation: collationName(indexColumn.Constraints)}
+		if keyColumn.Collation == "" {
+			for _, column := range tableColumns {
+				if strings.EqualFold(column.Name, indexColumn.Name) {
+					keyColumn.Collation = collationName(column.Constraints)
+				}
+			}
+		}
+		if keyColumn.Collation == "" {
+			keyColumn.Collation = "BINARY"
+		}
+		keyColumns = append(keyColumns, keyColumn)
+	}
+	return keyColumns
+}
+
+// collationName finds the name after COLLATE on the constraints of a column
+func collationName(constraints []string) string {
+	words := strings.Fields(strings.Join(constraints, " "))
+	for i := 0; i+1 < len(words); i++ {
+		if strings.EqualFold(words[i], "COLLATE") {
+			return words[i+1]
+		}
+	}
+	return ""
+}
+
+// retrieveRows reads the rows of a table using the access path, with the columns in the order of the
+// table definition. The rows may still need to be filtered.
+func (db *DbContext) retrieveRows(table SchemaEntry, path accessPath) []TableRecord {
+	var tableData []TableRecord
+	db.visitRows(table, path, func(record TableRecord) bool {
+		tableData = append(tableData, record)
+		return true
+	})
+	return tableData
+}
+
+// visitRows reads the rows of a table using the access path like retrieveRows, one at a time so the caller
+// can stop reading when visit returns false. The key ranges are read backwards for a reverse path.
+func (db *DbContext) visitRows(table SchemaEntry, path accessPath, visit func(record TableRecord) bool) bool {
+	if path.Kind == "scan" {
+		// the keys of the b-tree are kept in order of the primary key, including the ones on interior pages
+		path.KeyRanges = []KeyRange{{}}
+		if table.WithoutRowid {
+			path.Kind, path.KeyColumns = "pk", indexKeyColumns(table.Columns, table.PrimaryKey)
+		}
+	}
+	keyRanges := path.KeyRanges
+	if path.Reverse {
+		keyRanges = slices.Clone(keyRanges)
+		slices.Reverse(keyRanges)
+	}
+
+	// the scans also stop at the end of each range, so the ranges after it are still read
+	stopped := false
+	visitRecord := func(record TableRecord) bool {
+		stopped = !visit(record)
+		return !stopped
+	}
+	// the records read from the table have their fields placed on the columns storing them. The records
+	// written before columns were added to the table don't have them, and get their default values.
+	order, defaults, virtual := recordColumns(table), columnDefaults(table), virtualColumns(table)
+	visitTableRecord := func(record TableRecord) bool {
+		if len(virtual) == 0 && len(order) == len(table.Columns) && len(record.Columns) >= len(order) && !table.WithoutRowid {
+			return visitRecord(record)
+		}
+		fields := record.Columns
+		record.Columns = make([]any, len(table.Columns))
+		for field, column := range order {
+			if field < len(fields) {
+				record.Columns[column] = fields[field]
+			} else {
+				record.Columns[column] = defaults[column]
+			}
+		}
+		computeVirtualColumns(table, record, virtual)
+		return visitRecord(record)
+	}
+	// the keys of a table without rowid are its records, starting with the primary key columns
+	visitKey := func(key []any) bool {
+		return visitTableRecord(TableRecord{Rowid: -1, Columns: key})
+	}
+	for _, keyRange := range keyRanges {
+		switch {
+		case path.Kind == "index" && path.Covering:
+			db.coveringIndexScan(table, path.IndexPage, keyRange, path.KeyColumns, path.Reverse, visitRecord)
+		case path.Kind == "index" && table.WithoutRowid:
+			db.indexedPKScan(table, path.IndexPage, keyRange, path.KeyColumns, path.Reverse, visitKey)
+		case path.Kind == "index":
+			db.indexedTableScan(table.RootPage, path.IndexPage, keyRange, path.KeyColumns, path.Reverse, visitTableRecord)
+		case path.Kind == "pk":
+			db.scanIndexRange(table.RootPage, keyRange, path.KeyColumns, path.Reverse, visitKey)
+		case path.Kind == "automatic":
+			db.automaticIndexScan(table, path, keyRange, visitRecord)
+		default:
+			db.scanTableRange(table.RootPage, keyRange, path.Reverse, visitTableRecord)
+		}
+		if stopped {
+			return false
+		}
+	}
+	return true
+}
+
+// automaticIndexScan reads the rows of a table with keys in the range from its automatic index, which is
+// built from all the rows of the table the first time
+func (db *DbContext) automaticIndexScan(table SchemaEntry, path accessPath, keyRange KeyRange, visit func(record TableRecord) bool) bool {
+	index := path.Automatic
+	key := func(record TableRecord) []any {
+		values := make([]any, len(index.Columns))
+		for i, column := range index.Columns {
+			values[i] = record.Columns[column]
+		}
+		return values
+	}
+	if !index.Built {
+		db.visitRows(table, accessPath{Kind: "scan"}, func(record TableRecord) bool {
+			index.Records = append(index.Records, record)
+			return true
+		})
+		slices.SortStableFunc(index.Records, func(a, b TableRecord) int {
+			return compareKeys(key(a), key(b), path.KeyColumns)
+		})
+		index.Built = true
+	}
+	first := sort.Search(len(index.Records), func(i int) bool {
+		return !keyRange.before(key(index.Records[i]), path.KeyColumns)
+	})
+	for _, record := range index.Records[first:] {
+		if keyRange.after(key(record), path.KeyColumns) {
+			break
+		}
+		if !visit(record) {
+			return false
+		}
+	}
+	return true
+}
+
+// coveringIndexScan reads the rows of a table from the keys of an index alone. The columns that are not
+// on the index are left NULL.
+func (db *DbContext) coveringIndexScan(table SchemaEntry, indexPage int, keyRange KeyRange, keyColumns []KeyColumn, reverse bool, visit func(record TableRecord) bool) bool {
+	// column of the table found on each field of the keys
+	fields := []int{}
+	for _, entry := range db.Schema {
+		if entry.RootPage == indexPage && entry.Type == "index" {
+			for _, indexColumn := range entry.Columns {
+				fields = append(fields, slices.IndexFunc(table.Columns, func(column ColumnDef) bool {
+					return strings.EqualFold(column.Name, indexColumn.Name)
+				}))
+			}
+		}
+	}
+	for _, primaryKeyColumn := range table.PrimaryKey {
+		columnNumber := slices.IndexFunc(table.Columns, func(column ColumnDef) bool {
+			return strings.EqualFold(column.Name, primaryKeyColumn.Name)
+		})
+		if table.WithoutRowid && !slices.Contains(fields, columnNumber) {
+			fields = append(fields, columnNumber)
+		}
+	}
+
+	return db.scanIndexRange(indexPage, keyRange, keyColumns, reverse, func(key []any) bool {
+		record := TableRecord{Rowid: -1, Columns: make([]any, len(table.Columns))}
+		for field, value := range key {
+			if field < len(fields) && fields[field] >= 0 {
+				record.Columns[fields[field]] = value
+			}
+		}
+		if rowid, ok := key[len(key)-1].(int64); ok && !table.WithoutRowid {
+			record.Rowid = rowid
+		}
+		return visit(record)
+	})
+}
+
+// indexedPKScan reads the rows of a table without rowid found on an index. Instead of the rowid, the keys
+// of the index end with the primary key columns that are not part of the index. The records are visited
+// as they are stored on the table, with the primary key first.
+func (db *DbContext) indexedPKScan(table SchemaEntry, indexPage int, keyRange KeyRange, keyColumns []KeyColumn, reverse bool, visit func(key []any) bool) bool {
+	indexColumns := []string{}
+	for _, entry := range db.Schema {
+		if entry.RootPage == indexPage {
+			for _, column := range entry.Columns {
+				indexColumns = append(indexColumns, strings.ToLower(column.Name))
+			}
+		}
+	}
+	// position of each primary key column on the keys of the index
+	positions := []int{}
+	suffix := len(indexColumns)
+	for _, primaryKeyColumn := range table.PrimaryKey {
+		if position := slices.Index(indexColumns, strings.ToLower(primaryKeyColumn.Name)); position >= 0 {
+			positions = append(positions, position)
+		} else {
+			positions = append(positions, suffix)
+			suffix++
+		}
+	}
+
+	primaryKeyColumns := indexKeyColumns(table.Columns, table.PrimaryKey)
+	return db.scanIndexRange(indexPage, keyRange, keyColumns, reverse, func(key []any) bool {
+		primaryKey := []any{}
+		for _, position := range positions {
+			primaryKey = append(primaryKey, key[position])
+		}
+		record := db.getRecordByPK(table.RootPage, primaryKey, primaryKeyColumns)
+		if record == nil {
+			log.Fatal("unexpected missing primary key: ", primaryKey)
+		}
+		return visit(record.Columns)
+	})
+}
+
+// columnRanges combines the ranges of values allowed for the column of a key part by each term, with the
+// values compared with the column taken from the row. The ranges are sorted and don't overlap.
+func columnRanges(part keyPart, row []any) ([]KeyRange, error) {
+	keyRanges := []KeyRange{{}}
+	for _, term := range part.Terms {
+		termRanges, found, err := termRanges(term, part, row)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			keyRanges = intersectRanges(keyRanges, termRanges, part.Collation)
+		}
+	}
+	return keyRanges, nil
+}
+
+// keyConstraint checks if the term compares the column of the key part with values that are usable,
+// returning the operator with the column on the left side and the expressions of the values. The values
+// must be compared with an affinity that keeps the order of the key, which has the affinity of the
+// column, and with the collation of the key.
+func keyConstraint(term *Expr, part keyPart, usable func(*Expr) bool) (op string, values []*Expr, ok bool) {
+	isColumn := func(expr *Expr) bool {
+		expr = skipCollate(expr)
+		return expr.Op == "column" && slices.Contains(part.Columns, expr.Column)
+	}
+	isValue := func(exprs ...*Expr) bool {
+		for _, expr := range exprs {
+			if usesColumns(expr, part.Columns) || !usable(expr) || !keepsKeyOrder(part.Affinity, seekAffinity(term.Op, part.Affinity, expr)) {
+				return false
+			}
+		}
+		return true
+	}
+	// the rowid has no collation, as it is never text
+	sameCollation := func(collation string) bool {
+		return part.Collation == "" || strings.EqualFold(collation, part.Collation)
+	}
+
+	switch term.Op {
+	case "=", "<", "<=", ">", ">=", "IS":
+		if term.Not {
+			return "", nil, false
+		}
+		column, value, op := term.Args[0], term.Args[1], term.Op
+		if !isColumn(column) {
+			column, value = value, column
+			op = strings.NewReplacer("<", ">", ">", "<").Replace(op)
+		}
+		if isColumn(column) && isValue(value) && sameCollation(comparisonCollation(term.Args[0], term.Args[1])) {
+			return op, []*Expr{value}, true
+		}
+	case "ISNULL":
+		// IS NULL finds the NULLs of the key like IS with a NULL, but the rowid, which has no collation, is never NULL
+		if !term.Not && part.Collation != "" && isColumn(term.Args[0]) {
+			return "IS", []*Expr{{Op: "literal"}}, true
+		}
+	case "BETWEEN":
+		if !term.Not && isColumn(term.Args[0]) && isValue(term.Args[1], term.Args[2]) &&
+			sameCollation(comparisonCollation(term.Args[0], term.Args[1])) && sameCollation(comparisonCollation(term.Args[0], term.Args[2])) {
+			return "BETWEEN", term.Args[1:], true
+		}
+	case "IN":
+		if term.Query != nil {
+			// the values of a subquery are compared with the affinity and collation of its column
+			column := term.Query.plan.Columns[0].Expr
+			if !term.Not && isColumn(term.Args[0]) && isValue(&Expr{Op: "subquery", Query: term.Query}) &&
+				keepsKeyOrder(part.Affinity, comparisonAffinity(term.Args[0], column)) && sameCollation(comparisonCollation(term.Args[0], column)) {
+				return "IN", nil, true
+			}
+			return "", nil, false
+		}
+		if !term.Not && isColumn(term.Args[0]) && isValue(term.Args[1:]...) && sameCollation(orderCollation(term.Args[0])) {
+			return "IN", term.Args[1:], true
+		}
+	case "LIKE":
+		// LIKE ignores the case of ASCII letters, which can be searched with a BINARY or NOCASE key
+		collation := strings.ToUpper(part.Collation)
+		if term.Not || len(term.Args) > 2 || part.Affinity != "TEXT" || collation != "BINARY" && collation != "NOCASE" || !isColumn(term.Args[0]) || term.Args[1].Op != "literal" {
+			return "", nil, false
+		}
+		// only a pattern starting with some text can be searched
+		if pattern, isText := term.Args[1].Value.(string); isText && pattern != "" && !strings.ContainsAny(pattern[:1], "%_") {
+			return "LIKE", term.Args[1:], true
+		}
+	case "GLOB":
+		// GLOB is case sensitive, so it can only be searched with a BINARY key
+		if term.Not || part.Affinity != "TEXT" || !strings.EqualFold(part.Collation, "BINARY") || !isColumn(term.Args[0]) || term.Args[1].Op != "literal" {
+			return "", nil, false
+		}
+		if pattern, isText := term.Args[1].Value.(string); isText && pattern != "" && !strings.ContainsAny(pattern[:1], "*?[") {
+			return "GLOB", term.Args[1:], true
+		}
+	}
+	return "", nil, false
+}
+
+// seekAffinity is the affinity of the comparison of a column having an affinity with a value, where the
+// values of IN take the affinity of the column
+func seekAffinity(op string, affinity string, value *Expr) string {
+	if op == "IN" {
+		return affinity
+	}
+	return comparisonAffinity(&Expr{Affinity: affinity}, value)
+}
+
+// keepsKeyOrder tells if comparing with an affinity finds the same values as seeking a key with the
+// affinity of its column, which is not the case when texts of a TEXT column are compared as numbers
+func keepsKeyOrder(keyAffinity string, affinity string) bool {
+	isNumeric := func(affinity string) bool {
+		return affinity == "INTEGER" || affinity == "REAL" || affinity == "NUMERIC"
+	}
+	return affinity == "" || affinity == "BLOB" || affinity == keyAffinity || isNumeric(affinity) && isNumeric(keyAffinity)
+}
+
+// seekValue converts a value compared with a column of a key like the comparison would, knowing that the
+// values of the column already have its affinity
+func seekValue(affinity string, value any) any {
+	switch affinity {
+	case "INTEGER", "REAL", "NUMERIC":
+		return numericAffinity(value)
+	case "TEXT":
+		return textAffinity(value)
+	}
+	return value
+}
+
+// termRanges finds the ranges of values allowed by a comparison of the column with values, which are
+// computed from the row
+func termRanges(term *Expr, part keyPart, row []any) ([]KeyRange, bool, error) {
+	op, valueExprs, ok := keyConstraint(term, part, func(*Expr) bool { return true })
+	if !ok {
+		return nil, false, nil
+	}
+	values := []any{}
+	for _, valueExpr := range valueExprs {
+		value, err := evalExpr(valueExpr, row)
+		if err != nil {
+			return nil, false, err
+		}
+		values = append(values, seekValue(seekAffinity(op, part.Affinity, valueExpr), value))
+	}
+	if op == "IN" && term.Query != nil {
+		subqueryValues, err := term.Query.sortedValues(term.Args[0], row)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, value := range subqueryValues {
+			values = append(values, seekValue(part.Affinity, value))
+		}
+	}
+	// NULL is never equal, smaller or greater than any value, and is found before them on indexes
+	notNull := []any{nil}
+
+	switch op {
+	case "IS":
+		// unlike =, IS finds the NULLs
+		bound := []any{values[0]}
+		return []KeyRange{{Low: bound, High: bound, LowInclusive: true, HighInclusive: true}}, true, nil
+
+	case "=", "<", "<=", ">", ">=":
+		if values[0] == nil {
+			return []KeyRange{}, true, nil
+		}
+		bound := []any{values[0]}
+		switch op {
+		case "=":
+			return []KeyRange{{Low: bound, High: bound, LowInclusive: true, HighInclusive: true}}, true, nil
+		case "<":
+			return []KeyRange{{Low: notNull, High: bound}}, true, nil
+		case "<=":
+			return []KeyRange{{Low: notNull, High: bound, HighInclusive: true}}, true, nil
+		case ">":
+			return []KeyRange{{Low: bound}}, true, nil
+		case ">=":
+			return []KeyRange{{Low: bound, LowInclusive: true}}, true, nil
+		}
+
+	case "BETWEEN":
+		low, high := values[0], values[1]
+		if low == nil || high == nil {
+			return []KeyRange{}, true, nil
+		}
+		return []KeyRange{{Low: []any{low}, High: []any{high}, LowInclusive: true, HighInclusive: true}}, true, nil
+
+	case "IN":
+		values = slices.DeleteFunc(values, func(value any) bool { return value == nil })
+		compare := func(a, b any) int { return compareCollated(a, b, part.Collation) }
+		slices.SortFunc(values, compare)
+		values = slices.CompactFunc(values, func(a, b any) bool { return compare(a, b) == 0 })
+		keyRanges := []KeyRange{}
+		for _, value := range values {
+			bound := []any{value}
+			keyRanges = append(keyRanges, KeyRange{Low: bound, High: bound, LowInclusive: true, HighInclusive: true})
+		}
+		return keyRanges, true, nil
+
+	case "LIKE":
+		pattern := values[0].(string)
+		prefix := pattern
+		if wildcard := strings.IndexAny(pattern, "%_"); wildcard >= 0 {
+			prefix = pattern[:wildcard]
+		}
+		if strings.EqualFold(part.Collation, "NOCASE") {
+			return []KeyRange{{Low: []any{prefix}, High: []any{nextPrefix(lowerASCII(prefix))}, LowInclusive: true}}, true, nil
+		}
+		return likeRanges(prefix), true, nil
+
+	case "GLOB":
+		pattern := values[0].(string)
+		prefix := pattern[:strings.IndexAny(pattern+"*", "*?[")]
+		return []KeyRange{{Low: []any{prefix}, High: []any{nextPrefix(prefix)}, LowInclusive: true}}, true, nil
+	}
+	return nil, false, nil
+}
+
+// likeRanges finds the text values that may start with the prefix when LIKE ignores the case of ASCII
+// letters. Each case variation of the first letters gets its own range, and the remaining letters are
+// limited from their upper case to their lower case forms, with the values in between filtered later.
+func likeRanges(prefix string) []KeyRange {
+	const maxLetters = 5
+	split, letters := 0, 0
+	for ; split < len(prefix) && letters < maxLetters; split++ {
+		if lowerASCII(prefix[split:split+1]) != upperASCII(prefix[split:split+1]) {
+			letters++
+		}
+	}
+	variants := []string{""}
+	for i := 0; i < split; i++ {
+		lower, upper := lowerASCII(prefix[i:i+1]), upperASCII(prefix[i:i+1])
+		next := []string{}
+		for _, variant := range variants {
+			next = append(next, variant+lower)
+			if upper != lower {
+				next = append(next, variant+upper)
+			}
+		}
+		variants = next
+	}
+	slices.Sort(variants)
+	keyRanges := []KeyRange{}
+	for _, variant := range variants {
+		low, high := variant+upperASCII(prefix[split:]), nextPrefix(variant+lowerASCII(prefix[split:]))
+		keyRanges = append(keyRanges, KeyRange{Low: []any{low}, High: []any{high}, LowInclusive: true})
+	}
+	return keyRanges
+}
+
+// nextPrefix is the first text after all the texts starting with the prefix
+func nextPrefix(prefix string) string {
+	next := []byte(prefix)
+	next[len(next)-1]++
+	return string(next)
+}
+
+// intersectRanges keeps only the values found in both lists of ranges, comparing them with the collation
+func intersectRanges(a, b []KeyRange, collation string) []KeyRange {
+	keyColumns := []KeyColumn{{Collation: collation}}
+	result := []KeyRange{}
+	for _, x := range a {
+		for _, y := range b {
+			keyRange := x
+			if y.Low != nil {
+				comparison := 1
+				if keyRange.Low != nil {
+					comparison = compareKeys(y.Low, keyRange.Low, keyColumns)
+				}
+				if comparison > 0 || (comparison == 0 && !y.LowInclusive) {
+					keyRange.Low, keyRange.LowInclusive = y.Low, y.LowInclusive
+				}
+			}
+			if y.High != nil {
+				comparison := -1
+				if keyRange.High != nil {
+					comparison = compareKeys(y.High, keyRange.High, keyColumns)
+				}
+				if comparison < 0 || (comparison == 0 && !y.HighInclusive) {
+					keyRange.High, keyRange.HighInclusive = y.High, y.HighInclusive
+				}
+			}
+			if keyRange.Low != nil && keyRange.High != nil {
+				comparison := compareKeys(keyRange.Low, keyRange.High, keyColumns)
+				if comparison > 0 || (comparison == 0 && !(keyRange.LowInclusive && keyRange.HighInclusive)) {
+					continue
+				}
+			}
+			result = append(result, keyRange)
+		}
+	}
+	return result
+}
+
+// reverseRanges converts ranges of values to the order used by a descending index
+func reverseRanges(keyRanges []KeyRange) []KeyRange {
+	result := []KeyRange{}
+	for i := len(keyRanges) - 1; i >= 0; i-- {
+		keyRange := keyRanges[i]
+		result = append(result, KeyRange{
+			Low: keyRange.High, LowInclusive: keyRange.HighInclusive,
+			High: keyRange.Low, HighInclusive: keyRange.LowInclusive,
+		})
+	}
+	return result
+}
+
+// isEquality tells if the ranges only have single values
+func isEquality(keyRanges []KeyRange) bool {
+	for _, keyRange := range keyRanges {
+		if keyRange.Low == nil || keyRange.High == nil || !keyRange.LowInclusive || !keyRange.HighInclusive ||
+			compareKeys(keyRange.Low, keyRange.High, nil) != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// printfSpec is a conversion of a printf format, like "%-08.3f"
+type printfSpec struct {
+	LeftJustify, PlusSign, BlankSign, AlternateForm, AlternateForm2, ZeroPad, Commas bool
+	Width, Precision                                                                 int
+	Conversion                                                                       byte
+}
+
+// printfFunction formats the arguments like the printf of C, with the extra conversions of SQLite:
+// %q and %Q quote a text, %w quotes an identifier, %z is %s, and "!" asks for more digits or counts
+// characters instead of bytes. The l and ll length modifiers are ignored, as all the integers have 64
+// bits. Missing arguments are taken as NULL.
+func printfFunction(args []any) (any, error) {
+	if args[0] == nil {
+		return nil, nil
+	}
+	format := textValue(args[0])
+	args = args[1:]
+	next := func() any {
+		if len(args) == 0 {
+			return nil
+		}
+		arg := args[0]
+		args = args[1:]
+		return arg
+	}
+	var builder strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			builder.WriteByte(format[i])
+			continue
+		}
+		if i == len(format)-1 {
+			// like SQLite, a % ending the format is written as is
+			builder.WriteByte('%')
+			break
+		}
+		spec := printfSpec{Precision: -1}
+		for i++; i < len(format); i++ {
+			switch format[i] {
+			case '-':
+				spec.LeftJustify = true
+			case '+':
+				spec.PlusSign = true
+			case ' ':
+				spec.BlankSign = true
+			case '#':
+				spec.AlternateForm = true
+			case '!':
+				spec.AlternateForm2 = true
+			case '0':
+				spec.ZeroPad = true
+			case ',':
+				spec.Commas = true
+			default:
+				goto width
+			}
+		}
+	width:
+		if i < len(format) && format[i] == '*' {
+			width := integerValue(next())
+			if width < 0 {
+				spec.LeftJustify, width = true, -width
+			}
+			spec.Width = int(width)
+			i++
+		} else {
+			for ; i < len(format) && format[i] >= '0' && format[i] <= '9'; i++ {
+				spec.Width = min(spec.Width*10+int(format[i]-'0'), maxLength+1)
+			}
+		}
+		if i < len(format) && format[i] == '.' {
+			i++
+			spec.Precision = 0
+			if i < len(format) && format[i] == '*' {
+				precision := integerValue(next())
+				if precision < 0 {
+					precision = -precision
+				}
+				spec.Precision = int(precision)
+				i++
+			} else {
+				for ; i < len(format) && format[i] >= '0' && format[i] <= '9'; i++ {
+					spec.Precision = min(spec.Precision*10+int(format[i]-'0'), maxLength+1)
+				}
+			}
+		}
+		// the negation of the smallest integer stays negative
+		if spec.Width < 0 || spec.Width > maxLength || spec.Precision < -1 || spec.Precision > maxLength {
+			return nil, errTooBig
+		}
+		for l := 0; l < 2 && i < len(format) && format[i] == 'l'; l++ {
+			i++
+		}
+		if i == len(format) {
+			break
+		}
+		spec.Conversion = format[i]
+		var text string
+		switch spec.Conversion {
+		case '%':
+			text = "%"
+		case 'd', 'i', 'u', 'x', 'X', 'o':
+			text = spec.formatInteger(integerValue(next()))
+		case 'f', 'e', 'E', 'g', 'G':
+			text = spec.formatReal(realValue(next()))
+		case 's', 'z':
+			text = spec.truncate(textValue(next()))
+		case 'q', 'Q', 'w':
+			text = spec.formatQuoted(next())
+		case 'c':
+			// the character of an empty text is empty
+			if ch, size := utf8.DecodeRuneInString(textValue(next())); size > 0 {
+				text = strings.Repeat(string(ch), max(spec.Precision, 1))
+			}
+		default:
+			// like SQLite, the output stops at an unknown conversion
+			return builder.String(), nil
+		}
+		builder.WriteString(spec.pad(text))
+		if builder.Len() > maxLength {
+			return nil, errTooBig
+		}
+	}
+	return builder.String(), nil
+}
+
+// formatInteger writes an integer for the conversions d, i, u, x, X and o
+func (spec printfSpec) formatInteger(value int64) string {
+	var digits, prefix string
+	switch spec.Conversion {
+	case 'd', 'i':
+		digits = strconv.FormatUint(absUint64(value), 10)
+		prefix = spec.signPrefix(value < 0)
+	case 'u':
+		digits = strconv.FormatUint(uint64(value), 10)
+	case 'x', 'X':
+		digits = strconv.FormatUint(uint64(value), 16)
+		if spec.AlternateForm && value != 0 {
+			prefix = "0x"
+		}
+	case 'o':
+		digits = strconv.FormatUint(uint64(value), 8)
+		if spec.AlternateForm && value != 0 {
+			prefix = "0"
+		}
+	}
+	if spec.Conversion == 'X' {
+		digits, prefix = strings.ToUpper(digits), strings.ToUpper(prefix)
+	}
+	precision := spec.Precision
+	if spec.ZeroPad && !spec.LeftJustify && precision < spec.Width-len(prefix) {
+		precision = spec.Width - len(prefix)
+	}
+	if spec.Commas && (spec.Conversion == 'd' || spec.Conversion == 'i') {
+		for i := len(digits) - 3; i > 0; i -= 3 {
+			digits = digits[:i] + "," + digits[i:]
+		}
+	}
+	if len(digits) < precision {
+		digits = strings.Repeat("0", precision-len(digits)) + digits
+	}
+	return prefix + digits
+}
+
+// formatReal writes a floating point number for the conversions f, e, E, g and G like SQLite, which rounds
+// half away from zero on the decimal digits and writes zeros after 16 significant digits, or 26 with "!"
+func (spec printfSpec) formatReal(value float64) string {
+	prefix := spec.signPrefix(math.Signbit(value) && value != 0)
+	value = math.Abs(value)
+	if math.IsNaN(value) {
+		return "NaN"
+	} else if math.IsInf(value, 0) {
+		return prefix + "Inf"
+	}
+	precision := spec.Precision
+	if precision < 0 {
+		precision = 6
+	}
+	maxDigits := 16
+	if spec.AlternateForm2 {
+		maxDigits = 26
+	}
+	digits, exponent := decimalDigits(value, spec.AlternateForm2)
+	conversion := spec.Conversion | 0x20
+	// the "!" flag removes the zeros at the end, and so does %g without "#"
+	trimZeros := spec.AlternateForm2
+	if conversion == 'g' {
+		precision = max(precision, 1)
+		_, rounded := roundDigits(digits, exponent, min(precision, maxDigits))
+		if rounded < -4 || rounded >= precision {
+			conversion, precision = 'e', precision-1
+		} else {
+			conversion, precision = 'f', precision-1-rounded
+		}
+		trimZeros = !spec.AlternateForm
+	}
+	count := precision + 1
+	if conversion == 'f' {
+		count = exponent + 1 + precision
+	}
+	digits, exponent = roundDigits(digits, exponent, min(count, maxDigits))
+
+	var integer, fraction string
+	if conversion == 'e' {
+		integer, fraction = digits[:1], digits[1:]
+	} else if exponent >= 0 {
+		split := min(exponent+1, len(digits))
+		integer, fraction = digits[:split]+strings.Repeat("0", exponent+1-split), digits[split:]
+	} else {
+		integer, fraction = "0", strings.Repeat("0", -exponent-1)+digits
+	}
+	fraction = (fraction + strings.Repeat("0", max(precision-len(fraction), 0)))[:precision]
+	if trimZeros {
+		fraction = strings.TrimRight(fraction, "0")
+		if fraction == "" && spec.AlternateForm2 {
+			fraction = "0"
+		}
+	}
+	text := integer
+	if fraction != "" || spec.AlternateForm {
+		text += "." + fraction
+	}
+	if conversion == 'e' {
+		sign := "+"
+		if exponent < 0 {
+			sign, exponent = "-", -exponent
+		}
+		text += "e" + sign + leftPad(strconv.Itoa(exponent), 2, '0')
+	}
+	if spec.Conversion == 'E' || spec.Conversion == 'G' {
+		text = strings.ToUpper(text)
+	}
+	if spec.ZeroPad && !spec.LeftJustify {
+		text = leftPad(text, spec.Width-len(prefix), '0')
+	}
+	return prefix + text
+}
+
+// decimalDigits finds the significant digits of a positive number and the power of ten of the first one.
+// Like SQLite, only the first 17 digits are kept, or 19 for the "!" flag.
+func decimalDigits(value float64, extended bool) (string, int) {
+	if value == 0 {
+		return "0", 0
+	}
+	mantissa, exponentText, _ := strings.Cut(strconv.FormatFloat(value, 'e', 25, 64), "e")
+	exponent, _ := strconv.Atoi(exponentText)
+	digits := strings.Replace(mantissa, ".", "", 1)
+	if extended {
+		return digits[:19], exponent
+	}
+	return digits[:17], exponent
+}
+
+// roundDigits rounds the significant digits to a number of them, rounding half away from zero, and gives
+// the new power of ten of the first digit when it carries. No digits left means a zero.
+func roundDigits(digits string, exponent, count int) (string, int) {
+	if count < 0 {
+		return "0", exponent
+	}
+	if count >= len(digits) {
+		return digits, exponent
+	}
+	rounded := []byte(digits[:count])
+	if digits[count] >= '5' {
+		i := count - 1
+		for ; i >= 0 && rounded[i] == '9'; i-- {
+			rounded[i] = '0'
+		}
+		if i >= 0 {
+			rounded[i]++
+		} else {
+			rounded = append([]byte{'1'}, rounded...)
+			exponent++
+		}
+	}
+	if len(rounded) == 0 {
+		return "0", exponent
+	}
+	return string(rounded), exponent
+}
+
+// formatQuoted writes a value for the conversions q, Q and w, doubling the quotes inside it
+func (spec printfSpec) formatQuoted(value any) string {
+	switch {
+	case value == nil && spec.Conversion == 'Q':
+		return "NULL"
+	case value == nil && spec.Conversion == 'q':
+		return "(NULL)"
+	case spec.Conversion == 'w':
+		return strings.ReplaceAll(spec.truncate(textValue(value)), `"`, `""`)
+	}
+	text := strings.ReplaceAll(spec.truncate(textValue(value)), "'", "''")
+	if spec.Conversion == 'Q' {
+		text = "'" + text + "'"
+	}
+	return text
+}
+
+// truncate keeps the number of bytes of the precision, or of characters with the "!" flag
+func (spec printfSpec) truncate(text string) string {
+	if spec.Precision < 0 {
+		return text
+	}
+	if !spec.AlternateForm2 {
+		return text[:min(spec.Precision, len(text))]
+	}
+	count := 0
+	for i := range text {
+		if count == spec.Precision {
+			return text[:i]
+		}
+		count++
+	}
+	return text
+}
+
+// signPrefix is the sign written before a number
+func (spec printfSpec) signPrefix(negative bool) string {
+	switch {
+	case negative:
+		return "-"
+	case spec.PlusSign:
+		return "+"
+	case spec.BlankSign:
+		return " "
+	}
+	return ""
+}
+
+// pad adds spaces up to the width, counting bytes or characters with the "!" flag of a text
+func (spec printfSpec) pad(text string) string {
+	length := len(text)
+	if spec.AlternateForm2 {
+		length = utf8.RuneCountInString(text)
+	}
+	if length >= spec.Width {
+		return text
+	}
+	padding := strings.Repeat(" ", spec.Width-length)
+	if spec.LeftJustify {
+		return text + padding
+	}
+	return padding + text
+}
+
+// leftPad adds characters before a text up to the length
+func leftPad(text string, length int, ch byte) string {
+	if len(text) >= length {
+		return text
+	}
+	return strings.Repeat(string(ch), length-len(text)) + text
+}
+
+// absUint64 is the absolute value of an integer, which doesn't overflow for the smallest integer
+func absUint64(value int64) uint64 {
+	if value < 0 {
+		return uint64(-(value + 1)) + 1
+	}
+	return uint64(value)
+}
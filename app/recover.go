@@ -0,0 +1,511 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// RecoveredRecord is a record found by scanning the raw pages of the database file
+type RecoveredRecord struct {
+	RootPage int // root page of the b-tree that still links to the page (0 when unknown)
+	Page     int
+	Rowid    int64
+	HasRowid bool // rowids are lost when the start of a deleted cell is overwritten
+	Live     bool
+	Columns  []any
+
+	WithoutRowid bool // found on an index b-tree page, where tables without rowid keep their rows
+}
+
+// Recover writes SQL that rebuilds as much data as possible from a damaged database.
+//
+// Instead of trusting the b-tree structure, every page in the file is scanned: cells from
+// table leaf pages and from the index pages of tables without rowid (including the ones on
+// the freelist), deleted cells left on table leaf freeblocks
+// and unallocated space, and anything that decodes as a valid cell on pages that are not
+// b-tree pages anymore. Records are matched to tables by their number of fields and the
+// type of their values. Records that can't be matched to a single table are written to a
+// "lost_and_found" table.
+func (db *DbContext) Recover(writer io.Writer) {
+	tables := []SchemaEntry{}
+	for _, entry := range db.Schema {
+		if entry.Type == "table" && entry.RootPage > 0 && !strings.HasPrefix(entry.Name, "sqlite_") {
+			tables = append(tables, entry)
+		}
+	}
+
+	records := db.scanRecords(tables)
+
+	recoveredRows := map[string][]RecoveredRecord{}
+	lostRecords := []RecoveredRecord{}
+	seen := map[string]bool{}
+	for _, record := range records {
+		var table *SchemaEntry
+		if record.Live && record.RootPage == 1 {
+			// the schema is written from the parsed definitions
+			continue
+		}
+		for i := range tables {
+			if score, _ := recordMatchScore(record, tables[i]); record.Live && tables[i].RootPage == record.RootPage && score >= 0 {
+				table = &tables[i]
+				break
+			}
+		}
+		if table == nil {
+			if !record.Live && !plausibleValues(record.Columns) {
+				continue
+			}
+			table = bestMatchingTable(record, tables)
+			if table == nil && !record.Live && record.WithoutRowid {
+				// most likely a copy from a dropped index page, and not a lost row
+				continue
+			}
+		}
+
+		// the same rowid found more than once is an older version of the row, and the same values
+		// found without a rowid are most likely a copy left behind when the page was rearranged
+		tableName := ""
+		if table != nil {
+			tableName = table.Name
+		}
+		values := []string{}
+		for _, value := range record.Columns {
+			values = append(values, quoteLiteral(value))
+		}
+		keys := []string{tableName + "\x00" + strings.Join(values, ",")}
+		if record.HasRowid {
+			keys = append(keys, fmt.Sprint(tableName, "\x00", record.Rowid))
+		}
+		duplicated := false
+		for _, key := range keys {
+			duplicated = duplicated || seen[key]
+			seen[key] = true
+		}
+		if duplicated {
+			continue
+		}
+
+		if table == nil {
+			lostRecords = append(lostRecords, record)
+		} else {
+			recoveredRows[table.Name] = append(recoveredRows[table.Name], record)
+		}
+	}
+
+	fmt.Fprintln(writer, "BEGIN;")
+	for _, entry := range tables {
+		if strings.HasPrefix(strings.ToUpper(entry.SQL), "CREATE VIRTUAL TABLE") {
+			continue
+		}
+		fmt.Fprintf(writer, "%s;\n", entry.SQL)
+		aliasedPKColumnNumber := aliasedRowidColumn(entry.Columns)
+		order := recordColumns(entry)
+		for _, record := range recoveredRows[entry.Name] {
+			columnNames := []string{}
+			values := []string{}
+			if record.HasRowid && aliasedPKColumnNumber < 0 && !entry.WithoutRowid {
+				columnNames = append(columnNames, "_rowid_")
+				values = append(values, quoteLiteral(record.Rowid))
+			}
+			for i, value := range record.Columns {
+				if order[i] == aliasedPKColumnNumber && record.HasRowid {
+					value = record.Rowid
+				}
+				if entry.Columns[order[i]].Generated != nil {
+					// stored generated columns are computed again when the row is inserted
+					continue
+				}
+				columnNames = append(columnNames, quoteIdentifier(entry.Columns[order[i]].Name))
+				values = append(values, quoteLiteral(value))
+			}
+			fmt.Fprintf(writer, "INSERT OR IGNORE INTO %s(%s) VALUES(%s);\n",
+				quoteIdentifier(entry.Name), strings.Join(columnNames, ","), strings.Join(values, ","))
+		}
+	}
+	for _, entry := range db.Schema {
+		if (entry.Type == "index" || entry.Type == "trigger" || entry.Type == "view") && entry.SQL != "" {
+			fmt.Fprintf(writer, "%s;\n", entry.SQL)
+		}
+	}
+
+	if len(lostRecords) > 0 {
+		lostAndFound := "lost_and_found"
+		for i := 0; db.hasSchemaEntry(lostAndFound); i++ {
+			lostAndFound = fmt.Sprintf("lost_and_found_%d", i)
+		}
+		maxFields := 0
+		for _, record := range lostRecords {
+			maxFields = max(maxFields, len(record.Columns))
+		}
+		columnNames := []string{"rootpgno INTEGER", "pgno INTEGER", "nfield INTEGER", "id INTEGER"}
+		for i := 0; i < maxFields; i++ {
+			columnNames = append(columnNames, fmt.Sprintf("c%d", i))
+		}
+		fmt.Fprintf(writer, "CREATE TABLE %s(%s);\n", lostAndFound, strings.Join(columnNames, ", "))
+		for _, record := range lostRecords {
+			var rootPage, rowid any
+			if record.RootPage > 0 {
+				rootPage = int64(record.RootPage)
+			}
+			if record.HasRowid {
+				rowid = record.Rowid
+			}
+			values := []string{quoteLiteral(rootPage), quoteLiteral(int64(record.Page)), quoteLiteral(int64(len(record.Columns))), quoteLiteral(rowid)}
+			for _, value := range record.Columns {
+				values = append(values, quoteLiteral(value))
+			}
+			// shorter records leave the last columns null
+			for i := len(record.Columns); i < maxFields; i++ {
+				values = append(values, "NULL")
+			}
+			fmt.Fprintf(writer, "INSERT INTO %s VALUES(%s);\n", lostAndFound, strings.Join(values, ","))
+		}
+	}
+	fmt.Fprintln(writer, "COMMIT;")
+}
+
+func (db *DbContext) hasSchemaEntry(name string) bool {
+	for _, entry := range db.Schema {
+		if strings.EqualFold(entry.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// scanRecords reads every page in the file looking for records, live ones first
+func (db *DbContext) scanRecords(tables []SchemaEntry) []RecoveredRecord {
+	// the header may be outdated on a damaged file, so the actual file size is used
+	pageCount := int(db.Info.DatabasePageCount)
+	if stat, err := db.File.Stat(); err == nil {
+		pageCount = int(stat.Size() / int64(db.Info.DatabasePageSize))
+	}
+
+	// pages still linked to the schema b-trees and the overflow pages used by their cells
+	owners := map[int]int{}
+	overflowPages := map[int]bool{}
+	db.mapBtreePages(1, 1, owners, overflowPages)
+	for _, entry := range db.Schema {
+		if entry.RootPage > 0 {
+			db.mapBtreePages(entry.RootPage, entry.RootPage, owners, overflowPages)
+		}
+	}
+
+	withoutRowidRoots := map[int]bool{}
+	for _, table := range tables {
+		if table.WithoutRowid {
+			withoutRowidRoots[table.RootPage] = true
+		}
+	}
+
+	liveRecords := []RecoveredRecord{}
+	deletedRecords := []RecoveredRecord{}
+	for pageNumber := 1; pageNumber <= pageCount; pageNumber++ {
+		if overflowPages[pageNumber] {
+			continue
+		}
+		page, err := db.readPage(pageNumber)
+		if err != nil {
+			continue
+		}
+		rootPage := owners[pageNumber]
+		header, err := db.parsePageHeader(pageNumber, page)
+		if err != nil {
+			// freelist trunk pages, overwritten pages or pages with a damaged header
+			start := 0
+			if pageNumber == 1 {
+				start = 100
+			}
+			deletedRecords = append(deletedRecords, db.scanRegion(pageNumber, rootPage, page, start, int(db.Info.UsablePageSize), tables)...)
+			continue
+		}
+		withoutRowid := header.PageType == 0x0a || header.PageType == 0x02
+		if header.PageType != 0x0d && !(withoutRowid && (rootPage == 0 || withoutRowidRoots[rootPage])) {
+			// interior table pages and the pages of actual indexes only have copies of data stored somewhere else
+			continue
+		}
+
+		for _, offset := range getCellOffsets(header, page) {
+			cell, err := db.parseCell(header, page, offset)
+			if err != nil {
+				continue
+			}
+			payload := cell.LocalPayload
+			if cell.OverflowPage != 0 {
+				payload, err = db.readDataWithOverflow(header, page, cell.PayloadOffset, cell.PayloadSize)
+				if err != nil {
+					continue
+				}
+			}
+			columns, _, err := db.decodeRecord(payload)
+			if err != nil {
+				continue
+			}
+			record := RecoveredRecord{RootPage: rootPage, Page: pageNumber, Rowid: cell.Rowid, HasRowid: !withoutRowid,
+				Live: rootPage > 0, Columns: columns, WithoutRowid: withoutRowid}
+			if record.Live {
+				liveRecords = append(liveRecords, record)
+			} else {
+				deletedRecords = append(deletedRecords, record)
+			}
+		}
+		if withoutRowid {
+			// deleted index cells are not told apart from random data without the rowid
+			continue
+		}
+
+		// deleted cells are left behind on freeblocks and on the unallocated space
+		cellPointerArrayEnd := int(header.CellPointerArrayOffset) + int(header.CellCount)*2
+		deletedRecords = append(deletedRecords, db.scanRegion(pageNumber, rootPage, page, cellPointerArrayEnd, int(header.StartOfCellContentArea), tables)...)
+		visited := map[int]bool{}
+		for freeblock := int(header.FirstFreeBlock); freeblock != 0 && !visited[freeblock]; {
+			visited[freeblock] = true
+			if freeblock+4 > int(db.Info.UsablePageSize) {
+				break
+			}
+			size := int(readBigEndianUint16(page[freeblock+2:]))
+			end := min(freeblock+size, int(db.Info.UsablePageSize))
+			next := freeblock + 4
+			if record, recordEnd, ok := db.recoverFreeblockHead(pageNumber, rootPage, page, freeblock, end, tables); ok {
+				deletedRecords = append(deletedRecords, record)
+				next = recordEnd
+			}
+			// adjacent deleted cells are merged on a single freeblock, keeping their own stale headers
+			deletedRecords = append(deletedRecords, db.scanRegion(pageNumber, rootPage, page, next, end, tables)...)
+			freeblock = int(readBigEndianUint16(page[freeblock:]))
+		}
+	}
+	return append(liveRecords, deletedRecords...)
+}
+
+// mapBtreePages records which root page each reachable page belongs to, tolerating damaged pages
+func (db *DbContext) mapBtreePages(pageNumber, rootPage int, owners map[int]int, overflowPages map[int]bool) {
+	if _, visited := owners[pageNumber]; visited || overflowPages[pageNumber] {
+		return
+	}
+	page, err := db.readPage(pageNumber)
+	if err != nil {
+		return
+	}
+	header, err := db.parsePageHeader(pageNumber, page)
+	if err != nil {
+		return
+	}
+	owners[pageNumber] = rootPage
+	for _, offset := range getCellOffsets(header, page) {
+		cell, err := db.parseCell(header, page, offset)
+		if err != nil {
+			continue
+		}
+		for overflowPage := int(cell.OverflowPage); overflowPage != 0 && !overflowPages[overflowPage]; {
+			overflowPages[overflowPage] = true
+			next, _, err := db.getOverflowPage(overflowPage)
+			if err != nil {
+				break
+			}
+			overflowPage = next
+		}
+		if cell.LeftChildPage != 0 {
+			db.mapBtreePages(int(cell.LeftChildPage), rootPage, owners, overflowPages)
+		}
+	}
+	if header.RightMostPointer != 0 {
+		db.mapBtreePages(int(header.RightMostPointer), rootPage, owners, overflowPages)
+	}
+}
+
+// scanRegion looks for anything that decodes as a table leaf cell between start and end, or as a
+// deleted cell that still starts with the header of the freeblock it was on. Those headers stay behind
+// when the freeblocks are merged, or moved to the unallocated space by a defragmentation.
+func (db *DbContext) scanRegion(pageNumber, rootPage int, page []byte, start, end int, tables []SchemaEntry) (records []RecoveredRecord) {
+	for offset := start; offset < end; offset++ {
+		if rowid, columns, cellEnd, ok := db.readLeafCell(page, offset, end); ok {
+			records = append(records, RecoveredRecord{RootPage: rootPage, Page: pageNumber, Rowid: rowid, HasRowid: true, Columns: columns})
+			offset = cellEnd - 1
+		} else if db.isFreeblockHeader(page, offset) {
+			if record, recordEnd, ok := db.recoverFreeblockHead(pageNumber, rootPage, page, offset, end, tables); ok {
+				records = append(records, record)
+				offset = recordEnd - 1
+			}
+		}
+	}
+	return
+}
+
+// readLeafCell decodes the table leaf cell at the offset, without overflow pages, returning where it ends
+func (db *DbContext) readLeafCell(page []byte, offset, end int) (rowid int64, columns []any, cellEnd int, ok bool) {
+	payloadSize, bytes, ok := tryReadBigEndianVarint(page[offset:end])
+	if !ok || payloadSize < 2 || payloadSize > int64(db.Info.UsablePageSize-35) {
+		return 0, nil, 0, false
+	}
+	rowid, rowidBytes, ok := tryReadBigEndianVarint(page[offset+bytes : end])
+	recordStart := offset + bytes + rowidBytes
+	if !ok || rowid < 1 || int64(recordStart)+payloadSize > int64(end) {
+		return 0, nil, 0, false
+	}
+	columns, size, err := db.decodeRecord(page[recordStart : recordStart+int(payloadSize)])
+	if err != nil || int64(size) != payloadSize {
+		return 0, nil, 0, false
+	}
+	return rowid, columns, recordStart + size, true
+}
+
+// isFreeblockHeader checks if the 4 bytes at the offset could be the header of a freeblock, which points
+// to a later freeblock or to none, and has room for its own header
+func (db *DbContext) isFreeblockHeader(page []byte, offset int) bool {
+	usableSize := int(db.Info.UsablePageSize)
+	if offset+4 > usableSize {
+		return false
+	}
+	next := int(readBigEndianUint16(page[offset:]))
+	size := int(readBigEndianUint16(page[offset+2:]))
+	return (next == 0 || (next > offset && next+4 <= usableSize)) && size >= 4 && offset+size <= usableSize
+}
+
+// recoverFreeblockHead tries to rebuild the deleted cell at the start of a freeblock. Its first 4 bytes
+// are overwritten by the freeblock header, losing the payload size, the rowid and usually the start of
+// the record header. The missing header is guessed for each table, assuming single byte serial types
+// and a null on the column aliased with the rowid, and only a perfect match with a table is accepted.
+// It also returns where the record ends.
+func (db *DbContext) recoverFreeblockHead(pageNumber, rootPage int, page []byte, start, end int, tables []SchemaEntry) (RecoveredRecord, int, bool) {
+	overwrittenEnd := start + 4
+	// the payload size and the rowid take at least one byte each
+	for headerStart := start + 2; headerStart <= overwrittenEnd; headerStart++ {
+		for _, table := range tables {
+			order := recordColumns(table)
+			columnCount := len(order)
+			headerEnd := headerStart + 1 + columnCount
+			if columnCount == 0 || columnCount+1 > 0x7f || headerEnd > end {
+				continue
+			}
+			if headerStart >= overwrittenEnd && int(page[headerStart]) != columnCount+1 {
+				continue
+			}
+			aliasedPKColumnNumber := aliasedRowidColumn(table.Columns)
+			recordHeader := []byte{byte(columnCount + 1)}
+			for i := 0; i < columnCount; i++ {
+				position := headerStart + 1 + i
+				if position >= overwrittenEnd {
+					recordHeader = append(recordHeader, page[position])
+				} else if order[i] == aliasedPKColumnNumber {
+					recordHeader = append(recordHeader, 0)
+				} else {
+					break
+				}
+			}
+			if len(recordHeader) != columnCount+1 {
+				continue
+			}
+			record := append(recordHeader, page[headerEnd:end]...)
+			columns, size, err := db.decodeRecord(record)
+			if err != nil || len(columns) != columnCount {
+				continue
+			}
+			recovered := RecoveredRecord{RootPage: rootPage, Page: pageNumber, Columns: columns}
+			if score, exact := recordMatchScore(recovered, table); plausibleValues(columns) && exact && score == 2*columnCount+1 {
+				return recovered, headerStart + size, true
+			}
+		}
+	}
+	return RecoveredRecord{}, 0, false
+}
+
+// bestMatchingTable returns the table that best fits the record, or nil if there is no single best one.
+// Records not found on live pages must fit the table exactly.
+func bestMatchingTable(record RecoveredRecord, tables []SchemaEntry) (table *SchemaEntry) {
+	bestScore := 0
+	tied := false
+	for i := range tables {
+		score, exact := recordMatchScore(record, tables[i])
+		if !record.Live && !exact {
+			continue
+		}
+		if score > bestScore {
+			table, bestScore, tied = &tables[i], score, false
+		} else if score == bestScore && score > 0 {
+			tied = true
+		}
+	}
+	if tied {
+		return nil
+	}
+	return
+}
+
+// recordMatchScore checks how well the record fits a table. Values that have the storage class
+// preferred by the column affinity count the most, and the match is exact when all values do.
+// Negative scores mean the record can't be from that table.
+func recordMatchScore(record RecoveredRecord, table SchemaEntry) (score int, exact bool) {
+	order := recordColumns(table)
+	if len(record.Columns) > len(order) || len(order) == 0 || record.WithoutRowid != table.WithoutRowid {
+		return -1, false
+	}
+	exact = true
+	if len(record.Columns) == len(order) {
+		score++
+	}
+	aliasedPKColumnNumber := -1
+	if !table.WithoutRowid {
+		aliasedPKColumnNumber = aliasedRowidColumn(table.Columns)
+	}
+	for i, value := range record.Columns {
+		if order[i] == aliasedPKColumnNumber {
+			if value != nil {
+				return -1, false
+			}
+			score += 2
+			continue
+		}
+		if value == nil {
+			score++
+			continue
+		}
+		var preferred bool
+		switch columnAffinity(table.Columns[order[i]].Type) {
+		case "INTEGER":
+			_, preferred = value.(int64)
+		case "REAL", "NUMERIC":
+			switch value.(type) {
+			case int64, float64:
+				preferred = true
+			}
+		case "TEXT":
+			// numbers are always converted to text before being stored
+			switch value.(type) {
+			case int64, float64:
+				return -1, false
+			}
+			_, preferred = value.(string)
+		case "BLOB":
+			// no preference, but still a possible match
+			score++
+			continue
+		}
+		if preferred {
+			score += 2
+		} else {
+			exact = false
+		}
+	}
+	return
+}
+
+// plausibleValues rejects text with invalid encoding or control characters, common on random data
+func plausibleValues(columns []any) bool {
+	for _, value := range columns {
+		if text, ok := value.(string); ok {
+			if !utf8.ValidString(text) {
+				return false
+			}
+			for _, ch := range text {
+				if unicode.IsControl(ch) && !unicode.IsSpace(ch) {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
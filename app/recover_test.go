@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRecover(t *testing.T) {
+	db := NewDbContext("testdata/recover.db")
+	defer db.Close()
+
+	result := new(bytes.Buffer)
+	db.Recover(result)
+	lines := strings.Split(strings.TrimSpace(result.String()), "\n")
+	if lines[0] != "BEGIN;" || lines[len(lines)-1] != "COMMIT;" {
+		t.Errorf("recovered SQL is not wrapped in a transaction:\n%s", result.String())
+	}
+
+	tests := []struct{ description, expected string }{
+		{"live row", "INSERT OR IGNORE INTO items(id,name,qty) VALUES(1,'item-1',3);"},
+		{"row on damaged page", "INSERT OR IGNORE INTO items(id,name,qty) VALUES(500,'item-500',1500);"},
+		{"deleted row on freeblock", "INSERT OR IGNORE INTO items(id,name,qty) VALUES(NULL,'item-5',15);"},
+		{"deleted row on unallocated space", "INSERT OR IGNORE INTO items(id,name,qty) VALUES(NULL,'item-150',450);"},
+		{"table without rowid alias", "INSERT OR IGNORE INTO notes(_rowid_,body,score) VALUES(2,'world',2.5);"},
+		{"index definition", "CREATE INDEX idx_items_qty ON items(qty);"},
+	}
+	for _, test := range tests {
+		if !strings.Contains(result.String(), test.expected) {
+			t.Errorf("%s not recovered: %q", test.description, test.expected)
+		}
+	}
+	if strings.Count(result.String(), "'item-2',") != 1 {
+		t.Errorf("live row recovered more than once: %q", "item-2")
+	}
+}
+
+func TestRecoverWithoutRowid(t *testing.T) {
+	db := NewDbContext("testdata/indexes.db")
+	defer db.Close()
+
+	result := new(bytes.Buffer)
+	db.Recover(result)
+	tests := []struct{ description, expected string }{
+		{"row on index leaf page", "INSERT OR IGNORE INTO stock(warehouse,item,qty) VALUES('w0',295,295);"},
+		{"row with primary key columns first", "INSERT OR IGNORE INTO prices(sku,currency,note,amount) VALUES(77,'BRL','price note 0233',58.5);"},
+	}
+	for _, test := range tests {
+		if !strings.Contains(result.String(), test.expected) {
+			t.Errorf("%s not recovered: %q", test.description, test.expected)
+		}
+	}
+	if count := strings.Count(result.String(), "INSERT OR IGNORE INTO stock("); count != 6000 {
+		t.Errorf("expected 6000 rows recovered from stock, got %d", count)
+	}
+	for _, line := range strings.Split(result.String(), "\n") {
+		if (strings.HasPrefix(line, "INSERT OR IGNORE INTO stock(") || strings.HasPrefix(line, "INSERT OR IGNORE INTO prices(")) &&
+			strings.Contains(line, "_rowid_") {
+			t.Errorf("rowid recovered for a table without rowid: %q", line)
+		}
+	}
+}
+
+func TestRecoverGeneratedColumns(t *testing.T) {
+	db := NewDbContext("testdata/generated.db")
+	defer db.Close()
+
+	result := new(bytes.Buffer)
+	db.Recover(result)
+	tests := []struct{ description, expected string }{
+		{"row without its virtual columns", "INSERT OR IGNORE INTO orders(id,quantity,price) VALUES(3,3,1.5);"},
+		{"row without rowid without its virtual columns", "INSERT OR IGNORE INTO shapes(name,width,height) VALUES('wide',5,1);"},
+	}
+	for _, test := range tests {
+		if !strings.Contains(result.String(), test.expected) {
+			t.Errorf("%s not recovered: %q", test.description, test.expected)
+		}
+	}
+	if count := strings.Count(result.String(), "INSERT OR IGNORE INTO shapes("); count != 3 {
+		t.Errorf("expected 3 rows recovered from shapes, got %d", count)
+	}
+}
+
+func TestRecoverReplay(t *testing.T) {
+	for _, file := range []string{"testdata/recover.db", "testdata/indexes.db", "testdata/generated.db", "../superheroes.db"} {
+		db := NewDbContext(file)
+		result := new(bytes.Buffer)
+		db.Recover(result)
+		db.Close()
+		replayRecoveredSQL(t, file, result.String())
+	}
+}
+
+// replayRecoveredSQL checks that every INSERT of the recovered SQL gives a value to each of the columns it lists,
+// or to each column of the table when it lists none, the way SQLite checks them when the SQL is run
+func replayRecoveredSQL(t *testing.T, file, sql string) {
+	tables := map[string][]ColumnDef{}
+	withoutRowid := map[string]bool{}
+	statement := ""
+	for _, line := range strings.Split(sql, "\n") {
+		statement += line + "\n"
+		if !strings.HasSuffix(line, ";") {
+			continue
+		}
+		statement, line = "", strings.TrimSpace(statement)
+		switch {
+		case strings.HasPrefix(line, "CREATE TABLE"):
+			name, columns, constraints, _, err := parseCreateTable(line)
+			if err != nil {
+				t.Errorf("%s: can't parse %q: %v", file, line, err)
+				continue
+			}
+			tables[strings.ToLower(name)] = columns
+			withoutRowid[strings.ToLower(name)] = slices.Contains(constraints, "WITHOUT ROWID")
+		case strings.HasPrefix(line, "INSERT"):
+			tokens := NewTokenizer(line)
+			tokens.Match("INSERT")
+			if tokens.Match("OR") {
+				tokens.Match("IGNORE")
+			}
+			tokens.Match("INTO")
+			name, _ := tokens.MustGetIdentifier()
+			columns, ok := tables[strings.ToLower(name)]
+			if !ok {
+				t.Errorf("%s: insert into a table that was not created: %q", file, line)
+				continue
+			}
+			expected := len(columns)
+			if tokens.Match("(") {
+				expected = 0
+				for !tokens.Match(")") {
+					column, _ := tokens.MustGetIdentifier()
+					number := slices.IndexFunc(columns, func(c ColumnDef) bool { return strings.EqualFold(c.Name, column) })
+					if number < 0 && (column != "_rowid_" || withoutRowid[strings.ToLower(name)]) {
+						t.Errorf("%s: insert into a column that is not on the table: %q", file, line)
+					} else if number >= 0 && columns[number].Generated != nil {
+						t.Errorf("%s: insert into a generated column: %q", file, line)
+					}
+					expected++
+					tokens.Match(",")
+				}
+			}
+			values := strings.TrimSuffix(line[strings.Index(line, " VALUES(")+len(" VALUES("):], ");")
+			selectStatement, err := parseSelectStatement("SELECT " + values)
+			if err != nil {
+				t.Errorf("%s: can't parse the values of %q: %v", file, line, err)
+			} else if len(selectStatement.Columns) != expected {
+				t.Errorf("%s: %d values for %d columns: %q", file, len(selectStatement.Columns), expected, line)
+			}
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+)
+
+// sorterMemory is the size of the rows a rowSorter keeps in memory before writing them to its file
+var sorterMemory = 16 << 20
+
+// rowSorter sorts rows by their values, compared with the collation of each column like compareRows. The
+// rows are kept in memory up to sorterMemory bytes, and then written sorted to a temporary file, where
+// each group of rows written together is a run. The runs are merged when the rows are read.
+type rowSorter struct {
+	collations []string
+	rows       [][]any
+	size       int
+	file       *os.File
+	runs       []int64 // the offsets of the ends of the runs in the file
+}
+
+// compareRows compares rows by their values, using the collation of each column for text, so that rows
+// are equal when SQLite finds all their values equal, like 1 and 1.0
+func compareRows(a, b []any, collations []string) int {
+	for i, collation := range collations {
+		if comparison := compareCollated(a[i], b[i], collation); comparison != 0 {
+			return comparison
+		}
+	}
+	return 0
+}
+
+func (sorter *rowSorter) compare(a, b []any) int {
+	return compareRows(a, b, sorter.collations)
+}
+
+// add adds a row to the sorter, writing the rows kept in memory to the file when they are too large
+func (sorter *rowSorter) add(row []any) error {
+	sorter.rows = append(sorter.rows, row)
+	sorter.size += 24 * len(row)
+	for _, value := range row {
+		switch v := value.(type) {
+		case string:
+			sorter.size += len(v)
+		case []byte:
+			sorter.size += len(v)
+		}
+	}
+	if sorter.size < sorterMemory {
+		return nil
+	}
+	return sorter.spill()
+}
+
+// spill writes the rows kept in memory to the file as a new run
+func (sorter *rowSorter) spill() (err error) {
+	if sorter.file == nil {
+		if sorter.file, err = os.CreateTemp("", "sqlite-sorter-"); err != nil {
+			return err
+		}
+	}
+	slices.SortStableFunc(sorter.rows, sorter.compare)
+	start := int64(0)
+	if len(sorter.runs) > 0 {
+		start = sorter.runs[len(sorter.runs)-1]
+	}
+	writer := bufio.NewWriter(io.NewOffsetWriter(sorter.file, start))
+	buffer := []byte{}
+	for _, row := range sorter.rows {
+		buffer = appendRow(buffer[:0], row)
+		if _, err = writer.Write(buffer); err != nil {
+			return err
+		}
+		start += int64(len(buffer))
+	}
+	if err = writer.Flush(); err != nil {
+		return err
+	}
+	sorter.runs = append(sorter.runs, start)
+	sorter.rows, sorter.size = nil, 0
+	return nil
+}
+
+// close removes the file of the sorter
+func (sorter *rowSorter) close() {
+	if sorter.file != nil {
+		sorter.file.Close()
+		os.Remove(sorter.file.Name())
+		sorter.file = nil
+	}
+}
+
+// distinct reads the rows in order, calling next until it returns nil. Like SQLite, the rows found equal
+// are given once, as the last one that was added.
+func (sorter *rowSorter) distinct() (next func() ([]any, error)) {
+	slices.SortStableFunc(sorter.rows, sorter.compare)
+	merge := &runMerge{compare: sorter.compare}
+	start := int64(0)
+	for i, end := range sorter.runs {
+		run := &sortedRun{reader: bufio.NewReader(io.NewSectionReader(sorter.file, start, end-start)), number: i}
+		merge.runs = append(merge.runs, run)
+		start = end
+	}
+	merge.runs = append(merge.runs, &sortedRun{rows: sorter.rows, number: len(sorter.runs)})
+	var pending []any
+	return func() ([]any, error) {
+		for {
+			row, err := merge.next()
+			if err != nil {
+				return nil, err
+			}
+			if row != nil && pending != nil && sorter.compare(row, pending) == 0 {
+				pending = row
+				continue
+			}
+			row, pending = pending, row
+			if row != nil || pending == nil {
+				return row, nil
+			}
+		}
+	}
+}
+
+// sortedRun gives the rows of a run of the file, or the rows kept in memory, which are the last run. The
+// runs have their number in the order they were added.
+type sortedRun struct {
+	reader *bufio.Reader
+	rows   [][]any
+	row    []any
+	number int
+}
+
+// advance reads the next row of the run into row, which is nil at the end of the run
+func (run *sortedRun) advance() (err error) {
+	if run.reader == nil {
+		run.row = nil
+		if len(run.rows) > 0 {
+			run.row, run.rows = run.rows[0], run.rows[1:]
+		}
+		return nil
+	}
+	if run.row, err = readRow(run.reader); err == io.EOF {
+		return nil
+	}
+	return err
+}
+
+// runMerge merges the sorted runs, as a heap of the runs sorted by their current row. Equal rows are
+// given in the order they were added.
+type runMerge struct {
+	compare func(a, b []any) int
+	runs    []*sortedRun
+	started bool
+}
+
+func (merge *runMerge) next() ([]any, error) {
+	if !merge.started {
+		merge.started = true
+		runs := merge.runs
+		merge.runs = nil
+		for _, run := range runs {
+			if err := run.advance(); err != nil {
+				return nil, err
+			}
+			if run.row != nil {
+				merge.runs = append(merge.runs, run)
+			}
+		}
+		heap.Init(merge)
+	}
+	if len(merge.runs) == 0 {
+		return nil, nil
+	}
+	run := merge.runs[0]
+	row := run.row
+	if err := run.advance(); err != nil {
+		return nil, err
+	}
+	if run.row == nil {
+		heap.Pop(merge)
+	} else {
+		heap.Fix(merge, 0)
+	}
+	return row, nil
+}
+
+func (merge *runMerge) Len() int      { return len(merge.runs) }
+func (merge *runMerge) Swap(a, b int) { merge.runs[a], merge.runs[b] = merge.runs[b], merge.runs[a] }
+func (merge *runMerge) Push(run any)  { merge.runs = append(merge.runs, run.(*sortedRun)) }
+
+func (merge *runMerge) Less(a, b int) bool {
+	if comparison := merge.compare(merge.runs[a].row, merge.runs[b].row); comparison != 0 {
+		return comparison < 0
+	}
+	return merge.runs[a].number < merge.runs[b].number
+}
+
+func (merge *runMerge) Pop() any {
+	run := merge.runs[len(merge.runs)-1]
+	merge.runs = merge.runs[:len(merge.runs)-1]
+	return run
+}
+
+// appendRow encodes a row for the file of a sorter, as its number of values followed by each value with
+// a byte telling its type
+func appendRow(buffer []byte, row []any) []byte {
+	buffer = binary.AppendUvarint(buffer, uint64(len(row)))
+	for _, value := range row {
+		switch v := value.(type) {
+		case nil:
+			buffer = append(buffer, 0)
+		case int64:
+			buffer = binary.AppendVarint(append(buffer, 1), v)
+		case float64:
+			buffer = binary.BigEndian.AppendUint64(append(buffer, 2), math.Float64bits(v))
+		case string:
+			buffer = append(binary.AppendUvarint(append(buffer, 3), uint64(len(v))), v...)
+		case []byte:
+			buffer = append(binary.AppendUvarint(append(buffer, 4), uint64(len(v))), v...)
+		}
+	}
+	return buffer
+}
+
+// readRow decodes a row written by appendRow, returning io.EOF when there is none
+func readRow(reader *bufio.Reader) ([]any, error) {
+	count, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return nil, err
+	}
+	row := make([]any, count)
+	for i := range row {
+		kind, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case 0:
+		case 1:
+			row[i], err = binary.ReadVarint(reader)
+		case 2:
+			var bits [8]byte
+			_, err = io.ReadFull(reader, bits[:])
+			row[i] = math.Float64frombits(binary.BigEndian.Uint64(bits[:]))
+		case 3, 4:
+			var length uint64
+			if length, err = binary.ReadUvarint(reader); err != nil {
+				return nil, err
+			}
+			data := make([]byte, length)
+			if _, err = io.ReadFull(reader, data); err != nil {
+				return nil, err
+			}
+			row[i] = data
+			if kind == 3 {
+				row[i] = string(data)
+			}
+		default:
+			err = fmt.Errorf("corrupted temporary file")
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return row, nil
+}
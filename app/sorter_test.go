@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRowSorterSpillsToFile(t *testing.T) {
+	defer func(memory int) { sorterMemory = memory }(sorterMemory)
+	sorterMemory = 1000
+
+	sorter := &rowSorter{collations: []string{"NOCASE", ""}}
+	defer sorter.close()
+	for i := 0; i < 1000; i++ {
+		// each row is added twice, as an integer and then as a real with a different case
+		for _, row := range [][]any{{fmt.Sprintf("k%03d", i%300), int64(i % 7)}, {fmt.Sprintf("K%03d", i%300), float64(i % 7)}} {
+			if err := sorter.add(row); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if len(sorter.runs) < 2 {
+		t.Fatalf("expected the rows to be written to the file - got %d runs", len(sorter.runs))
+	}
+	next := sorter.distinct()
+	var previous []any
+	count := 0
+	for {
+		row, err := next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if row == nil {
+			break
+		}
+		if previous != nil && sorter.compare(previous, row) >= 0 {
+			t.Fatalf("expected distinct sorted rows - got %v after %v", row, previous)
+		}
+		if _, isReal := row[1].(float64); !isReal {
+			t.Fatalf("expected the last row added of equal rows - got %v", row)
+		}
+		previous = row
+		count++
+	}
+	if count != 1000 {
+		t.Errorf("expected 1000 distinct rows - got %d", count)
+	}
+}
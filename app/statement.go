@@ -0,0 +1,395 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+)
+
+// PreparedStatement is a SELECT parsed and planned once, that can be run many times with different values
+// bound to its parameters. Parameters without a value are NULL.
+type PreparedStatement struct {
+	*SelectStatement
+	db   *DbContext
+	plan *queryPlan
+}
+
+// Prepare parses and plans a SELECT with parameters written as "?", "?NNN", ":name", "@name" or "$name"
+func (db *DbContext) Prepare(query string) (*PreparedStatement, error) {
+	statement, err := parseSelectStatement(query)
+	if err != nil {
+		return nil, err
+	}
+	plan, err := db.planSelect(statement, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &PreparedStatement{SelectStatement: statement, db: db, plan: plan}, nil
+}
+
+// ParameterCount is the largest number of the parameters of the statement
+func (statement *PreparedStatement) ParameterCount() int {
+	count := 0
+	for _, parameter := range statement.Parameters {
+		count = max(count, parameter.Column)
+	}
+	return count
+}
+
+// ParameterName finds the name of a parameter by its number, including the prefix like ":name" or "?NNN".
+// It is empty for a parameter written as "?" and for numbers not used by the statement.
+func (statement *PreparedStatement) ParameterName(number int) string {
+	for _, parameter := range statement.Parameters {
+		if parameter.Column == number && parameter.Name != "?" {
+			return parameter.Name
+		}
+	}
+	return ""
+}
+
+// ParameterNumber finds the number of a parameter by its name, or returns 0 if not used by the statement
+func (statement *PreparedStatement) ParameterNumber(name string) int {
+	for _, parameter := range statement.Parameters {
+		if parameter.Name == name && name != "?" {
+			return parameter.Column
+		}
+	}
+	return 0
+}
+
+// Bind gives a value to the parameter with the number. The value may be nil, an integer, a float, a bool,
+// a string or a []byte.
+func (statement *PreparedStatement) Bind(number int, value any) error {
+	if number < 1 || number > statement.ParameterCount() {
+		return fmt.Errorf("column index out of range: %d", number)
+	}
+	switch v := value.(type) {
+	case nil, int64, float64, string, []byte:
+	case int:
+		value = int64(v)
+	case int32:
+		value = int64(v)
+	case float32:
+		value = float64(v)
+	case bool:
+		value = boolValue(v)
+	default:
+		return fmt.Errorf("unsupported type for parameter %d: %T", number, value)
+	}
+	for _, parameter := range statement.Parameters {
+		if parameter.Column == number {
+			parameter.Value = value
+		}
+	}
+	return nil
+}
+
+// BindNamed gives a value to the parameter with the name, like ":name"
+func (statement *PreparedStatement) BindNamed(name string, value any) error {
+	number := statement.ParameterNumber(name)
+	if number == 0 {
+		return fmt.Errorf("no such parameter: %s", name)
+	}
+	return statement.Bind(number, value)
+}
+
+// ClearBindings sets all the parameters back to NULL
+func (statement *PreparedStatement) ClearBindings() {
+	for _, parameter := range statement.Parameters {
+		parameter.Value = nil
+	}
+}
+
+// Execute runs the statement with the values bound to its parameters, writing the rows of the result
+func (statement *PreparedStatement) Execute(writer io.Writer) error {
+	if statement.Explain {
+		writeQueryPlan(writer, statement.plan)
+		return nil
+	}
+
+	plan := statement.plan
+	first := statement.Columns[0].Expr
+	countingOnly := first.Op == "aggregate" && first.Name == "COUNT" && isStar(first.Args[0]) && first.Filter == nil && len(plan.Columns) == 1
+
+	// use a fast count if no filter is used to avoid processing all data
+	if countingOnly && len(plan.Sources) == 1 && plan.Sources[0].Function == nil && plan.Sources[0].Query == nil && statement.Where == nil && statement.Limit == nil && statement.GroupBy == nil && statement.Having == nil {
+		page := plan.Sources[0].Table.RootPage
+		path := plan.Steps[0].Path
+		partial := slices.ContainsFunc(statement.db.Schema, func(entry SchemaEntry) bool { return entry.RootPage == path.IndexPage && entry.Where != nil })
+		if path.Kind == "index" && path.Covering && len(path.Parts) == 0 && !partial {
+			// an index has the same number of entries on fewer pages, unless it is a partial one
+			page = path.IndexPage
+		}
+		rowCount := statement.db.fastCountRows(page)
+		fmt.Fprintln(writer, rowCount)
+		return nil
+	}
+	plan.reset()
+	return statement.run(nil, func(values []any) error {
+		writeRow(writer, values)
+		return nil
+	})
+}
+
+// run computes the rows of the result, calling visit with the values of the result columns of each one
+// until visit returns an error. Returning errStopPlan stops without an error. A subquery is run for a row
+// of the queries around it.
+func (statement *PreparedStatement) run(outer []any, visit func(values []any) error) error {
+	plan := statement.plan
+	// the rows before OFFSET are skipped, and no more rows are read after LIMIT
+	limit, offset, err := limitValues(statement.SelectStatement)
+	if err != nil {
+		return err
+	}
+	// the rows of SELECT DISTINCT are compared with the ones found before, or only with the last one when the
+	// equal rows are found one after the other. OFFSET skips distinct rows.
+	found := &rowSet{}
+	for _, column := range plan.Columns {
+		found.collations = append(found.collations, orderCollation(column.Expr))
+	}
+	var previous []any
+	isDistinct := func(values []any) bool {
+		if plan.Distinct == "ordered" {
+			distinct := previous == nil || found.compare(previous, values) != 0
+			previous = values
+			return distinct
+		}
+		return plan.Distinct == "" || found.add(values)
+	}
+	emit := func(row []any) error {
+		if offset > 0 && plan.Distinct == "" {
+			offset--
+			return nil
+		}
+		if limit == 0 {
+			return errStopPlan
+		}
+		values := make([]any, len(plan.Columns))
+		for i, column := range plan.Columns {
+			data, err := evalExpr(column.Expr, row)
+			if err != nil {
+				return err
+			}
+			values[i] = data
+		}
+		if !isDistinct(values) {
+			return nil
+		}
+		if offset > 0 {
+			offset--
+			return nil
+		}
+		if err := visit(values); err != nil {
+			return err
+		}
+		if limit--; limit == 0 {
+			return errStopPlan
+		}
+		return nil
+	}
+
+	var sortedRows [][]any
+	read := func(row []any) error {
+		if len(plan.OrderBy) == 0 || plan.Sorted {
+			return emit(row)
+		}
+		// the rows are kept until all of them are read and sorted
+		sortedRows = append(sortedRows, slices.Clone(row))
+		return nil
+	}
+	// the window functions are computed once all the rows are read
+	var windowRows [][]any
+	source := read
+	if len(plan.Windows) > 0 {
+		source = func(row []any) error {
+			windowRows = append(windowRows, slices.Clone(row))
+			return nil
+		}
+	}
+	switch {
+	case len(plan.Aggregates) > 0 || len(plan.GroupBy) > 0:
+		err = statement.db.runAggregates(plan, outer, source)
+	case len(plan.Compound) > 0:
+		err = statement.db.runCompound(plan, outer, read)
+	default:
+		err = statement.db.runPlan(plan, outer, source)
+	}
+	if err != nil {
+		return err
+	}
+	if len(plan.Windows) > 0 {
+		if err = runWindows(plan, windowRows); err != nil {
+			return err
+		}
+		for _, row := range windowRows {
+			if err = read(row); err == errStopPlan {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+	}
+
+	return sortRows(sortedRows, plan.OrderBy, emit)
+}
+
+// sortRows emits the rows in the order of the terms, keeping the order of the rows found equal. The values
+// of the terms are found once for each row before sorting.
+func sortRows(rows [][]any, order []OrderTerm, emit func(row []any) error) error {
+	keys := make([][]any, len(rows))
+	for i, row := range rows {
+		for _, term := range order {
+			data, err := evalExpr(term.Expr, row)
+			if err != nil {
+				return err
+			}
+			keys[i] = append(keys[i], data)
+		}
+	}
+	positions := make([]int, len(rows))
+	for i := range positions {
+		positions[i] = i
+	}
+	collations := []string{}
+	for _, term := range order {
+		collations = append(collations, orderCollation(term.Expr))
+	}
+	slices.SortStableFunc(positions, func(a, b int) int {
+		for i, term := range order {
+			comparison := compareCollated(keys[a][i], keys[b][i], collations[i])
+			if term.Descending {
+				comparison = -comparison
+			}
+			if comparison != 0 {
+				return comparison
+			}
+		}
+		return 0
+	})
+	for _, i := range positions {
+		if err := emit(rows[i]); err != nil {
+			if err == errStopPlan {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// limitValues finds the number of rows given by LIMIT and skipped by OFFSET, where -1 has no limit
+func limitValues(statement *SelectStatement) (limit int64, offset int64, err error) {
+	limit = -1
+	for _, clause := range []struct {
+		expr  *Expr
+		value *int64
+	}{{statement.Limit, &limit}, {statement.Offset, &offset}} {
+		if clause.expr == nil {
+			continue
+		}
+		var value any
+		if value, err = evalExpr(clause.expr, nil); err != nil {
+			return
+		}
+		// like SQLite, the value is converted with the affinity of an INTEGER column first, so that a text
+		// or a real with an integer value is that integer
+		number, isInteger := storedValue("INTEGER", value).(int64)
+		if !isInteger {
+			return 0, 0, fmt.Errorf("datatype mismatch")
+		}
+		*clause.value = number
+	}
+	if offset < 0 {
+		offset = 0
+	}
+	return
+}
+
+// runAggregates computes the aggregates from the rows of the plan and emits the rows of the result: a single
+// row, or with GROUP BY one for each group of rows with the same values of its terms, compared with their
+// collations, when it passes HAVING. The other columns are taken from the row of the result of a single
+// MIN or MAX, or like SQLite from the last row without GROUP BY and from the first row of each group.
+func (db *DbContext) runAggregates(plan *queryPlan, outer []any, emit func(row []any) error) error {
+	states := make([]aggregateState, len(plan.Aggregates))
+	single := len(plan.Aggregates) == 1 && (plan.Aggregates[0].Name == "MIN" || plan.Aggregates[0].Name == "MAX")
+	collations := make([]string, len(plan.GroupBy))
+	for i, term := range plan.GroupBy {
+		collations[i] = orderCollation(term.Expr)
+	}
+	var last, group []any
+	stopped := false
+	// finish emits the row of the aggregates of the rows seen since the last one
+	finish := func() error {
+		row := last
+		if row == nil {
+			row = make([]any, plan.Width)
+		}
+		var err error
+		for i, aggregate := range plan.Aggregates {
+			if row[aggregate.Column], err = states[i].result(aggregate); err != nil {
+				return err
+			}
+		}
+		states, last = make([]aggregateState, len(plan.Aggregates)), nil
+		if passed, err := allTrue(plan.Having, row); err != nil || !passed {
+			return err
+		}
+		if err = emit(row); err == errStopPlan {
+			stopped = true
+		}
+		return err
+	}
+	read := func(row []any) error {
+		if len(plan.GroupBy) > 0 {
+			key := make([]any, len(plan.GroupBy))
+			for i, term := range plan.GroupBy {
+				var err error
+				if key[i], err = evalExpr(term.Expr, row); err != nil {
+					return err
+				}
+			}
+			if group != nil && compareRows(key, group, collations) != 0 {
+				if err := finish(); err != nil {
+					return err
+				}
+			}
+			group = key
+		}
+		changed := false
+		for i, aggregate := range plan.Aggregates {
+			stepped, err := states[i].step(aggregate, row)
+			if err != nil {
+				return err
+			}
+			changed = changed || stepped
+		}
+		if changed || !single && len(plan.GroupBy) == 0 || last == nil {
+			last = slices.Clone(row)
+		}
+		if plan.MinMax && plan.Sorted && states[0].Count > 0 {
+			// the first value found in the order of the column is the result
+			return errStopPlan
+		}
+		return nil
+	}
+	var err error
+	if len(plan.GroupBy) > 0 && !plan.GroupSorted {
+		// the rows are sorted so that the rows of each group are read together
+		var rows [][]any
+		if err = db.runPlan(plan, outer, func(row []any) error {
+			rows = append(rows, slices.Clone(row))
+			return nil
+		}); err == nil {
+			err = sortRows(rows, plan.GroupBy, read)
+		}
+	} else {
+		err = db.runPlan(plan, outer, read)
+	}
+	if err != nil || stopped || len(plan.GroupBy) > 0 && group == nil {
+		return err
+	}
+	if err = finish(); err == errStopPlan {
+		return nil
+	}
+	return err
+}
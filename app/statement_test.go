@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPreparedStatement(t *testing.T) {
+	db := NewDbContext("testdata/stats.db")
+	defer db.Close()
+
+	statement, err := db.Prepare("select id, total from orders where customer = :customer and total > ? order by id limit ?3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := statement.ParameterCount(); count != 3 {
+		t.Errorf("expected 3 parameters - got: %d", count)
+	}
+	if name := statement.ParameterName(2); name != "" {
+		t.Errorf("expected no name for ? - got: %q", name)
+	}
+
+	// the same statement runs again with other values
+	tests := []struct {
+		customer, total, limit any
+		expected               string
+	}{
+		{7, 500, 2, "506|506\n1506|506\n"},
+		{int64(8), 0.5, 1, "7|7\n"},
+		{"8", 500, 10, "507|507\n1507|507\n2507|507\n3507|507\n4507|507\n"},
+		{nil, 0, 10, ""},
+		{7, 500, " 1.0", "506|506\n"},
+	}
+	for _, test := range tests {
+		if err := statement.BindNamed(":customer", test.customer); err != nil {
+			t.Fatal(err)
+		}
+		if err := statement.Bind(2, test.total); err != nil {
+			t.Fatal(err)
+		}
+		if err := statement.Bind(3, test.limit); err != nil {
+			t.Fatal(err)
+		}
+		result := new(bytes.Buffer)
+		if err := statement.Execute(result); err != nil {
+			t.Errorf("values: %v - error: %v", test, err)
+		} else if result.String() != test.expected {
+			t.Errorf("values: %v - expected: %q - got: %q", test, test.expected, result.String())
+		}
+	}
+
+	// parameters without values are NULL
+	statement.ClearBindings()
+	if err := statement.Execute(new(bytes.Buffer)); err == nil || err.Error() != "datatype mismatch" {
+		t.Errorf("expected datatype mismatch for LIMIT NULL - got: %v", err)
+	}
+	if err := statement.Bind(3, 1.5); err != nil {
+		t.Fatal(err)
+	}
+	if err := statement.Execute(new(bytes.Buffer)); err == nil || err.Error() != "datatype mismatch" {
+		t.Errorf("expected datatype mismatch for LIMIT 1.5 - got: %v", err)
+	}
+	if err := statement.Bind(4, 1); err == nil {
+		t.Errorf("expected error for parameter out of range")
+	}
+	if err := statement.BindNamed(":nope", 1); err == nil {
+		t.Errorf("expected error for unknown parameter")
+	}
+}
+
+func TestSetParameter(t *testing.T) {
+	db := NewDbContext("testdata/indexes.db")
+	defer db.Close()
+
+	for name, value := range map[string]string{":id": "5", "@kind": "'kind-5'", "$label": "label 0005", "?3": "2.5"} {
+		if err := db.SetParameter(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.SetParameter("id", "5"); err == nil {
+		t.Errorf("expected error for parameter without prefix")
+	}
+
+	result := new(bytes.Buffer)
+	db.PrintParameters(result)
+	expected := "$label 'label 0005'\n:id    5\n?3     2.5\n@kind  'kind-5'\n"
+	if result.String() != expected {
+		t.Errorf("expected: %q - got: %q", expected, result.String())
+	}
+
+	// "?" is found by its number
+	result.Reset()
+	if err := db.HandleSelect("select id, ?, ?, ? from events where id = :id and kind = @kind and label = $label", result); err != nil {
+		t.Fatal(err)
+	} else if expected := "5|||2.5\n"; result.String() != expected {
+		t.Errorf("expected: %q - got: %q", expected, result.String())
+	}
+
+	db.UnsetParameter(":id")
+	db.UnsetParameter("")
+	result.Reset()
+	db.PrintParameters(result)
+	if result.Len() > 0 {
+		t.Errorf("expected no parameters - got: %q", result.String())
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// indexStats has the statistics gathered by ANALYZE for an index, or for a table without indexes
+type indexStats struct {
+	Rows    float64
+	EqRows  []float64
+	Samples []statSample
+}
+
+// statSample is a key of an index sampled by ANALYZE, with the number of rows equal to its first columns
+// and with the number of rows smaller than them
+type statSample struct {
+	Key    []any
+	EqRows []float64
+	LtRows []float64
+}
+
+// statistics loads sqlite_stat1 and sqlite_stat4 on the first use, with the entries found by the lower
+// case name of the index. The primary key of a table without rowid uses the name of the table.
+func (db *DbContext) statistics() map[string]*indexStats {
+	if db.stats != nil {
+		return db.stats
+	}
+	db.stats = map[string]*indexStats{}
+	for _, entry := range db.Schema {
+		if entry.Type != "table" || (entry.Name != "sqlite_stat1" && entry.Name != "sqlite_stat4") {
+			continue
+		}
+		for _, row := range db.fullTableScan(entry.RootPage) {
+			if len(row.Columns) < 3 {
+				continue
+			}
+			table, _ := row.Columns[0].(string)
+			index, _ := row.Columns[1].(string)
+			if index == "" {
+				index = table
+			}
+			stats := db.stats[strings.ToLower(index)]
+			if stats == nil {
+				stats = &indexStats{}
+				db.stats[strings.ToLower(index)] = stats
+			}
+			if entry.Name == "sqlite_stat1" {
+				counts := parseStatCounts(row.Columns[2])
+				if len(counts) > 0 {
+					stats.Rows, stats.EqRows = counts[0], counts[1:]
+				}
+			} else if len(row.Columns) >= 6 {
+				// the sample is the record of the sampled key
+				sample, _ := row.Columns[5].([]byte)
+				key, _, err := db.decodeRecord(sample)
+				if err != nil {
+					continue
+				}
+				stats.Samples = append(stats.Samples, statSample{
+					Key:    key,
+					EqRows: parseStatCounts(row.Columns[2]),
+					LtRows: parseStatCounts(row.Columns[3]),
+				})
+			}
+		}
+	}
+	return db.stats
+}
+
+// parseStatCounts reads the list of numbers of a statistics column, ignoring the options after them
+func parseStatCounts(value any) []float64 {
+	counts := []float64{}
+	for _, field := range strings.Fields(textValue(value)) {
+		count, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			break
+		}
+		counts = append(counts, count)
+	}
+	return counts
+}
+
+// estimateTableRows guesses the number of rows of a b-tree without reading all of it, multiplying the number
+// of cells found on the pages of its left-most path
+func (db *DbContext) estimateTableRows(rootPage int) float64 {
+	rows := 1.0
+	page := rootPage
+	for {
+		header, data := db.getPage(page)
+		switch header.PageType {
+		case 0x05:
+			rows *= float64(header.CellCount) + 1
+			page = int(getInteriorTableEntries(header, data)[0].childPage)
+		case 0x02:
+			rows *= float64(header.CellCount) + 1
+			page = int(db.getInteriorIndexEntries(header, data)[0].childPage)
+		default:
+			return rows * float64(header.CellCount)
+		}
+	}
+}
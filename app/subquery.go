@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+)
+
+// subquery is a SELECT used by another query, read like a table as the one of a view or of the FROM clause,
+// or giving a value to an expression of the kind "SCALAR" for a single value or EXISTS, or "LIST" for IN.
+// Outer has the positions of the columns of the queries around it that it uses, found at the start of its
+// rows. Without them its result is the same for all the rows, so it is computed once and kept. The rows
+// of a table are also kept when they are read again for each row of the tables before it, and the ones
+// of a table of a WITH clause, given by Table, are always kept.
+type subquery struct {
+	*PreparedStatement
+	Kind   string
+	Outer  []int
+	Table  *commonTable
+	rows   [][]any
+	values []any
+	cached bool
+}
+
+// planSubquery plans a SELECT used by a query, which may use the columns of the tables in scope
+func (db *DbContext) planSubquery(statement *SelectStatement, scope []tableSource) (*subquery, error) {
+	outer := make([]tableSource, len(scope))
+	for i, source := range scope {
+		source.Depth++
+		outer[i] = source
+	}
+	plan, err := db.planSelect(statement, outer)
+	if err != nil {
+		return nil, err
+	}
+	query := &subquery{PreparedStatement: &PreparedStatement{SelectStatement: statement, db: db, plan: plan}}
+	query.Outer = plan.columnsBelow(scopeWidth(scope), nil)
+	return query, nil
+}
+
+// planSubqueries plans the subqueries of an expression, which may use the columns of the tables in scope.
+// The ones giving a value must give a single column.
+func (db *DbContext) planSubqueries(expr *Expr, scope []tableSource) (err error) {
+	if expr == nil {
+		return nil
+	}
+	if expr.Select != nil && expr.Query == nil {
+		if expr.Query, err = db.planSubquery(expr.Select, scope); err != nil {
+			return err
+		}
+		expr.Query.Kind = "SCALAR"
+		if expr.Op == "IN" {
+			expr.Query.Kind = "LIST"
+		}
+		if columns := len(expr.Query.plan.Columns); expr.Op != "EXISTS" && columns != 1 {
+			return fmt.Errorf("sub-select returns %d columns - expected 1", columns)
+		}
+	}
+	for _, arg := range operands(expr) {
+		if err = db.planSubqueries(arg, scope); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bindExpr plans the subqueries of an expression and binds its columns to the tables in scope
+func (db *DbContext) bindExpr(expr *Expr, scope []tableSource) error {
+	if err := db.planSubqueries(expr, scope); err != nil {
+		return err
+	}
+	return bindColumns(expr, scope)
+}
+
+// scopeWidth is the number of values of the rows of the tables, which are found before the values of the
+// tables of a subquery using them
+func scopeWidth(sources []tableSource) int {
+	width := 0
+	for _, source := range sources {
+		width = max(width, source.Offset+len(source.Table.Columns)+1)
+	}
+	return width
+}
+
+// statementSubqueries finds the subqueries of the expressions of a statement, in the order they are written
+func statementSubqueries(statement *SelectStatement) []*subquery {
+	exprs := []*Expr{}
+	for _, column := range statement.Columns {
+		exprs = append(exprs, column.Expr)
+	}
+	for _, ref := range statement.From {
+		exprs = append(append(exprs, ref.Args...), ref.On)
+	}
+	exprs = append(append(append(exprs, statement.Where), statement.GroupBy...), statement.Having)
+	for _, term := range statement.OrderBy {
+		exprs = append(exprs, term.Expr)
+	}
+	subqueries := []*subquery{}
+	var find func(expr *Expr)
+	find = func(expr *Expr) {
+		if expr == nil {
+			return
+		}
+		if expr.Query != nil && !slices.Contains(subqueries, expr.Query) {
+			subqueries = append(subqueries, expr.Query)
+		}
+		for _, arg := range operands(expr) {
+			find(arg)
+		}
+	}
+	for _, expr := range exprs {
+		find(expr)
+	}
+	return subqueries
+}
+
+// columnsBelow adds the positions before width of the columns used by the plan and by its subqueries,
+// which are the columns of the queries around it
+func (plan *queryPlan) columnsBelow(width int, positions []int) []int {
+	if len(plan.Compound) > 0 {
+		// the columns of a compound SELECT are the values of its rows, which are not columns of tables
+		for _, part := range plan.Compound {
+			positions = part.plan.columnsBelow(width, positions)
+		}
+		return positions
+	}
+	var find func(expr *Expr)
+	find = func(expr *Expr) {
+		if expr.Op == "column" && expr.Column < width && !slices.Contains(positions, expr.Column) {
+			positions = append(positions, expr.Column)
+		}
+		if expr.Query != nil {
+			positions = expr.Query.plan.columnsBelow(width, positions)
+		}
+		for _, arg := range operands(expr) {
+			find(arg)
+		}
+	}
+	exprs := slices.Clone(plan.Filters)
+	for _, column := range plan.Columns {
+		exprs = append(exprs, column.Expr)
+	}
+	for _, term := range plan.OrderBy {
+		exprs = append(exprs, term.Expr)
+	}
+	for _, step := range plan.Steps {
+		exprs = append(append(exprs, step.On...), step.Filters...)
+	}
+	for _, source := range plan.Sources {
+		exprs = append(exprs, source.Args...)
+		if source.Query != nil {
+			positions = source.Query.plan.columnsBelow(width, positions)
+		}
+	}
+	for _, expr := range exprs {
+		find(expr)
+	}
+	return positions
+}
+
+// reset forgets the results kept by the subqueries of the plan, as the values of the parameters may have
+// changed since they were computed
+func (plan *queryPlan) reset() {
+	for _, part := range plan.Compound {
+		part.plan.reset()
+	}
+	queries := slices.Clone(plan.Subqueries)
+	for _, source := range plan.Sources {
+		if source.Query != nil {
+			queries = append(queries, source.Query)
+		}
+	}
+	for _, query := range queries {
+		query.rows, query.values, query.cached = nil, nil, false
+		query.plan.reset()
+		if query.Table != nil && query.Table.step != nil {
+			query.Table.step.plan.reset()
+		}
+	}
+}
+
+// visit gives the rows of the query as records without rowid, for the row of the queries around it, keeping
+// them to be read again when cache is true and they don't depend on that row. The rows of a recursive
+// table read once are given as they are found, as the table may never end when it is a series read up
+// to a LIMIT.
+func (query *subquery) visit(cache bool, outer []any, visit func(record TableRecord) bool) error {
+	if !cache && query.Table != nil && query.Table.step != nil && query.Table.reads == 1 {
+		err := query.Table.run(func(values []any) error {
+			if !visit(TableRecord{Columns: values}) {
+				return errStopPlan
+			}
+			return nil
+		})
+		if err == errStopPlan {
+			return nil
+		}
+		return err
+	}
+	if (cache || query.Table != nil) && len(query.Outer) == 0 && !query.cached {
+		query.rows = nil
+		keep := func(values []any) error {
+			query.rows = append(query.rows, values)
+			return nil
+		}
+		var err error
+		if query.Table != nil && query.Table.step != nil {
+			err = query.Table.run(keep)
+		} else {
+			err = query.run(outer, keep)
+		}
+		if err != nil {
+			return err
+		}
+		query.cached = true
+	}
+	if query.cached {
+		for _, values := range query.rows {
+			if !visit(TableRecord{Columns: values}) {
+				break
+			}
+		}
+		return nil
+	}
+	return query.run(outer, func(values []any) error {
+		if !visit(TableRecord{Columns: values}) {
+			return errStopPlan
+		}
+		return nil
+	})
+}
+
+// firstRow computes the first row of the result, or nil when there is none
+func (query *subquery) firstRow(outer []any) ([]any, error) {
+	if query.cached {
+		return query.rows[0], nil
+	}
+	var first []any
+	err := query.run(outer, func(values []any) error {
+		first = values
+		return errStopPlan
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(query.Outer) == 0 {
+		query.rows, query.cached = [][]any{first}, true
+	}
+	return first, nil
+}
+
+// value is the value of the first column of the first row, or NULL when there are no rows
+func (query *subquery) value(outer []any) (any, error) {
+	first, err := query.firstRow(outer)
+	if err != nil || first == nil {
+		return nil, err
+	}
+	return first[0], nil
+}
+
+// exists tells if the query has any row
+func (query *subquery) exists(outer []any) (bool, error) {
+	first, err := query.firstRow(outer)
+	return first != nil, err
+}
+
+// sortedValues finds the values of the first column of the rows, converted to the affinity used to compare
+// them with the left operand of IN and sorted with the collation of the comparison, NULL first
+func (query *subquery) sortedValues(left *Expr, outer []any) ([]any, error) {
+	if query.cached {
+		return query.values, nil
+	}
+	column := query.plan.Columns[0].Expr
+	affinity, collation := comparisonAffinity(left, column), comparisonCollation(left, column)
+	values := []any{}
+	err := query.run(outer, func(row []any) error {
+		values = append(values, seekValue(affinity, row[0]))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	compare := func(a, b any) int { return compareCollated(a, b, collation) }
+	slices.SortFunc(values, compare)
+	values = slices.CompactFunc(values, func(a, b any) bool { return compare(a, b) == 0 })
+	if len(query.Outer) == 0 {
+		query.values, query.cached = values, true
+	}
+	return values, nil
+}
+
+// contains tells if the value of the left operand of IN is one of the values of the rows. It is NULL when
+// the value is NULL or when it isn't found but the rows have a NULL, and false when there are no rows.
+func (query *subquery) contains(left *Expr, value any, outer []any) (any, error) {
+	values, err := query.sortedValues(left, outer)
+	if err != nil || len(values) == 0 {
+		return false, err
+	}
+	if value == nil {
+		return nil, nil
+	}
+	column := query.plan.Columns[0].Expr
+	value = seekValue(comparisonAffinity(left, column), value)
+	collation := comparisonCollation(left, column)
+	if _, found := slices.BinarySearchFunc(values, value, func(a, b any) int { return compareCollated(a, b, collation) }); found {
+		return true, nil
+	}
+	if values[0] == nil {
+		return nil, nil
+	}
+	return false, nil
+}
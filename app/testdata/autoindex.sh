@@ -0,0 +1,18 @@
+#!/bin/sh
+#
+# Builds autoindex.db, a database with the indexes SQLite creates for PRIMARY KEY and UNIQUE constraints,
+# which have no SQL on the schema.
+set -e
+cd "$(dirname "$0")"
+rm -f autoindex.db
+sqlite3 autoindex.db <<SQL
+CREATE TABLE k(name TEXT PRIMARY KEY, v);
+CREATE TABLE u(id integer primary key, e text UNIQUE, f integer, g text, UNIQUE(g, f), UNIQUE(e));
+CREATE TABLE w(a text, b integer UNIQUE, c, PRIMARY KEY(a, b)) WITHOUT ROWID;
+WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c WHERE x<300)
+INSERT INTO k SELECT 'key-' || x, x * 2 FROM c;
+WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c WHERE x<300)
+INSERT INTO u SELECT x, 'e-' || x, x % 10, 'g-' || (x / 10) FROM c;
+WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c WHERE x<300)
+INSERT INTO w SELECT 'a-' || (x % 5), x, x * 3 FROM c;
+SQL
@@ -0,0 +1,19 @@
+#!/bin/sh
+#
+# Builds collation.db, a database with columns and indexes using the NOCASE and RTRIM collations, used to
+# test comparing, sorting and searching text with collations.
+set -e
+cd "$(dirname "$0")"
+rm -f collation.db
+sqlite3 collation.db <<SQL
+CREATE TABLE users(id integer primary key, name text COLLATE NOCASE, email text, code text COLLATE RTRIM);
+CREATE INDEX idx_users_name ON users(name);
+CREATE INDEX idx_users_email ON users(email COLLATE NOCASE);
+CREATE INDEX idx_users_code ON users(code);
+WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c WHERE x<2000)
+INSERT INTO users SELECT x,
+	CASE x % 3 WHEN 0 THEN 'user-' || (x % 400) WHEN 1 THEN 'USER-' || (x % 400) ELSE 'User-' || (x % 400) END,
+	printf('%s%04d@example.com', CASE x % 2 WHEN 0 THEN 'mail' ELSE 'MAIL' END, x),
+	printf('c%03d', x % 250) || substr('   ', 1, x % 3)
+FROM c;
+SQL
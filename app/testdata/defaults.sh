@@ -0,0 +1,25 @@
+#!/bin/sh
+#
+# Builds defaults.db, a database with columns added by ALTER TABLE after some rows were written, so that
+# their records are missing the new columns, which take their default values.
+set -e
+cd "$(dirname "$0")"
+rm -f defaults.db
+sqlite3 defaults.db <<SQL
+CREATE TABLE items(id integer primary key, name text);
+INSERT INTO items VALUES(1, 'first'), (2, 'second');
+ALTER TABLE items ADD COLUMN qty integer DEFAULT 5;
+ALTER TABLE items ADD COLUMN note text NOT NULL DEFAULT 'none' COLLATE NOCASE;
+ALTER TABLE items ADD COLUMN price real DEFAULT -1;
+ALTER TABLE items ADD COLUMN tag DEFAULT x'4142';
+ALTER TABLE items ADD COLUMN flag boolean DEFAULT TRUE;
+ALTER TABLE items ADD COLUMN extra;
+INSERT INTO items VALUES(3, 'third', 7, 'some', 2.5, NULL, FALSE, 'x');
+INSERT INTO items(id, name) VALUES(4, 'fourth');
+CREATE INDEX idx_items_qty ON items(qty);
+CREATE TABLE pairs(k text PRIMARY KEY, v) WITHOUT ROWID;
+INSERT INTO pairs VALUES('a', 1), ('b', 2);
+ALTER TABLE pairs ADD COLUMN w DEFAULT 'dflt';
+INSERT INTO pairs VALUES('c', 3, 'w');
+CREATE TABLE events(id integer primary key, at text DEFAULT CURRENT_TIMESTAMP, n integer DEFAULT (2 * 3));
+SQL
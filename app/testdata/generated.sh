@@ -0,0 +1,24 @@
+#!/bin/sh
+#
+# Builds generated.db, a database with VIRTUAL and STORED generated columns, so that the records don't have
+# the virtual columns, and with an index on a virtual column.
+set -e
+cd "$(dirname "$0")"
+rm -f generated.db
+sqlite3 generated.db <<SQL
+CREATE TABLE orders(
+	id integer PRIMARY KEY,
+	quantity integer NOT NULL,
+	total text GENERATED ALWAYS AS (price * quantity) VIRTUAL,
+	price real,
+	code text AS ('order-' || id) STORED,
+	discounted real AS (total * 0.9),
+	label AS (upper(code) || ':' || quantity)
+);
+CREATE INDEX idx_orders_total ON orders(total);
+WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c WHERE x<1000)
+INSERT INTO orders(id, quantity, price) SELECT x, x % 7, (x % 13) * 0.5 FROM c;
+CREATE TABLE shapes(name text PRIMARY KEY, width integer, height integer, area integer AS (width * height)) WITHOUT ROWID;
+CREATE INDEX idx_shapes_area ON shapes(area);
+INSERT INTO shapes(name, width, height) VALUES('square', 2, 2), ('wide', 5, 1), ('tall', 1, 3);
+SQL
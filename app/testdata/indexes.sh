@@ -0,0 +1,31 @@
+#!/bin/sh
+#
+# Builds indexes.db, a database with indexes deep enough to have interior pages, used to test
+# the access to tables by rowid and index ranges, using indexes and primary keys with several columns, also on tables without rowid.
+set -e
+cd "$(dirname "$0")"
+rm -f indexes.db
+sqlite3 indexes.db <<SQL
+CREATE TABLE events(id integer primary key, kind text, score integer, label text);
+CREATE INDEX idx_events_kind ON events(kind);
+CREATE INDEX idx_events_score ON events(score DESC);
+WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c WHERE x<3000)
+INSERT INTO events SELECT x,
+	CASE WHEN x % 50 = 0 THEN NULL ELSE 'kind-' || (x % 7) END,
+	CASE WHEN x % 40 = 0 THEN NULL ELSE x % 100 END,
+	printf('label %04d', x)
+FROM c;
+INSERT INTO events VALUES(3001, 'Kind-1', 'high', 'upper case kind'), (3002, 'KIND-9', 5.5, 'real score');
+CREATE TABLE readings(id integer primary key, sensor text, day integer, value real);
+CREATE INDEX idx_readings_sensor_day ON readings(sensor, day DESC);
+WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c WHERE x<5000)
+INSERT INTO readings SELECT x, 'sensor-' || (x % 10), x / 10, x * 0.5 FROM c;
+CREATE TABLE stock(warehouse text, item integer, qty integer, PRIMARY KEY (warehouse, item)) WITHOUT ROWID;
+WITH RECURSIVE c(x) AS (SELECT 0 UNION ALL SELECT x+1 FROM c WHERE x<5999)
+INSERT INTO stock SELECT 'w' || (x / 300), x % 300, x FROM c;
+CREATE TABLE prices(note text, currency text, amount real, sku integer, PRIMARY KEY (sku, currency)) WITHOUT ROWID;
+CREATE INDEX idx_prices_amount ON prices(amount);
+WITH RECURSIVE c(x) AS (SELECT 0 UNION ALL SELECT x+1 FROM c WHERE x<1999)
+INSERT INTO prices SELECT printf('price note %04d', x), CASE x % 3 WHEN 0 THEN 'USD' WHEN 1 THEN 'EUR' ELSE 'BRL' END,
+	CASE WHEN x % 25 = 0 THEN NULL ELSE (x % 97) * 1.5 END, x / 3 FROM c;
+SQL
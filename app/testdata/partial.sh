@@ -0,0 +1,13 @@
+#!/bin/sh
+#
+# Builds partial.db, a database with partial indexes, which only have the rows matching their WHERE clause.
+set -e
+cd "$(dirname "$0")"
+rm -f partial.db
+sqlite3 partial.db <<SQL
+CREATE TABLE t(a integer PRIMARY KEY, b text, c real);
+CREATE INDEX t_part ON t(c) WHERE c > 1;
+CREATE INDEX t_b ON t(b) WHERE c IS NOT NULL;
+WITH RECURSIVE s(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM s WHERE x<1000)
+INSERT INTO t SELECT x, 'b-' || (x % 50), CASE WHEN x % 10 = 0 THEN NULL ELSE (x % 40) * 0.25 END FROM s;
+SQL
@@ -0,0 +1,25 @@
+#!/bin/sh
+#
+# Builds recover.db, a database with deleted rows still on its pages and a damaged leaf page,
+# used to test the .recover command.
+set -e
+cd "$(dirname "$0")"
+rm -f recover.db
+sqlite3 recover.db <<SQL
+PRAGMA secure_delete=OFF;
+CREATE TABLE items(id integer primary key, name text, qty integer);
+CREATE TABLE notes(body text, score real);
+CREATE INDEX idx_items_qty ON items(qty);
+WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c WHERE x<500)
+INSERT INTO items SELECT x, 'item-' || x, x*3 FROM c;
+INSERT INTO notes VALUES('hello', 1.5), ('world', 2.5);
+DELETE FROM items WHERE id BETWEEN 100 AND 300;
+DELETE FROM items WHERE id = 5 OR id = 400;
+SQL
+# overwrite the header of the leaf page holding the last rows
+page=$(sqlite3 recover.db "SELECT pageno FROM dbstat WHERE name = 'items' AND pagetype = 'leaf' ORDER BY pageno DESC LIMIT 1" 2>/dev/null || echo "")
+if [ -z "$page" ]; then
+	echo "dbstat is required to find the page to damage" >&2
+	exit 1
+fi
+printf '\377\377\377\377\377\377\377\377' | dd of=recover.db bs=1 seek=$(( (page - 1) * 4096 )) conv=notrunc 2>/dev/null
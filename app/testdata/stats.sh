@@ -0,0 +1,32 @@
+#!/bin/sh
+#
+# Builds stats.db, a database with skewed data analyzed into sqlite_stat1, used to test the choices of
+# the query planner. The sqlite3 shell may not have STAT4 enabled, so sqlite_stat4 is created through the
+# writable schema and filled with a sample for each status of the orders.
+set -e
+cd "$(dirname "$0")"
+rm -f stats.db
+sqlite3 stats.db <<SQL
+CREATE TABLE customers(id integer primary key, name text, city text);
+CREATE INDEX idx_customers_city ON customers(city);
+WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c WHERE x<500)
+INSERT INTO customers SELECT x, printf('customer %03d', x), 'city-' || (x % 50) FROM c;
+CREATE TABLE orders(id integer primary key, status text, customer integer, total integer);
+CREATE INDEX idx_orders_status ON orders(status);
+CREATE INDEX idx_orders_customer ON orders(customer);
+WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c WHERE x<5000)
+INSERT INTO orders SELECT x, CASE WHEN x % 100 = 0 THEN 'void' WHEN x % 25 = 0 THEN 'open' ELSE 'done' END,
+	x % 500 + 1, x % 1000 FROM c;
+ANALYZE;
+SQL
+sqlite3 -unsafe-testing stats.db ".dbconfig defensive off" "PRAGMA writable_schema=ON" \
+	"CREATE TABLE stat4(tbl,idx,neq,nlt,ndlt,sample)" \
+	"UPDATE sqlite_schema SET name='sqlite_stat4', tbl_name='sqlite_stat4', sql=replace(sql, 'stat4', 'sqlite_stat4') WHERE name='stat4'" >/dev/null
+sqlite3 stats.db <<SQL
+INSERT INTO sqlite_stat4 SELECT 'orders', 'idx_orders_status',
+	(SELECT count(*) FROM orders WHERE status = s.status) || ' 1',
+	(SELECT count(*) FROM orders WHERE status < s.status) || ' ' || (SELECT count(*) FROM orders WHERE status < s.status),
+	(SELECT count(DISTINCT status) FROM orders WHERE status < s.status) || ' ' || (SELECT count(*) FROM orders WHERE status < s.status),
+	cast(unhex(printf('03%02x04', 13 + 2 * length(s.status))) || s.status || unhex(printf('%08x', s.id)) AS blob)
+FROM (SELECT status, min(id) AS id FROM orders GROUP BY status) s;
+SQL
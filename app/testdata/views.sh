@@ -0,0 +1,19 @@
+#!/bin/sh
+#
+# Builds views.db, a database with views on a table, a view with a list of column names, a view with
+# an aggregate and views built on other views.
+set -e
+cd "$(dirname "$0")"
+rm -f views.db
+sqlite3 views.db <<SQL
+CREATE TABLE employees(id integer PRIMARY KEY, name text COLLATE NOCASE, dept text, salary real, manager integer);
+CREATE INDEX idx_employees_dept ON employees(dept);
+INSERT INTO employees VALUES(1, 'Ada', 'eng', 120, NULL), (2, 'bob', 'eng', 95.5, 1), (3, 'Cy', 'ops', 70, 1),
+	(4, 'dee', 'ops', 82, 3), (5, 'Eve', 'sales', 60, 1), (6, 'fay', 'eng', 101, 2);
+CREATE VIEW engineers AS SELECT id, name, salary FROM employees WHERE dept = 'eng';
+CREATE VIEW payroll(who, monthly) AS SELECT name, salary / 12 FROM employees ORDER BY salary DESC;
+CREATE VIEW headcount AS SELECT count(*) AS total, max(salary) FROM employees;
+CREATE VIEW senior_engineers AS SELECT * FROM engineers WHERE salary > 100;
+CREATE VIEW top_senior AS SELECT name || '!' FROM senior_engineers ORDER BY salary DESC LIMIT 1;
+CREATE VIEW managers AS SELECT e.name AS employee, m.name AS manager FROM employees e LEFT JOIN employees m ON m.id = e.manager;
+SQL
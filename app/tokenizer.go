@@ -11,6 +11,8 @@ type Tokenizer struct {
 	Current int
 	Source  string
 	Tokens  []string
+	// Parameters are the expressions of the parameters found while parsing the tokens
+	Parameters []*Expr
 }
 
 func NewTokenizer(source string) (tokenizer *Tokenizer) {
@@ -44,6 +46,17 @@ func (t *Tokenizer) tokenize(source string) {
 				}
 				panic(err)
 			}
+			if ch2 == '>' {
+				// the JSON operators -> and ->>
+				token := "->"
+				if ch3, _, err := r.ReadRune(); err == nil && ch3 == '>' {
+					token = "->>"
+				} else if err == nil {
+					r.UnreadRune()
+				}
+				tokens = append(tokens, token)
+				continue
+			}
 			if ch2 == '-' {
 				// ignore everything until linefeed
 				for {
@@ -115,15 +128,73 @@ func (t *Tokenizer) tokenize(source string) {
 				}
 				runes = append(runes, ch)
 				if ch == '\'' {
+					// a doubled quote is an escaped quote inside the string
+					ch2, _, err := r.ReadRune()
+					if err == nil && ch2 == '\'' {
+						runes = append(runes, ch2)
+						continue
+					} else if err == nil {
+						r.UnreadRune()
+					}
 					break
 				}
 			}
 			tokens = append(tokens, string(runes))
 
-		case '(', ')', ',', '*':
+		case '(', ')', ',', '*', ';', '/', '%', '~', '&':
 			tokens = append(tokens, string(ch))
 
+		case '|':
+			// a single bar is the bitwise OR, and two are the concatenation operator
+			token := "|"
+			if ch2, _, err := r.ReadRune(); err == nil && ch2 == '|' {
+				token = "||"
+			} else if err == nil {
+				r.UnreadRune()
+			}
+			tokens = append(tokens, token)
+
+		case '.':
+			// a dot separates qualified names, unless it starts a number like .5
+			ch2, _, err := r.ReadRune()
+			if err == nil {
+				r.UnreadRune()
+			}
+			if err != nil || !unicode.IsDigit(ch2) {
+				tokens = append(tokens, ".")
+				continue
+			}
+			runes := []rune{ch}
+			for {
+				ch, _, err := r.ReadRune()
+				if err != nil {
+					break
+				}
+				if !unicode.IsDigit(ch) && ch != 'e' && ch != 'E' && ch != '-' && ch != '+' {
+					r.UnreadRune()
+					break
+				}
+				runes = append(runes, ch)
+			}
+			tokens = append(tokens, string(runes))
+
+		case '=', '<', '>', '!':
+			// comparison operators may have two characters: == <= >= <> !=, and so do the shifts << >>
+			token := string(ch)
+			ch2, _, err := r.ReadRune()
+			if err == nil {
+				if ch2 == '=' || (ch == '<' && ch2 == '>') || (ch != '=' && ch != '!' && ch2 == ch) {
+					token += string(ch2)
+				} else {
+					r.UnreadRune()
+				}
+			}
+			tokens = append(tokens, token)
+
 		case '-', '+', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+			// a sign followed by a number is kept with it, and the parser splits it after an operand
+			// like in "a -1". Inside the number, a sign may only follow the exponent. A number starting
+			// with 0x is hexadecimal, and takes the letters and digits after it for the parser to check.
 			runes := []rune{ch}
 		number_loop:
 			for {
@@ -134,8 +205,36 @@ func (t *Tokenizer) tokenize(source string) {
 					}
 					panic(err)
 				}
+				last := runes[len(runes)-1]
 				switch ch {
-				case '-', '+', '0', '1', '2', '3', '4', '5', '6', '7', '8', '9', '.', 'e', 'E':
+				case '-', '+':
+					if last != 'e' && last != 'E' {
+						r.UnreadRune()
+						break number_loop
+					}
+					runes = append(runes, ch)
+				case 'x', 'X':
+					if last != '0' || strings.TrimLeft(string(runes), "+-") != "0" {
+						r.UnreadRune()
+						break number_loop
+					}
+					runes = append(runes, ch)
+					for {
+						ch, _, err := r.ReadRune()
+						if err != nil {
+							break number_loop
+						}
+						if !unicode.IsLetter(ch) && !unicode.IsDigit(ch) {
+							r.UnreadRune()
+							break number_loop
+						}
+						runes = append(runes, ch)
+					}
+				case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9', '.', 'e', 'E':
+					if (last == '-' || last == '+') && len(runes) == 1 && ch != '.' && !unicode.IsDigit(ch) {
+						r.UnreadRune()
+						break number_loop
+					}
 					runes = append(runes, ch)
 				default:
 					r.UnreadRune()
@@ -156,7 +255,7 @@ func (t *Tokenizer) tokenize(source string) {
 					panic(err)
 				}
 				switch ch {
-				case '(', ')', ',', '*', '[', '"':
+				case '(', ')', ',', '*', ';', '[', '"', '\'', '=', '<', '>', '!', '.', '+', '-', '/', '%', '|', '&', '~':
 					r.UnreadRune()
 					break default_loop
 				}
@@ -14,7 +14,14 @@ func TestTokenizer(t *testing.T) {
 		{",abc, def,ghi   ,  jkl  , mno,", []string{",", "abc", ",", "def", ",", "ghi", ",", "jkl", ",", "mno", ","}},
 		{"123 456 789 3.1415926 -123 +45.12 +1e10 -3.5e-1", []string{"123", "456", "789", "3.1415926", "-123", "+45.12", "+1e10", "-3.5e-1"}},
 		{"\"abc\",[def],'ghi'", []string{"abc", ",", "def", ",", "'ghi'"}},
+		{"'it''s' 'a''' ''", []string{"'it''s'", "'a'''", "''"}},
+		{"select a from t;", []string{"select", "a", "from", "t", ";"}},
 		{"abc(((*,*)))def", []string{"abc", "(", "(", "(", "*", ",", "*", ")", ")", ")", "def"}},
+		{"a=1 b<>'x' c<=d e>=f g!=h i==j k<l m>n", []string{"a", "=", "1", "b", "<>", "'x'", "c", "<=", "d", "e", ">=", "f", "g", "!=", "h", "i", "==", "j", "k", "<", "l", "m", ">", "n"}},
+		{"a-1 1+2 -b a||b x<<2 y>>1 ~c&d|e 5%2/f 1e-3", []string{"a", "-1", "1", "+2", "-", "b", "a", "||", "b", "x", "<<", "2", "y", ">>", "1", "~", "c", "&", "d", "|", "e", "5", "%", "2", "/", "f", "1e-3"}},
+		{"a->'b' c->>2 d-->x\n-e", []string{"a", "->", "'b'", "c", "->>", "2", "d", "-", "e"}},
+		{"t.a, \"t\".\"b c\", s.*, .5, 1.5", []string{"t", ".", "a", ",", "t", ".", "b c", ",", "s", ".", "*", ",", ".5", ",", "1.5"}},
+		{"0xff+0X1a -0x1g,10x 0.x", []string{"0xff", "+0X1a", "-0x1g", ",", "10", "x", "0.", "x"}},
 	}
 
 	for _, test := range tests {
@@ -1,9 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"cmp"
+	"encoding/hex"
 	"log"
+	"math"
 	"slices"
+	"strconv"
+	"strings"
 )
 
 func readBigEndianUint16(b []byte) uint16 {
@@ -30,6 +35,17 @@ func readBigEndianVarint(data []byte) (value int64, size int) {
 	return
 }
 
+// tryReadBigEndianVarint is like readBigEndianVarint but reports truncated input instead of panicking
+func tryReadBigEndianVarint(data []byte) (value int64, size int, ok bool) {
+	for i := 0; i < len(data) && i < 9; i++ {
+		if i == 8 || data[i]>>7 == 0 {
+			value, size = readBigEndianVarint(data)
+			return value, size, true
+		}
+	}
+	return 0, 0, false
+}
+
 func readBigEndianInt(data []byte) (value int64) {
 	for _, b := range data {
 		value = (value << 8) | int64(b)
@@ -37,44 +53,222 @@ func readBigEndianInt(data []byte) (value int64) {
 	return value
 }
 
-func compareAny(a any, b any) int {
-	if a == nil && b == nil {
-		return 0
-	} else if a == nil {
-		return 1
-	} else if b == nil {
-		return -1
+// compareValues orders any two values the same way SQLite does when no affinity or collation applies:
+// NULL first, then numbers, text and blobs, see https://www.sqlite.org/datatype3.html#sort_order
+func compareValues(a any, b any) int {
+	aClass, bClass := storageClassOrder(a), storageClassOrder(b)
+	if aClass != bClass {
+		return cmp.Compare(aClass, bClass)
 	}
-	switch a.(type) {
-	case string:
-		switch b.(type) {
-		case string:
-			return cmp.Compare(a.(string), b.(string))
-		case []byte:
-			return cmp.Compare(a.(string), string(b.([]byte)))
-		}
+	switch a := a.(type) {
 	case int64:
-		switch b.(type) {
+		switch b := b.(type) {
 		case int64:
-			return cmp.Compare(a.(int64), b.(int64))
+			return cmp.Compare(a, b)
 		case float64:
-			return cmp.Compare(float64(a.(int64)), b.(float64))
+			return -compareRealInteger(b, a)
 		}
 	case float64:
-		switch b.(type) {
-		case float64:
-			return cmp.Compare(a.(float64), b.(float64))
+		switch b := b.(type) {
 		case int64:
-			return cmp.Compare(a.(float64), float64(b.(int64)))
+			return compareRealInteger(a, b)
+		case float64:
+			return cmp.Compare(a, b)
+		}
+	case string:
+		return strings.Compare(a, b.(string))
+	case []byte:
+		return bytes.Compare(a, b.([]byte))
+	}
+	return 0
+}
+
+// compareCollated compares two values like compareValues, but using a collation when both are text.
+// Unknown collations compare like BINARY, as they are refused when preparing the statements.
+func compareCollated(a any, b any, collation string) int {
+	aText, aIsText := a.(string)
+	bText, bIsText := b.(string)
+	if !aIsText || !bIsText {
+		return compareValues(a, b)
+	}
+	if compare := findCollation(collation); compare != nil {
+		return compare(aText, bText)
+	}
+	return strings.Compare(aText, bText)
+}
+
+func storageClassOrder(value any) int {
+	switch value.(type) {
+	case nil:
+		return 0
+	case int64, float64:
+		return 1
+	case string:
+		return 2
+	}
+	return 3
+}
+
+// compareRealInteger compares without losing precision on integers that can't be exactly represented as float64
+func compareRealInteger(a float64, b int64) int {
+	if a < -9223372036854775808.0 {
+		return -1
+	} else if a >= 9223372036854775808.0 {
+		return 1
+	}
+	if c := cmp.Compare(math.Trunc(a), float64(b)); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(int64(a), b); c != 0 {
+		return c
+	}
+	return cmp.Compare(a, math.Trunc(a))
+}
+
+// quoteLiteral formats a value as an SQL literal that reads back as the same value
+func quoteLiteral(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		if math.IsNaN(v) {
+			return "NULL"
+		} else if math.IsInf(v, 1) {
+			return "9.0e+999"
+		} else if math.IsInf(v, -1) {
+			return "-9.0e+999"
 		}
+		// shortest representation that round-trips, but always with a decimal point or exponent
+		// so it is not read back as an integer
+		text := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(text, ".e") {
+			text += ".0"
+		}
+		return text
+	case string:
+		quoted := "'" + strings.ReplaceAll(v, "'", "''") + "'"
+		if strings.ContainsAny(v, "\r\n") {
+			// keep each statement on a single line
+			if strings.Contains(v, "\n") {
+				quoted = "replace(" + strings.ReplaceAll(quoted, "\n", `\n`) + `,'\n',char(10))`
+			}
+			if strings.Contains(v, "\r") {
+				quoted = "replace(" + strings.ReplaceAll(quoted, "\r", `\r`) + `,'\r',char(13))`
+			}
+		}
+		return quoted
 	case []byte:
-		switch b.(type) {
-		case string:
-			return cmp.Compare(string(a.([]byte)), b.(string))
-		case []byte:
-			return slices.Compare(a.([]byte), b.([]byte))
+		return "X'" + hex.EncodeToString(v) + "'"
+	}
+	log.Fatalf("no literal for type: %T", value)
+	return ""
+}
+
+// quoteIdentifier adds double quotes to names that would not be read back as a plain identifier
+func quoteIdentifier(name string) string {
+	plain := name != "" && !slices.Contains(sqlKeywords, strings.ToUpper(name))
+	for i, ch := range name {
+		if !(ch == '_' || ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || i > 0 && ch >= '0' && ch <= '9') {
+			plain = false
+			break
+		}
+	}
+	if plain {
+		return name
+	}
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+var sqlKeywords = []string{
+	"ABORT", "ACTION", "ADD", "AFTER", "ALL", "ALTER", "ALWAYS", "ANALYZE", "AND", "AS", "ASC", "ATTACH",
+	"AUTOINCREMENT", "BEFORE", "BEGIN", "BETWEEN", "BY", "CASCADE", "CASE", "CAST", "CHECK", "COLLATE",
+	"COLUMN", "COMMIT", "CONFLICT", "CONSTRAINT", "CREATE", "CROSS", "CURRENT", "CURRENT_DATE",
+	"CURRENT_TIME", "CURRENT_TIMESTAMP", "DATABASE", "DEFAULT", "DEFERRABLE", "DEFERRED", "DELETE", "DESC",
+	"DETACH", "DISTINCT", "DO", "DROP", "EACH", "ELSE", "END", "ESCAPE", "EXCEPT", "EXCLUDE", "EXCLUSIVE",
+	"EXISTS", "EXPLAIN", "FAIL", "FILTER", "FIRST", "FOLLOWING", "FOR", "FOREIGN", "FROM", "FULL",
+	"GENERATED", "GLOB", "GROUP", "GROUPS", "HAVING", "IF", "IGNORE", "IMMEDIATE", "IN", "INDEX", "INDEXED",
+	"INITIALLY", "INNER", "INSERT", "INSTEAD", "INTERSECT", "INTO", "IS", "ISNULL", "JOIN", "KEY", "LAST",
+	"LEFT", "LIKE", "LIMIT", "MATCH", "MATERIALIZED", "NATURAL", "NO", "NOT", "NOTHING", "NOTNULL", "NULL",
+	"NULLS", "OF", "OFFSET", "ON", "OR", "ORDER", "OTHERS", "OUTER", "OVER", "PARTITION", "PLAN", "PRAGMA",
+	"PRECEDING", "PRIMARY", "QUERY", "RAISE", "RANGE", "RECURSIVE", "REFERENCES", "REGEXP", "REINDEX",
+	"RELEASE", "RENAME", "REPLACE", "RESTRICT", "RETURNING", "RIGHT", "ROLLBACK", "ROW", "ROWS", "SAVEPOINT",
+	"SELECT", "SET", "TABLE", "TEMP", "TEMPORARY", "THEN", "TIES", "TO", "TRANSACTION", "TRIGGER",
+	"UNBOUNDED", "UNION", "UNIQUE", "UPDATE", "USING", "VACUUM", "VALUES", "VIEW", "VIRTUAL", "WHEN", "WHERE",
+	"WINDOW", "WITH", "WITHOUT",
+}
+
+// matchLike implements the LIKE operator: "%" matches any sequence, "_" matches a single character
+// and ASCII letters are case insensitive
+func matchLike(pattern, text string, escape rune) bool {
+	p, s := []rune(pattern), []rune(text)
+	for len(p) > 0 {
+		ch := p[0]
+		switch {
+		case ch == escape && len(p) > 1:
+			if len(s) == 0 || !equalFoldASCII(p[1], s[0]) {
+				return false
+			}
+			p, s = p[2:], s[1:]
+		case ch == '%':
+			for len(p) > 0 && (p[0] == '%' || p[0] == '_') {
+				if p[0] == '_' {
+					if len(s) == 0 {
+						return false
+					}
+					s = s[1:]
+				}
+				p = p[1:]
+			}
+			if len(p) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if matchLike(string(p), string(s[i:]), escape) {
+					return true
+				}
+			}
+			return false
+		case ch == '_':
+			if len(s) == 0 {
+				return false
+			}
+			p, s = p[1:], s[1:]
+		default:
+			if len(s) == 0 || !equalFoldASCII(ch, s[0]) {
+				return false
+			}
+			p, s = p[1:], s[1:]
 		}
 	}
-	log.Fatalf("no comparison for types: %T and %T", a, b)
-	return -1
+	return len(s) == 0
+}
+
+func equalFoldASCII(a, b rune) bool {
+	if a >= 'A' && a <= 'Z' {
+		a += 'a' - 'A'
+	}
+	if b >= 'A' && b <= 'Z' {
+		b += 'a' - 'A'
+	}
+	return a == b
+}
+
+func upperASCII(text string) string {
+	return strings.Map(func(ch rune) rune {
+		if ch >= 'a' && ch <= 'z' {
+			return ch - 'a' + 'A'
+		}
+		return ch
+	}, text)
+}
+
+func lowerASCII(text string) string {
+	return strings.Map(func(ch rune) rune {
+		if ch >= 'A' && ch <= 'Z' {
+			return ch - 'A' + 'a'
+		}
+		return ch
+	}, text)
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// planView plans the SELECT of a view, which is then read like a table whose columns are the result
+// columns of the SELECT, renamed by the list of columns of the view if any
+func (db *DbContext) planView(entry SchemaEntry) (SchemaEntry, *subquery, error) {
+	if slices.ContainsFunc(db.openViews, func(name string) bool { return strings.EqualFold(name, entry.Name) }) {
+		return SchemaEntry{}, nil, fmt.Errorf("view %s is circularly defined", entry.Name)
+	}
+	db.openViews = append(db.openViews, entry.Name)
+	// the tables of the WITH clauses of the query reading the view are not known by its SELECT
+	known := db.commonTables
+	db.commonTables = nil
+	defer func() { db.openViews, db.commonTables = db.openViews[:len(db.openViews)-1], known }()
+
+	name, names, statement, _, err := parseCreateView(entry.SQL)
+	if err != nil {
+		return SchemaEntry{}, nil, err
+	}
+	plan, err := db.planSelect(statement, nil)
+	if err != nil {
+		return SchemaEntry{}, nil, err
+	}
+	if names != nil && len(names) != len(plan.Columns) {
+		return SchemaEntry{}, nil, fmt.Errorf("expected %d columns for '%s' but got %d", len(names), name, len(plan.Columns))
+	}
+	view := SchemaEntry{Type: "view", Name: entry.Name, TableName: entry.Name, SQL: entry.SQL, WithoutRowid: true}
+	view.Columns = queryColumns(plan.Columns, names)
+	return view, &subquery{PreparedStatement: &PreparedStatement{SelectStatement: statement, db: db, plan: plan}}, nil
+}
+
+// queryColumns defines the columns of a table made of the result columns of a query. They are named by
+// the names given, by their alias or by the name of the column they are, and then by their expression,
+// adding a number to the names found more than once. A column keeps the affinity and the collation of its
+// expression.
+func queryColumns(columns []ResultColumn, names []string) []ColumnDef {
+	definitions := []ColumnDef{}
+	for i, column := range columns {
+		definition := ColumnDef{Name: column.Alias, Type: column.Expr.Affinity}
+		switch {
+		case i < len(names):
+			definition.Name = names[i]
+		case definition.Name == "" && column.Expr.Op == "column":
+			definition.Name = column.Expr.Name
+		case definition.Name == "":
+			definition.Name = formatExpr(column.Expr)
+		}
+		if collation, _ := exprCollation(column.Expr); collation != "" {
+			definition.Constraints = []string{"COLLATE " + collation}
+		}
+		base := definition.Name
+		for number := 1; slices.ContainsFunc(definitions, func(previous ColumnDef) bool {
+			return strings.EqualFold(previous.Name, definition.Name)
+		}); number++ {
+			definition.Name = fmt.Sprintf("%s:%d", base, number)
+		}
+		definitions = append(definitions, definition)
+	}
+	return definitions
+}
+
+// CreateView adds a view to the schema after checking its SELECT. The database file is not changed, so
+// the view is only known until the database is closed.
+func (db *DbContext) CreateView(sql string) error {
+	name, _, _, ifNotExists, err := parseCreateView(sql)
+	if err != nil {
+		return err
+	}
+	for _, entry := range db.Schema {
+		if strings.EqualFold(entry.Name, name) {
+			if ifNotExists && entry.Type == "view" {
+				return nil
+			}
+			if entry.Type == "index" {
+				return fmt.Errorf("there is already an index named %s", name)
+			}
+			return fmt.Errorf("%s %s already exists", entry.Type, name)
+		}
+	}
+	entry := SchemaEntry{Type: "view", Name: name, TableName: name, SQL: strings.TrimRight(strings.TrimSpace(sql), ";")}
+	if _, _, err = db.planView(entry); err != nil {
+		return err
+	}
+	db.Schema = append(db.Schema, entry)
+	return nil
+}
+
+// DropView removes a view from the schema. Like CreateView, it does not change the database file.
+func (db *DbContext) DropView(sql string) error {
+	name, ifExists, err := parseDropView(sql)
+	if err != nil {
+		return err
+	}
+	for i, entry := range db.Schema {
+		if !strings.EqualFold(entry.Name, name) {
+			continue
+		}
+		if entry.Type == "table" {
+			return fmt.Errorf("use DROP TABLE to delete table %s", entry.Name)
+		}
+		if entry.Type == "view" {
+			db.Schema = slices.Delete(db.Schema, i, i+1)
+			return nil
+		}
+	}
+	if ifExists {
+		return nil
+	}
+	return fmt.Errorf("no such view: %s", name)
+}
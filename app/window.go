@@ -0,0 +1,672 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// windowFunctions are the functions computed from the rows of a window, which are only called with OVER,
+// with their least and largest number of arguments. The aggregates can be called with OVER too.
+var windowFunctions = map[string]struct{ MinArgs, MaxArgs int }{
+	"ROW_NUMBER":   {0, 0},
+	"RANK":         {0, 0},
+	"DENSE_RANK":   {0, 0},
+	"PERCENT_RANK": {0, 0},
+	"CUME_DIST":    {0, 0},
+	"NTILE":        {1, 1},
+	"LAG":          {1, 3},
+	"LEAD":         {1, 3},
+	"FIRST_VALUE":  {1, 1},
+	"LAST_VALUE":   {1, 1},
+	"NTH_VALUE":    {2, 2},
+}
+
+// defaultFrame is the frame of a window without one
+var defaultFrame = &WindowFrame{Unit: "RANGE", Start: FrameBound{Kind: "UNBOUNDED PRECEDING"}, End: FrameBound{Kind: "CURRENT ROW"}}
+
+// rankingFrames are the frames SQLite gives the window functions that don't use the frame of their
+// window, which decide the window functions computed together
+var rankingFrames = map[string]*WindowFrame{
+	"ROW_NUMBER":   {Unit: "ROWS", Start: FrameBound{Kind: "UNBOUNDED PRECEDING"}, End: FrameBound{Kind: "CURRENT ROW"}},
+	"RANK":         defaultFrame,
+	"DENSE_RANK":   defaultFrame,
+	"PERCENT_RANK": {Unit: "GROUPS", Start: FrameBound{Kind: "CURRENT ROW"}, End: FrameBound{Kind: "UNBOUNDED FOLLOWING"}},
+	"CUME_DIST":    {Unit: "GROUPS", Start: FrameBound{Kind: "FOLLOWING"}, End: FrameBound{Kind: "UNBOUNDED FOLLOWING"}},
+	"NTILE":        {Unit: "ROWS", Start: FrameBound{Kind: "CURRENT ROW"}, End: FrameBound{Kind: "UNBOUNDED FOLLOWING"}},
+	"LEAD":         {Unit: "ROWS", Start: FrameBound{Kind: "UNBOUNDED PRECEDING"}, End: FrameBound{Kind: "UNBOUNDED FOLLOWING"}},
+	"LAG":          {Unit: "ROWS", Start: FrameBound{Kind: "UNBOUNDED PRECEDING"}, End: FrameBound{Kind: "CURRENT ROW"}},
+}
+
+// checkWindowFunction makes sure a function called with OVER is a window function or an aggregate, called
+// with the right number of arguments
+func checkWindowFunction(expr *Expr) error {
+	name := strings.ToLower(expr.Name)
+	if function, found := windowFunctions[expr.Name]; found {
+		if len(expr.Args) < function.MinArgs || len(expr.Args) > function.MaxArgs {
+			return fmt.Errorf("wrong number of arguments to function %s()", name)
+		}
+		return nil
+	}
+	if function, found := aggregateFunctions[expr.Name]; found {
+		if len(expr.Args) < function.MinArgs || len(expr.Args) > function.MaxArgs {
+			return fmt.Errorf("wrong number of arguments to function %s()", name)
+		}
+		return nil
+	}
+	if _, found := scalarFunctions[expr.Name]; found {
+		return fmt.Errorf("%s() may not be used as a window function", name)
+	}
+	return fmt.Errorf("no such function: %s", name)
+}
+
+// operands are the expressions an expression is computed from: its arguments, followed for an aggregate
+// by its FILTER clause, and for a window function by the PARTITION BY and ORDER BY terms and the frame
+// offsets of its window
+func operands(expr *Expr) []*Expr {
+	if expr.Window == nil && expr.Filter == nil {
+		return expr.Args
+	}
+	exprs := slices.Clone(expr.Args)
+	if expr.Filter != nil {
+		exprs = append(exprs, expr.Filter)
+	}
+	if expr.Window == nil {
+		return exprs
+	}
+	exprs = append(exprs, expr.Window.PartitionBy...)
+	for _, term := range expr.Window.OrderBy {
+		exprs = append(exprs, term.Expr)
+	}
+	if frame := expr.Window.Frame; frame != nil {
+		for _, bound := range []FrameBound{frame.Start, frame.End} {
+			if bound.Offset != nil {
+				exprs = append(exprs, bound.Offset)
+			}
+		}
+	}
+	return exprs
+}
+
+// formatWindow writes a window like it's written after OVER, with the frame always written with BETWEEN
+func formatWindow(window *WindowDef) string {
+	if window.Name != "" {
+		return window.Name
+	}
+	parts := []string{}
+	if window.Base != "" {
+		parts = append(parts, window.Base)
+	}
+	if len(window.PartitionBy) > 0 {
+		terms := []string{}
+		for _, expr := range window.PartitionBy {
+			terms = append(terms, formatExpr(expr))
+		}
+		parts = append(parts, "PARTITION BY "+strings.Join(terms, ", "))
+	}
+	if len(window.OrderBy) > 0 {
+		terms := []string{}
+		for _, term := range window.OrderBy {
+			text := formatExpr(term.Expr)
+			if term.Descending {
+				text += " DESC"
+			}
+			terms = append(terms, text)
+		}
+		parts = append(parts, "ORDER BY "+strings.Join(terms, ", "))
+	}
+	if frame := window.Frame; frame != nil {
+		bounds := []string{}
+		for _, bound := range []FrameBound{frame.Start, frame.End} {
+			if bound.Offset != nil {
+				bounds = append(bounds, formatExpr(bound.Offset)+" "+bound.Kind)
+			} else {
+				bounds = append(bounds, bound.Kind)
+			}
+		}
+		text := frame.Unit + " BETWEEN " + bounds[0] + " AND " + bounds[1]
+		if frame.Exclude != "" {
+			text += " EXCLUDE " + frame.Exclude
+		}
+		parts = append(parts, text)
+	}
+	return "(" + strings.Join(parts, " ") + ")"
+}
+
+// resolveWindows gives the window functions called by the expression the definition of their window,
+// taking the terms of the windows of the WINDOW clause they name
+func resolveWindows(expr *Expr, named []*WindowDef) error {
+	if expr == nil {
+		return nil
+	}
+	if expr.Window != nil {
+		window, err := resolveWindow(expr.Window, named)
+		if err != nil {
+			return err
+		}
+		expr.Window = window
+	}
+	for _, arg := range expr.Args {
+		if err := resolveWindows(arg, named); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveWindow finds the definition of a window. A window of the WINDOW clause may be based on the ones
+// named before it, and the last one with a name hides the others. A window based on another one takes its
+// PARTITION BY terms and its ORDER BY terms if it has none, and the other one can't have a frame.
+func resolveWindow(window *WindowDef, named []*WindowDef) (*WindowDef, error) {
+	if window.Name != "" {
+		for i := len(named) - 1; i >= 0; i-- {
+			if strings.EqualFold(named[i].Name, window.Name) {
+				definition := *named[i]
+				definition.Name = ""
+				return resolveWindow(&definition, named[:i])
+			}
+		}
+		return nil, fmt.Errorf("no such window: %s", window.Name)
+	}
+	if window.Base != "" {
+		base, err := resolveWindow(&WindowDef{Name: window.Base}, named)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case len(window.PartitionBy) > 0:
+			return nil, fmt.Errorf("cannot override PARTITION clause of window: %s", window.Base)
+		case len(window.OrderBy) > 0 && len(base.OrderBy) > 0:
+			return nil, fmt.Errorf("cannot override ORDER BY clause of window: %s", window.Base)
+		case base.Frame != nil:
+			return nil, fmt.Errorf("cannot override frame specification of window: %s", window.Base)
+		}
+		resolved := &WindowDef{PartitionBy: base.PartitionBy, OrderBy: base.OrderBy, Frame: window.Frame}
+		if len(window.OrderBy) > 0 {
+			resolved.OrderBy = window.OrderBy
+		}
+		window = resolved
+	}
+	if frame := window.Frame; frame != nil && frame.Unit == "RANGE" && (frame.Start.Offset != nil || frame.End.Offset != nil) && len(window.OrderBy) != 1 {
+		return nil, fmt.Errorf("RANGE with offset PRECEDING/FOLLOWING requires one ORDER BY expression")
+	}
+	return window, nil
+}
+
+// findWindows adds the window functions called by the expression to the list, once each
+func findWindows(expr *Expr, windows []*Expr) []*Expr {
+	if expr == nil {
+		return windows
+	}
+	if expr.Window != nil {
+		if !slices.Contains(windows, expr) {
+			windows = append(windows, expr)
+		}
+		return windows
+	}
+	for _, arg := range operands(expr) {
+		windows = findWindows(arg, windows)
+	}
+	return windows
+}
+
+// misusedWindow makes sure no window function is called by the expressions, like the WHERE clause or the
+// arguments of an aggregate, which are computed before the windows
+func misusedWindow(exprs ...*Expr) error {
+	for _, expr := range exprs {
+		if windows := findWindows(expr, nil); len(windows) > 0 {
+			return fmt.Errorf("misuse of window function %s()", strings.ToLower(windows[0].Name))
+		}
+	}
+	return nil
+}
+
+// windowPass computes the window functions sharing a window, from the rows sorted by the PARTITION BY terms
+// and then by the ORDER BY terms of the window. Sorted tells if the rows are already in that order.
+type windowPass struct {
+	Window    *WindowDef
+	Functions []*Expr
+	Sorted    bool
+}
+
+// planWindows groups the window functions by their window, in the order they are first used. Like SQLite,
+// the functions that don't use the frame have the frame of their own function, so they are computed with
+// the aggregates only when it is the frame of the aggregates.
+func planWindows(windows []*Expr) []*windowPass {
+	passes := []*windowPass{}
+	for _, function := range windows {
+		window := function.Window
+		if frame := rankingFrames[function.Name]; frame != nil {
+			window = &WindowDef{PartitionBy: window.PartitionBy, OrderBy: window.OrderBy, Frame: frame}
+		} else if window.Frame == nil {
+			window = &WindowDef{PartitionBy: window.PartitionBy, OrderBy: window.OrderBy, Frame: defaultFrame}
+		}
+		key := formatWindow(window)
+		index := slices.IndexFunc(passes, func(pass *windowPass) bool { return formatWindow(pass.Window) == key })
+		if index == -1 {
+			passes = append(passes, &windowPass{Window: window})
+			index = len(passes) - 1
+		}
+		passes[index].Functions = append(passes[index].Functions, function)
+	}
+	return passes
+}
+
+// order is the order of the rows needed by the window
+func (pass *windowPass) order() []OrderTerm {
+	order := []OrderTerm{}
+	for _, expr := range pass.Window.PartitionBy {
+		order = append(order, OrderTerm{Expr: expr})
+	}
+	return append(order, pass.Window.OrderBy...)
+}
+
+// orderPrefix tells if the rows sorted in an order are also sorted in the order of prefix
+func orderPrefix(prefix []OrderTerm, order []OrderTerm) bool {
+	if len(prefix) > len(order) {
+		return false
+	}
+	for i, term := range prefix {
+		if term.Descending != order[i].Descending || formatExpr(term.Expr) != formatExpr(order[i].Expr) {
+			return false
+		}
+	}
+	return true
+}
+
+// addWindows gives the window functions slots after the ones of the aggregates. Like SQLite, the windows
+// are computed from the last one, so the rows of the result end in the order of the first one. The last
+// window is sorted when the rows are read in its order, and the others when the window computed before
+// them already sorted the rows in their order, as when they differ by their frame.
+func (plan *queryPlan) addWindows(passes []*windowPass, sorted bool) {
+	for i, pass := range passes {
+		if i == len(passes)-1 {
+			pass.Sorted = sorted || len(pass.order()) == 0
+		} else {
+			pass.Sorted = orderPrefix(pass.order(), passes[i+1].order())
+		}
+		for _, function := range pass.Functions {
+			function.Op, function.Column = "window", plan.Width
+			plan.Width++
+		}
+	}
+	plan.Windows = passes
+}
+
+// runWindows computes the window functions for all the rows of the result, keeping their values in their
+// slots. The rows are sorted for each window, so they end in the order of the first window.
+func runWindows(plan *queryPlan, rows [][]any) error {
+	for p := len(plan.Windows) - 1; p >= 0; p-- {
+		pass := plan.Windows[p]
+		order := pass.order()
+		collations := []string{}
+		for _, term := range order {
+			collations = append(collations, orderCollation(term.Expr))
+		}
+		keys := make([][]any, len(rows))
+		for i, row := range rows {
+			for _, term := range order {
+				value, err := evalExpr(term.Expr, row)
+				if err != nil {
+					return err
+				}
+				keys[i] = append(keys[i], value)
+			}
+		}
+		compare := func(a, b []any, from, to int) int {
+			for i := from; i < to; i++ {
+				comparison := compareCollated(a[i], b[i], collations[i])
+				if order[i].Descending {
+					comparison = -comparison
+				}
+				if comparison != 0 {
+					return comparison
+				}
+			}
+			return 0
+		}
+		if !pass.Sorted {
+			positions := make([]int, len(rows))
+			for i := range positions {
+				positions[i] = i
+			}
+			slices.SortStableFunc(positions, func(a, b int) int { return compare(keys[a], keys[b], 0, len(order)) })
+			sortedRows, sortedKeys := make([][]any, len(rows)), make([][]any, len(rows))
+			for i, position := range positions {
+				sortedRows[i], sortedKeys[i] = rows[position], keys[position]
+			}
+			copy(rows, sortedRows)
+			keys = sortedKeys
+		}
+		partitioned := len(pass.Window.PartitionBy)
+		for start := 0; start < len(rows); {
+			end := start + 1
+			for end < len(rows) && compare(keys[start], keys[end], 0, partitioned) == 0 {
+				end++
+			}
+			partition := &windowPartition{pass: pass, rows: rows[start:end]}
+			// the rows are peers when they have the same values for the ORDER BY terms
+			for i := start; i < end; i++ {
+				if i == start || compare(keys[i-1], keys[i], partitioned, len(order)) != 0 {
+					partition.groups = append(partition.groups, i-start)
+				}
+				partition.group = append(partition.group, len(partition.groups)-1)
+				partition.keys = append(partition.keys, keys[i][partitioned:])
+			}
+			partition.groups = append(partition.groups, end-start)
+			if err := partition.run(collations[partitioned:]); err != nil {
+				return err
+			}
+			start = end
+		}
+	}
+	return nil
+}
+
+// windowPartition has the rows of a partition of a window, sorted for the window, with the values of its
+// ORDER BY terms. The peers having the same values make groups, which start at the positions on groups,
+// followed by the number of rows, and group has the group of each row.
+type windowPartition struct {
+	pass   *windowPass
+	rows   [][]any
+	keys   [][]any
+	groups []int
+	group  []int
+}
+
+// run computes the window functions for the rows of the partition
+func (partition *windowPartition) run(collations []string) error {
+	// the frames are only found when a function of the window uses them
+	frame := partition.pass.Window.Frame
+	var starts, ends []int
+	if slices.ContainsFunc(partition.pass.Functions, func(function *Expr) bool { return rankingFrames[function.Name] == nil }) {
+		var err error
+		if starts, ends, err = partition.frames(frame, collations); err != nil {
+			return err
+		}
+	}
+	rows := partition.rows
+	count := len(rows)
+	for _, function := range partition.pass.Functions {
+		var compute func(i int) (any, error)
+		switch function.Name {
+		case "ROW_NUMBER":
+			compute = func(i int) (any, error) { return int64(i + 1), nil }
+		case "RANK":
+			compute = func(i int) (any, error) { return int64(partition.groups[partition.group[i]] + 1), nil }
+		case "DENSE_RANK":
+			compute = func(i int) (any, error) { return int64(partition.group[i] + 1), nil }
+		case "PERCENT_RANK":
+			compute = func(i int) (any, error) {
+				if count == 1 {
+					return 0.0, nil
+				}
+				return float64(partition.groups[partition.group[i]]) / float64(count-1), nil
+			}
+		case "CUME_DIST":
+			compute = func(i int) (any, error) {
+				return float64(partition.groups[partition.group[i]+1]) / float64(count), nil
+			}
+		case "NTILE":
+			// the number of buckets is the one of the first row
+			value, err := evalExpr(function.Args[0], rows[0])
+			if err != nil {
+				return err
+			}
+			buckets := integerValue(value)
+			if buckets <= 0 {
+				return fmt.Errorf("argument of ntile must be a positive integer")
+			}
+			compute = func(i int) (any, error) { return ntileBucket(int64(i), int64(count), buckets), nil }
+		case "LAG", "LEAD":
+			compute = func(i int) (any, error) { return partition.offsetValue(function, i) }
+		case "FIRST_VALUE", "LAST_VALUE", "NTH_VALUE":
+			compute = func(i int) (any, error) { return partition.frameValue(function, frame, i, starts[i], ends[i]) }
+		default:
+			compute = partition.aggregate(function, frame, starts, ends)
+		}
+		for i, row := range rows {
+			value, err := compute(i)
+			if err != nil {
+				return err
+			}
+			row[function.Column] = value
+		}
+	}
+	return nil
+}
+
+// ntileBucket finds the bucket of a row when the rows are divided in buckets, where the first ones have
+// one more row than the others when they can't all have the same number of rows
+func ntileBucket(row int64, count int64, buckets int64) int64 {
+	size := count / buckets
+	if size == 0 {
+		return row + 1
+	}
+	large := count - buckets*size
+	small := large * (size + 1)
+	if row < small {
+		return 1 + row/(size+1)
+	}
+	return 1 + large + (row-small)/size
+}
+
+// offsetValue finds the value of LAG or LEAD for a row: the value of the row before or after it by the
+// offset, which is 1 by default, or the default value when there is none. Like SQLite, an offset that
+// isn't an integer finds no row.
+func (partition *windowPartition) offsetValue(function *Expr, i int) (any, error) {
+	rows := partition.rows
+	var offset int64 = 1
+	found := true
+	if len(function.Args) > 1 {
+		value, err := evalExpr(function.Args[1], rows[i])
+		if err != nil {
+			return nil, err
+		}
+		switch number := numericValue(value).(type) {
+		case int64:
+			offset = number
+		case float64:
+			offset, found = int64(number), number == math.Trunc(number) && math.Abs(number) < 1<<62
+		default:
+			found = false
+		}
+	}
+	if function.Name == "LAG" {
+		offset = -offset
+	}
+	if target := int64(i) + offset; found && target >= 0 && target < int64(len(rows)) {
+		return evalExpr(function.Args[0], rows[target])
+	}
+	if len(function.Args) > 2 {
+		return evalExpr(function.Args[2], rows[i])
+	}
+	return nil, nil
+}
+
+// frameValue finds the value of FIRST_VALUE, LAST_VALUE or NTH_VALUE for a row from the rows of its frame
+func (partition *windowPartition) frameValue(function *Expr, frame *WindowFrame, i int, start int, end int) (any, error) {
+	var position int64 = 1
+	if function.Name == "NTH_VALUE" {
+		value, err := evalExpr(function.Args[1], partition.rows[i])
+		if err != nil {
+			return nil, err
+		}
+		switch number := numericAffinity(value).(type) {
+		case int64:
+			position = number
+		case float64:
+			position = int64(number)
+			if float64(position) != number {
+				position = 0
+			}
+		default:
+			position = 0
+		}
+		if position <= 0 {
+			return nil, fmt.Errorf("second argument to nth_value must be a positive integer")
+		}
+	}
+	found := -1
+	for j := start; j < end; j++ {
+		if partition.excluded(frame, i, j) {
+			continue
+		}
+		found = j
+		if function.Name != "LAST_VALUE" {
+			if position--; position == 0 {
+				break
+			}
+		}
+	}
+	if found == -1 || position > 0 && function.Name != "LAST_VALUE" {
+		return nil, nil
+	}
+	return evalExpr(function.Args[0], partition.rows[found])
+}
+
+// aggregate computes an aggregate over the frame of each row. As the frames move forward, the rows
+// entering the frame are added to the result and the ones leaving it are removed, unless the aggregate
+// can't remove them or rows are excluded from the frame, where it is computed again.
+func (partition *windowPartition) aggregate(function *Expr, frame *WindowFrame, starts []int, ends []int) func(i int) (any, error) {
+	state := &aggregateState{}
+	from, to := 0, 0
+	return func(i int) (any, error) {
+		start, end := starts[i], ends[i]
+		if frame.Exclude != "" || start < from || start > from && !hasInverse(function) {
+			state, from, to = &aggregateState{}, start, start
+		}
+		for ; to < end; to++ {
+			if partition.excluded(frame, i, to) {
+				continue
+			}
+			if _, err := state.step(function, partition.rows[to]); err != nil {
+				return nil, err
+			}
+		}
+		for ; from < start; from++ {
+			if err := state.inverse(function, partition.rows[from]); err != nil {
+				return nil, err
+			}
+		}
+		return state.result(function)
+	}
+}
+
+// excluded tells if the frame of row i excludes row j by its EXCLUDE clause
+func (partition *windowPartition) excluded(frame *WindowFrame, i int, j int) bool {
+	switch frame.Exclude {
+	case "CURRENT ROW":
+		return i == j
+	case "GROUP":
+		return partition.group[i] == partition.group[j]
+	case "TIES":
+		return i != j && partition.group[i] == partition.group[j]
+	}
+	return false
+}
+
+// frames finds the frame of each row of the partition, from its start to before its end. ROWS counts
+// rows and GROUPS counts groups of peers, while RANGE finds the rows whose value of the ORDER BY term is
+// within the offset from the value of the row. A frame ends at its start when its end is before it.
+func (partition *windowPartition) frames(frame *WindowFrame, collations []string) (starts []int, ends []int, err error) {
+	count := len(partition.rows)
+	offsets := []any{nil, nil}
+	for b, bound := range []FrameBound{frame.Start, frame.End} {
+		if bound.Offset != nil {
+			if offsets[b], err = frameOffset(bound.Offset, frame.Unit, []string{"starting", "ending"}[b], partition.rows[0]); err != nil {
+				return
+			}
+		}
+	}
+	position := func(bound FrameBound, offset any, i int, end bool) int {
+		group := partition.group[i]
+		groups := len(partition.groups) - 1
+		after := 0
+		if end {
+			after = 1
+		}
+		switch bound.Kind {
+		case "UNBOUNDED PRECEDING":
+			return 0
+		case "UNBOUNDED FOLLOWING":
+			return count
+		case "CURRENT ROW":
+			if frame.Unit == "ROWS" {
+				return i + after
+			}
+			return partition.groups[group+after]
+		}
+		if frame.Unit == "RANGE" {
+			return partition.rangePosition(bound, offset, i, end, collations[0])
+		}
+		distance := int(min(offset.(int64), int64(count)))
+		if bound.Kind == "PRECEDING" {
+			distance = -distance
+		}
+		if frame.Unit == "ROWS" {
+			return i + distance + after
+		}
+		return partition.groups[min(max(group+distance+after, 0), groups)]
+	}
+	for i := range partition.rows {
+		start := min(max(position(frame.Start, offsets[0], i, false), 0), count)
+		end := min(max(position(frame.End, offsets[1], i, true), start), count)
+		starts, ends = append(starts, start), append(ends, end)
+	}
+	return
+}
+
+// rangePosition finds the first row of a RANGE frame, or the first row after it, from the value of the
+// ORDER BY term of the row plus or minus the offset. The value of a row that isn't a number is not
+// changed, so its frame has its peers.
+func (partition *windowPartition) rangePosition(bound FrameBound, offset any, i int, end bool, collation string) int {
+	descending := partition.pass.Window.OrderBy[0].Descending
+	limit := partition.keys[i][0]
+	switch limit.(type) {
+	case int64, float64:
+		if bound.Kind == "PRECEDING" != descending {
+			limit = arithmetic("-", limit, offset)
+		} else {
+			limit = arithmetic("+", limit, offset)
+		}
+	}
+	return sort.Search(len(partition.rows), func(j int) bool {
+		comparison := compareCollated(partition.keys[j][0], limit, collation)
+		if descending {
+			comparison = -comparison
+		}
+		if end {
+			return comparison > 0
+		}
+		return comparison >= 0
+	})
+}
+
+// frameOffset computes the offset of a bound of a frame, which is a number for RANGE and an integer for
+// ROWS and GROUPS, and can't be negative
+func frameOffset(expr *Expr, unit string, bound string, row []any) (any, error) {
+	value, err := evalExpr(expr, row)
+	if err != nil {
+		return nil, err
+	}
+	switch number := numericAffinity(value).(type) {
+	case int64:
+		if number >= 0 {
+			return number, nil
+		}
+	case float64:
+		if unit == "RANGE" && number >= 0 {
+			return number, nil
+		}
+		if number >= 0 && number == math.Trunc(number) && number < math.MaxInt64 {
+			return int64(number), nil
+		}
+	}
+	if unit == "RANGE" {
+		return nil, fmt.Errorf("frame %s offset must be a non-negative number", bound)
+	}
+	return nil, fmt.Errorf("frame %s offset must be a non-negative integer", bound)
+}